@@ -0,0 +1,50 @@
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Message is an ephemeral application message spread to every node, see Broadcast
+type Message struct {
+	Id     string          `json:"id"`
+	Topic  string          `json:"topic,omitempty"`
+	Data   json.RawMessage `json:"data,omitempty"`
+	Origin string          `json:"origin"` // ID of the node it was broadcast from
+	Sent   time.Time       `json:"sent"`
+}
+
+// Broadcast spreads a message, such as "match started", to every node, where Broadcasts streams deliver it.
+// Delivery is best-effort and messages aren't stored, so a stream that isn't open when one spreads never sees it.
+// The message is given an ID up front, so a retry on another node isn't delivered twice
+func (c *Client) Broadcast(ctx context.Context, topic string, data any) (Message, error) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return Message{}, err
+	}
+	id := make([]byte, 16)
+	rand.Read(id)
+	body, err := json.Marshal(struct {
+		Id    string          `json:"id"`
+		Topic string          `json:"topic,omitempty"`
+		Data  json.RawMessage `json:"data"`
+	}{hex.EncodeToString(id), topic, raw})
+	if err != nil {
+		return Message{}, err
+	}
+	var msg Message
+	err = c.do(ctx, http.MethodPost, "/broadcast", body, &msg)
+	return msg, err
+}
+
+// Broadcasts streams the broadcast messages of a topic, or of every topic if topic is empty, until ctx is done, at
+// which point the channel is closed. Like Watch it reconnects to the next node if the stream ends, missing the
+// messages spread meanwhile
+func (c *Client) Broadcasts(ctx context.Context, topic string) (<-chan Message, error) {
+	return stream[Message](ctx, c, "/broadcast?topic="+url.QueryEscape(topic))
+}
@@ -0,0 +1,487 @@
+// Package client is a Go client for the gossiper HTTP API. A Client spreads requests across the nodes it is given,
+// retrying failed requests on the next node, so callers don't have to track which nodes are up
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// PlayerState is a player's score along with the metadata the cluster uses to order updates
+type PlayerState struct {
+	Score     int64  `json:"score"`
+	Timestamp int64  `json:"timestamp"`     // wall-clock time of the update in nanoseconds
+	Clock     uint64 `json:"clock"`         // hybrid logical clock timestamp of the update
+	Origin    string `json:"origin"`        // ID of the node that made the update
+	TTL       int64  `json:"ttl,omitempty"` // seconds after the update at which the player expires, 0 never
+
+	Attributes map[string]json.RawMessage `json:"attributes,omitempty"` // fields besides the score, see SetAttributes
+	Priority   string                     `json:"priority,omitempty"`   // how urgently the cluster gossips the player, see SetPriority
+}
+
+// RankedPlayer is a player's position on the leaderboard
+type RankedPlayer struct {
+	Rank     int    `json:"rank"`
+	PlayerId string `json:"playerId"`
+	Score    int64  `json:"score"`
+}
+
+// Member is a node's entry in the cluster's membership list
+type Member struct {
+	Address     string            `json:"address"`
+	Status      string            `json:"status"` // alive, suspect, dead or left
+	Incarnation uint64            `json:"incarnation"`
+	Meta        map[string]string `json:"meta,omitempty"` // metadata the node advertises, such as its region
+}
+
+// Ownership reports which nodes hold a player's state
+type Ownership struct {
+	PlayerId   string   `json:"playerId"`
+	Shard      int      `json:"shard"`  // -1 when the cluster isn't sharded
+	Shards     int      `json:"shards"` // shards in the cluster, 0 when it isn't sharded
+	Owners     []string `json:"owners"` // nodes holding the player by the node's placement, its owner first
+	Advertised []string `json:"advertised,omitempty"`
+	Local      bool     `json:"local"` // the node asked has the player's state
+}
+
+// Session is a player's connection to a game server, and the node that hosts it
+type Session struct {
+	PlayerId string    `json:"playerId"`
+	Node     string    `json:"node"`    // ID of the node hosting the session
+	Address  string    `json:"address"` // address of the node hosting the session
+	Session  string    `json:"session,omitempty"`
+	Since    time.Time `json:"since"`
+	Expires  time.Time `json:"expires"` // unless it is heartbeated again with Connect
+}
+
+// ErrNotConnected is returned by Presence for a player without a session, and by Disconnect for a session the node
+// doesn't host
+var ErrNotConnected = errors.New("player not connected")
+
+// ErrNotFound is returned by GetPlayer for a player that doesn't exist
+var ErrNotFound = errors.New("player not found")
+
+// ErrConflict is returned by UpdateScoreIf for a player written since it was read
+var ErrConflict = errors.New("player changed since it was read")
+
+// ErrLeaseHeld is returned by AcquireLease for a lease another holder has
+var ErrLeaseHeld = errors.New("lease held by another holder")
+
+// ErrLeaseNotHeld is returned by GetLease for a lease nobody holds, and by ReleaseLease for one the holder doesn't
+var ErrLeaseNotHeld = errors.New("lease not held")
+
+// APIError is an error response from a node. Requests that fail with one are only retried on 5xx and 429 responses
+type APIError struct {
+	StatusCode int
+	Code       string         `json:"code"` // e.g. "invalid_argument" or "not_found", empty if the body wasn't JSON
+	Message    string         `json:"message"`
+	Details    map[string]any `json:"details"`
+	Field      string         `json:"-"` // request field that failed validation, if any, from Details
+	RetryAfter time.Duration  `json:"-"` // from the Retry-After header of a 429 or 503 response
+}
+
+func (e *APIError) Error() string {
+	if e.Field != "" {
+		return fmt.Sprintf("gossiper: %s (%s, status %d)", e.Message, e.Field, e.StatusCode)
+	}
+	return fmt.Sprintf("gossiper: %s (status %d)", e.Message, e.StatusCode)
+}
+
+// Client talks to a gossiper cluster. Set its fields before the first request
+type Client struct {
+	HTTPClient *http.Client
+	Retries    int           // how many other nodes a failed request is retried on
+	Backoff    time.Duration // wait before the first retry, doubled for every retry after it
+	Token      string        // API key or JWT sent as a bearer token, for nodes that require one
+	Room       string        // room whose players the player and leaderboard methods address, "" for those outside any room
+
+	// Send IncrementScore and UpdateScoreIf with an idempotency key, so that nodes answer their retries without
+	// applying them again and they can be retried like any other request. Needs nodes with --idempotency-window
+	IdempotencyKeys bool
+
+	nodes []string // base URLs
+	next  atomic.Uint64
+}
+
+// New returns a Client for the nodes at the given addresses, either host:port, which uses plain HTTP, or a URL
+// such as https://host:port. Requests go round-robin across the nodes
+func New(addrs ...string) (*Client, error) {
+	if len(addrs) == 0 {
+		return nil, errors.New("gossiper: no node addresses")
+	}
+	c := &Client{
+		HTTPClient: &http.Client{Timeout: 10 * time.Second},
+		Retries:    len(addrs) - 1,
+		Backoff:    100 * time.Millisecond,
+	}
+	for _, addr := range addrs {
+		if !strings.Contains(addr, "://") {
+			addr = "http://" + addr
+		}
+		u, err := url.Parse(addr)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("gossiper: invalid node address %q", addr)
+		}
+		c.nodes = append(c.nodes, strings.TrimSuffix(u.String(), "/"))
+	}
+	if c.Retries < 1 {
+		c.Retries = 1
+	}
+	return c, nil
+}
+
+// UpdateScore sets a player's score
+func (c *Client) UpdateScore(ctx context.Context, playerId string, score int64) error {
+	return c.UpdateScoreWithTTL(ctx, playerId, score, 0)
+}
+
+// UpdateScoreWithTTL sets a player's score and expires the player if it isn't updated again within ttl. A ttl of 0
+// uses the node's default
+func (c *Client) UpdateScoreWithTTL(ctx context.Context, playerId string, score int64, ttl time.Duration) error {
+	req := struct {
+		PlayerId string `json:"playerId"`
+		Score    int64  `json:"score"`
+		TTL      string `json:"ttl,omitempty"`
+	}{PlayerId: playerId, Score: score}
+	if ttl > 0 {
+		req.TTL = ttl.String()
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	return c.do(ctx, http.MethodPost, c.inRoom("/update"), body, nil)
+}
+
+// UpdateScoreIf sets a player's score only if the node still has the player at clock, the Clock of the
+// PlayerState it was read as, or doesn't have it if clock is 0. It returns the player as written, whose Clock the
+// next UpdateScoreIf expects, or ErrConflict if the player has been written since. Like IncrementScore it is only
+// retried when it certainly didn't reach a node, since a retry of a write that was made would conflict with it,
+// unless the client sends IdempotencyKeys
+func (c *Client) UpdateScoreIf(ctx context.Context, playerId string, score int64, clock uint64) (PlayerState, error) {
+	body, err := json.Marshal(struct {
+		PlayerId      string `json:"playerId"`
+		Score         int64  `json:"score"`
+		ExpectedClock uint64 `json:"expectedClock"`
+	}{playerId, score, clock})
+	if err != nil {
+		return PlayerState{}, err
+	}
+	var state PlayerState
+	err = c.sendOnce(ctx, c.inRoom("/update"), body, &state)
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusConflict {
+		return PlayerState{}, ErrConflict
+	}
+	return state, err
+}
+
+// SetAttributes sets some of a player's attributes, leaving its score and other attributes as they are. Values
+// are encoded as JSON; a nil value removes the attribute
+func (c *Client) SetAttributes(ctx context.Context, playerId string, attrs map[string]any) error {
+	body, err := json.Marshal(struct {
+		PlayerId   string         `json:"playerId"`
+		Attributes map[string]any `json:"attributes"`
+	}{playerId, attrs})
+	if err != nil {
+		return err
+	}
+	return c.do(ctx, http.MethodPost, c.inRoom("/update"), body, nil)
+}
+
+// SetPriority sets how urgently the cluster gossips a player's writes, "high", "normal" or "low", leaving its score
+// and attributes as they are. Writes of a high-priority player, such as a match result, reach every node within a
+// round or so however busy gossip is; those of a low-priority one wait for rounds with room to spare. Later writes
+// keep the priority
+func (c *Client) SetPriority(ctx context.Context, playerId, priority string) error {
+	body, err := json.Marshal(struct {
+		PlayerId string `json:"playerId"`
+		Priority string `json:"priority"`
+	}{playerId, priority})
+	if err != nil {
+		return err
+	}
+	return c.do(ctx, http.MethodPost, c.inRoom("/update"), body, nil)
+}
+
+// IncrementScore adds delta, which may be negative, to a player's score and returns the new score as the node
+// saw it. Increments made through different nodes at the same time are all counted. As an increment isn't
+// idempotent it is only retried when it certainly didn't reach a node, so a timeout is returned rather than
+// risking counting it twice, unless the client sends IdempotencyKeys
+func (c *Client) IncrementScore(ctx context.Context, playerId string, delta int64) (int64, error) {
+	var result struct {
+		Score int64 `json:"score"`
+	}
+	path := c.inRoom("/increment?playerId=" + url.QueryEscape(playerId) + "&delta=" + strconv.FormatInt(delta, 10))
+	err := c.sendOnce(ctx, path, nil, &result)
+	return result.Score, err
+}
+
+// DeletePlayer deletes a player cluster-wide
+func (c *Client) DeletePlayer(ctx context.Context, playerId string) error {
+	return c.do(ctx, http.MethodDelete, c.inRoom("/delete?playerId="+url.QueryEscape(playerId)), nil, nil)
+}
+
+// GetPlayer returns a player's state as seen by one node, or ErrNotFound
+func (c *Client) GetPlayer(ctx context.Context, playerId string) (PlayerState, error) {
+	var state PlayerState
+	err := c.do(ctx, http.MethodGet, c.inRoom("/state/"+url.PathEscape(playerId)), nil, &state)
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+		return PlayerState{}, ErrNotFound
+	}
+	return state, err
+}
+
+// GetPlayerWithConsistency is GetPlayer reading from as many of the player's replicas as consistency needs:
+// "local", "quorum" or "all"
+func (c *Client) GetPlayerWithConsistency(ctx context.Context, playerId, consistency string) (PlayerState, error) {
+	var state PlayerState
+	path := c.inRoom("/state/" + url.PathEscape(playerId) + "?consistency=" + url.QueryEscape(consistency))
+	err := c.do(ctx, http.MethodGet, path, nil, &state)
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+		return PlayerState{}, ErrNotFound
+	}
+	return state, err
+}
+
+// GetState returns the state of every player as seen by one node
+func (c *Client) GetState(ctx context.Context) (map[string]PlayerState, error) {
+	var state map[string]PlayerState
+	err := c.do(ctx, http.MethodGet, c.inRoom("/state"), nil, &state)
+	return state, err
+}
+
+// Leaderboard returns up to top players with the highest scores
+func (c *Client) Leaderboard(ctx context.Context, top int) ([]RankedPlayer, error) {
+	var ranked []RankedPlayer
+	err := c.do(ctx, http.MethodGet, c.inRoom("/leaderboard?top="+strconv.Itoa(top)), nil, &ranked)
+	return ranked, err
+}
+
+// Members returns the membership list as seen by one node, keeping only the members whose metadata has every key
+// in filter with the same value. A nil filter returns every member
+func (c *Client) Members(ctx context.Context, filter map[string]string) ([]Member, error) {
+	query := url.Values{}
+	for key, value := range filter {
+		query.Set("meta."+key, value)
+	}
+	path := "/members"
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+	var members []Member
+	err := c.do(ctx, http.MethodGet, path, nil, &members)
+	return members, err
+}
+
+// WhoIs returns which nodes hold a player's state, as seen by one node
+func (c *Client) WhoIs(ctx context.Context, playerId string) (Ownership, error) {
+	var ownership Ownership
+	err := c.do(ctx, http.MethodGet, "/whois/"+url.PathEscape(playerId), nil, &ownership)
+	return ownership, err
+}
+
+// Leader is the cluster's leader as one node sees it
+type Leader struct {
+	Leader   string    `json:"leader"` // address of the leader, "" while there is none
+	Since    time.Time `json:"since,omitzero"`
+	Self     string    `json:"self"` // address of the node asked
+	IsLeader bool      `json:"isLeader"`
+}
+
+// Leader returns the cluster's leader as the next node sees it. Nodes may briefly disagree while the leader changes
+func (c *Client) Leader(ctx context.Context) (Leader, error) {
+	var leader Leader
+	err := c.do(ctx, http.MethodGet, "/leader", nil, &leader)
+	return leader, err
+}
+
+// Connect records that a player's session is hosted by the node the request reaches, and is called again as a
+// heartbeat to keep it. A session belongs to whichever node it was connected on, so create the client with just
+// the game server's own node
+func (c *Client) Connect(ctx context.Context, playerId, session string) (Session, error) {
+	body, err := json.Marshal(struct {
+		PlayerId string `json:"playerId"`
+		Session  string `json:"session,omitempty"`
+	}{playerId, session})
+	if err != nil {
+		return Session{}, err
+	}
+	var s Session
+	err = c.do(ctx, http.MethodPost, "/presence", body, &s)
+	return s, err
+}
+
+// Disconnect ends a player's session before it expires, only the one with the given ID unless session is
+// empty. Like Connect it must reach the node hosting the session, or it returns ErrNotConnected
+func (c *Client) Disconnect(ctx context.Context, playerId, session string) error {
+	path := "/presence/" + url.PathEscape(playerId)
+	if session != "" {
+		path += "?session=" + url.QueryEscape(session)
+	}
+	err := c.do(ctx, http.MethodDelete, path, nil, nil)
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+		return ErrNotConnected
+	}
+	return err
+}
+
+// Presence returns the session of a player, and so the node hosting it, as far as one node has heard, or
+// ErrNotConnected
+func (c *Client) Presence(ctx context.Context, playerId string) (Session, error) {
+	var s Session
+	err := c.do(ctx, http.MethodGet, "/presence/"+url.PathEscape(playerId), nil, &s)
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+		return Session{}, ErrNotConnected
+	}
+	return s, err
+}
+
+// inRoom returns the path of an API call for the players of the client's Room
+func (c *Client) inRoom(path string) string {
+	if c.Room == "" {
+		return path
+	}
+	return "/rooms/" + url.PathEscape(c.Room) + path
+}
+
+// do sends a request to the next node, moving on to the following ones if it fails, and decodes a JSON response
+// into out if it isn't nil
+func (c *Client) do(ctx context.Context, method, path string, body []byte, out any) error {
+	return c.send(ctx, method, path, nil, body, out, retryable)
+}
+
+// sendOnce posts a write that mustn't be applied twice. With IdempotencyKeys it carries a key of its own, which
+// its retries carry too, and is retried like any other request, as well as while the node is still handling the
+// first attempt; without, only when it certainly didn't reach a node
+func (c *Client) sendOnce(ctx context.Context, path string, body []byte, out any) error {
+	if !c.IdempotencyKeys {
+		return c.send(ctx, http.MethodPost, path, nil, body, out, notApplied)
+	}
+	header := http.Header{"Idempotency-Key": {rand.Text()}}
+	return c.send(ctx, http.MethodPost, path, header, body, out, func(err error) bool {
+		var apiErr *APIError
+		return retryable(err) || errors.As(err, &apiErr) && apiErr.Code == "request_in_progress"
+	})
+}
+
+// retryable reports whether a failed request may be sent again: it wasn't answered, or was answered with a 5xx or,
+// once the node says it may be, for being rate limited
+func retryable(err error) bool {
+	var apiErr *APIError
+	return !errors.As(err, &apiErr) || apiErr.StatusCode >= 500 || apiErr.StatusCode == http.StatusTooManyRequests
+}
+
+// notApplied reports whether a failed request certainly had no effect, so that even a request that mustn't be
+// applied twice may be sent again: it was rate limited, or no connection was made
+func notApplied(err error) bool {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode == http.StatusTooManyRequests
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// send is do with extra request headers and the rule for which failures are retried
+func (c *Client) send(ctx context.Context, method, path string, header http.Header, body []byte, out any,
+	retry func(error) bool) error {
+	start := c.next.Add(1)
+	backoff := c.Backoff
+	var err error
+	for attempt := 0; attempt <= c.Retries; attempt++ {
+		wait := backoff
+		var apiErr *APIError
+		if errors.As(err, &apiErr) {
+			wait = max(wait, apiErr.RetryAfter)
+		}
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(wait):
+			}
+			backoff *= 2
+		}
+
+		node := c.nodes[(start+uint64(attempt))%uint64(len(c.nodes))]
+		err = c.try(ctx, method, node+path, header, body, out)
+		if err == nil || ctx.Err() != nil || !retry(err) {
+			return err
+		}
+	}
+	return err
+}
+
+func (c *Client) try(ctx context.Context, method, url string, header http.Header, body []byte, out any) error {
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	c.authorize(req)
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return readError(resp)
+	}
+	if out == nil {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("gossiper: failed to decode response from %s: %w", url, err)
+	}
+	return nil
+}
+
+// authorize adds the client's token to a request, if it has one
+func (c *Client) authorize(req *http.Request) {
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+}
+
+// readError builds an APIError from an error response, which nodes always send as JSON but a proxy in front of
+// them may not
+func readError(resp *http.Response) error {
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	apiErr := &APIError{StatusCode: resp.StatusCode}
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+		apiErr.RetryAfter = time.Duration(seconds) * time.Second
+	}
+	if json.Unmarshal(data, apiErr) != nil || apiErr.Message == "" {
+		apiErr.Message = strings.TrimSpace(string(data))
+	}
+	apiErr.Field, _ = apiErr.Details["field"].(string)
+	if apiErr.Message == "" {
+		apiErr.Message = http.StatusText(resp.StatusCode)
+	}
+	return apiErr
+}
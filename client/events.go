@@ -0,0 +1,38 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Change is an entry of a node's change log, read with Changes
+type Change struct {
+	Seq      uint64       `json:"seq"`
+	Time     time.Time    `json:"time"`   // when the node accepted the change
+	Type     string       `json:"type"`   // EventUpdate or EventDelete
+	Source   string       `json:"source"` // "local" if made on the node, "remote" if merged from a peer
+	PlayerId string       `json:"playerId"`
+	State    *PlayerState `json:"state,omitempty"` // new state of an updated player
+}
+
+// ChangeFeed is a page of a node's change log
+type ChangeFeed struct {
+	Changes []Change `json:"changes"`
+	First   uint64   `json:"first"` // oldest change the node still has; above since+1, changes were missed
+	Last    uint64   `json:"last"`  // newest change the node has
+	Next    uint64   `json:"next"`  // since to pass for the following page
+}
+
+// Changes returns up to limit changes after since from a node's change log, oldest first. Sequence numbers are
+// the node's own, so give the Client only the node to read from. A limit of 0 uses the node's default
+func (c *Client) Changes(ctx context.Context, since uint64, limit int) (ChangeFeed, error) {
+	path := "/events?since=" + strconv.FormatUint(since, 10)
+	if limit > 0 {
+		path += "&limit=" + strconv.Itoa(limit)
+	}
+	var feed ChangeFeed
+	err := c.do(ctx, http.MethodGet, path, nil, &feed)
+	return feed, err
+}
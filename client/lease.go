@@ -0,0 +1,63 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Lease is a named, time-limited claim held by one holder at a time, see AcquireLease
+type Lease struct {
+	Name     string    `json:"name"`
+	Holder   string    `json:"holder"`
+	Node     string    `json:"node"`  // ID of the node it was last taken or renewed through
+	Token    uint64    `json:"token"` // fencing token, higher for every lease taken after this one
+	Acquired time.Time `json:"acquired"`
+	Expires  time.Time `json:"expires"` // unless it is renewed with AcquireLease
+}
+
+// AcquireLease takes the named lease for holder, for ttl, or renews it if holder already has it, and returns
+// ErrLeaseHeld while another holder does. Leases are best-effort: two holders can take the same lease through
+// different nodes at once, until the nodes hear of each other and the one taken last wins. Pass the lease's Token
+// along to whatever it guards, so that it can refuse a holder with a lower token than it has seen. Renew well
+// before Expires, and through the same node where possible
+func (c *Client) AcquireLease(ctx context.Context, name, holder string, ttl time.Duration) (Lease, error) {
+	body, err := json.Marshal(struct {
+		Holder string `json:"holder"`
+		TTL    string `json:"ttl"`
+	}{holder, ttl.String()})
+	if err != nil {
+		return Lease{}, err
+	}
+	var l Lease
+	err = c.do(ctx, http.MethodPost, "/leases/"+url.PathEscape(name), body, &l)
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusConflict {
+		return Lease{}, ErrLeaseHeld
+	}
+	return l, err
+}
+
+// ReleaseLease gives up a lease holder has before it expires, or returns ErrLeaseNotHeld
+func (c *Client) ReleaseLease(ctx context.Context, name, holder string) error {
+	err := c.do(ctx, http.MethodDelete, "/leases/"+url.PathEscape(name)+"?holder="+url.QueryEscape(holder), nil, nil)
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+		return ErrLeaseNotHeld
+	}
+	return err
+}
+
+// GetLease returns the named lease as far as one node has heard, or ErrLeaseNotHeld
+func (c *Client) GetLease(ctx context.Context, name string) (Lease, error) {
+	var l Lease
+	err := c.do(ctx, http.MethodGet, "/leases/"+url.PathEscape(name), nil, &l)
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+		return Lease{}, ErrLeaseNotHeld
+	}
+	return l, err
+}
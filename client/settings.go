@@ -0,0 +1,34 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// Setting is a cluster-wide setting set by an operator, such as a feature flag
+type Setting struct {
+	Key     string    `json:"key"`
+	Value   string    `json:"value"`
+	Origin  string    `json:"origin"` // ID of the node it was set on
+	Updated time.Time `json:"updated"`
+}
+
+// Settings returns the cluster-wide settings as the next node knows them. A setting reaches every node within a
+// few seconds of being set, so poll rather than expect a change to show everywhere at once
+func (c *Client) Settings(ctx context.Context) ([]Setting, error) {
+	var settings []Setting
+	err := c.do(ctx, http.MethodGet, "/settings", nil, &settings)
+	return settings, err
+}
+
+// Setting returns the value of one cluster-wide setting, or "" if it isn't set
+func (c *Client) Setting(ctx context.Context, key string) (string, error) {
+	settings, err := c.Settings(ctx)
+	for _, s := range settings {
+		if s.Key == key {
+			return s.Value, nil
+		}
+	}
+	return "", err
+}
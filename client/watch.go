@@ -0,0 +1,129 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	EventUpdate = "update"
+	EventDelete = "delete" // deleted or expired
+)
+
+// Event is a change to a player, streamed by Watch
+type Event struct {
+	Type     string       `json:"type"`
+	PlayerId string       `json:"playerId"`
+	State    *PlayerState `json:"state,omitempty"` // new state of an updated player
+}
+
+// Watch streams changes to players whose ID starts with prefix, or to every player if prefix is empty, until ctx
+// is done, at which point the channel is closed. If the node streaming the changes goes away, Watch reconnects to
+// the next one; changes made while it was reconnecting are not replayed, so read the state again if that matters
+func (c *Client) Watch(ctx context.Context, prefix string) (<-chan Event, error) {
+	return stream[Event](ctx, c, "/watch?prefix="+url.QueryEscape(prefix))
+}
+
+// stream reads the Server-Sent Events stream at path into a channel until ctx is done, reconnecting to the next
+// node whenever the stream ends
+func stream[T any](ctx context.Context, c *Client, path string) (<-chan T, error) {
+	events := make(chan T)
+	start := c.next.Add(1)
+
+	// Connect once up front so that a cluster that can't be reached is reported straight away
+	resp, err := c.openStream(ctx, start, path)
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		defer close(events)
+		attempt := start
+		for {
+			readEvents(ctx, resp, events)
+			if ctx.Err() != nil {
+				return
+			}
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(c.Backoff):
+				}
+				attempt++
+				if resp, err = c.openStream(ctx, attempt, path); err == nil {
+					break
+				}
+			}
+		}
+	}()
+	return events, nil
+}
+
+// openStream opens an event stream, trying up to Retries more nodes after the one at index attempt
+func (c *Client) openStream(ctx context.Context, attempt uint64, path string) (*http.Response, error) {
+	var err error
+	for i := uint64(0); i <= uint64(c.Retries); i++ {
+		node := c.nodes[(attempt+i)%uint64(len(c.nodes))]
+		var req *http.Request
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, node+path, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", "text/event-stream")
+		c.authorize(req)
+
+		// The stream lasts as long as ctx, so it mustn't be cut off by the client's timeout
+		streaming := *c.HTTPClient
+		streaming.Timeout = 0
+		var resp *http.Response
+		resp, err = streaming.Do(req)
+		if err != nil {
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			err = readError(resp)
+			resp.Body.Close()
+			continue
+		}
+		return resp, nil
+	}
+	return nil, fmt.Errorf("gossiper: failed to open %s: %w", strings.SplitN(path, "?", 2)[0], err)
+}
+
+// readEvents parses a Server-Sent Events stream into events until it ends
+func readEvents[T any](ctx context.Context, resp *http.Response, events chan<- T) {
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	var data strings.Builder
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			// A blank line ends the event
+			var event T
+			if data.Len() > 0 && json.Unmarshal([]byte(data.String()), &event) == nil {
+				select {
+				case events <- event:
+				case <-ctx.Done():
+					return
+				}
+			}
+			data.Reset()
+		case strings.HasPrefix(line, "data:"):
+			if data.Len() > 0 {
+				data.WriteByte('\n')
+			}
+			data.WriteString(strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+		// Event names repeat the type in the data, and comments are keep-alives
+	}
+}
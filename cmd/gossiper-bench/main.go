@@ -0,0 +1,269 @@
+// Command gossiper-bench measures how quickly a cluster converges under a write workload, and what it costs. It
+// starts a cluster of real nodes in one process, talking HTTP over loopback, writes to them at a steady rate, and
+// reports how long each write took to reach every node, how long the cluster took to settle after the last one,
+// and the bytes, peer messages and allocations spent per write. Unlike cmd/simulate it runs in real time on the real
+// network stack, so a change of gossip settings shows up as it would on a deployed cluster
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"math/rand"
+	"net"
+	"net/http"
+	"runtime"
+	"slices"
+	"sync"
+	"time"
+
+	"gmathur.dev/gossiper/gossip"
+	"gmathur.dev/gossiper/server"
+	"gmathur.dev/gossiper/transport"
+)
+
+func main() {
+	nodes := flag.Int("nodes", 10, "Number of nodes")
+	players := flag.Int("players", 1000, "Distinct players written to")
+	rate := flag.Float64("rate", 100, "Writes per second, spread over random nodes")
+	duration := flag.Duration("duration", 10*time.Second, "How long writes are made for")
+	timeout := flag.Duration("timeout", 2*time.Minute, "Give up waiting for the cluster to converge after the last write after this long")
+	seed := flag.Int64("seed", 1, "Seed for the nodes and players written to")
+	modeStr := flag.String("gossip-mode", string(server.GossipPush), "Gossip mode: push or push-pull")
+	interval := flag.Duration("gossip-interval", 2*time.Second, "Time between gossip rounds")
+	fanout := flag.Int("gossip-fanout", 1, "Peers gossiped with per round")
+	fullSyncEvery := flag.Int("full-sync-every", 10, "Full sync every N rounds to a peer (0 disables)")
+	digestSync := flag.Bool("digest-sync", false, "Reconcile full syncs with digests")
+	antiEntropy := flag.Duration("anti-entropy-interval", time.Minute, "Time between anti-entropy syncs (0 disables)")
+	codecName := flag.String("codec", "json", "Encoding for gossip messages: json, msgpack or protobuf")
+	compression := flag.String("compression", "snappy,gzip", "Encodings offered for compressing peer messages (empty disables)")
+	flag.Parse()
+
+	if *nodes < 2 || *players < 1 || *rate <= 0 {
+		log.Fatal("need at least 2 nodes, 1 player and a positive rate")
+	}
+	mode, err := server.ParseGossipMode(*modeStr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	codec, err := server.ParseCodec(*codecName)
+	if err != nil {
+		log.Fatal(err)
+	}
+	encodings, err := server.ParseEncodings(*compression)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	c, err := startCluster(*nodes, codec, func(gs *server.GameServer) {
+		gs.Mode = mode
+		gs.Gossip.Interval, gs.Gossip.Fanout = *interval, *fanout
+		gs.FullSyncEvery = *fullSyncEvery
+		gs.DigestSync = *digestSync
+		gs.AntiEntropy = server.AntiEntropyConfig{Interval: *antiEntropy}
+		gs.Compression.Encodings = encodings
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer c.stop()
+
+	before := c.meter.read()
+	var memBefore, memAfter runtime.MemStats
+	runtime.ReadMemStats(&memBefore)
+	start := time.Now()
+	writes := c.write(rand.New(rand.NewSource(*seed)), *players, *rate, *duration)
+	lastWrite := time.Now()
+	converged := c.waitConverged(*timeout)
+	settled := time.Since(lastWrite)
+	used := c.meter.read().sub(before)
+	elapsed := time.Since(start)
+	runtime.ReadMemStats(&memAfter)
+	allocs, allocBytes := int64(memAfter.Mallocs-memBefore.Mallocs), int64(memAfter.TotalAlloc-memBefore.TotalAlloc)
+
+	fmt.Printf("nodes=%d writes=%d players=%d rate=%g/s duration=%s mode=%s interval=%s fanout=%d codec=%s\n",
+		*nodes, writes, *players, *rate, *duration, mode, *interval, *fanout, *codecName)
+	if converged {
+		fmt.Printf("converged=true settled=%s after the last write\n", settled.Round(time.Millisecond))
+	} else {
+		fmt.Printf("converged=false after waiting %s\n", *timeout)
+	}
+	fmt.Println(c.tracker.summary())
+	fmt.Printf("bytes=%d bytes_per_write=%.0f bytes_per_second=%.0f\n", used.bytes, perWrite(used.bytes, writes),
+		float64(used.bytes)/elapsed.Seconds())
+	fmt.Printf("gossip_messages=%d sync_messages=%d probes=%d messages_per_write=%.2f\n", used.gossip, used.syncs,
+		used.probes, perWrite(used.gossip+used.syncs, writes))
+	// Counted over the whole process, so they take in the writes and the bench's own bookkeeping as well
+	fmt.Printf("allocs=%d allocs_per_message=%.0f alloc_bytes_per_message=%.0f\n", allocs,
+		perWrite(allocs, int(used.gossip+used.syncs)), perWrite(allocBytes, int(used.gossip+used.syncs)))
+}
+
+func perWrite(n int64, writes int) float64 {
+	if writes == 0 {
+		return 0
+	}
+	return float64(n) / float64(writes)
+}
+
+// cluster is a set of nodes serving HTTP on loopback ports
+type cluster struct {
+	nodes   []*server.GameServer
+	servers []*http.Server
+	meter   *meter
+	tracker *tracker
+	cancel  context.CancelFunc
+}
+
+// startCluster starts n fully meshed nodes sending gossip over HTTP in codec, configured by configure before they
+// start
+func startCluster(n int, codec server.Codec, configure func(gs *server.GameServer)) (*cluster, error) {
+	listeners := make([]net.Listener, n)
+	addrs := make([]string, n)
+	for i := range listeners {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return nil, err
+		}
+		listeners[i], addrs[i] = l, l.Addr().String()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &cluster{meter: &meter{}, tracker: newTracker(n), cancel: cancel}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	for i, addr := range addrs {
+		id := fmt.Sprintf("node-%d", i)
+		client := transport.NewPeerClient()
+		client.Codec = codec
+		gs := server.NewGameServer(id, addr, addrs, server.WithLogger(logger),
+			server.WithTransport(transport.NewHTTPGossipTransport(client)))
+		configure(gs)
+		gs.State.OnChange(c.tracker.observe)
+
+		srv := &http.Server{Handler: c.meter.handler(transport.NewServer(gs))}
+		go srv.Serve(c.meter.listener(listeners[i]))
+		gs.Start(ctx)
+		c.nodes = append(c.nodes, gs)
+		c.servers = append(c.servers, srv)
+	}
+	return c, nil
+}
+
+func (c *cluster) stop() {
+	c.cancel()
+	for i, gs := range c.nodes {
+		c.servers[i].Close()
+		gs.Close()
+	}
+}
+
+// write makes writes at the given rate for the given duration, each to a random player on a random node, and
+// returns how many it made
+func (c *cluster) write(rng *rand.Rand, players int, rate float64, duration time.Duration) int {
+	every := time.Duration(float64(time.Second) / rate)
+	start := time.Now()
+	n := 0
+	for ; time.Duration(n)*every < duration; n++ {
+		time.Sleep(time.Until(start.Add(time.Duration(n) * every)))
+		gs := c.nodes[rng.Intn(len(c.nodes))]
+		gs.UpdatePlayerScore(fmt.Sprintf("player-%d", rng.Intn(players)), int64(n))
+	}
+	return n
+}
+
+// waitConverged waits until every write has reached every node and the nodes hold the same entries, or timeout
+func (c *cluster) waitConverged(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if c.tracker.spreading() == 0 && c.converged() {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+// converged compares a one-bucket digest of every node's entries
+func (c *cluster) converged() bool {
+	first, _ := c.nodes[0].State.Digest(1)
+	for _, gs := range c.nodes[1:] {
+		if d, _ := gs.State.Digest(1); !slices.Equal(d, first) {
+			return false
+		}
+	}
+	return true
+}
+
+// tracker follows the winning write of each player until every node holds it, recording how long that took. A
+// write overwritten before it got everywhere is superseded, as some nodes may go straight to the later write, and
+// so is one made on a node that hadn't yet heard of a write it loses to
+type tracker struct {
+	nodes int
+
+	mu         sync.Mutex
+	pending    map[string]*pendingWrite // latest write of each player still spreading
+	latencies  []time.Duration
+	superseded int
+}
+
+type pendingWrite struct {
+	entry   gossip.Entry
+	at      time.Time
+	holders int
+}
+
+func newTracker(nodes int) *tracker {
+	return &tracker{nodes: nodes, pending: make(map[string]*pendingWrite)}
+}
+
+// observe is a gossip.Store OnChange observer on every node. A write is picked up as it is made, by the observer
+// of the node it is made on, so it is tracked before any gossip can carry it
+func (t *tracker) observe(ch gossip.Change) {
+	now := time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if ch.Source == gossip.ChangeLocal {
+		if w, ok := t.pending[ch.Key]; ok {
+			t.superseded++
+			if w.entry.After(ch.New) {
+				return
+			}
+		}
+		t.pending[ch.Key] = &pendingWrite{entry: ch.New, at: now, holders: 1}
+		return
+	}
+	w, ok := t.pending[ch.Key]
+	if !ok || ch.New.Clock != w.entry.Clock || ch.New.Origin != w.entry.Origin {
+		return
+	}
+	if w.holders++; w.holders == t.nodes {
+		t.latencies = append(t.latencies, now.Sub(w.at))
+		delete(t.pending, ch.Key)
+	}
+}
+
+// spreading returns how many writes haven't reached every node yet
+func (t *tracker) spreading() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.pending)
+}
+
+// summary reports percentiles of the time writes took to reach every node
+func (t *tracker) summary() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	latencies := slices.Clone(t.latencies)
+	slices.Sort(latencies)
+	if len(latencies) == 0 {
+		return fmt.Sprintf("propagated=0 superseded=%d", t.superseded)
+	}
+	p := func(q float64) time.Duration {
+		return latencies[min(int(q*float64(len(latencies))), len(latencies)-1)].Round(time.Millisecond)
+	}
+	return fmt.Sprintf("propagated=%d superseded=%d p50=%s p90=%s p99=%s max=%s", len(latencies), t.superseded,
+		p(0.5), p(0.9), p(0.99), latencies[len(latencies)-1].Round(time.Millisecond))
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"sync/atomic"
+)
+
+// meter counts the traffic between the nodes: bytes on the wire, HTTP headers included, and peer requests by
+// kind. Client writes are made in process, so everything it sees is the cluster talking to itself
+type meter struct {
+	bytes  atomic.Int64
+	gossip atomic.Int64 // pushes and push-pull exchanges
+	syncs  atomic.Int64 // full syncs, digest exchanges and anti-entropy
+	probes atomic.Int64 // failure detector pings
+}
+
+// traffic is a reading of a meter
+type traffic struct {
+	bytes, gossip, syncs, probes int64
+}
+
+func (m *meter) read() traffic {
+	return traffic{m.bytes.Load(), m.gossip.Load(), m.syncs.Load(), m.probes.Load()}
+}
+
+func (t traffic) sub(o traffic) traffic {
+	return traffic{t.bytes - o.bytes, t.gossip - o.gossip, t.syncs - o.syncs, t.probes - o.probes}
+}
+
+// handler counts the requests a node serves
+func (m *meter) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/gossip":
+			m.gossip.Add(1)
+		case "/sync", "/digest":
+			m.syncs.Add(1)
+		case "/ping", "/ping-req":
+			m.probes.Add(1)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// listener counts the bytes read and written on every connection a node accepts, which covers both directions
+// of each request
+func (m *meter) listener(l net.Listener) net.Listener {
+	return &meteredListener{Listener: l, m: m}
+}
+
+type meteredListener struct {
+	net.Listener
+	m *meter
+}
+
+func (l *meteredListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &meteredConn{Conn: conn, m: l.m}, nil
+}
+
+type meteredConn struct {
+	net.Conn
+	m *meter
+}
+
+func (c *meteredConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.m.bytes.Add(int64(n))
+	return n, err
+}
+
+func (c *meteredConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.m.bytes.Add(int64(n))
+	return n, err
+}
@@ -0,0 +1,622 @@
+// Command gossiperctl operates a gossiper cluster through a node's admin API: it lists members, shows a player's
+// state on every node, forces gossip rounds and full syncs, dumps and restores snapshots, and exports and imports
+// state
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"gmathur.dev/gossiper/client"
+	"gmathur.dev/gossiper/server"
+	"gmathur.dev/gossiper/transport"
+)
+
+const usage = `Usage: gossiperctl [flags] <command> [args]
+
+Commands:
+  status                         print the node's status as JSON
+  members                        list the cluster members as seen by the node
+  player <playerId>              show a player's state on the node and every alive peer
+  whois <playerId>               show which nodes hold a player's state
+  gossip                         run a gossip round now
+  sync [peer]                    run a full sync with one peer, or with every alive peer
+  snapshot dump [file]           write the node's state to file, or to stdout
+  snapshot restore <file>        merge a snapshot into the cluster through the node ("-" reads stdin)
+  export [file]                  stream the node's state to file, or to stdout, as an export
+  import <file>                  merge an export into the cluster through the node ("-" reads stdin)
+  chaos [off | key=value...]     show, turn off or set the faults injected into the node's peer traffic, e.g.
+                                 chaos drop=0.2 delay=100ms jitter=50ms corrupt=0.01 isolate=host:port,host:port
+  rooms [all | room...]          show the rooms the node holds, make it hold every room, or only the given ones,
+                                 e.g. rooms lobby match-*
+  leases [break <name>]          list the leases that are held, or break one whoever holds it
+  settings [key=value...]        list the cluster-wide settings, after setting the given ones, e.g.
+                                 settings gossip-interval=500ms new-matchmaker=on
+  settings unset <key>           remove a cluster-wide setting
+  clocks                         show how far the peers' clocks are from the node's
+  decommission [replicas=N] [force]
+                                 hand the node's state to N peers (2 by default), leave the cluster and shut the node
+                                 down; force goes ahead on a node without alive peers
+
+Flags:
+`
+
+func main() {
+	addr := flag.String("addr", "localhost:8081", "Node to talk to: host:port, or a URL such as https://host:port")
+	timeout := flag.Duration("timeout", 30*time.Second, "How long to wait for the node")
+	format := flag.String("format", "json", "Snapshot and export format: json or gob")
+	prefix := flag.String("prefix", "", "Only export players whose ID starts with this prefix")
+	token := flag.String("token", "", "API key or JWT for nodes that require one to read player state or whois")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	base, err := baseURL(*addr)
+	if err != nil {
+		fatal(err)
+	}
+	ctl := &ctl{base: base, http: &http.Client{Timeout: *timeout}, format: *format, prefix: *prefix,
+		token: *token}
+	ctx := context.Background()
+
+	args := flag.Args()
+	switch cmd := args[0]; {
+	case cmd == "status" && len(args) == 1:
+		err = ctl.status(ctx)
+	case cmd == "members" && len(args) == 1:
+		err = ctl.members(ctx)
+	case cmd == "player" && len(args) == 2:
+		err = ctl.player(ctx, args[1])
+	case cmd == "whois" && len(args) == 2:
+		err = ctl.whois(ctx, args[1])
+	case cmd == "gossip" && len(args) == 1:
+		err = ctl.call(ctx, http.MethodPost, "/admin/gossip", nil, nil)
+	case cmd == "sync" && len(args) <= 2:
+		peer := ""
+		if len(args) == 2 {
+			peer = args[1]
+		}
+		err = ctl.sync(ctx, peer)
+	case cmd == "snapshot" && len(args) >= 2 && args[1] == "dump" && len(args) <= 3:
+		file := "-"
+		if len(args) == 3 {
+			file = args[2]
+		}
+		err = ctl.dump(ctx, file)
+	case cmd == "snapshot" && len(args) == 3 && args[1] == "restore":
+		err = ctl.restore(ctx, args[2])
+	case cmd == "export" && len(args) <= 2:
+		file := "-"
+		if len(args) == 2 {
+			file = args[1]
+		}
+		err = ctl.export(ctx, file)
+	case cmd == "import" && len(args) == 2:
+		err = ctl.importState(ctx, args[1])
+	case cmd == "chaos":
+		err = ctl.chaos(ctx, args[1:])
+	case cmd == "rooms":
+		err = ctl.rooms(ctx, args[1:])
+	case cmd == "leases" && len(args) == 1:
+		err = ctl.leases(ctx)
+	case cmd == "leases" && len(args) == 3 && args[1] == "break":
+		err = ctl.call(ctx, http.MethodDelete, "/admin/leases?name="+url.QueryEscape(args[2]), nil, nil)
+	case cmd == "settings":
+		err = ctl.settings(ctx, args[1:])
+	case cmd == "clocks" && len(args) == 1:
+		err = ctl.clocks(ctx)
+	case cmd == "decommission":
+		err = ctl.decommission(ctx, args[1:])
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fatal(err)
+	}
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "gossiperctl:", err)
+	os.Exit(1)
+}
+
+func baseURL(addr string) (*url.URL, error) {
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	u, err := url.Parse(addr)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid node address %q", addr)
+	}
+	return u, nil
+}
+
+type ctl struct {
+	base   *url.URL
+	http   *http.Client
+	format string
+	prefix string // players the export command is limited to
+	token  string // for the client API, which the player command reads from, and /whois
+}
+
+// nodeURL returns the base URL of another node, reached with the same scheme as the one we talk to
+func (c *ctl) nodeURL(addr string) string {
+	return c.base.Scheme + "://" + addr
+}
+
+// call sends a request to the node and decodes a JSON response into out if it isn't nil
+func (c *ctl) call(ctx context.Context, method, path string, body io.Reader, out any) error {
+	resp, err := c.send(ctx, method, path, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// do sends a request to the node. path may include a query string
+func (c *ctl) do(ctx context.Context, method, path string, body io.Reader) (*http.Response, error) {
+	path, query, _ := strings.Cut(path, "?")
+	u := c.base.JoinPath(path)
+	u.RawQuery = query
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	return c.http.Do(req)
+}
+
+// send sends a request to the node, turning error responses into errors. The caller closes the body
+func (c *ctl) send(ctx context.Context, method, path string, body io.Reader) (*http.Response, error) {
+	resp, err := c.do(ctx, method, path, body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		return nil, responseError(method, path, resp)
+	}
+	return resp, nil
+}
+
+// responseError turns an error response, JSON from a node but possibly plain text from a proxy, into an error
+func responseError(method, path string, resp *http.Response) error {
+	var apiErr transport.APIError
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if json.Unmarshal(data, &apiErr) != nil || apiErr.Message == "" {
+		apiErr.Message = strings.TrimSpace(string(data))
+	}
+	return fmt.Errorf("%s %s: %s (status %d)", method, path, apiErr.Message, resp.StatusCode)
+}
+
+func (c *ctl) status(ctx context.Context) error {
+	var st server.Status
+	if err := c.call(ctx, http.MethodGet, "/admin/status", nil, &st); err != nil {
+		return err
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(st)
+}
+
+func (c *ctl) members(ctx context.Context) error {
+	var st server.Status
+	if err := c.call(ctx, http.MethodGet, "/admin/status", nil, &st); err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ADDRESS\tSTATUS\tINCARNATION\tLAST CONTACT\tROUNDS\tFAILURES\tPROTOCOL\tBREAKER\tMETA")
+	fmt.Fprintf(tw, "%s\t%s\t-\t-\t%d\t%d\t%s\t-\t%s\t(self, %s)\n", st.Address, server.MemberAlive, st.Gossip.Rounds,
+		st.Gossip.Failures, st.Protocols, formatMeta(st.Meta), st.ID)
+	for _, p := range st.Peers {
+		protocol := "-"
+		if p.Protocol > 0 {
+			protocol = strconv.Itoa(int(p.Protocol))
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%d\t%d\t%s\t%s\t%s\n", p.Address, p.Status, p.Incarnation, since(p.LastContact),
+			p.Rounds, p.Failures, protocol, p.Breaker, formatMeta(p.Meta))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if st.Leader != "" {
+		fmt.Printf("\nLeader: %s\n", st.Leader)
+	}
+	if p := st.Partition; p != nil {
+		fmt.Printf("\nPartitioned since %s: %d of %d members reachable\n", since(p.Since), p.Reachable, p.Members)
+	}
+	return nil
+}
+
+// player shows a player's state on every node the one we talk to believes is alive, so divergence between nodes
+// stands out
+func (c *ctl) player(ctx context.Context, playerId string) error {
+	var st server.Status
+	if err := c.call(ctx, http.MethodGet, "/admin/status", nil, &st); err != nil {
+		return err
+	}
+	nodes := []string{st.Address}
+	for _, p := range st.Peers {
+		if p.Status == server.MemberAlive {
+			nodes = append(nodes, p.Address)
+		}
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NODE\tSCORE\tCLOCK\tORIGIN\tUPDATED\tTTL\tATTRIBUTES")
+	for _, node := range nodes {
+		addr := c.nodeURL(node)
+		if node == st.Address {
+			addr = c.base.String()
+		}
+		cl, err := client.New(addr)
+		if err != nil {
+			return err
+		}
+		cl.HTTPClient, cl.Retries, cl.Token = c.http, 0, c.token
+
+		p, err := cl.GetPlayer(ctx, playerId)
+		switch {
+		case errors.Is(err, client.ErrNotFound):
+			fmt.Fprintf(tw, "%s\t-\t-\t-\t-\t-\t-\tnot found\n", node)
+		case err != nil:
+			fmt.Fprintf(tw, "%s\t-\t-\t-\t-\t-\t-\t%v\n", node, err)
+		default:
+			ttl := "-"
+			if p.TTL > 0 {
+				ttl = (time.Duration(p.TTL) * time.Second).String()
+			}
+			fmt.Fprintf(tw, "%s\t%d\t%d\t%s\t%s\t%s\t%s\n", node, p.Score, p.Clock, p.Origin,
+				since(time.Unix(0, p.Timestamp)), ttl, formatAttributes(p.Attributes))
+		}
+	}
+	return tw.Flush()
+}
+
+// formatAttributes lists attributes as name=value, by name
+func formatAttributes(attrs map[string]json.RawMessage) string {
+	if len(attrs) == 0 {
+		return "-"
+	}
+	var parts []string
+	for _, name := range slices.Sorted(maps.Keys(attrs)) {
+		parts = append(parts, name+"="+string(attrs[name]))
+	}
+	return strings.Join(parts, ",")
+}
+
+// whois lists the nodes holding a player's shard, by the node's placement and by what the nodes advertise
+func (c *ctl) whois(ctx context.Context, playerId string) error {
+	var o server.PlayerOwnership
+	if err := c.call(ctx, http.MethodGet, "/whois/"+url.PathEscape(playerId), nil, &o); err != nil {
+		return err
+	}
+	if o.Shards == 0 {
+		fmt.Printf("Not sharded: every node holds %s\n", o.PlayerId)
+	} else {
+		fmt.Printf("Shard %d of %d\n", o.Shard, o.Shards)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NODE\tROLE\tADVERTISED")
+	nodes := slices.Clone(o.Owners)
+	for _, node := range o.Advertised {
+		if !slices.Contains(nodes, node) {
+			nodes = append(nodes, node)
+		}
+	}
+	for _, node := range nodes {
+		role := "-"
+		if i := slices.Index(o.Owners, node); i == 0 && o.Shards > 0 {
+			role = "owner"
+		} else if i >= 0 {
+			role = "replica"
+		}
+		advertised := "-"
+		if o.Shards > 0 {
+			advertised = strconv.FormatBool(slices.Contains(o.Advertised, node))
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", node, role, advertised)
+	}
+	return tw.Flush()
+}
+
+func (c *ctl) sync(ctx context.Context, peer string) error {
+	path := "/admin/sync"
+	if peer != "" {
+		path += "?peer=" + url.QueryEscape(peer)
+	}
+	resp, err := c.do(ctx, http.MethodPost, path, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// A sync that failed with some peers still reports the others
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusBadGateway {
+		return responseError(http.MethodPost, path, resp)
+	}
+	var result transport.SyncResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("POST %s: %w", path, err)
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "PEER\tRESULT")
+	for peer, r := range result.Peers {
+		fmt.Fprintf(tw, "%s\t%s\n", peer, r)
+	}
+	tw.Flush()
+	if resp.StatusCode != http.StatusOK {
+		return errors.New("sync failed with some peers")
+	}
+	return nil
+}
+
+func (c *ctl) dump(ctx context.Context, file string) error {
+	return c.download(ctx, "/admin/snapshot?format="+url.QueryEscape(c.format), file)
+}
+
+// download writes the body of a GET to file, or to stdout for "-"
+func (c *ctl) download(ctx context.Context, path, file string) error {
+	resp, err := c.send(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if file == "-" {
+		_, err = io.Copy(os.Stdout, resp.Body)
+		return err
+	}
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (c *ctl) restore(ctx context.Context, file string) error {
+	body, err := openInput(file)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	var result transport.RestoreResult
+	if err := c.call(ctx, http.MethodPost, "/admin/snapshot?format="+url.QueryEscape(c.format), body, &result); err != nil {
+		return err
+	}
+	fmt.Printf("restored %d entries\n", result.Restored)
+	return nil
+}
+
+func (c *ctl) export(ctx context.Context, file string) error {
+	query := url.Values{"format": {c.format}}
+	if c.prefix != "" {
+		query.Set("prefix", c.prefix)
+	}
+	return c.download(ctx, "/admin/export?"+query.Encode(), file)
+}
+
+func (c *ctl) importState(ctx context.Context, file string) error {
+	body, err := openInput(file)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	var result transport.ImportResult
+	if err := c.call(ctx, http.MethodPost, "/admin/import?format="+url.QueryEscape(c.format), body, &result); err != nil {
+		return err
+	}
+	fmt.Printf("imported %d entries\n", result.Imported)
+	return nil
+}
+
+// chaos prints the faults the node injects, after turning them off with "off" or replacing them with key=value
+// settings
+func (c *ctl) chaos(ctx context.Context, args []string) error {
+	method, path := http.MethodGet, "/admin/chaos"
+	switch {
+	case len(args) == 1 && args[0] == "off":
+		method = http.MethodDelete
+	case len(args) > 0:
+		query := url.Values{}
+		for _, arg := range args {
+			key, value, ok := strings.Cut(arg, "=")
+			if !ok {
+				return fmt.Errorf("chaos setting %q is not key=value", arg)
+			}
+			query.Add(key, value)
+		}
+		method, path = http.MethodPost, path+"?"+query.Encode()
+	}
+
+	var settings transport.ChaosSettings
+	if err := c.call(ctx, method, path, nil, &settings); err != nil {
+		return err
+	}
+	isolate := "-"
+	if len(settings.Isolate) > 0 {
+		isolate = strings.Join(settings.Isolate, ",")
+	}
+	fmt.Printf("drop=%g delay=%s jitter=%s corrupt=%g isolate=%s\n", settings.Drop, settings.Delay, settings.Jitter,
+		settings.Corrupt, isolate)
+	return nil
+}
+
+// rooms prints the rooms the node holds, after making it hold every room with "all" or only the given ones
+func (c *ctl) rooms(ctx context.Context, args []string) error {
+	method, path := http.MethodGet, "/admin/rooms"
+	switch {
+	case len(args) == 1 && args[0] == "all":
+		method = http.MethodDelete
+	case len(args) > 0:
+		method, path = http.MethodPost, path+"?rooms="+url.QueryEscape(strings.Join(args, ","))
+	}
+
+	var settings transport.RoomSettings
+	if err := c.call(ctx, method, path, nil, &settings); err != nil {
+		return err
+	}
+	if len(settings.Rooms) == 0 {
+		fmt.Println("every room")
+		return nil
+	}
+	fmt.Println(strings.Join(settings.Rooms, " "))
+	return nil
+}
+
+// clocks prints the skew between the node's clock and its peers'
+func (c *ctl) clocks(ctx context.Context) error {
+	var report server.ClockReport
+	if err := c.call(ctx, http.MethodGet, "/admin/clocks", nil, &report); err != nil {
+		return err
+	}
+	fmt.Printf("Cluster offset: %s, correction: %s\n", report.Offset, report.Correction)
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "PEER\tOFFSET\tRTT\tSAMPLES\tUPDATED\tSKEWED")
+	for _, p := range report.Peers {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\t%t\n", p.Address, p.Offset, p.RTT, p.Samples, since(p.Updated), p.Skewed)
+	}
+	return tw.Flush()
+}
+
+// leases lists the leases that are held, as far as the node knows
+func (c *ctl) leases(ctx context.Context) error {
+	var leases []server.Lease
+	if err := c.call(ctx, http.MethodGet, "/admin/leases", nil, &leases); err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tHOLDER\tNODE\tTOKEN\tACQUIRED\tEXPIRES")
+	for _, l := range leases {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\t%s\n", l.Name, l.Holder, l.Node, l.Token, since(l.Acquired),
+			"in "+time.Until(l.Expires).Round(time.Second).String())
+	}
+	return tw.Flush()
+}
+
+// settings sets the given cluster-wide settings, or removes one with "unset", and then lists them all
+func (c *ctl) settings(ctx context.Context, args []string) error {
+	if len(args) == 2 && args[0] == "unset" {
+		if err := c.call(ctx, http.MethodDelete, "/admin/settings?key="+url.QueryEscape(args[1]), nil, nil); err != nil {
+			return err
+		}
+		args = nil
+	}
+	for _, arg := range args {
+		key, value, ok := strings.Cut(arg, "=")
+		if !ok {
+			return fmt.Errorf("setting %q is not key=value", arg)
+		}
+		query := url.Values{"key": {key}, "value": {value}}
+		if err := c.call(ctx, http.MethodPost, "/admin/settings?"+query.Encode(), nil, nil); err != nil {
+			return err
+		}
+	}
+
+	var settings []server.Setting
+	if err := c.call(ctx, http.MethodGet, "/admin/settings", nil, &settings); err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY\tVALUE\tORIGIN\tUPDATED")
+	for _, s := range settings {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", s.Key, s.Value, s.Origin, since(s.Updated))
+	}
+	return tw.Flush()
+}
+
+// decommission takes the node out of the cluster for good and prints the peers it handed its state to
+func (c *ctl) decommission(ctx context.Context, args []string) error {
+	query := url.Values{}
+	for _, arg := range args {
+		switch key, value, _ := strings.Cut(arg, "="); {
+		case arg == "force":
+			query.Set("force", "true")
+		case key == "replicas":
+			query.Set("replicas", value)
+		default:
+			return fmt.Errorf("unknown decommission option %q", arg)
+		}
+	}
+
+	path := "/admin/decommission"
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+	var report server.DecommissionReport
+	if err := c.call(ctx, http.MethodPost, path, nil, &report); err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "PEER\tHANDOFF")
+	for _, peer := range slices.Sorted(maps.Keys(report.Peers)) {
+		fmt.Fprintf(tw, "%s\t%s\n", peer, report.Peers[peer])
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	fmt.Println("Decommissioned; the node is shutting down")
+	return nil
+}
+
+// openInput opens file for reading, or stdin for "-"
+func openInput(file string) (io.ReadCloser, error) {
+	if file == "-" {
+		return io.NopCloser(os.Stdin), nil
+	}
+	return os.Open(file)
+}
+
+// since formats how long ago t was, or "-" for the zero time
+// formatMeta prints metadata as sorted key=value pairs
+func formatMeta(meta map[string]string) string {
+	if len(meta) == 0 {
+		return "-"
+	}
+	pairs := make([]string, 0, len(meta))
+	for _, key := range slices.Sorted(maps.Keys(meta)) {
+		pairs = append(pairs, key+"="+meta[key])
+	}
+	return strings.Join(pairs, ",")
+}
+
+func since(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return time.Since(t).Round(time.Second).String() + " ago"
+}
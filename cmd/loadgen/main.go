@@ -0,0 +1,213 @@
+// Command loadgen sends score updates to a gossiper cluster through its HTTP API, from several concurrent
+// workers, at a fixed rate or as fast as the nodes answer, and reports throughput, errors and latency percentiles.
+// Run it against a cluster to see how many writes it takes and how gossip settings hold up under realistic load
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"maps"
+	"math/rand"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"gmathur.dev/gossiper/client"
+)
+
+func main() {
+	addrs := flag.String("addrs", "localhost:8081", "Comma separated nodes to send updates to, round-robin: host:port or URLs")
+	concurrency := flag.Int("concurrency", 16, "Updates in flight at once")
+	rate := flag.Float64("rate", 0, "Updates per second across all workers (0 sends as fast as the nodes answer)")
+	duration := flag.Duration("duration", 30*time.Second, "How long to send updates for")
+	requests := flag.Int("requests", 0, "Stop after this many updates, if sooner than -duration (0 doesn't limit)")
+	players := flag.Int("players", 10000, "Distinct players updated, each update picks one at random")
+	prefix := flag.String("player-prefix", "player-", "Prefix of the player IDs updated")
+	timeout := flag.Duration("timeout", 5*time.Second, "How long one update may take before it counts as failed")
+	token := flag.String("token", "", "API key or JWT for nodes that require one")
+	reportEvery := flag.Duration("report-interval", 5*time.Second, "How often progress is printed (0 only prints the summary)")
+	seed := flag.Int64("seed", 1, "Seed for the players and scores sent")
+	flag.Parse()
+
+	if *concurrency < 1 || *players < 1 || *rate < 0 {
+		log.Fatal("need a concurrency and players of at least 1, and a rate that isn't negative")
+	}
+	c, err := client.New(strings.Split(*addrs, ",")...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// Every update is sent once, to one node, so that latencies and errors are the nodes' own
+	c.Retries = 0
+	c.Token = *token
+	c.HTTPClient = &http.Client{
+		Timeout:   *timeout,
+		Transport: &http.Transport{MaxIdleConnsPerHost: *concurrency, MaxConnsPerHost: *concurrency},
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	ctx, cancel := context.WithTimeout(ctx, *duration)
+	defer cancel()
+
+	g := &generator{client: c, players: *players, prefix: *prefix, limit: int64(*requests)}
+	results := make(chan []sample, *concurrency)
+	due := g.schedule(ctx, *rate, *concurrency)
+	start := time.Now()
+	for i := range *concurrency {
+		go func() { results <- g.worker(ctx, rand.New(rand.NewSource(*seed+int64(i))), due) }()
+	}
+	if *reportEvery > 0 {
+		go g.progress(ctx, *reportEvery)
+	}
+
+	var samples []sample
+	for range *concurrency {
+		samples = append(samples, <-results...)
+	}
+	report(samples, time.Since(start))
+}
+
+// sample is the outcome of one update
+type sample struct {
+	latency time.Duration
+	err     string // "" for an update that succeeded, otherwise its status code or "timeout" or "network"
+}
+
+type generator struct {
+	client  *client.Client
+	players int
+	prefix  string
+	limit   int64 // updates to send in all, 0 for no limit
+
+	sent   atomic.Int64
+	failed atomic.Int64
+}
+
+// schedule hands out the time each update is due. At a fixed rate the times keep to the schedule even while every
+// worker is busy, so an update's latency includes the time it waited for one, as a real client's would, instead
+// of the slowdown hiding in a lower rate. With no rate every update is due as soon as a worker takes it, which is
+// handed out as the zero time
+func (g *generator) schedule(ctx context.Context, rate float64, concurrency int) <-chan time.Time {
+	due := make(chan time.Time, concurrency)
+	go func() {
+		defer close(due)
+		var every time.Duration
+		if rate > 0 {
+			every = time.Duration(float64(time.Second) / rate)
+		}
+		start := time.Now()
+		for n := int64(0); g.limit == 0 || n < g.limit; n++ {
+			var at time.Time
+			if every > 0 {
+				at = start.Add(time.Duration(n) * every)
+				select {
+				case <-time.After(time.Until(at)):
+				case <-ctx.Done():
+					return
+				}
+			}
+			select {
+			case due <- at:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return due
+}
+
+// worker sends an update for each due time until they run out or ctx is done, returning how each went
+func (g *generator) worker(ctx context.Context, rng *rand.Rand, due <-chan time.Time) []sample {
+	var samples []sample
+	for at := range due {
+		if ctx.Err() != nil {
+			break
+		}
+		if at.IsZero() {
+			at = time.Now()
+		}
+		playerId := g.prefix + strconv.Itoa(rng.Intn(g.players))
+		err := g.client.UpdateScore(context.WithoutCancel(ctx), playerId, rng.Int63n(1_000_000))
+		s := sample{latency: time.Since(at), err: errorClass(err)}
+		g.sent.Add(1)
+		if s.err != "" {
+			g.failed.Add(1)
+		}
+		samples = append(samples, s)
+	}
+	return samples
+}
+
+// errorClass sorts failed updates by the status the node answered with, or by why there was no answer
+func errorClass(err error) string {
+	var apiErr *client.APIError
+	switch {
+	case err == nil:
+		return ""
+	case errors.As(err, &apiErr):
+		return strconv.Itoa(apiErr.StatusCode)
+	case errors.Is(err, context.DeadlineExceeded) || strings.Contains(err.Error(), "Client.Timeout"):
+		return "timeout"
+	default:
+		return "network"
+	}
+}
+
+// progress prints the updates sent and failed every interval until ctx is done
+func (g *generator) progress(ctx context.Context, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	var lastSent int64
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		sent, failed := g.sent.Load(), g.failed.Load()
+		fmt.Printf("sent=%d failed=%d rate=%.0f/s\n", sent, failed, float64(sent-lastSent)/every.Seconds())
+		lastSent = sent
+	}
+}
+
+func report(samples []sample, elapsed time.Duration) {
+	errs := make(map[string]int)
+	var latencies []time.Duration
+	var total time.Duration
+	for _, s := range samples {
+		if s.err != "" {
+			errs[s.err]++
+			continue
+		}
+		latencies = append(latencies, s.latency)
+		total += s.latency
+	}
+
+	fmt.Printf("updates=%d ok=%d failed=%d elapsed=%s throughput=%.0f/s\n", len(samples), len(latencies),
+		len(samples)-len(latencies), elapsed.Round(time.Millisecond), float64(len(latencies))/elapsed.Seconds())
+	if len(errs) > 0 {
+		var parts []string
+		for _, class := range slices.Sorted(maps.Keys(errs)) {
+			parts = append(parts, fmt.Sprintf("%s=%d", class, errs[class]))
+		}
+		fmt.Println("errors: " + strings.Join(parts, " "))
+	}
+	if len(latencies) == 0 {
+		return
+	}
+	slices.Sort(latencies)
+	p := func(q float64) time.Duration {
+		return latencies[min(int(q*float64(len(latencies))), len(latencies)-1)].Round(time.Microsecond)
+	}
+	fmt.Printf("latency: mean=%s p50=%s p90=%s p99=%s p99.9=%s max=%s\n",
+		(total / time.Duration(len(latencies))).Round(time.Microsecond), p(0.5), p(0.9), p(0.99), p(0.999),
+		latencies[len(latencies)-1].Round(time.Microsecond))
+}
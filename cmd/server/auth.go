@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"time"
+
+	"gmathur.dev/gossiper/transport"
+)
+
+// clientAuthenticator builds the client API authenticator from the auth flags, or returns nil if none are set.
+// JWTs are checked first, so a malformed token is reported as such rather than as an unknown API key
+func clientAuthenticator(apiKeysFile, jwtSecretFile, jwtPublicKeyFile, issuer, audience string) (transport.Authenticator, error) {
+	var auths []transport.Authenticator
+	if jwtSecretFile != "" || jwtPublicKeyFile != "" {
+		jwt := &transport.JWT{Issuer: issuer, Audience: audience, Leeway: 30 * time.Second}
+		if jwtSecretFile != "" {
+			secret, err := os.ReadFile(jwtSecretFile)
+			if err != nil {
+				return nil, err
+			}
+			if jwt.Secret = bytes.TrimSpace(secret); len(jwt.Secret) == 0 {
+				return nil, errors.New("empty -jwt-secret-file")
+			}
+		}
+		if jwtPublicKeyFile != "" {
+			key, err := transport.LoadJWTPublicKey(jwtPublicKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			jwt.PublicKey = key
+		}
+		auths = append(auths, jwt)
+	} else if issuer != "" || audience != "" {
+		return nil, errors.New("-jwt-issuer and -jwt-audience need -jwt-secret-file or -jwt-public-key-file")
+	}
+	if apiKeysFile != "" {
+		keys, err := transport.LoadAPIKeys(apiKeysFile)
+		if err != nil {
+			return nil, err
+		}
+		auths = append(auths, keys)
+	}
+
+	switch len(auths) {
+	case 0:
+		return nil, nil
+	case 1:
+		return auths[0], nil
+	default:
+		return transport.AnyOf(auths...), nil
+	}
+}
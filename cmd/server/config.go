@@ -0,0 +1,159 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// loadOptions fills in every flag not given on the command line, first from the config file, if any, and then
+// from GOSSIPER_ environment variables, so the precedence is flags, then environment, then config file. It
+// returns the flags given on the command line, for reloadOptions
+func loadOptions(fs *flag.FlagSet, configFile string) (map[string]bool, error) {
+	fromCommandLine := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { fromCommandLine[f.Name] = true })
+	return fromCommandLine, applyOptions(fs, configFile, fromCommandLine)
+}
+
+// reloadOptions sets every flag not given on the command line back to its default and fills them in again from
+// the config file and environment, so that an option taken out of the file reverts as well. It returns the flags
+// whose values changed, sorted. If the file can't be read every flag is left as it was
+func reloadOptions(fs *flag.FlagSet, configFile string, fromCommandLine map[string]bool) ([]string, error) {
+	before := flagValues(fs)
+	fs.VisitAll(func(f *flag.Flag) {
+		if !fromCommandLine[f.Name] {
+			f.Value.Set(f.DefValue)
+		}
+	})
+	if err := applyOptions(fs, configFile, fromCommandLine); err != nil {
+		fs.VisitAll(func(f *flag.Flag) { f.Value.Set(before[f.Name]) })
+		return nil, err
+	}
+
+	var changed []string
+	for name, value := range flagValues(fs) {
+		if value != before[name] {
+			changed = append(changed, name)
+		}
+	}
+	sort.Strings(changed)
+	return changed, nil
+}
+
+func flagValues(fs *flag.FlagSet) map[string]string {
+	values := make(map[string]string)
+	fs.VisitAll(func(f *flag.Flag) { values[f.Name] = f.Value.String() })
+	return values
+}
+
+// applyOptions sets the flags not given on the command line from the config file, if any, and the environment
+func applyOptions(fs *flag.FlagSet, configFile string, fromCommandLine map[string]bool) error {
+	if configFile != "" {
+		options, err := readConfig(configFile)
+		if err != nil {
+			return err
+		}
+		for _, name := range sortedKeys(options) {
+			if fs.Lookup(name) == nil {
+				return fmt.Errorf("%s: unknown option %q", configFile, name)
+			}
+			if fromCommandLine[name] {
+				continue
+			}
+			if err := fs.Set(name, options[name]); err != nil {
+				return fmt.Errorf("%s: invalid value %q for %s: %w", configFile, options[name], name, err)
+			}
+		}
+	}
+	return applyEnv(fs, fromCommandLine)
+}
+
+// readConfig parses a config file into flag values. Nested sections are joined to their keys with a dash, so
+//
+//	gossip:
+//	  fanout: 3
+//
+// sets -gossip-fanout, and lists are joined with commas, so peers can be given as a list
+func readConfig(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc map[string]any
+	switch ext := filepath.Ext(path); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &doc)
+	case ".toml":
+		err = toml.Unmarshal(data, &doc)
+	default:
+		return nil, fmt.Errorf("unsupported config file type %q", ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	options := make(map[string]string)
+	if err := flattenConfig("", doc, options); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return options, nil
+}
+
+func flattenConfig(prefix string, doc map[string]any, options map[string]string) error {
+	for key, value := range doc {
+		name := key
+		if prefix != "" {
+			name = prefix + "-" + key
+		}
+
+		switch v := value.(type) {
+		case map[string]any:
+			if err := flattenConfig(name, v, options); err != nil {
+				return err
+			}
+		case []any:
+			items := make([]string, len(v))
+			for i, item := range v {
+				items[i] = fmt.Sprint(item)
+			}
+			options[name] = strings.Join(items, ",")
+		case nil:
+		default:
+			options[name] = fmt.Sprint(v)
+		}
+	}
+	return nil
+}
+
+// applyEnv sets every flag not given on the command line from its GOSSIPER_ environment variable, if there is
+// one: -gossip-fanout is read from GOSSIPER_GOSSIP_FANOUT, and so on
+func applyEnv(fs *flag.FlagSet, fromCommandLine map[string]bool) error {
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		name := "GOSSIPER_" + strings.ToUpper(strings.ReplaceAll(f.Name, "-", "_"))
+		value, ok := os.LookupEnv(name)
+		if !ok || fromCommandLine[f.Name] || err != nil {
+			return
+		}
+		if setErr := fs.Set(f.Name, value); setErr != nil {
+			err = fmt.Errorf("invalid value %q for %s: %w", value, name, setErr)
+		}
+	})
+	return err
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gmathur.dev/gossiper/discovery"
+	"gmathur.dev/gossiper/server"
+)
+
+// discoveryFlags are the flags of every discovery provider
+type discoveryFlags struct {
+	name     *string
+	interval *time.Duration
+	port     *int
+
+	dnsName      *string
+	k8sSelector  *string
+	k8sNamespace *string
+	ec2Tag       *string
+	ec2Region    *string
+	gceGroup     *string
+	gceProject   *string
+	gceZone      *string
+	mdnsCluster  *string
+	mdnsIface    *string
+}
+
+// discoveryProviders builds each provider from its flags; port is the port nodes serve gossip on
+var discoveryProviders = map[string]func(f *discoveryFlags, port int) (server.Discoverer, error){
+	"dns": func(f *discoveryFlags, _ int) (server.Discoverer, error) {
+		if *f.dnsName == "" {
+			return nil, errors.New("-discovery=dns needs -discovery-dns-name")
+		}
+		return &discovery.DNS{Name: *f.dnsName}, nil
+	},
+	"kubernetes": func(f *discoveryFlags, port int) (server.Discoverer, error) {
+		if *f.k8sSelector == "" {
+			return nil, errors.New("-discovery=kubernetes needs -discovery-k8s-selector")
+		}
+		return discovery.NewKubernetes(*f.k8sNamespace, *f.k8sSelector, port)
+	},
+	"ec2": func(f *discoveryFlags, port int) (server.Discoverer, error) {
+		if *f.ec2Tag == "" {
+			return nil, errors.New("-discovery=ec2 needs -discovery-ec2-tag")
+		}
+		key, value, _ := strings.Cut(*f.ec2Tag, "=")
+		return &discovery.EC2{TagKey: key, TagValue: value, Port: port, Region: *f.ec2Region}, nil
+	},
+	"gce": func(f *discoveryFlags, port int) (server.Discoverer, error) {
+		if *f.gceGroup == "" {
+			return nil, errors.New("-discovery=gce needs -discovery-gce-group")
+		}
+		return &discovery.GCE{Group: *f.gceGroup, Port: port, Project: *f.gceProject, Zone: *f.gceZone}, nil
+	},
+	"mdns": func(f *discoveryFlags, _ int) (server.Discoverer, error) {
+		d := &discovery.MDNS{Cluster: *f.mdnsCluster}
+		if *f.mdnsIface != "" {
+			iface, err := net.InterfaceByName(*f.mdnsIface)
+			if err != nil {
+				return nil, fmt.Errorf("invalid -discovery-mdns-interface: %w", err)
+			}
+			d.Interface = iface
+		}
+		return d, nil
+	},
+}
+
+func registerDiscoveryFlags(fs *flag.FlagSet) *discoveryFlags {
+	names := make([]string, 0, len(discoveryProviders))
+	for name := range discoveryProviders {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	return &discoveryFlags{
+		name:     fs.String("discovery", "", "Discover peers from an external registry: "+strings.Join(names, ", ")),
+		interval: fs.Duration("discovery-interval", 30*time.Second, "How often the discovery registry is polled"),
+		port:     fs.Int("discovery-port", 0, "Port discovered nodes serve gossip on, for registries that only list IPs (default: the port of -addr)"),
+
+		dnsName:      fs.String("discovery-dns-name", "", "DNS name to resolve for -discovery=dns: host:port for A/AAAA records, or an SRV name"),
+		k8sSelector:  fs.String("discovery-k8s-selector", "", "Label selector of the pods to gossip with for -discovery=kubernetes"),
+		k8sNamespace: fs.String("discovery-k8s-namespace", "", "Namespace to list pods in (default: the pod's own)"),
+		ec2Tag:       fs.String("discovery-ec2-tag", "", "Tag of the instances to gossip with for -discovery=ec2, as key=value or just key"),
+		ec2Region:    fs.String("discovery-ec2-region", "", "Region to list instances in (default: the instance's own)"),
+		gceGroup:     fs.String("discovery-gce-group", "", "Instance group to gossip with for -discovery=gce"),
+		gceProject:   fs.String("discovery-gce-project", "", "Project of the instance group (default: the instance's own)"),
+		gceZone:      fs.String("discovery-gce-zone", "", "Zone of the instance group (default: the instance's own)"),
+		mdnsCluster:  fs.String("discovery-mdns-cluster", "default", "Cluster name for -discovery=mdns; only nodes with the same name find each other"),
+		mdnsIface:    fs.String("discovery-mdns-interface", "", "Network interface to send and receive mDNS on (default: the system's choice)"),
+	}
+}
+
+// provider returns the discovery provider selected by -discovery, or nil if discovery is disabled. addr is the
+// node's advertised address, whose port discovered nodes are assumed to share unless -discovery-port is set
+func (f *discoveryFlags) provider(addr string) (server.Discoverer, error) {
+	if *f.name == "" {
+		return nil, nil
+	}
+	newProvider, ok := discoveryProviders[*f.name]
+	if !ok {
+		return nil, fmt.Errorf("unknown discovery provider %q", *f.name)
+	}
+	if *f.interval <= 0 {
+		return nil, errors.New("-discovery-interval must be positive")
+	}
+
+	port := *f.port
+	if port == 0 {
+		_, portStr, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid node address: %w", err)
+		}
+		if port, err = strconv.Atoi(portStr); err != nil {
+			return nil, fmt.Errorf("invalid node address port %q", portStr)
+		}
+	}
+	return newProvider(f, port)
+}
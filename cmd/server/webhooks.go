@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"gmathur.dev/gossiper/server"
+)
+
+// webhookEntry is a webhook in the -webhooks-file, with the fields of server.WebhookConfig
+type webhookEntry struct {
+	Name          string            `yaml:"name"`
+	URL           string            `yaml:"url"`
+	Headers       map[string]string `yaml:"headers"`
+	Secret        string            `yaml:"secret"`
+	Format        string            `yaml:"format"`
+	Prefix        string            `yaml:"prefix"`
+	Events        []string          `yaml:"events"`
+	Threshold     *int64            `yaml:"threshold"`
+	BatchSize     int               `yaml:"batchSize"`
+	FlushInterval time.Duration     `yaml:"flushInterval"`
+	Retries       int               `yaml:"retries"`
+	Backoff       time.Duration     `yaml:"backoff"`
+	MaxQueue      int               `yaml:"maxQueue"`
+	Timeout       time.Duration     `yaml:"timeout"`
+}
+
+// loadWebhooks reads the webhooks listed under the webhooks key of a YAML or JSON file. Settings left out take
+// the defaults of server.DefaultWebhookConfig
+func loadWebhooks(path string) ([]server.WebhookConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var doc struct {
+		Webhooks []yaml.Node `yaml:"webhooks"`
+	}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse webhooks file %s: %w", path, err)
+	}
+
+	defaults := server.DefaultWebhookConfig()
+	webhooks := make([]server.WebhookConfig, 0, len(doc.Webhooks))
+	for i, node := range doc.Webhooks {
+		e := webhookEntry{
+			Format:        defaults.Format,
+			BatchSize:     defaults.BatchSize,
+			FlushInterval: defaults.FlushInterval,
+			Retries:       defaults.Retries,
+			Backoff:       defaults.Backoff,
+			MaxQueue:      defaults.MaxQueue,
+			Timeout:       defaults.Timeout,
+		}
+		if err := node.Decode(&e); err != nil {
+			return nil, fmt.Errorf("%s: webhook %d: %w", path, i+1, err)
+		}
+		webhooks = append(webhooks, server.WebhookConfig(e))
+	}
+	return webhooks, nil
+}
@@ -0,0 +1,68 @@
+// Command simulate runs a deterministic simulation of a gossiper cluster and reports how long it takes to
+// converge. The same flags and seed always give the same result
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"time"
+
+	"gmathur.dev/gossiper/internal/simulation"
+	"gmathur.dev/gossiper/server"
+)
+
+func main() {
+	nodes := flag.Int("nodes", 20, "Number of nodes")
+	seed := flag.Int64("seed", 1, "Seed for every random choice in the run")
+	runs := flag.Int("runs", 1, "Number of runs, with seeds seed, seed+1, ...")
+	players := flag.Int("players", 100, "Players written before gossip starts, spread over the nodes")
+	modeStr := flag.String("gossip-mode", string(server.GossipPush), "Gossip mode: push or push-pull")
+	fanout := flag.Int("gossip-fanout", 1, "Peers gossiped with per round")
+	fullSyncEvery := flag.Int("full-sync-every", 10, "Full sync every N rounds to a peer (0 disables)")
+	digestSync := flag.Bool("digest-sync", false, "Reconcile full syncs with digests")
+	minLatency := flag.Duration("min-latency", 5*time.Millisecond, "Minimum one-way latency")
+	maxLatency := flag.Duration("max-latency", 50*time.Millisecond, "Maximum one-way latency")
+	dropRate := flag.Float64("drop-rate", 0, "Probability that a message is lost")
+	partitionRounds := flag.Int("partition-rounds", 0, "Split the cluster in half for this many rounds at the start")
+	maxRounds := flag.Int("max-rounds", 1000, "Give up after this many rounds")
+	flag.Parse()
+
+	mode, err := server.ParseGossipMode(*modeStr)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for run := range *runs {
+		sim := simulation.New(simulation.Config{
+			Nodes:      *nodes,
+			Seed:       *seed + int64(run),
+			MinLatency: *minLatency,
+			MaxLatency: *maxLatency,
+			DropRate:   *dropRate,
+			Configure: func(gs *server.GameServer) {
+				gs.Mode = mode
+				gs.Gossip.Fanout = *fanout
+				gs.FullSyncEvery = *fullSyncEvery
+				gs.DigestSync = *digestSync
+			},
+		})
+		for i := range *players {
+			sim.Cluster.Nodes[i%*nodes].UpdatePlayerScore(fmt.Sprintf("player-%d", i), int64(i))
+		}
+
+		if *partitionRounds > 0 {
+			half := make([]int, *nodes/2)
+			for i := range half {
+				half[i] = i
+			}
+			sim.Partition(half)
+			for range *partitionRounds {
+				sim.Step()
+			}
+			sim.Heal()
+		}
+
+		fmt.Printf("seed=%d %s\n", *seed+int64(run), sim.Run(*maxRounds))
+	}
+}
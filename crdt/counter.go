@@ -0,0 +1,67 @@
+package crdt
+
+import (
+	"maps"
+)
+
+// GCounter is a grow-only counter. Each node only ever increments its own slot, and merging keeps the highest
+// count seen for every slot, so increments made concurrently on different nodes all survive
+type GCounter map[string]uint64
+
+// Increment returns a copy of c with n added to node's slot
+func (c GCounter) Increment(node string, n uint64) GCounter {
+	out := maps.Clone(c)
+	if out == nil {
+		out = make(GCounter)
+	}
+	out[node] += n
+	return out
+}
+
+// Value returns the sum of every node's slot
+func (c GCounter) Value() uint64 {
+	var sum uint64
+	for _, n := range c {
+		sum += n
+	}
+	return sum
+}
+
+func (c GCounter) Merge(other GCounter) GCounter {
+	if len(c) == 0 && len(other) == 0 {
+		return nil
+	}
+	out := make(GCounter, max(len(c), len(other)))
+	for node, n := range c {
+		out[node] = n
+	}
+	for node, n := range other {
+		out[node] = max(out[node], n)
+	}
+	return out
+}
+
+// PNCounter is a counter that can go up and down, built from one GCounter for increments and one for decrements
+type PNCounter struct {
+	P GCounter `json:"p,omitempty"`
+	N GCounter `json:"n,omitempty"`
+}
+
+// Add returns a copy of c with delta added on behalf of node. A negative delta decrements
+func (c PNCounter) Add(node string, delta int64) PNCounter {
+	switch {
+	case delta > 0:
+		c.P = c.P.Increment(node, uint64(delta))
+	case delta < 0:
+		c.N = c.N.Increment(node, uint64(-delta))
+	}
+	return c
+}
+
+func (c PNCounter) Value() int64 {
+	return int64(c.P.Value() - c.N.Value())
+}
+
+func (c PNCounter) Merge(other PNCounter) PNCounter {
+	return PNCounter{P: c.P.Merge(other.P), N: c.N.Merge(other.N)}
+}
@@ -0,0 +1,57 @@
+// Package crdt provides conflict-free replicated data types: values whose merge is commutative, associative and
+// idempotent, so replicas that have seen the same updates end up identical no matter in which order, or how many
+// times, the updates arrived. Unlike last-write-wins, concurrent updates made on different nodes are combined
+// rather than one of them being thrown away.
+//
+// Every type is a plain value that encodes to JSON, so it can be stored in a gossip.Store; register MergeFunc for
+// the keys holding it and the store merges replicas instead of picking one
+package crdt
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"gmathur.dev/gossiper/gossip"
+)
+
+// Mergeable is implemented by every type in this package. Merge must not modify either side
+type Mergeable[T any] interface {
+	Merge(other T) T
+}
+
+// MergeFunc builds a gossip.MergeFunc that decodes both entries as T and keeps their CRDT merge. The merged entry
+// takes its metadata from whichever entry was written last, which keeps the result independent of which side is
+// local. Entries that can't be decoded as T fall back to last-write-wins
+func MergeFunc[T Mergeable[T]]() gossip.MergeFunc {
+	return func(local, incoming gossip.Entry) gossip.Entry {
+		var l, in T
+		if json.Unmarshal(local.Value, &l) != nil || json.Unmarshal(incoming.Value, &in) != nil {
+			return gossip.LastWriteWins(local, incoming)
+		}
+		value, err := json.Marshal(l.Merge(in))
+		if err != nil {
+			return gossip.LastWriteWins(local, incoming)
+		}
+
+		merged := gossip.LastWriteWins(local, incoming)
+		if !bytes.Equal(merged.Value, value) {
+			merged.Value = value
+		}
+		return merged
+	}
+}
+
+// Stamp orders updates to registers and sets. Use the gossip.Store clock and origin, so stamps are comparable
+// across nodes
+type Stamp struct {
+	Clock  uint64 `json:"clock"`
+	Origin string `json:"origin"`
+}
+
+// After reports whether s is a later stamp than other, breaking clock ties by origin
+func (s Stamp) After(other Stamp) bool {
+	if s.Clock != other.Clock {
+		return s.Clock > other.Clock
+	}
+	return s.Origin > other.Origin
+}
@@ -0,0 +1,122 @@
+package crdt
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"gmathur.dev/gossiper/gossip"
+)
+
+// checkMergeLaws merges every pair and triple of values, checking that merging is commutative, associative and
+// idempotent and leaves both sides as they were
+func checkMergeLaws[T Mergeable[T]](t *testing.T, values []T) {
+	t.Helper()
+	for i, a := range values {
+		if got := a.Merge(a); !reflect.DeepEqual(got, a) {
+			t.Errorf("%d merged with itself: got %v, want %v", i, got, a)
+		}
+		for j, b := range values {
+			before := fmt.Sprint(a, b)
+			ab, ba := a.Merge(b), b.Merge(a)
+			if !reflect.DeepEqual(ab, ba) {
+				t.Errorf("%d and %d don't commute: %v, %v", i, j, ab, ba)
+			}
+			if after := fmt.Sprint(a, b); after != before {
+				t.Errorf("merging %d and %d changed them from %s to %s", i, j, before, after)
+			}
+			if got := ab.Merge(b); !reflect.DeepEqual(got, ab) {
+				t.Errorf("%d and %d merged with %d again: got %v, want %v", i, j, j, got, ab)
+			}
+			for k, c := range values {
+				left, right := ab.Merge(c), a.Merge(b.Merge(c))
+				if !reflect.DeepEqual(left, right) {
+					t.Errorf("%d, %d and %d don't associate: %v, %v", i, j, k, left, right)
+				}
+			}
+		}
+	}
+}
+
+func TestGCounterMerge(t *testing.T) {
+	checkMergeLaws(t, []GCounter{
+		nil,
+		{"a": 1},
+		{"a": 3, "b": 1},
+		{"b": 2, "c": 5},
+	})
+}
+
+func TestPNCounterMerge(t *testing.T) {
+	checkMergeLaws(t, []PNCounter{
+		{},
+		PNCounter{}.Add("a", 2),
+		PNCounter{}.Add("a", 2).Add("b", -1),
+		PNCounter{}.Add("b", 4).Add("b", -3).Add("c", -1),
+	})
+}
+
+func TestLWWRegisterMerge(t *testing.T) {
+	checkMergeLaws(t, []LWWRegister[string]{
+		{},
+		LWWRegister[string]{}.Set("x", Stamp{Clock: 1, Origin: "a"}),
+		LWWRegister[string]{}.Set("y", Stamp{Clock: 2, Origin: "a"}),
+		LWWRegister[string]{}.Set("z", Stamp{Clock: 2, Origin: "b"}),
+	})
+}
+
+func TestLWWSetMerge(t *testing.T) {
+	s1 := LWWSet{}.Add("x", Stamp{Clock: 1, Origin: "a"})
+	s2 := LWWSet{}.Remove("x", Stamp{Clock: 2, Origin: "b"}).Add("y", Stamp{Clock: 2, Origin: "b"})
+	s3 := LWWSet{}.Add("x", Stamp{Clock: 2, Origin: "b"}).Remove("y", Stamp{Clock: 3, Origin: "a"})
+	checkMergeLaws(t, []LWWSet{{}, s1, s2, s3})
+
+	tests := []struct {
+		name string
+		set  LWWSet
+		want []string
+	}{
+		{"add", s1, []string{"x"}},
+		{"later remove", s1.Merge(s2), []string{"y"}},
+		{"add ties remove", s2.Merge(s3), []string{"x"}},
+		{"all", s1.Merge(s2).Merge(s3), []string{"x"}},
+	}
+	for _, tt := range tests {
+		if got := tt.set.Elements(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMergeFunc(t *testing.T) {
+	entry := func(c GCounter, clock uint64, origin string) gossip.Entry {
+		value, _ := json.Marshal(c)
+		return gossip.Entry{Value: value, Clock: clock, Origin: origin}
+	}
+	merge := MergeFunc[GCounter]()
+	tests := []struct {
+		name            string
+		local, incoming gossip.Entry
+		want            uint64
+	}{
+		{"disjoint", entry(GCounter{"a": 2}, 1, "a"), entry(GCounter{"b": 3}, 2, "b"), 5},
+		{"same slot", entry(GCounter{"a": 2}, 1, "a"), entry(GCounter{"a": 4}, 2, "a"), 4},
+		{"undecodable", gossip.Entry{Value: []byte("{"), Clock: 1, Origin: "a"}, entry(GCounter{"b": 3}, 2, "b"), 3},
+	}
+	for _, tt := range tests {
+		for _, sides := range [][2]gossip.Entry{{tt.local, tt.incoming}, {tt.incoming, tt.local}} {
+			merged := merge(sides[0], sides[1])
+			var c GCounter
+			if err := json.Unmarshal(merged.Value, &c); err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+			if c.Value() != tt.want {
+				t.Errorf("%s: got %d, want %d", tt.name, c.Value(), tt.want)
+			}
+			if merged.Clock != tt.incoming.Clock || merged.Origin != tt.incoming.Origin {
+				t.Errorf("%s: took metadata %d/%s, want the later write's", tt.name, merged.Clock, merged.Origin)
+			}
+		}
+	}
+}
@@ -0,0 +1,23 @@
+package crdt
+
+// LWWRegister holds a single value; the update with the latest stamp wins. It is the same rule the store applies
+// to whole entries, useful as a field inside a larger CRDT
+type LWWRegister[V any] struct {
+	Value V     `json:"value"`
+	Stamp Stamp `json:"stamp"`
+}
+
+// Set returns a register holding value if stamp is later than the current one, and r unchanged otherwise
+func (r LWWRegister[V]) Set(value V, stamp Stamp) LWWRegister[V] {
+	if stamp.After(r.Stamp) {
+		return LWWRegister[V]{Value: value, Stamp: stamp}
+	}
+	return r
+}
+
+func (r LWWRegister[V]) Merge(other LWWRegister[V]) LWWRegister[V] {
+	if other.Stamp.After(r.Stamp) {
+		return other
+	}
+	return r
+}
@@ -0,0 +1,78 @@
+package crdt
+
+import (
+	"maps"
+	"sort"
+)
+
+// LWWSet is a set of strings where each element's latest add and latest remove are remembered; an element is in
+// the set if it was added no earlier than it was last removed. Concurrent adds and removes of different
+// elements all survive, and an add and remove of the same element made at the same stamp favour the add
+type LWWSet struct {
+	Adds    map[string]Stamp `json:"adds,omitempty"`
+	Removes map[string]Stamp `json:"removes,omitempty"`
+}
+
+// Add returns a copy of s with elem added at stamp
+func (s LWWSet) Add(elem string, stamp Stamp) LWWSet {
+	s.Adds = setStamp(s.Adds, elem, stamp)
+	return s
+}
+
+// Remove returns a copy of s with elem removed at stamp
+func (s LWWSet) Remove(elem string, stamp Stamp) LWWSet {
+	s.Removes = setStamp(s.Removes, elem, stamp)
+	return s
+}
+
+func (s LWWSet) Contains(elem string) bool {
+	added, ok := s.Adds[elem]
+	if !ok {
+		return false
+	}
+	removed, ok := s.Removes[elem]
+	return !ok || !removed.After(added)
+}
+
+// Elements returns the members of the set in sorted order
+func (s LWWSet) Elements() []string {
+	var elems []string
+	for elem := range s.Adds {
+		if s.Contains(elem) {
+			elems = append(elems, elem)
+		}
+	}
+	sort.Strings(elems)
+	return elems
+}
+
+func (s LWWSet) Merge(other LWWSet) LWWSet {
+	return LWWSet{Adds: mergeStamps(s.Adds, other.Adds), Removes: mergeStamps(s.Removes, other.Removes)}
+}
+
+func setStamp(m map[string]Stamp, elem string, stamp Stamp) map[string]Stamp {
+	out := maps.Clone(m)
+	if out == nil {
+		out = make(map[string]Stamp)
+	}
+	if current, ok := out[elem]; !ok || stamp.After(current) {
+		out[elem] = stamp
+	}
+	return out
+}
+
+func mergeStamps(a, b map[string]Stamp) map[string]Stamp {
+	if len(a) == 0 && len(b) == 0 {
+		return nil
+	}
+	out := maps.Clone(a)
+	if out == nil {
+		out = make(map[string]Stamp, len(b))
+	}
+	for elem, stamp := range b {
+		if current, ok := out[elem]; !ok || stamp.After(current) {
+			out[elem] = stamp
+		}
+	}
+	return out
+}
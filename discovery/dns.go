@@ -0,0 +1,51 @@
+// Package discovery has server.Discoverer implementations that find a cluster's nodes without a static peer
+// list
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// DNS discovers nodes by resolving a DNS name, such as a Kubernetes headless service, whose records change as
+// nodes come and go
+type DNS struct {
+	// Name is either host:port, which looks up the host's A and AAAA records and uses the port for every address,
+	// or a bare name, which looks up its SRV records for both host and port. For example
+	// gossiper.game.svc.cluster.local:8080 or _gossip._tcp.gossiper.game.svc.cluster.local
+	Name     string
+	Resolver *net.Resolver // net.DefaultResolver if nil
+}
+
+// Discover implements server.Discoverer
+func (d *DNS) Discover(ctx context.Context) ([]string, error) {
+	resolver := d.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+
+	var addrs []string
+	if host, port, err := net.SplitHostPort(d.Name); err == nil {
+		ips, err := resolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve %s: %w", host, err)
+		}
+		for _, ip := range ips {
+			addrs = append(addrs, net.JoinHostPort(ip.IP.String(), port))
+		}
+	} else {
+		_, srvs, err := resolver.LookupSRV(ctx, "", "", d.Name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up SRV records for %s: %w", d.Name, err)
+		}
+		for _, srv := range srvs {
+			addrs = append(addrs, net.JoinHostPort(strings.TrimSuffix(srv.Target, "."), strconv.Itoa(int(srv.Port))))
+		}
+	}
+	slices.Sort(addrs)
+	return slices.Compact(addrs), nil
+}
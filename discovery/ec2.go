@@ -0,0 +1,237 @@
+package discovery
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultIMDSEndpoint is the EC2 instance metadata service
+const DefaultIMDSEndpoint = "http://169.254.169.254"
+
+// EC2 discovers nodes by listing the running instances that carry a tag, using the EC2 DescribeInstances API.
+// Credentials come from AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN if they are set, and from
+// the instance's IAM role otherwise; the role needs ec2:DescribeInstances
+type EC2 struct {
+	TagKey   string
+	TagValue string // any value of TagKey when empty
+	Port     int    // port every instance serves gossip on
+	Region   string // the instance's own region when empty
+
+	Endpoint     string // EC2 API endpoint, https://ec2.<region>.amazonaws.com when empty
+	IMDSEndpoint string // DefaultIMDSEndpoint when empty
+	Client       *http.Client
+
+	mu    sync.Mutex
+	creds awsCredentials
+}
+
+type awsCredentials struct {
+	AccessKeyId     string
+	SecretAccessKey string
+	Token           string
+	Expiration      time.Time // zero for credentials that don't expire
+}
+
+// describeInstancesResponse is the part of a DescribeInstances response that discovery needs
+type describeInstancesResponse struct {
+	Reservations []struct {
+		Instances []struct {
+			PrivateIP string `xml:"privateIpAddress"`
+		} `xml:"instancesSet>item"`
+	} `xml:"reservationSet>item"`
+	NextToken string `xml:"nextToken"`
+}
+
+// Discover implements server.Discoverer, returning the private IP of every running instance with the tag
+func (d *EC2) Discover(ctx context.Context) ([]string, error) {
+	if d.TagKey == "" {
+		return nil, errors.New("ec2 discovery needs a tag key")
+	}
+	creds, err := d.credentials(ctx)
+	if err != nil {
+		return nil, err
+	}
+	region := d.Region
+	if region == "" {
+		if region, err = d.imdsGet(ctx, "/latest/meta-data/placement/region"); err != nil {
+			return nil, fmt.Errorf("failed to find the instance's region: %w", err)
+		}
+	}
+	endpoint := d.Endpoint
+	if endpoint == "" {
+		endpoint = "https://ec2." + region + ".amazonaws.com"
+	}
+
+	params := url.Values{
+		"Action":           {"DescribeInstances"},
+		"Version":          {"2016-11-15"},
+		"Filter.1.Name":    {"instance-state-name"},
+		"Filter.1.Value.1": {"running"},
+	}
+	if d.TagValue != "" {
+		params.Set("Filter.2.Name", "tag:"+d.TagKey)
+		params.Set("Filter.2.Value.1", d.TagValue)
+	} else {
+		params.Set("Filter.2.Name", "tag-key")
+		params.Set("Filter.2.Value.1", d.TagKey)
+	}
+
+	var addrs []string
+	for {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint+"/?"+params.Encode(), nil)
+		if err != nil {
+			return nil, err
+		}
+		signV4(req, creds, region, "ec2", time.Now())
+		resp, err := d.client().Do(req)
+		if err != nil {
+			return nil, err
+		}
+		var page describeInstancesResponse
+		err = decodeResponse(resp, "DescribeInstances", func(r io.Reader) error { return xml.NewDecoder(r).Decode(&page) })
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range page.Reservations {
+			for _, inst := range r.Instances {
+				if inst.PrivateIP != "" {
+					addrs = append(addrs, net.JoinHostPort(inst.PrivateIP, strconv.Itoa(d.Port)))
+				}
+			}
+		}
+		if page.NextToken == "" {
+			break
+		}
+		params.Set("NextToken", page.NextToken)
+	}
+	slices.Sort(addrs)
+	return slices.Compact(addrs), nil
+}
+
+func (d *EC2) client() *http.Client {
+	if d.Client != nil {
+		return d.Client
+	}
+	return http.DefaultClient
+}
+
+// credentials returns the static credentials from the environment, or the instance role's, refreshed shortly
+// before they expire
+func (d *EC2) credentials(ctx context.Context) (awsCredentials, error) {
+	if id, secret := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY"); id != "" && secret != "" {
+		return awsCredentials{AccessKeyId: id, SecretAccessKey: secret, Token: os.Getenv("AWS_SESSION_TOKEN")}, nil
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.creds.AccessKeyId != "" && time.Until(d.creds.Expiration) > 5*time.Minute {
+		return d.creds, nil
+	}
+	role, err := d.imdsGet(ctx, "/latest/meta-data/iam/security-credentials/")
+	if err != nil {
+		return awsCredentials{}, fmt.Errorf("no AWS credentials in the environment or from the instance role: %w", err)
+	}
+	role, _, _ = strings.Cut(role, "\n")
+	data, err := d.imdsGet(ctx, "/latest/meta-data/iam/security-credentials/"+role)
+	if err != nil {
+		return awsCredentials{}, fmt.Errorf("failed to get credentials for role %s: %w", role, err)
+	}
+	var creds awsCredentials
+	if err := json.Unmarshal([]byte(data), &creds); err != nil {
+		return awsCredentials{}, fmt.Errorf("failed to decode credentials for role %s: %w", role, err)
+	}
+	d.creds = creds
+	return creds, nil
+}
+
+// imdsGet reads a path from the instance metadata service, using an IMDSv2 session token
+func (d *EC2) imdsGet(ctx context.Context, path string) (string, error) {
+	endpoint := d.IMDSEndpoint
+	if endpoint == "" {
+		endpoint = DefaultIMDSEndpoint
+	}
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, endpoint+"/latest/api/token", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "60")
+	var token string
+	resp, err := d.client().Do(req)
+	if err != nil {
+		return "", err
+	}
+	if err := decodeResponse(resp, "IMDS token", readString(&token)); err != nil {
+		return "", err
+	}
+
+	req, err = http.NewRequestWithContext(ctx, http.MethodGet, endpoint+path, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token", token)
+	var value string
+	if resp, err = d.client().Do(req); err != nil {
+		return "", err
+	}
+	err = decodeResponse(resp, path, readString(&value))
+	return strings.TrimSpace(value), err
+}
+
+// signV4 signs a request without a body with AWS Signature Version 4
+func signV4(req *http.Request, creds awsCredentials, region, service string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	day := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	if creds.Token != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.Token)
+	}
+
+	// The canonical query string sorts parameters and escapes spaces as %20 rather than +
+	query := strings.ReplaceAll(req.URL.Query().Encode(), "+", "%20")
+	signed := []string{"host", "x-amz-date"}
+	headers := "host:" + req.URL.Host + "\nx-amz-date:" + amzDate + "\n"
+	if creds.Token != "" {
+		signed = append(signed, "x-amz-security-token")
+		headers += "x-amz-security-token:" + creds.Token + "\n"
+	}
+	signedHeaders := strings.Join(signed, ";")
+	emptyHash := sha256.Sum256(nil)
+	canonical := strings.Join([]string{req.Method, "/", query, headers, signedHeaders, hex.EncodeToString(emptyHash[:])}, "\n")
+
+	scope := day + "/" + region + "/" + service + "/aws4_request"
+	canonicalHash := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonicalHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+creds.SecretAccessKey), day)
+	for _, part := range []string{region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.AccessKeyId, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
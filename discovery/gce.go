@@ -0,0 +1,197 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Endpoints of the Google Compute Engine APIs
+const (
+	DefaultGCEMetadataEndpoint = "http://metadata.google.internal"
+	DefaultGCEComputeEndpoint  = "https://compute.googleapis.com"
+)
+
+// GCE discovers nodes by listing the running instances of a Compute Engine instance group. It authenticates as
+// the instance's service account, which needs compute.instanceGroups.list and compute.instances.list
+type GCE struct {
+	Group   string // instance group name
+	Port    int    // port every instance serves gossip on
+	Project string // the instance's own project when empty
+	Zone    string // the instance's own zone when empty
+
+	MetadataEndpoint string // DefaultGCEMetadataEndpoint when empty
+	ComputeEndpoint  string // DefaultGCEComputeEndpoint when empty
+	Client           *http.Client
+
+	mu          sync.Mutex
+	token       string
+	tokenExpiry time.Time
+}
+
+// Discover implements server.Discoverer, returning the internal IP of every running instance in the group
+func (d *GCE) Discover(ctx context.Context) ([]string, error) {
+	if d.Group == "" {
+		return nil, errors.New("gce discovery needs an instance group")
+	}
+	token, err := d.accessToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+	project, zone := d.Project, d.Zone
+	if project == "" {
+		if project, err = d.metadata(ctx, "project/project-id"); err != nil {
+			return nil, fmt.Errorf("failed to find the instance's project: %w", err)
+		}
+	}
+	if zone == "" {
+		// The metadata server answers with projects/<number>/zones/<zone>
+		if zone, err = d.metadata(ctx, "instance/zone"); err != nil {
+			return nil, fmt.Errorf("failed to find the instance's zone: %w", err)
+		}
+		zone = path.Base(zone)
+	}
+
+	// listInstances only returns links to the group's instances, so their IPs come from listing the zone's
+	// instances and keeping those in the group
+	zoneURL := fmt.Sprintf("%s/compute/v1/projects/%s/zones/%s", d.computeEndpoint(), url.PathEscape(project), url.PathEscape(zone))
+	members := make(map[string]bool)
+	var group struct {
+		Items []struct {
+			Instance string `json:"instance"`
+		} `json:"items"`
+		NextPageToken string `json:"nextPageToken"`
+	}
+	for pageToken := ""; ; pageToken = group.NextPageToken {
+		group.Items, group.NextPageToken = nil, ""
+		u := zoneURL + "/instanceGroups/" + url.PathEscape(d.Group) + "/listInstances?pageToken=" + url.QueryEscape(pageToken)
+		body := strings.NewReader(`{"instanceState":"RUNNING"}`)
+		if err := d.call(ctx, token, http.MethodPost, u, body, &group); err != nil {
+			return nil, err
+		}
+		for _, item := range group.Items {
+			members[path.Base(item.Instance)] = true
+		}
+		if group.NextPageToken == "" {
+			break
+		}
+	}
+
+	var addrs []string
+	var instances struct {
+		Items []struct {
+			Name              string `json:"name"`
+			Status            string `json:"status"`
+			NetworkInterfaces []struct {
+				NetworkIP string `json:"networkIP"`
+			} `json:"networkInterfaces"`
+		} `json:"items"`
+		NextPageToken string `json:"nextPageToken"`
+	}
+	for pageToken := ""; ; pageToken = instances.NextPageToken {
+		instances.Items, instances.NextPageToken = nil, ""
+		u := zoneURL + "/instances?pageToken=" + url.QueryEscape(pageToken)
+		if err := d.call(ctx, token, http.MethodGet, u, nil, &instances); err != nil {
+			return nil, err
+		}
+		for _, inst := range instances.Items {
+			if !members[inst.Name] || inst.Status != "RUNNING" || len(inst.NetworkInterfaces) == 0 {
+				continue
+			}
+			addrs = append(addrs, net.JoinHostPort(inst.NetworkInterfaces[0].NetworkIP, strconv.Itoa(d.Port)))
+		}
+		if instances.NextPageToken == "" {
+			break
+		}
+	}
+	slices.Sort(addrs)
+	return addrs, nil
+}
+
+func (d *GCE) client() *http.Client {
+	if d.Client != nil {
+		return d.Client
+	}
+	return http.DefaultClient
+}
+
+func (d *GCE) computeEndpoint() string {
+	if d.ComputeEndpoint != "" {
+		return d.ComputeEndpoint
+	}
+	return DefaultGCEComputeEndpoint
+}
+
+// call sends a Compute API request and decodes the JSON response into out
+func (d *GCE) call(ctx context.Context, token, method, u string, body io.Reader, out any) error {
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := d.client().Do(req)
+	if err != nil {
+		return err
+	}
+	return decodeResponse(resp, method+" "+req.URL.Path, decodeJSON(out))
+}
+
+// accessToken returns the service account's OAuth token, refreshed shortly before it expires
+func (d *GCE) accessToken(ctx context.Context) (string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.token != "" && time.Until(d.tokenExpiry) > time.Minute {
+		return d.token, nil
+	}
+
+	data, err := d.metadata(ctx, "instance/service-accounts/default/token")
+	if err != nil {
+		return "", fmt.Errorf("failed to get a service account token: %w", err)
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.Unmarshal([]byte(data), &token); err != nil {
+		return "", fmt.Errorf("failed to decode service account token: %w", err)
+	}
+	d.token, d.tokenExpiry = token.AccessToken, time.Now().Add(time.Duration(token.ExpiresIn)*time.Second)
+	return d.token, nil
+}
+
+// metadata reads a path under computeMetadata/v1 from the metadata server
+func (d *GCE) metadata(ctx context.Context, p string) (string, error) {
+	endpoint := d.MetadataEndpoint
+	if endpoint == "" {
+		endpoint = DefaultGCEMetadataEndpoint
+	}
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint+"/computeMetadata/v1/"+p, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	resp, err := d.client().Do(req)
+	if err != nil {
+		return "", err
+	}
+	var value string
+	err = decodeResponse(resp, p, readString(&value))
+	return strings.TrimSpace(value), err
+}
@@ -0,0 +1,37 @@
+package discovery
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// decodeResponse closes resp after decoding a successful body with decode, or turns an error status into an
+// error naming what was requested
+func decodeResponse(resp *http.Response, what string, decode func(io.Reader) error) error {
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+		return fmt.Errorf("%s returned %s: %s", what, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if err := decode(resp.Body); err != nil {
+		return fmt.Errorf("failed to decode %s response: %w", what, err)
+	}
+	return nil
+}
+
+func readString(s *string) func(io.Reader) error {
+	return func(r io.Reader) error {
+		data, err := io.ReadAll(io.LimitReader(r, 64<<10))
+		*s = string(data)
+		return err
+	}
+}
+
+func decodeJSON(out any) func(io.Reader) error {
+	return func(r io.Reader) error {
+		return json.NewDecoder(r).Decode(out)
+	}
+}
@@ -0,0 +1,129 @@
+package discovery
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Paths of the service account credentials mounted into every pod
+const (
+	serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+	tokenFile         = serviceAccountDir + "/token"
+	caFile            = serviceAccountDir + "/ca.crt"
+	namespaceFile     = serviceAccountDir + "/namespace"
+)
+
+// Kubernetes discovers nodes by listing the pods that match a label selector through the Kubernetes API, so a
+// cluster scales with its Deployment or StatefulSet. The pod's service account needs permission to list pods in
+// Namespace
+type Kubernetes struct {
+	APIServer     string // base URL of the API server
+	Namespace     string
+	LabelSelector string // e.g. app=gossiper
+	Port          int    // port every pod serves gossip on
+	TokenFile     string // bearer token, read on every request since projected tokens are rotated
+	Client        *http.Client
+}
+
+// NewKubernetes returns a Kubernetes discoverer configured from the service account of the pod it runs in. An
+// empty namespace means the pod's own
+func NewKubernetes(namespace, labelSelector string, port int) (*Kubernetes, error) {
+	host, apiPort := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || apiPort == "" {
+		return nil, errors.New("not running in a Kubernetes pod: KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT are not set")
+	}
+	if namespace == "" {
+		ns, err := os.ReadFile(namespaceFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read pod namespace: %w", err)
+		}
+		namespace = strings.TrimSpace(string(ns))
+	}
+
+	ca, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cluster CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("no certificates in %s", caFile)
+	}
+
+	return &Kubernetes{
+		APIServer:     "https://" + net.JoinHostPort(host, apiPort),
+		Namespace:     namespace,
+		LabelSelector: labelSelector,
+		Port:          port,
+		TokenFile:     tokenFile,
+		Client: &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+		},
+	}, nil
+}
+
+// podList is the part of a PodList that discovery needs
+type podList struct {
+	Items []struct {
+		Metadata struct {
+			Name              string     `json:"name"`
+			DeletionTimestamp *time.Time `json:"deletionTimestamp"`
+		} `json:"metadata"`
+		Status struct {
+			Phase string `json:"phase"`
+			PodIP string `json:"podIP"`
+		} `json:"status"`
+	} `json:"items"`
+}
+
+// Discover implements server.Discoverer. Running pods that have an IP are returned whether or not they are ready,
+// since a node's readiness depends on reaching its peers; pods being deleted are left out
+func (k *Kubernetes) Discover(ctx context.Context) ([]string, error) {
+	u := fmt.Sprintf("%s/api/v1/namespaces/%s/pods?labelSelector=%s", strings.TrimSuffix(k.APIServer, "/"),
+		url.PathEscape(k.Namespace), url.QueryEscape(k.LabelSelector))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if k.TokenFile != "" {
+		token, err := os.ReadFile(k.TokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read service account token: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+
+	client := k.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	var pods podList
+	if err := decodeResponse(resp, "listing pods", decodeJSON(&pods)); err != nil {
+		return nil, err
+	}
+	var addrs []string
+	for _, pod := range pods.Items {
+		if pod.Status.Phase != "Running" || pod.Status.PodIP == "" || pod.Metadata.DeletionTimestamp != nil {
+			continue
+		}
+		addrs = append(addrs, net.JoinHostPort(pod.Status.PodIP, strconv.Itoa(k.Port)))
+	}
+	slices.Sort(addrs)
+	return addrs, nil
+}
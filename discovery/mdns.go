@@ -0,0 +1,358 @@
+package discovery
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"time"
+)
+
+// DefaultMDNSGroup is the multicast group and port mDNS uses
+const DefaultMDNSGroup = "224.0.0.251:5353"
+
+// DefaultMDNSService is the DNS-SD service type nodes advertise themselves under
+const DefaultMDNSService = "_gossiper._tcp.local."
+
+// MDNS discovers nodes on the local network with multicast DNS, so that nodes started on the same LAN find each
+// other without any configuration. Every node both answers queries for the service, advertising its own address,
+// and queries for the other nodes each time it is polled. Nodes only see each other if they share Cluster, so
+// several clusters can run on one network
+type MDNS struct {
+	Cluster   string         // cluster name, advertised in a TXT record
+	Service   string         // DefaultMDNSService if empty
+	Group     string         // DefaultMDNSGroup if empty
+	Interface *net.Interface // multicast interface, the system default if nil
+	Timeout   time.Duration  // how long a query waits for answers, a second if 0
+}
+
+// DNS record types and classes used by mDNS
+const (
+	typePTR = 12
+	typeTXT = 16
+	typeSRV = 33
+	typeANY = 255
+
+	classIN     = 1
+	cacheFlush  = 0x8000 // in the class of a record: replaces cached records of the same name and type
+	unicastResp = 0x8000 // in the class of a question: the answer may be sent unicast
+
+	flagResponse = 0x8400 // QR and AA
+	recordTTL    = 120
+)
+
+func (d *MDNS) service() string {
+	if d.Service == "" {
+		return DefaultMDNSService
+	}
+	return d.Service
+}
+
+func (d *MDNS) group() (*net.UDPAddr, error) {
+	group := d.Group
+	if group == "" {
+		group = DefaultMDNSGroup
+	}
+	return net.ResolveUDPAddr("udp4", group)
+}
+
+// Advertise implements server.Advertiser: it answers mDNS queries for the service with this node's address until
+// ctx is done
+func (d *MDNS) Advertise(ctx context.Context, id, addr string) error {
+	group, err := d.group()
+	if err != nil {
+		return err
+	}
+	conn, err := net.ListenMulticastUDP("udp4", d.Interface, group)
+	if err != nil {
+		return fmt.Errorf("failed to join mDNS group %s: %w", group, err)
+	}
+	context.AfterFunc(ctx, func() { conn.Close() })
+
+	instance := instanceLabel(id) + "." + d.service()
+	buf := make([]byte, 9000)
+	for {
+		n, src, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		msgId, asked := d.asksForService(buf[:n])
+		if !asked {
+			continue
+		}
+
+		// Queries from port 5353 are answered to the whole group, one-shot queries from any other port straight to
+		// the sender, echoing the query ID as legacy unicast DNS expects
+		dst, unicast := group, src.Port != group.Port
+		if unicast {
+			dst = src
+		} else {
+			msgId = 0
+		}
+		resp := d.response(msgId, instance, addr, unicast)
+		if _, err := conn.WriteToUDP(resp, dst); err != nil && ctx.Err() != nil {
+			return nil
+		}
+	}
+}
+
+// Discover implements server.Discoverer: it queries for the service and returns the address of every node in
+// Cluster that answers within Timeout, this one included
+func (d *MDNS) Discover(ctx context.Context) ([]string, error) {
+	group, err := d.group()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp4", d.localAddr())
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	timeout := d.Timeout
+	if timeout == 0 {
+		timeout = time.Second
+	}
+	deadline := time.Now().Add(timeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	conn.SetDeadline(deadline)
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	if _, err := conn.WriteToUDP(d.query(), group); err != nil {
+		return nil, fmt.Errorf("failed to send mDNS query: %w", err)
+	}
+
+	var addrs []string
+	buf := make([]byte, 9000)
+	for {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				break
+			}
+			return nil, err
+		}
+		addrs = append(addrs, d.parseResponse(buf[:n])...)
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	slices.Sort(addrs)
+	return slices.Compact(addrs), nil
+}
+
+// localAddr is the address queries are sent from: an IPv4 address of Interface, so that they go out on it, or any
+// address if Interface isn't set
+func (d *MDNS) localAddr() *net.UDPAddr {
+	if d.Interface == nil {
+		return &net.UDPAddr{}
+	}
+	addrs, _ := d.Interface.Addrs()
+	for _, a := range addrs {
+		if ipNet, ok := a.(*net.IPNet); ok && ipNet.IP.To4() != nil {
+			return &net.UDPAddr{IP: ipNet.IP}
+		}
+	}
+	return &net.UDPAddr{}
+}
+
+// query builds a one-shot PTR query for the service
+func (d *MDNS) query() []byte {
+	msg := binary.BigEndian.AppendUint16(nil, 0) // ID
+	msg = binary.BigEndian.AppendUint16(msg, 0)  // flags
+	msg = binary.BigEndian.AppendUint16(msg, 1)  // questions
+	msg = append(msg, make([]byte, 6)...)        // answers, authorities, additionals
+	msg = appendName(msg, d.service())
+	msg = binary.BigEndian.AppendUint16(msg, typePTR)
+	return binary.BigEndian.AppendUint16(msg, classIN|unicastResp)
+}
+
+// response builds the answer to a query: a PTR record pointing at this node's instance, and the instance's SRV
+// and TXT records. The TXT record carries the cluster name and the node's address as given, host name or IP
+func (d *MDNS) response(id uint16, instance, addr string, withQuestion bool) []byte {
+	host, port, _ := net.SplitHostPort(addr)
+	portNum, _ := net.LookupPort("tcp", port)
+
+	msg := binary.BigEndian.AppendUint16(nil, id)
+	msg = binary.BigEndian.AppendUint16(msg, flagResponse)
+	questions := uint16(0)
+	if withQuestion {
+		questions = 1
+	}
+	msg = binary.BigEndian.AppendUint16(msg, questions)
+	msg = binary.BigEndian.AppendUint16(msg, 1) // answers
+	msg = binary.BigEndian.AppendUint16(msg, 0) // authorities
+	msg = binary.BigEndian.AppendUint16(msg, 2) // additionals
+	if withQuestion {
+		msg = appendName(msg, d.service())
+		msg = binary.BigEndian.AppendUint16(msg, typePTR)
+		msg = binary.BigEndian.AppendUint16(msg, classIN)
+	}
+
+	msg = appendRecord(msg, d.service(), typePTR, classIN, appendName(nil, instance))
+
+	srv := binary.BigEndian.AppendUint16(nil, 0) // priority
+	srv = binary.BigEndian.AppendUint16(srv, 0)  // weight
+	srv = binary.BigEndian.AppendUint16(srv, uint16(portNum))
+	srv = appendName(srv, strings.TrimSuffix(host, ".")+".")
+	msg = appendRecord(msg, instance, typeSRV, classIN|cacheFlush, srv)
+
+	var txt []byte
+	for _, s := range []string{"cluster=" + d.Cluster, "addr=" + addr} {
+		txt = append(txt, byte(min(len(s), 255)))
+		txt = append(txt, s[:min(len(s), 255)]...)
+	}
+	return appendRecord(msg, instance, typeTXT, classIN|cacheFlush, txt)
+}
+
+// asksForService reports whether msg is a query with a question for the service, and returns its ID
+func (d *MDNS) asksForService(msg []byte) (uint16, bool) {
+	if len(msg) < 12 || binary.BigEndian.Uint16(msg[2:])&0x8000 != 0 {
+		return 0, false
+	}
+	id, questions := binary.BigEndian.Uint16(msg), int(binary.BigEndian.Uint16(msg[4:]))
+	off := 12
+	for range questions {
+		name, next, err := readName(msg, off)
+		if err != nil || next+4 > len(msg) {
+			return 0, false
+		}
+		qtype := binary.BigEndian.Uint16(msg[next:])
+		off = next + 4
+		if strings.EqualFold(name, d.service()) && (qtype == typePTR || qtype == typeANY) {
+			return id, true
+		}
+	}
+	return 0, false
+}
+
+// parseResponse returns the addresses advertised in the TXT records of a response by nodes in our cluster
+func (d *MDNS) parseResponse(msg []byte) []string {
+	if len(msg) < 12 || binary.BigEndian.Uint16(msg[2:])&0x8000 == 0 {
+		return nil
+	}
+	questions := int(binary.BigEndian.Uint16(msg[4:]))
+	records := int(binary.BigEndian.Uint16(msg[6:])) + int(binary.BigEndian.Uint16(msg[8:])) +
+		int(binary.BigEndian.Uint16(msg[10:]))
+
+	off := 12
+	for range questions {
+		_, next, err := readName(msg, off)
+		if err != nil {
+			return nil
+		}
+		off = next + 4
+	}
+
+	var addrs []string
+	suffix := "." + strings.ToLower(d.service())
+	for range records {
+		name, next, err := readName(msg, off)
+		if err != nil || next+10 > len(msg) {
+			return addrs
+		}
+		rtype := binary.BigEndian.Uint16(msg[next:])
+		length := int(binary.BigEndian.Uint16(msg[next+8:]))
+		data := next + 10
+		if data+length > len(msg) {
+			return addrs
+		}
+		off = data + length
+		if rtype != typeTXT || !strings.HasSuffix(strings.ToLower(name), suffix) {
+			continue
+		}
+
+		var cluster, addr string
+		for txt := msg[data:off]; len(txt) > 0; {
+			n := int(txt[0])
+			if 1+n > len(txt) {
+				break
+			}
+			key, value, _ := strings.Cut(string(txt[1:1+n]), "=")
+			switch key {
+			case "cluster":
+				cluster = value
+			case "addr":
+				addr = value
+			}
+			txt = txt[1+n:]
+		}
+		if cluster == d.Cluster && addr != "" {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
+// instanceLabel makes a node ID usable as the first label of a DNS-SD instance name
+func instanceLabel(id string) string {
+	id = strings.ReplaceAll(id, ".", "-")
+	if len(id) > 63 {
+		id = id[:63]
+	}
+	return id
+}
+
+func appendName(b []byte, name string) []byte {
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if label == "" {
+			continue
+		}
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	return append(b, 0)
+}
+
+func appendRecord(b []byte, name string, rtype, class uint16, data []byte) []byte {
+	b = appendName(b, name)
+	b = binary.BigEndian.AppendUint16(b, rtype)
+	b = binary.BigEndian.AppendUint16(b, class)
+	b = binary.BigEndian.AppendUint32(b, recordTTL)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(data)))
+	return append(b, data...)
+}
+
+// readName reads a possibly compressed name at off, returning it with a trailing dot and the offset just past it
+func readName(msg []byte, off int) (string, int, error) {
+	var labels []string
+	end := -1
+	for jumps := 0; ; {
+		if off >= len(msg) {
+			return "", 0, errors.New("name out of bounds")
+		}
+		n := int(msg[off])
+		switch {
+		case n == 0:
+			if end < 0 {
+				end = off + 1
+			}
+			return strings.Join(labels, ".") + ".", end, nil
+		case n&0xc0 == 0xc0:
+			if off+1 >= len(msg) || jumps > 10 {
+				return "", 0, errors.New("invalid name pointer")
+			}
+			if end < 0 {
+				end = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(msg[off:]) & 0x3fff)
+			jumps++
+		default:
+			if off+1+n > len(msg) {
+				return "", 0, errors.New("label out of bounds")
+			}
+			labels = append(labels, string(msg[off+1:off+1+n]))
+			off += 1 + n
+		}
+	}
+}
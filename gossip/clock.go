@@ -0,0 +1,68 @@
+package gossip
+
+import (
+	"sync"
+	"time"
+)
+
+// HLC is a hybrid logical clock. Timestamps are packed into a uint64 with wall-clock milliseconds in the upper 48
+// bits and a logical counter in the lower 16, so they compare like plain integers. The clock never goes backwards
+// and always moves past any timestamp it has observed from a peer, which gives a causal order for last-write-wins
+// even when node clocks drift
+type HLC struct {
+	// MaxDrift is how far ahead of the wall clock an observed timestamp may take the clock; later ones are clamped
+	// to it, so one peer with a clock far in the future can't drag every node's clock there for good. 0 is no limit
+	MaxDrift time.Duration
+	OnClamp  func(ts uint64) // called with every observed timestamp clamped by MaxDrift, under the clock's lock
+
+	mu   sync.Mutex
+	last uint64
+	now  func() time.Time
+}
+
+// DefaultMaxDrift is the MaxDrift of new clocks
+const DefaultMaxDrift = time.Minute
+
+func NewHLC() *HLC {
+	return NewHLCWithClock(time.Now)
+}
+
+// NewHLCWithClock creates an HLC that reads wall-clock time from now, e.g. a fake clock in tests
+func NewHLCWithClock(now func() time.Time) *HLC {
+	return &HLC{MaxDrift: DefaultMaxDrift, now: now}
+}
+
+// Now returns a timestamp greater than every timestamp previously returned or observed
+func (c *HLC) Now() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// If the logical counter overflows it simply carries into the wall-clock bits, which keeps ordering intact
+	if pt := uint64(c.now().UnixMilli()) << 16; pt > c.last {
+		c.last = pt
+	} else {
+		c.last++
+	}
+	return c.last
+}
+
+// Observe folds a timestamp received from a peer into the clock, up to MaxDrift ahead of the wall clock
+func (c *HLC) Observe(ts uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.MaxDrift > 0 {
+		if limit := uint64(c.now().Add(c.MaxDrift).UnixMilli()) << 16; ts > limit {
+			if c.OnClamp != nil {
+				c.OnClamp(ts)
+			}
+			ts = limit
+		}
+	}
+	c.last = max(c.last, ts)
+}
+
+// HLCWallTime returns the wall-clock component of an HLC timestamp
+func HLCWallTime(ts uint64) time.Time {
+	return time.UnixMilli(int64(ts >> 16))
+}
@@ -0,0 +1,58 @@
+package gossip
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHLC(t *testing.T) {
+	wall := time.UnixMilli(1_000_000)
+	at := func(d time.Duration) uint64 { return uint64(wall.Add(d).UnixMilli()) << 16 }
+
+	tests := []struct {
+		name     string
+		maxDrift time.Duration
+		observed uint64
+		want     uint64 // of the next Now
+		clamped  bool
+	}{
+		{"wall clock", time.Minute, 0, at(0), false},
+		{"behind", time.Minute, at(-time.Second), at(0), false},
+		{"ahead", time.Minute, at(time.Second) + 5, at(time.Second) + 6, false},
+		{"at the limit", time.Minute, at(time.Minute), at(time.Minute) + 1, false},
+		{"past the limit", time.Minute, at(time.Hour), at(time.Minute) + 1, true},
+		{"no limit", 0, at(time.Hour), at(time.Hour) + 1, false},
+	}
+	for _, tt := range tests {
+		c := NewHLCWithClock(func() time.Time { return wall })
+		c.MaxDrift = tt.maxDrift
+		clamped := false
+		c.OnClamp = func(ts uint64) {
+			if ts != tt.observed {
+				t.Errorf("%s: clamped %d, want %d", tt.name, ts, tt.observed)
+			}
+			clamped = true
+		}
+		c.Observe(tt.observed)
+		if got := c.Now(); got != tt.want {
+			t.Errorf("%s: got %d, want %d", tt.name, got, tt.want)
+		}
+		if clamped != tt.clamped {
+			t.Errorf("%s: clamped %v, want %v", tt.name, clamped, tt.clamped)
+		}
+	}
+}
+
+func TestHLCNeverGoesBackwards(t *testing.T) {
+	wall := time.UnixMilli(1_000_000)
+	c := NewHLCWithClock(func() time.Time { return wall })
+	first := c.Now()
+	wall = wall.Add(-time.Second)
+	if second := c.Now(); second <= first {
+		t.Errorf("clock went from %d to %d when the wall clock was set back", first, second)
+	}
+	wall = wall.Add(2 * time.Second)
+	if third, want := c.Now(), uint64(wall.UnixMilli())<<16; third != want {
+		t.Errorf("got %d, want the wall clock %d once it caught up", third, want)
+	}
+}
@@ -0,0 +1,127 @@
+package gossip
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+)
+
+// MaxDigestBuckets bounds the size of a digest a peer can ask us to compute
+const MaxDigestBuckets = 1 << 16
+
+// Digest summarises a store as one hash per bucket of keys. Two stores holding identical entries have identical
+// digests, so comparing digests narrows a reconciliation down to the buckets that actually differ without
+// moving any entries
+type Digest []uint64
+
+// Diff returns the indices of the buckets that differ between two digests of the same size
+func (d Digest) Diff(other Digest) []int {
+	var differ []int
+	for i := range d {
+		if i >= len(other) || d[i] != other[i] {
+			differ = append(differ, i)
+		}
+	}
+	return differ
+}
+
+// Hash returns a hash of every field of the entry, so entries that hash equal are, for all practical purposes,
+// Equal
+func (e Entry) Hash() uint64 {
+	h := fnv.New64a()
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], e.Clock)
+	h.Write(buf[:])
+	binary.BigEndian.PutUint64(buf[:], uint64(e.Timestamp))
+	h.Write(buf[:])
+	binary.BigEndian.PutUint64(buf[:], uint64(e.TTL))
+	h.Write(buf[:])
+	if e.Deleted {
+		h.Write([]byte{1})
+	} else {
+		h.Write([]byte{0})
+	}
+	h.Write([]byte(e.Origin))
+	h.Write([]byte{0})
+	h.Write(e.Value)
+	// Left out at normal priority, so entries hash as they did before priorities
+	if e.Priority != PriorityNormal {
+		h.Write([]byte{0, byte(e.Priority)})
+	}
+	return h.Sum64()
+}
+
+// digestBucket returns the bucket a key belongs to in a digest of the given size
+func digestBucket(key string, buckets int) int {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return int(h.Sum64() % uint64(buckets))
+}
+
+// keyHash combines a key with its entry's hash, so identical entries under different keys don't cancel out
+func keyHash(key string, e Entry) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], e.Hash())
+	h.Write(buf[:])
+	return h.Sum64()
+}
+
+// Digest returns a digest of every entry, tombstones included, split into the given number of buckets, along with
+// the store version it describes
+func (s *Store) Digest(buckets int) (Digest, uint64) {
+	version := s.version.Load()
+	d := make(Digest, buckets)
+	s.scan(func(key string, e Entry, _ uint64) bool {
+		d[digestBucket(key, buckets)] ^= keyHash(key, e)
+		return true
+	})
+	return d, version
+}
+
+// KeyHashes returns the hash of every entry whose key falls in one of the given buckets of a digest of the given
+// size, for comparing the differing buckets key by key
+func (s *Store) KeyHashes(buckets int, which []int) map[string]uint64 {
+	wanted := make(map[int]bool, len(which))
+	for _, b := range which {
+		wanted[b] = true
+	}
+
+	hashes := make(map[string]uint64)
+	s.scan(func(key string, e Entry, _ uint64) bool {
+		if wanted[digestBucket(key, buckets)] {
+			hashes[key] = e.Hash()
+		}
+		return true
+	})
+	return hashes
+}
+
+// Reconcile compares the store against a peer's key hashes for the given buckets. It returns the keys whose local
+// entry the peer is missing or has a different version of, and the keys the peer has that we are missing or
+// have a different version of. A key that differs on both sides is in both lists; merging resolves it
+func (s *Store) Reconcile(buckets int, which []int, remote map[string]uint64) (send, want []string) {
+	local := s.KeyHashes(buckets, which)
+	for key, h := range local {
+		if remote[key] != h {
+			send = append(send, key)
+		}
+	}
+	for key, h := range remote {
+		if local[key] != h {
+			want = append(want, key)
+		}
+	}
+	return send, want
+}
+
+// Entries returns the entries, tombstones included, for the given keys that exist
+func (s *Store) Entries(keys []string) map[string]Entry {
+	entries := make(map[string]Entry, len(keys))
+	for _, key := range keys {
+		if e, ok := s.lookup(key); ok {
+			entries[key] = e
+		}
+	}
+	return entries
+}
@@ -0,0 +1,51 @@
+package gossip
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+)
+
+// Entry is a single replicated value together with the metadata needed to merge it. Value is opaque to the
+// store; applications usually store JSON and read it back through Typed
+type Entry struct {
+	Value     json.RawMessage `json:"value,omitempty"`
+	Timestamp int64           `json:"timestamp"`         // wall-clock time of the write, informational only
+	Clock     uint64          `json:"clock"`             // hybrid logical clock timestamp used to order writes
+	Origin    string          `json:"origin"`            // ID of the node that made the write, breaks clock ties
+	Deleted   bool            `json:"deleted,omitempty"` // tombstone: the key was deleted at Clock
+	TTL       int64           `json:"ttl,omitempty"`     // seconds after Clock at which the entry expires, 0 never
+	Priority  Priority        `json:"priority,omitzero"` // how urgently peers need the write, see Priority
+}
+
+// After reports whether e is a later write than other under last-write-wins
+func (e Entry) After(other Entry) bool {
+	if e.Clock != other.Clock {
+		return e.Clock > other.Clock
+	}
+	return e.Origin > other.Origin
+}
+
+func (e Entry) Equal(other Entry) bool {
+	return e.Timestamp == other.Timestamp && e.Clock == other.Clock && e.Origin == other.Origin &&
+		e.Deleted == other.Deleted && e.TTL == other.TTL && e.Priority == other.Priority && bytes.Equal(e.Value, other.Value)
+}
+
+// expiryMarker returns the tombstone that replaces an entry once its TTL has run out. The marker is derived
+// purely from the entry, with a clock value at the moment of expiry, so every node that expires the entry
+// produces an identical tombstone: the markers don't conflict with each other, and they lose to any write made
+// after the expiry
+func (e Entry) expiryMarker() (Entry, bool) {
+	if e.TTL <= 0 || e.Deleted {
+		return Entry{}, false
+	}
+
+	expiresAt := HLCWallTime(e.Clock).Add(time.Duration(e.TTL) * time.Second)
+	return Entry{
+		Timestamp: expiresAt.UnixNano(),
+		Clock:     uint64(expiresAt.UnixMilli()) << 16,
+		Origin:    e.Origin,
+		Deleted:   true,
+		Priority:  e.Priority,
+	}, true
+}
@@ -0,0 +1,141 @@
+package gossip
+
+import (
+	"bufio"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+)
+
+// An export is a stream of records rather than one document like a snapshot, so neither side holds the whole
+// state at once: a header, then one record per entry in key order. In the json format every record is a line of
+// JSON; in the gob format they are successive gob values
+const (
+	exportMagic   = "gossiper-export"
+	exportVersion = 1
+	exportPage    = 1000 // entries read from the store under one lock
+)
+
+// ExportHeader opens an export stream
+type ExportHeader struct {
+	Format  string    `json:"format"` // always gossiper-export
+	Version int       `json:"version"`
+	Node    string    `json:"node"` // origin of the store exported
+	Taken   time.Time `json:"taken"`
+}
+
+// ExportRecord is an entry of an export stream
+type ExportRecord struct {
+	Key   string `json:"key"`
+	Entry Entry  `json:"entry"`
+}
+
+// ExportFilter selects the entries exported
+type ExportFilter struct {
+	Prefix     string // only keys starting with it
+	Tombstones bool   // include deleted and expired entries, so that importing them deletes the keys as well
+}
+
+// Export streams the entries matching filter to w in the given format ("json" or "gob"), in key order. Entries
+// are read a page at a time, so writes carry on during a long export; an entry changed while the export runs may
+// appear with either its old or new value. It returns how many entries were written
+func (s *Store) Export(w io.Writer, format string, filter ExportFilter) (int, error) {
+	bw := bufio.NewWriter(w)
+	var encode func(v any) error
+	switch format {
+	case "json":
+		encode = json.NewEncoder(bw).Encode
+	case "gob":
+		encode = gob.NewEncoder(bw).Encode
+	default:
+		return 0, fmt.Errorf("unknown export format %q", format)
+	}
+
+	var keys []string
+	s.scan(func(key string, _ Entry, _ uint64) bool {
+		if strings.HasPrefix(key, filter.Prefix) {
+			keys = append(keys, key)
+		}
+		return true
+	})
+	slices.Sort(keys)
+
+	if err := encode(ExportHeader{Format: exportMagic, Version: exportVersion, Node: s.Origin, Taken: s.Now()}); err != nil {
+		return 0, err
+	}
+	n := 0
+	records := make([]ExportRecord, 0, exportPage)
+	for page := range slices.Chunk(keys, exportPage) {
+		records = records[:0]
+		for _, key := range page {
+			if e, ok := s.lookup(key); ok && (filter.Tombstones || !e.Deleted) {
+				records = append(records, ExportRecord{Key: key, Entry: e})
+			}
+		}
+
+		for _, record := range records {
+			if err := encode(record); err != nil {
+				return n, err
+			}
+			n++
+		}
+	}
+	return n, bw.Flush()
+}
+
+// ReadExport decodes an export stream written by Export, calling fn with its entries in batches of up to
+// batchSize, and returns how many entries it held. It stops at the first error, from the stream or from fn
+func ReadExport(r io.Reader, format string, batchSize int, fn func(entries map[string]Entry) error) (int, error) {
+	var decode func(v any) error
+	switch format {
+	case "json":
+		decode = json.NewDecoder(r).Decode
+	case "gob":
+		decode = gob.NewDecoder(bufio.NewReader(r)).Decode
+	default:
+		return 0, fmt.Errorf("unknown export format %q", format)
+	}
+
+	var header ExportHeader
+	if err := decode(&header); err != nil {
+		return 0, fmt.Errorf("failed to read export header: %w", err)
+	}
+	if header.Format != exportMagic {
+		return 0, errors.New("not a gossiper export")
+	}
+	if header.Version != exportVersion {
+		return 0, fmt.Errorf("unsupported export version %d", header.Version)
+	}
+
+	n := 0
+	batch := make(map[string]Entry, batchSize)
+	for {
+		var record ExportRecord
+		err := decode(&record)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return n, fmt.Errorf("failed to read export record %d: %w", n+1, err)
+		}
+		batch[record.Key] = record.Entry
+		n++
+		if len(batch) >= batchSize {
+			if err := fn(batch); err != nil {
+				return n, err
+			}
+			batch = make(map[string]Entry, batchSize)
+		}
+	}
+	if len(batch) > 0 {
+		if err := fn(batch); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,81 @@
+package gossip
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// MergeFunc resolves a conflict between the local and an incoming entry for the same key, returning the entry
+// to keep. To guarantee convergence it must be deterministic and give the same answer regardless of which side
+// is local, i.e. merge(a, b) == merge(b, a)
+type MergeFunc func(local, incoming Entry) Entry
+
+// LastWriteWins keeps whichever entry was written last according to the hybrid logical clock. This is the
+// default strategy
+func LastWriteWins(local, incoming Entry) Entry {
+	if incoming.After(local) {
+		return incoming
+	}
+	return local
+}
+
+// PreferValue builds a MergeFunc that keeps the entry whose value compares greater under cmp, for state where
+// "newest" isn't the right winner. Entries that compare equal, or whose values can't be decoded as V, fall back
+// to last-write-wins
+func PreferValue[V any](cmp func(a, b V) int) MergeFunc {
+	return func(local, incoming Entry) Entry {
+		var l, in V
+		if json.Unmarshal(local.Value, &l) != nil || json.Unmarshal(incoming.Value, &in) != nil {
+			return LastWriteWins(local, incoming)
+		}
+		switch c := cmp(in, l); {
+		case c > 0:
+			return incoming
+		case c < 0:
+			return local
+		default:
+			return LastWriteWins(local, incoming)
+		}
+	}
+}
+
+// SetMergeFunc registers the strategy used for every key starting with prefix. When several prefixes match,
+// the longest wins; the empty prefix sets the default for all keys. Passing a nil fn removes the registration
+func (s *Store) SetMergeFunc(prefix string, fn MergeFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if fn == nil {
+		delete(s.mergeFuncs, prefix)
+		return
+	}
+	s.mergeFuncs[prefix] = fn
+}
+
+// Resolve returns the entry two versions of key converge on, by the same rules as Merge, without changing the
+// store. It is for combining copies of an entry read from several nodes
+func (s *Store) Resolve(key string, a, b Entry) Entry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.resolveLocked(key, a, b)
+}
+
+// resolveLocked merges two entries for the same key. Deletions are always ordered by last-write-wins, whatever
+// strategy is registered, since e.g. a higher score must not be able to undo a later delete. Caller must hold s.mu
+func (s *Store) resolveLocked(key string, local, incoming Entry) Entry {
+	if local.Deleted || incoming.Deleted {
+		return LastWriteWins(local, incoming)
+	}
+	return s.mergeFuncLocked(key)(local, incoming)
+}
+
+// mergeFuncLocked returns the strategy registered for the longest prefix of key. Caller must hold s.mu
+func (s *Store) mergeFuncLocked(key string) MergeFunc {
+	best, fn := -1, MergeFunc(LastWriteWins)
+	for prefix, candidate := range s.mergeFuncs {
+		if len(prefix) > best && strings.HasPrefix(key, prefix) {
+			best, fn = len(prefix), candidate
+		}
+	}
+	return fn
+}
@@ -0,0 +1,76 @@
+package gossip
+
+import (
+	"fmt"
+	"time"
+)
+
+// Priority tells gossip how urgently peers need an entry's writes. High-priority entries, such as match results,
+// go out in every round even when the state changing around them is too much to send at once; low-priority ones,
+// such as cosmetics, wait for rounds with room to spare. Priority is a property of an entry that its writes keep,
+// see Store.UpdateEntryPriority
+type Priority int8
+
+const (
+	PriorityLow    Priority = -1
+	PriorityNormal Priority = 0
+	PriorityHigh   Priority = 1
+)
+
+// ParsePriority parses "high", "normal" or "low"
+func ParsePriority(s string) (Priority, error) {
+	switch s {
+	case "low":
+		return PriorityLow, nil
+	case "normal":
+		return PriorityNormal, nil
+	case "high":
+		return PriorityHigh, nil
+	}
+	return 0, fmt.Errorf("unknown priority %q, want high, normal or low", s)
+}
+
+func (p Priority) String() string {
+	switch {
+	case p < PriorityNormal:
+		return "low"
+	case p > PriorityNormal:
+		return "high"
+	}
+	return "normal"
+}
+
+func (p Priority) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+func (p *Priority) UnmarshalText(text []byte) error {
+	parsed, err := ParsePriority(string(text))
+	if err != nil {
+		return err
+	}
+	*p = parsed
+	return nil
+}
+
+// UpdateEntryPriority is UpdateEntry for a write that also sets the entry's priority, which later writes keep
+func (s *Store) UpdateEntryPriority(key string, ttl time.Duration, priority Priority,
+	fn func(current Entry, ok bool, clock uint64) ([]byte, error)) (Entry, error) {
+	return s.updateEntry(key, ttl, &priority, fn)
+}
+
+// PriorityDelta returns the entries changed since the given version with at least the given priority, for rounds
+// that send them ahead of the rest of a delta
+func (s *Store) PriorityDelta(since uint64, priority Priority) map[string]Entry {
+	var delta map[string]Entry
+	s.scan(func(key string, e Entry, changed uint64) bool {
+		if changed > since && e.Priority >= priority {
+			if delta == nil {
+				delta = make(map[string]Entry)
+			}
+			delta[key] = e
+		}
+		return true
+	})
+	return delta
+}
@@ -0,0 +1,86 @@
+package gossip
+
+import (
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"gmathur.dev/gossiper/store"
+)
+
+// snapshot is the on-disk layout of a snapshot file
+type snapshot struct {
+	Node    string           `json:"node"`
+	Taken   time.Time        `json:"taken"`
+	Entries map[string]Entry `json:"entries"`
+}
+
+// SaveSnapshot writes every entry, tombstones included, to path in the given format ("json" or "gob"),
+// atomically replacing any previous snapshot
+func (s *Store) SaveSnapshot(path, format string) error {
+	return store.WriteFileAtomic(path, func(w io.Writer) error {
+		return s.WriteSnapshot(w, format)
+	})
+}
+
+// WriteSnapshot writes every entry, tombstones included, to w in the given format ("json" or "gob")
+func (s *Store) WriteSnapshot(w io.Writer, format string) error {
+	if format != "json" && format != "gob" {
+		return fmt.Errorf("unknown snapshot format %q", format)
+	}
+
+	snap := snapshot{Node: s.Origin, Taken: s.Now(), Entries: make(map[string]Entry, s.Len())}
+	s.scan(func(key string, e Entry, _ uint64) bool {
+		snap.Entries[key] = e
+		return true
+	})
+
+	if format == "gob" {
+		return gob.NewEncoder(w).Encode(snap)
+	}
+	return json.NewEncoder(w).Encode(snap)
+}
+
+// ReadSnapshot decodes the entries of a snapshot written by WriteSnapshot
+func ReadSnapshot(r io.Reader, format string) (map[string]Entry, error) {
+	var snap snapshot
+	var err error
+	switch format {
+	case "json":
+		err = json.NewDecoder(r).Decode(&snap)
+	case "gob":
+		err = gob.NewDecoder(r).Decode(&snap)
+	default:
+		err = fmt.Errorf("unknown snapshot format %q", format)
+	}
+	return snap.Entries, err
+}
+
+// LoadSnapshot merges a snapshot file into the store and returns how many entries it held. A missing file is not
+// an error, it just means no snapshot has been taken yet
+func (s *Store) LoadSnapshot(path, format string) (int, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	entries, err := ReadSnapshot(f, format)
+	if err != nil {
+		return 0, fmt.Errorf("failed to load snapshot %s: %w", path, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, e := range entries {
+		s.restoreLocked(key, e)
+	}
+	return len(entries), nil
+}
@@ -0,0 +1,572 @@
+// Package gossip is a replicated key-value state engine for gossip protocols. A Store holds opaque,
+// versioned entries and knows how to merge entries from peers, hand out deltas for peers to catch up on, expire
+// and delete entries, and persist itself; moving messages between nodes is left to the caller
+package gossip
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Backend persists every change to a Store so that a restarted node comes back with its pre-crash view instead
+// of an empty map. Values are handed over already encoded, so backends (a file WAL, bolt, badger, ...) only deal
+// with bytes
+type Backend interface {
+	Save(key string, value []byte) error
+	Load(fn func(key string, value []byte)) error
+	Close() error
+}
+
+// Remover is implemented by backends that can forget a key, so that entries the store drops without a tombstone,
+// see CollectTombstones and DropEntries, don't come back on Restore
+type Remover interface {
+	Remove(key string) error
+}
+
+// HealthChecker is implemented by backends that can fail outside of Save, for example while flushing in the
+// background. See Store.Healthy
+type HealthChecker interface {
+	Healthy() error
+}
+
+// Store is a replicated map from string keys to entries. Every change bumps the store's version, which is
+// recorded against the changed key, so a delta is simply every entry above some version watermark. Entries are
+// split into shards, see shard, so that reads don't hold up writes
+type Store struct {
+	Origin  string           // node ID stamped on local writes
+	Clock   *HLC             // orders local writes after everything seen from peers
+	Backend Backend          // optional durable storage, see Restore
+	Logger  *slog.Logger     // used to report persistence failures
+	Now     func() time.Time // wall clock for timestamps and the HLC; replace before the first write to fake time
+
+	mu         sync.RWMutex // held to change the store, and to read mergeFuncs
+	shards     [storeShards]shard
+	version    atomic.Uint64        // of the last change, published once the change is in its shard
+	mergeFuncs map[string]MergeFunc // conflict resolution strategy by key prefix, see SetMergeFunc
+	observers  []func(Change)
+	persistErr error // result of the last write to Backend
+}
+
+func NewStore(origin string, logger *slog.Logger) *Store {
+	s := &Store{
+		Origin:     origin,
+		Logger:     logger,
+		Now:        time.Now,
+		mergeFuncs: make(map[string]MergeFunc),
+	}
+	for i := range s.shards {
+		s.shards[i] = shard{entries: make(map[string]Entry), versions: make(map[string]uint64)}
+	}
+	s.Clock = NewHLCWithClock(func() time.Time { return s.Now() })
+	return s
+}
+
+// storeShards is the number of shards a Store's entries are split into
+const storeShards = 32
+
+// shard holds the entries of the keys that hash to it. Writers hold Store.mu, which orders every change, and the
+// lock of the shard they change; readers only take the locks of the shards they read, one at a time. So a scan of
+// a large store, such as a gossip delta or a copy of the state, holds up a write for no longer than it takes to
+// scan one shard, and lookups of different keys don't wait on each other
+type shard struct {
+	mu       sync.RWMutex
+	entries  map[string]Entry
+	versions map[string]uint64 // store version each entry was last changed at
+}
+
+// shardOf returns the shard holding key, by its FNV-1a hash
+func (s *Store) shardOf(key string) *shard {
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return &s.shards[h%storeShards]
+}
+
+// lookup returns the entry for key, tombstones included
+func (s *Store) lookup(key string) (Entry, bool) {
+	sh := s.shardOf(key)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+	e, ok := sh.entries[key]
+	return e, ok
+}
+
+// entryLocked is lookup for callers holding s.mu, while which no shard changes but by them
+func (s *Store) entryLocked(key string) (Entry, bool) {
+	e, ok := s.shardOf(key).entries[key]
+	return e, ok
+}
+
+// scan calls fn for every entry, tombstones included, with the version it was last changed at, until fn returns
+// false. Each shard is read-locked while fn runs on its entries, so fn must not call back into the store. Changes
+// made during the scan may or may not be seen
+func (s *Store) scan(fn func(key string, e Entry, version uint64) bool) {
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.RLock()
+		for key, e := range sh.entries {
+			if !fn(key, e, sh.versions[key]) {
+				sh.mu.RUnlock()
+				return
+			}
+		}
+		sh.mu.RUnlock()
+	}
+}
+
+// scanLocked is scan for callers holding s.mu, which may change the entries they are given with putLocked or
+// removeLocked as they go
+func (s *Store) scanLocked(fn func(key string, e Entry, version uint64)) {
+	for i := range s.shards {
+		sh := &s.shards[i]
+		for key, e := range sh.entries {
+			fn(key, e, sh.versions[key])
+		}
+	}
+}
+
+// putLocked stores an entry at the next version. Caller must hold s.mu
+func (s *Store) putLocked(key string, e Entry) {
+	version := s.version.Load() + 1
+	sh := s.shardOf(key)
+	sh.mu.Lock()
+	sh.entries[key] = e
+	sh.versions[key] = version
+	sh.mu.Unlock()
+	// Only once the entry is in place, so that a delta up to the version misses nothing
+	s.version.Store(version)
+}
+
+// removeLocked forgets the entry for key. Caller must hold s.mu
+func (s *Store) removeLocked(key string) {
+	sh := s.shardOf(key)
+	sh.mu.Lock()
+	delete(sh.entries, key)
+	delete(sh.versions, key)
+	sh.mu.Unlock()
+}
+
+// MergeStats summarises what a Merge changed
+type MergeStats struct {
+	Added        int // keys we didn't have before
+	KeptLocal    int // conflicts where the local entry won
+	TookIncoming int // conflicts where the incoming entry won
+	Combined     int // conflicts where the merge function produced a new entry
+}
+
+// ChangeSource says what caused a change to an entry
+type ChangeSource int
+
+const (
+	ChangeLocal    ChangeSource = iota // Set, Update or Delete on this store
+	ChangeRemote                       // Merge of an entry from a peer
+	ChangeExpired                      // ExpireEntries replaced the entry with its expiry marker
+	ChangeRestored                     // Restore or LoadSnapshot
+	ChangeDropped                      // DropEntries forgot the entry locally; New is the zero Entry
+)
+
+// Change describes one entry written to the store. Deletions and expiries arrive as tombstones
+type Change struct {
+	Key     string
+	Old     Entry // previous entry for Key, the zero Entry if Existed is false
+	New     Entry
+	Existed bool // whether the store had an entry, possibly a tombstone, for Key before the change
+	Source  ChangeSource
+}
+
+// OnChange registers fn to be called with every change to the store. fn runs with the store locked, so it must be
+// quick and must not call back into the store. Register observers before the store is in use
+func (s *Store) OnChange(fn func(Change)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.observers = append(s.observers, fn)
+}
+
+// Set writes a new value for key, stamped with the store's clock. A ttl of 0 never expires
+func (s *Store) Set(key string, value []byte, ttl time.Duration) Entry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e := Entry{
+		Value:     value,
+		Timestamp: s.Now().UnixNano(),
+		Clock:     s.Clock.Now(),
+		Origin:    s.Origin,
+		TTL:       int64(ttl.Round(time.Second) / time.Second),
+	}
+	current, _ := s.entryLocked(key)
+	e.Priority = current.Priority
+	s.setLocked(key, e, ChangeLocal)
+	return e
+}
+
+// Update atomically replaces the value for key with the result of fn, for read-modify-write updates such as
+// incrementing a CRDT counter. fn gets the current value, nil if key doesn't exist or was deleted, and the clock
+// the new write will carry, for values that embed their own timestamps. If fn fails nothing is written
+func (s *Store) Update(key string, ttl time.Duration, fn func(value []byte, clock uint64) ([]byte, error)) (Entry, error) {
+	return s.UpdateEntry(key, ttl, func(current Entry, ok bool, clock uint64) ([]byte, error) {
+		return fn(current.Value, clock)
+	})
+}
+
+// UpdateEntry is Update for values that need more of the current entry than its value, such as the clock it was
+// written at. ok is false, and current the zero Entry, if key doesn't exist or was deleted
+func (s *Store) UpdateEntry(key string, ttl time.Duration, fn func(current Entry, ok bool, clock uint64) ([]byte, error)) (Entry, error) {
+	return s.updateEntry(key, ttl, nil, fn)
+}
+
+// updateEntry is UpdateEntry, setting the entry's priority unless priority is nil
+func (s *Store) updateEntry(key string, ttl time.Duration, priority *Priority,
+	fn func(current Entry, ok bool, clock uint64) ([]byte, error)) (Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, ok := s.entryLocked(key)
+	kept := current.Priority
+	if priority != nil {
+		kept = *priority
+	}
+	if ok && current.Deleted {
+		current, ok = Entry{}, false
+	}
+	clock := s.Clock.Now()
+	value, err := fn(current, ok, clock)
+	if err != nil {
+		return Entry{}, err
+	}
+
+	e := Entry{
+		Value:     value,
+		Timestamp: s.Now().UnixNano(),
+		Clock:     clock,
+		Origin:    s.Origin,
+		TTL:       int64(ttl.Round(time.Second) / time.Second),
+		Priority:  kept,
+	}
+	s.setLocked(key, e, ChangeLocal)
+	return e, nil
+}
+
+// Delete removes key cluster-wide. Simply dropping the key would not work, as the next gossip round from a peer
+// that still has it would bring it back, so it is replaced by a tombstone that is gossiped like any other write.
+// Tombstones are garbage collected by CollectTombstones; a peer that was partitioned away for longer than that
+// can still resurrect the key
+func (s *Store) Delete(key string) Entry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.deleteLocked(key)
+}
+
+// DeleteIf is Delete for conditional deletes, such as releasing a lease only while its holder still has it: key
+// is deleted only if it exists and match returns true for its current entry. It reports whether key was deleted
+func (s *Store) DeleteIf(key string, match func(current Entry) bool) (Entry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, ok := s.entryLocked(key)
+	if !ok || current.Deleted || !match(current) {
+		return Entry{}, false
+	}
+	return s.deleteLocked(key), true
+}
+
+func (s *Store) deleteLocked(key string) Entry {
+	current, _ := s.entryLocked(key)
+	e := Entry{
+		Timestamp: s.Now().UnixNano(),
+		Clock:     s.Clock.Now(),
+		Origin:    s.Origin,
+		Deleted:   true,
+		Priority:  current.Priority,
+	}
+	s.setLocked(key, e, ChangeLocal)
+	return e
+}
+
+// Get returns the entry for key, if it exists and hasn't been deleted
+func (s *Store) Get(key string) (Entry, bool) {
+	e, ok := s.lookup(key)
+	if !ok || e.Deleted {
+		return Entry{}, false
+	}
+	return e, true
+}
+
+// Range calls fn for every live entry until fn returns false. The store is read-locked a shard at a time, so fn
+// must not call back into it, and writes made meanwhile may or may not be seen
+func (s *Store) Range(fn func(key string, e Entry) bool) {
+	s.scan(func(key string, e Entry, _ uint64) bool {
+		return e.Deleted || fn(key, e)
+	})
+}
+
+// Len returns the number of entries, including tombstones
+func (s *Store) Len() int {
+	n := 0
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.RLock()
+		n += len(sh.entries)
+		sh.mu.RUnlock()
+	}
+	return n
+}
+
+// Version returns the store's current version
+func (s *Store) Version() uint64 {
+	return s.version.Load()
+}
+
+// Merge applies entries received from a peer
+func (s *Store) Merge(incoming map[string]Entry) MergeStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var stats MergeStats
+	for key, in := range incoming {
+		s.Clock.Observe(in.Clock)
+		local, exists := s.entryLocked(key)
+		if !exists {
+			s.setLocked(key, in, ChangeRemote)
+			stats.Added++
+			continue
+		}
+		if in.Equal(local) {
+			continue
+		}
+		merged := s.resolveLocked(key, local, in)
+		switch {
+		case merged.Equal(local):
+			stats.KeptLocal++
+		case merged.Equal(in):
+			stats.TookIncoming++
+			s.setLocked(key, merged, ChangeRemote)
+		default:
+			stats.Combined++
+			s.setLocked(key, merged, ChangeRemote)
+		}
+	}
+	return stats
+}
+
+// Delta returns every entry, tombstones included, changed after the given version along with the store's
+// current version. A since of 0 returns the full map. Entries changed while the delta is taken may be in it
+// although the version returned doesn't cover them; they are sent again in the next delta
+func (s *Store) Delta(since uint64) (map[string]Entry, uint64) {
+	version := s.version.Load()
+	delta := make(map[string]Entry)
+	s.scan(func(key string, e Entry, changed uint64) bool {
+		if changed > since {
+			delta[key] = e
+		}
+		return true
+	})
+	return delta, version
+}
+
+// DeltaWithin is Delta capped at roughly maxBytes of JSON-encoded entries. Entries are taken oldest change
+// first, and the returned version is the one up to which the delta is complete, so a caller that uses it as its
+// watermark picks up the rest next time. At least one entry is always returned, however large. A maxBytes of 0
+// or less means no cap
+func (s *Store) DeltaWithin(since uint64, maxBytes int) (map[string]Entry, uint64) {
+	if maxBytes <= 0 {
+		return s.Delta(since)
+	}
+
+	// Changes made after version are left out: one in a shard already scanned would be missed, so a cut beyond
+	// version could skip it. The entries are encoded once the shards are unlocked
+	version := s.version.Load()
+	type change struct {
+		key     string
+		e       Entry
+		version uint64
+	}
+	var changes []change
+	s.scan(func(key string, e Entry, changed uint64) bool {
+		if changed > since && changed <= version {
+			changes = append(changes, change{key, e, changed})
+		}
+		return true
+	})
+	sort.Slice(changes, func(i, j int) bool { return changes[i].version < changes[j].version })
+
+	delta := make(map[string]Entry)
+	size := 0
+	for _, c := range changes {
+		encoded, _ := json.Marshal(c.e)
+		size += len(c.key) + len(encoded)
+		if size > maxBytes && len(delta) > 0 {
+			return delta, c.version - 1
+		}
+		delta[c.key] = c.e
+	}
+	return delta, version
+}
+
+// setLocked stores an entry, records the change for deltas and persists it. Caller must hold s.mu
+func (s *Store) setLocked(key string, e Entry, source ChangeSource) {
+	old, existed := s.entryLocked(key)
+	s.putLocked(key, e)
+	s.persistLocked(key, e)
+	s.notifyLocked(Change{Key: key, Old: old, New: e, Existed: existed, Source: source})
+}
+
+// notifyLocked calls the OnChange observers. Caller must hold s.mu
+func (s *Store) notifyLocked(c Change) {
+	for _, fn := range s.observers {
+		fn(c)
+	}
+}
+
+// ExpireEntries replaces every entry whose TTL has run out by now with its expiry marker, returning how many
+// expired
+func (s *Store) ExpireEntries(now time.Time) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	clock := uint64(now.UnixMilli()) << 16
+	expired := 0
+	s.scanLocked(func(key string, e Entry, _ uint64) {
+		if marker, ok := e.expiryMarker(); ok && marker.Clock <= clock {
+			s.setLocked(key, marker, ChangeExpired)
+			expired++
+		}
+	})
+	return expired
+}
+
+// CollectTombstones forgets tombstones written before the cutoff and returns how many were removed
+func (s *Store) CollectTombstones(cutoff time.Time) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	removed := 0
+	s.scanLocked(func(key string, e Entry, _ uint64) {
+		if e.Deleted && HLCWallTime(e.Clock).Before(cutoff) {
+			s.removeLocked(key)
+			s.unpersistLocked(key)
+			removed++
+		}
+	})
+	return removed
+}
+
+// DropEntries forgets, on this store only, every entry for which drop returns true, and returns how many were
+// removed. Unlike Delete nothing is gossiped: it is for entries the node no longer needs to hold, which live on
+// elsewhere. drop gets each entry with the version it was last changed at and runs with the store locked, so it
+// must not call back into the store
+func (s *Store) DropEntries(drop func(key string, e Entry, version uint64) bool) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	removed := 0
+	s.scanLocked(func(key string, e Entry, version uint64) {
+		if drop(key, e, version) {
+			s.removeLocked(key)
+			s.unpersistLocked(key)
+			s.notifyLocked(Change{Key: key, Old: e, Existed: true, Source: ChangeDropped})
+			removed++
+		}
+	})
+	return removed
+}
+
+// Restore loads the map from s.Backend. Restored entries count as changes, so they are gossiped to peers that
+// may have missed them while this node was down
+func (s *Store) Restore() error {
+	if s.Backend == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var decodeErr error
+	err := s.Backend.Load(func(key string, value []byte) {
+		var e Entry
+		if err := json.Unmarshal(value, &e); err != nil {
+			decodeErr = fmt.Errorf("failed to decode stored entry %s: %w", key, err)
+			return
+		}
+		s.restoreLocked(key, e)
+	})
+	if err != nil {
+		return err
+	}
+	return decodeErr
+}
+
+// restoreLocked merges an entry loaded from disk without writing it back to the backend. Caller must hold s.mu
+func (s *Store) restoreLocked(key string, e Entry) {
+	s.Clock.Observe(e.Clock)
+	local, exists := s.entryLocked(key)
+	if exists {
+		e = s.resolveLocked(key, local, e)
+		if e.Equal(local) {
+			return
+		}
+	}
+	s.putLocked(key, e)
+	s.notifyLocked(Change{Key: key, Old: local, New: e, Existed: exists, Source: ChangeRestored})
+}
+
+// Close closes s.Backend, flushing anything it has buffered
+func (s *Store) Close() error {
+	if s.Backend == nil {
+		return nil
+	}
+	return s.Backend.Close()
+}
+
+// persistLocked writes a changed entry to the backend. A failed write is logged rather than failing the change:
+// the change is still in memory and will be gossiped, it just won't survive a restart of this node
+func (s *Store) persistLocked(key string, e Entry) {
+	if s.Backend == nil {
+		return
+	}
+
+	value, err := json.Marshal(e)
+	if err == nil {
+		err = s.Backend.Save(key, value)
+	}
+	if err != nil {
+		s.Logger.Error("failed to persist entry", "key", key, "err", err)
+	}
+	s.persistErr = err
+}
+
+// unpersistLocked removes a forgotten entry from the backend, if it is a Remover. A failure is logged like one in
+// persistLocked
+func (s *Store) unpersistLocked(key string) {
+	r, ok := s.Backend.(Remover)
+	if !ok {
+		return
+	}
+	err := r.Remove(key)
+	if err != nil {
+		s.Logger.Error("failed to remove persisted entry", "key", key, "err", err)
+	}
+	s.persistErr = err
+}
+
+// Healthy reports whether changes are reaching the backend: it returns the error from the last write if that
+// failed, or the backend's own error if it is a HealthChecker
+func (s *Store) Healthy() error {
+	s.mu.RLock()
+	err := s.persistErr
+	s.mu.RUnlock()
+	if err != nil {
+		return fmt.Errorf("failed to persist last change: %w", err)
+	}
+	if hc, ok := s.Backend.(HealthChecker); ok {
+		return hc.Healthy()
+	}
+	return nil
+}
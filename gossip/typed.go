@@ -0,0 +1,69 @@
+package gossip
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Typed is a view over a Store whose values are JSON encodings of V, for applications that would rather not deal
+// with raw bytes
+type Typed[V any] struct {
+	Store *Store
+}
+
+func NewTyped[V any](s *Store) Typed[V] {
+	return Typed[V]{Store: s}
+}
+
+// Get returns the decoded value for key along with its entry metadata
+func (t Typed[V]) Get(key string) (V, Entry, bool) {
+	var v V
+	e, ok := t.Store.Get(key)
+	if !ok || json.Unmarshal(e.Value, &v) != nil {
+		return v, Entry{}, false
+	}
+	return v, e, true
+}
+
+// Set encodes value and writes it for key. A ttl of 0 never expires
+func (t Typed[V]) Set(key string, value V, ttl time.Duration) (Entry, error) {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return Entry{}, err
+	}
+	return t.Store.Set(key, encoded, ttl), nil
+}
+
+// Update atomically replaces the value for key with the result of fn, see Store.Update. fn gets the zero V when
+// key doesn't exist
+func (t Typed[V]) Update(key string, ttl time.Duration, fn func(value V, clock uint64) (V, error)) (Entry, error) {
+	return t.Store.Update(key, ttl, func(current []byte, clock uint64) ([]byte, error) {
+		var v V
+		if current != nil {
+			if err := json.Unmarshal(current, &v); err != nil {
+				return nil, err
+			}
+		}
+		v, err := fn(v, clock)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(v)
+	})
+}
+
+func (t Typed[V]) Delete(key string) Entry {
+	return t.Store.Delete(key)
+}
+
+// Range calls fn with every live entry whose value decodes as V, until fn returns false. As with Store.Range, fn
+// must not call back into the store
+func (t Typed[V]) Range(fn func(key string, value V, e Entry) bool) {
+	t.Store.Range(func(key string, e Entry) bool {
+		var v V
+		if json.Unmarshal(e.Value, &v) != nil {
+			return true
+		}
+		return fn(key, v, e)
+	})
+}
@@ -0,0 +1,152 @@
+// Package gossiptest runs several game servers in one process for tests. Nodes are connected by an in-memory
+// Network instead of sockets and share a fake Clock, and rounds are driven by the caller rather than by timers,
+// so merge and gossip behaviour can be tested deterministically and quickly
+package gossiptest
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"slices"
+	"time"
+
+	"gmathur.dev/gossiper/server"
+)
+
+// TB is the subset of testing.TB used by the assertions
+type TB interface {
+	Helper()
+	Fatalf(format string, args ...any)
+}
+
+// Cluster is a set of fully meshed nodes. Node i is named node-i and listens on the address node-i
+type Cluster struct {
+	Nodes   []*server.GameServer
+	Clock   *Clock
+	Network *Network
+	Rand    *rand.Rand // picks gossip peers; seeded with 1, so runs are repeatable
+}
+
+// NewCluster creates n nodes that all know about each other. configure, if not nil, is called on every node
+// before it is attached to the network, to set the gossip mode, merge functions and so on
+func NewCluster(n int, configure func(gs *server.GameServer)) *Cluster {
+	c := &Cluster{
+		Clock:   NewClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)),
+		Network: NewNetwork(),
+		Rand:    rand.New(rand.NewSource(1)),
+	}
+
+	addrs := make([]string, n)
+	for i := range addrs {
+		addrs[i] = fmt.Sprintf("node-%d", i)
+	}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	for _, addr := range addrs {
+		gs := server.NewGameServer(addr, addr, addrs, server.WithLogger(logger), server.WithClock(c.Clock.Now))
+		if configure != nil {
+			configure(gs)
+		}
+		c.Network.Attach(gs)
+		c.Nodes = append(c.Nodes, gs)
+	}
+	return c
+}
+
+// Step advances the clock by one gossip interval, then runs a round and maintenance
+func (c *Cluster) Step() {
+	c.Clock.Advance(c.Nodes[0].Gossip.Interval)
+	c.Round()
+	c.Maintain()
+}
+
+// Round runs one gossip round on every node in turn. Like a live node, each gossips with Fanout of the peers it
+// believes are alive, but the peers are picked with c.Rand and contacted one after another, so the whole round is
+// repeatable
+func (c *Cluster) Round() {
+	for _, gs := range c.Nodes {
+		peers := gs.Membership.Peers()
+		slices.Sort(peers)
+		c.Rand.Shuffle(len(peers), func(i, j int) { peers[i], peers[j] = peers[j], peers[i] })
+		for _, peerAddr := range peers[:min(max(gs.Gossip.Fanout, 1), len(peers))] {
+			gs.GossipWithPeer(context.Background(), peerAddr)
+		}
+	}
+}
+
+// Maintain runs expiry and tombstone collection on every node at the current time
+func (c *Cluster) Maintain() {
+	now := c.Clock.Now()
+	for _, gs := range c.Nodes {
+		gs.State.ExpireEntries(now)
+		if gs.TombstoneTTL > 0 {
+			gs.State.CollectTombstones(now.Add(-gs.TombstoneTTL))
+		}
+	}
+}
+
+// Converged reports whether every node holds exactly the same entries
+func (c *Cluster) Converged() bool {
+	keys := c.keys()
+	first := c.Nodes[0].State.Entries(keys)
+	for _, gs := range c.Nodes[1:] {
+		other := gs.State.Entries(keys)
+		if len(other) != len(first) {
+			return false
+		}
+		for key, e := range first {
+			if o, ok := other[key]; !ok || !o.Equal(e) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// RunUntilConverged steps the cluster until it converges or maxRounds have run, returning the number of rounds
+// it took and whether it converged
+func (c *Cluster) RunUntilConverged(maxRounds int) (int, bool) {
+	for round := 0; round < maxRounds; round++ {
+		if c.Converged() {
+			return round, true
+		}
+		c.Step()
+	}
+	return maxRounds, c.Converged()
+}
+
+// AssertConverged fails the test unless the cluster converges within maxRounds
+func (c *Cluster) AssertConverged(t TB, maxRounds int) {
+	t.Helper()
+	if _, ok := c.RunUntilConverged(maxRounds); !ok {
+		t.Fatalf("cluster of %d nodes did not converge within %d rounds", len(c.Nodes), maxRounds)
+	}
+}
+
+// AssertScore fails the test unless every node reports the given score for the player
+func (c *Cluster) AssertScore(t TB, playerId string, score int64) {
+	t.Helper()
+	for _, gs := range c.Nodes {
+		p, _, ok := gs.Players.Get(playerId)
+		if !ok {
+			t.Fatalf("%s: player %s not found", gs.ID, playerId)
+		}
+		if p.Score != score {
+			t.Fatalf("%s: player %s has score %d, want %d", gs.ID, playerId, p.Score, score)
+		}
+	}
+}
+
+// keys returns every key held by any node, tombstones included
+func (c *Cluster) keys() []string {
+	var keys []string
+	for _, gs := range c.Nodes {
+		delta, _ := gs.State.Delta(0)
+		for key := range delta {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	return slices.Compact(keys)
+}
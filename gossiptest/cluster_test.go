@@ -0,0 +1,161 @@
+package gossiptest
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"gmathur.dev/gossiper/gossip"
+	"gmathur.dev/gossiper/server"
+)
+
+func TestGossipConverges(t *testing.T) {
+	c := NewCluster(5, nil)
+	for i, gs := range c.Nodes {
+		gs.UpdatePlayerScore(fmt.Sprintf("player-%d", i), int64(10*i))
+	}
+
+	c.AssertConverged(t, 50)
+	for i := range c.Nodes {
+		c.AssertScore(t, fmt.Sprintf("player-%d", i), int64(10*i))
+	}
+}
+
+func TestPushGossipConverges(t *testing.T) {
+	c := NewCluster(5, func(gs *server.GameServer) { gs.Mode = server.GossipPush })
+	c.Nodes[2].UpdatePlayerScore("alice", 7)
+
+	c.AssertConverged(t, 50)
+	c.AssertScore(t, "alice", 7)
+}
+
+func TestLaterWriteWins(t *testing.T) {
+	c := NewCluster(4, nil)
+	c.Nodes[0].UpdatePlayerScore("alice", 1)
+	c.Clock.Advance(time.Second)
+	c.Nodes[3].UpdatePlayerScore("alice", 2)
+
+	c.AssertConverged(t, 50)
+	c.AssertScore(t, "alice", 2)
+}
+
+func TestConcurrentWritesConverge(t *testing.T) {
+	c := NewCluster(3, nil)
+	// Written at the same instant, so the ordering falls to the clocks and origins, which every node breaks alike
+	for i, gs := range c.Nodes {
+		gs.UpdatePlayerScore("alice", int64(i+1))
+	}
+
+	c.AssertConverged(t, 50)
+	want, ok := c.Nodes[0].GetPlayer("alice")
+	if !ok {
+		t.Fatal("alice not found")
+	}
+	c.AssertScore(t, "alice", want.Score)
+}
+
+func TestDeleteConverges(t *testing.T) {
+	c := NewCluster(3, func(gs *server.GameServer) { gs.TombstoneTTL = time.Hour })
+	c.Nodes[0].UpdatePlayerScore("alice", 5)
+	c.AssertConverged(t, 50)
+
+	c.Clock.Advance(time.Second)
+	c.Nodes[1].DeletePlayer("alice")
+	c.AssertConverged(t, 50)
+	for _, gs := range c.Nodes {
+		if _, ok := gs.GetPlayer("alice"); ok {
+			t.Fatalf("%s: deleted player alice still found", gs.ID)
+		}
+	}
+}
+
+func TestMergeState(t *testing.T) {
+	c := NewCluster(2, nil)
+	from, to := c.Nodes[0], c.Nodes[1]
+	from.UpdatePlayerScore("alice", 5)
+	older, _ := from.State.Delta(0)
+
+	if stats := to.MergeState(older); stats.Added != 1 {
+		t.Fatalf("first merge: got %+v, want 1 added", stats)
+	}
+	if stats := to.MergeState(older); stats != (gossip.MergeStats{}) {
+		t.Fatalf("merging the same entries again: got %+v, want no changes", stats)
+	}
+
+	c.Clock.Advance(time.Second)
+	from.UpdatePlayerScore("alice", 9)
+	newer, _ := from.State.Delta(0)
+	if stats := to.MergeState(newer); stats.TookIncoming != 1 {
+		t.Fatalf("merging a later write: got %+v, want 1 taken", stats)
+	}
+	if stats := to.MergeState(older); stats.KeptLocal != 1 {
+		t.Fatalf("merging an earlier write: got %+v, want 1 kept", stats)
+	}
+	if p, _ := to.GetPlayer("alice"); p.Score != 9 {
+		t.Fatalf("alice has score %d after merges, want 9", p.Score)
+	}
+}
+
+// TestStartedNodesOverNetworkTransport runs nodes on their own over the network, with no HTTP to fall back on: the
+// last one only knows a seed, and has to join through it before gossip, settings and broadcasts reach it
+func TestStartedNodesOverNetworkTransport(t *testing.T) {
+	network := NewNetwork()
+	newNode := func(addr string, peers, seeds []string) *server.GameServer {
+		gs := server.NewGameServer(addr, addr, peers, server.WithTransport(network.Transport(addr)),
+			server.WithGossipInterval(20*time.Millisecond))
+		gs.Seeds = seeds
+		gs.Bootstrap.Timeout = 0
+		gs.SettingsGossip.Interval = 20 * time.Millisecond
+		gs.Broadcasts.Interval = 20 * time.Millisecond
+		return gs
+	}
+	a := newNode("node-a:1", []string{"node-b:1"}, nil)
+	b := newNode("node-b:1", []string{"node-a:1"}, nil)
+	c := newNode("node-c:1", nil, []string{"node-a:1"})
+	heard := make(chan server.Message, 1)
+	c.OnBroadcast(func(msg server.Message) { heard <- msg })
+	for _, gs := range []*server.GameServer{a, b, c} {
+		gs.Start(t.Context())
+	}
+
+	a.UpdatePlayerScore("alice", 3)
+	if err := a.SetSetting("motd", "hello"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Broadcast(server.Message{Topic: "match-started"}); err != nil {
+		t.Fatal(err)
+	}
+
+	eventually(t, "c to learn of b", func() bool { return len(c.Membership.Peers()) == 2 })
+	eventually(t, "alice to reach c", func() bool {
+		p, ok := c.GetPlayer("alice")
+		return ok && p.Score == 3
+	})
+	eventually(t, "the setting to reach c", func() bool {
+		motd, _ := c.Setting("motd")
+		return motd == "hello"
+	})
+	select {
+	case msg := <-heard:
+		if msg.Topic != "match-started" {
+			t.Errorf("c heard %q, want match-started", msg.Topic)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("the broadcast did not reach c")
+	}
+	if cs := c.ClusterStatus(t.Context()); len(cs.Nodes) != 3 || len(cs.Errors) != 0 {
+		t.Errorf("cluster status holds %d nodes, errors %v", len(cs.Nodes), cs.Errors)
+	}
+}
+
+// eventually fails the test if cond doesn't hold within 5 seconds
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,230 @@
+package gossiptest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"gmathur.dev/gossiper/gossip"
+	"gmathur.dev/gossiper/server"
+)
+
+// Network is an in-memory gossip transport connecting the nodes of a cluster without sockets. Messages are
+// delivered synchronously, and round-tripped through JSON on the way so that they look exactly like they would
+// after crossing the wire. Nodes driven by a Cluster are attached to it; nodes that run on their own, started with
+// Start, are given a Transport of it instead
+type Network struct {
+	mu    sync.Mutex
+	nodes map[string]*server.GameServer
+}
+
+func NewNetwork() *Network {
+	return &Network{nodes: make(map[string]*server.GameServer)}
+}
+
+// Attach connects a node to the network under its address and makes the network its transport, and that of its
+// failure detector's probes
+func (n *Network) Attach(gs *server.GameServer) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.nodes[gs.Address] = gs
+	gs.Transport = n
+	gs.Membership.Probes = n
+}
+
+// Transport returns the transport of a node at addr, to create it with server.WithTransport. The node joins the
+// network once its Start serves the transport, and leaves it when it stops. All of the node's peer traffic goes over
+// the network: gossip, syncs, digests, the failure detector's probes, joins, broadcasts and the side stores
+func (n *Network) Transport(addr string) *Transport {
+	return &Transport{Network: n, addr: server.CanonicalAddr(addr)}
+}
+
+func (n *Network) node(addr string) (*server.GameServer, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	gs, ok := n.nodes[addr]
+	if !ok {
+		return nil, fmt.Errorf("no node at %s", addr)
+	}
+	return gs, nil
+}
+
+func (n *Network) SendGossip(ctx context.Context, peerAddr string, msg server.GossipMessage, mode server.GossipMode) (server.GossipMessage, error) {
+	peer, err := n.node(peerAddr)
+	if err != nil {
+		return server.GossipMessage{}, err
+	}
+	if err := ctx.Err(); err != nil {
+		return server.GossipMessage{}, err
+	}
+	if err := roundTrip(&msg); err != nil {
+		return server.GossipMessage{}, err
+	}
+
+	if mode != server.GossipPushPull {
+		peer.MergeState(msg.State)
+		return server.GossipMessage{}, nil
+	}
+	reply := peer.ReceiveGossip(msg)
+	return reply, roundTrip(&reply)
+}
+
+func (n *Network) SendDigest(ctx context.Context, peerAddr string, msg server.DigestMessage) (server.DigestReply, error) {
+	peer, err := n.node(peerAddr)
+	if err != nil {
+		return server.DigestReply{}, err
+	}
+	if err := ctx.Err(); err != nil {
+		return server.DigestReply{}, err
+	}
+	if err := roundTrip(&msg); err != nil {
+		return server.DigestReply{}, err
+	}
+
+	reply, err := peer.ReceiveDigest(msg)
+	if err != nil {
+		return server.DigestReply{}, err
+	}
+	return reply, roundTrip(&reply)
+}
+
+func (n *Network) SendSync(ctx context.Context, peerAddr string, msg server.GossipMessage) (server.GossipMessage, error) {
+	peer, err := n.node(peerAddr)
+	if err != nil {
+		return server.GossipMessage{}, err
+	}
+	if err := ctx.Err(); err != nil {
+		return server.GossipMessage{}, err
+	}
+	if err := roundTrip(&msg); err != nil {
+		return server.GossipMessage{}, err
+	}
+
+	reply := peer.ReceiveSync(msg)
+	return reply, roundTrip(&reply)
+}
+
+func (n *Network) SendProbe(ctx context.Context, peerAddr string, msg server.PingMessage) (server.PingMessage, error) {
+	peer, err := n.node(peerAddr)
+	if err != nil {
+		return server.PingMessage{}, err
+	}
+	if err := ctx.Err(); err != nil {
+		return server.PingMessage{}, err
+	}
+	if err := roundTrip(&msg); err != nil {
+		return server.PingMessage{}, err
+	}
+
+	if msg.Target == "" {
+		reply := peer.Membership.HandlePing(msg)
+		return reply, roundTrip(&reply)
+	}
+	reply, err := peer.Membership.HandlePingReq(ctx, msg)
+	if err != nil {
+		return server.PingMessage{}, err
+	}
+	return reply, roundTrip(&reply)
+}
+
+// Transport is one node's transport on a Network, see Network.Transport
+type Transport struct {
+	*Network
+	addr string
+}
+
+// Serve connects node to the network under the transport's address until ctx is done
+func (t *Transport) Serve(ctx context.Context, node *server.GameServer) error {
+	t.mu.Lock()
+	if _, ok := t.nodes[t.addr]; ok {
+		t.mu.Unlock()
+		return fmt.Errorf("a node is already at %s", t.addr)
+	}
+	t.nodes[t.addr] = node
+	t.mu.Unlock()
+
+	<-ctx.Done()
+	t.mu.Lock()
+	delete(t.nodes, t.addr)
+	t.mu.Unlock()
+	return nil
+}
+
+func (t *Transport) Addr() string {
+	return t.addr
+}
+
+func (t *Transport) FetchMembers(ctx context.Context, peerAddr string) ([]server.Member, error) {
+	peer, err := t.peer(ctx, peerAddr)
+	if err != nil {
+		return nil, err
+	}
+	members := peer.Membership.Members()
+	return members, roundTrip(&members)
+}
+
+func (t *Transport) FetchReadiness(ctx context.Context, peerAddr string) (server.Health, error) {
+	peer, err := t.peer(ctx, peerAddr)
+	if err != nil {
+		return server.Health{}, err
+	}
+	h := peer.Readiness()
+	return h, roundTrip(&h)
+}
+
+func (t *Transport) FetchStatus(ctx context.Context, peerAddr string) (server.Status, error) {
+	peer, err := t.peer(ctx, peerAddr)
+	if err != nil {
+		return server.Status{}, err
+	}
+	st := peer.Status()
+	return st, roundTrip(&st)
+}
+
+func (t *Transport) SendBroadcasts(ctx context.Context, peerAddr string, msgs []server.Message) error {
+	peer, err := t.peer(ctx, peerAddr)
+	if err != nil {
+		return err
+	}
+	if err := roundTrip(&msgs); err != nil {
+		return err
+	}
+	peer.MergeRumors(t.addr, msgs)
+	return nil
+}
+
+func (t *Transport) SendStore(ctx context.Context, peerAddr, store string, entries map[string]gossip.Entry) error {
+	peer, err := t.peer(ctx, peerAddr)
+	if err != nil {
+		return err
+	}
+	if err := roundTrip(&entries); err != nil {
+		return err
+	}
+	_, err = peer.MergeStore(store, entries)
+	return err
+}
+
+// peer returns the node at peerAddr, unless ctx is done
+func (t *Transport) peer(ctx context.Context, peerAddr string) (*server.GameServer, error) {
+	peer, err := t.node(peerAddr)
+	if err != nil {
+		return nil, err
+	}
+	return peer, ctx.Err()
+}
+
+// roundTrip replaces v with the result of encoding and decoding it, so sender and receiver share no memory
+func roundTrip[T any](v *T) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	var out T
+	if err := json.Unmarshal(data, &out); err != nil {
+		return err
+	}
+	*v = out
+	return nil
+}
@@ -0,0 +1,29 @@
+// Package bufpool holds the buffers that gossip messages are encoded into, compressed into and read into, shared by
+// the gossip path of package server and the peer handlers of package transport, so that a round reuses the memory
+// of earlier ones rather than growing new buffers for every message
+package bufpool
+
+import (
+	"bytes"
+	"sync"
+)
+
+// maxPooledBuffer is the capacity over which a buffer is dropped rather than pooled, so that one unusually large
+// message doesn't keep its memory alive for the rounds after it
+const maxPooledBuffer = 8 << 20
+
+var bufferPool = sync.Pool{New: func() any { return new(bytes.Buffer) }}
+
+// Get returns an empty buffer from the pool. Give it back with Put once nothing refers to its bytes
+func Get() *bytes.Buffer {
+	return bufferPool.Get().(*bytes.Buffer)
+}
+
+// Put gives a buffer from Get back to the pool. The buffer, and any slice of its bytes, must not be used afterwards
+func Put(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledBuffer {
+		return
+	}
+	buf.Reset()
+	bufferPool.Put(buf)
+}
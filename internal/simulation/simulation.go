@@ -0,0 +1,223 @@
+// Package simulation measures how a cluster converges under adverse network conditions. It runs a
+// gossiptest.Cluster over a simulated network that adds latency, drops messages and partitions nodes, with every
+// random choice drawn from one seeded source, so a run is exactly repeatable from its seed and a change in
+// convergence behaviour shows up as a different result rather than a flaky test
+package simulation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"time"
+
+	"gmathur.dev/gossiper/gossiptest"
+	"gmathur.dev/gossiper/server"
+)
+
+// Config describes a simulated cluster and its network
+type Config struct {
+	Nodes      int
+	Seed       int64
+	MinLatency time.Duration // one-way latency is drawn uniformly from [MinLatency, MaxLatency]
+	MaxLatency time.Duration
+	DropRate   float64                     // probability that any one message is lost
+	Configure  func(gs *server.GameServer) // optional per-node setup, see gossiptest.NewCluster
+}
+
+// Stats counts what happened on the simulated network
+type Stats struct {
+	Sent     int // messages handed to the network
+	Dropped  int // lost to DropRate
+	Blocked  int // refused because sender and receiver were partitioned
+	TimedOut int // exchanges whose round trip took longer than the sender's gossip timeout
+}
+
+// Result is the outcome of Run
+type Result struct {
+	Rounds    int
+	Converged bool
+	Elapsed   time.Duration // simulated time
+	Stats     Stats
+}
+
+func (r Result) String() string {
+	return fmt.Sprintf("converged=%t rounds=%d elapsed=%s sent=%d dropped=%d blocked=%d timed_out=%d",
+		r.Converged, r.Rounds, r.Elapsed, r.Stats.Sent, r.Stats.Dropped, r.Stats.Blocked, r.Stats.TimedOut)
+}
+
+// Simulator runs a cluster over a simulated network. Use Cluster to make writes on individual nodes between steps
+type Simulator struct {
+	Cluster *gossiptest.Cluster
+	Stats   Stats
+
+	cfg       Config
+	rng       *rand.Rand
+	start     time.Time
+	partition map[string]int // node address to partition group; nodes in different groups can't talk
+	pending   []delivery     // push messages in flight, delivered once the clock reaches their arrival time
+}
+
+type delivery struct {
+	at  time.Time
+	seq int // keeps deliveries with equal arrival times in send order
+	to  string
+	msg server.GossipMessage
+}
+
+var (
+	errDropped     = errors.New("message dropped")
+	errPartitioned = errors.New("peer unreachable: network partition")
+	errTimedOut    = errors.New("round trip exceeded gossip timeout")
+)
+
+func New(cfg Config) *Simulator {
+	rng := rand.New(rand.NewSource(cfg.Seed))
+	s := &Simulator{
+		cfg:       cfg,
+		rng:       rng,
+		partition: make(map[string]int),
+	}
+	s.Cluster = gossiptest.NewCluster(cfg.Nodes, cfg.Configure)
+	s.Cluster.Rand = rng
+	for _, gs := range s.Cluster.Nodes {
+		gs.Transport = s
+	}
+	s.start = s.Cluster.Clock.Now()
+	return s
+}
+
+// Partition splits the cluster into groups of node indices; nodes not listed end up in a group of their own
+// together. Messages between groups are refused until Heal
+func (s *Simulator) Partition(groups ...[]int) {
+	s.partition = make(map[string]int)
+	for g, nodes := range groups {
+		for _, i := range nodes {
+			s.partition[s.Cluster.Nodes[i].Address] = g + 1
+		}
+	}
+}
+
+// Heal removes any partition
+func (s *Simulator) Heal() {
+	s.partition = make(map[string]int)
+}
+
+// Step advances the clock by one gossip interval, delivers the push messages that have arrived by then, and runs
+// a gossip round and maintenance on every node
+func (s *Simulator) Step() {
+	s.Cluster.Clock.Advance(s.Cluster.Nodes[0].Gossip.Interval)
+	s.deliver()
+	s.Cluster.Round()
+	s.Cluster.Maintain()
+}
+
+// Run steps the cluster until every node holds the same state, or maxRounds have run
+func (s *Simulator) Run(maxRounds int) Result {
+	rounds := 0
+	for ; rounds < maxRounds; rounds++ {
+		if s.Cluster.Converged() {
+			break
+		}
+		s.Step()
+	}
+	return Result{
+		Rounds:    rounds,
+		Converged: s.Cluster.Converged(),
+		Elapsed:   s.Cluster.Clock.Now().Sub(s.start),
+		Stats:     s.Stats,
+	}
+}
+
+func (s *Simulator) deliver() {
+	now := s.Cluster.Clock.Now()
+	sort.SliceStable(s.pending, func(i, j int) bool {
+		if !s.pending[i].at.Equal(s.pending[j].at) {
+			return s.pending[i].at.Before(s.pending[j].at)
+		}
+		return s.pending[i].seq < s.pending[j].seq
+	})
+
+	due := 0
+	for due < len(s.pending) && !s.pending[due].at.After(now) {
+		d := s.pending[due]
+		s.Cluster.Network.SendGossip(context.Background(), d.to, d.msg, server.GossipPush)
+		due++
+	}
+	s.pending = s.pending[due:]
+}
+
+// admit decides the fate of one message from one node to another, returning its one-way latency
+func (s *Simulator) admit(from, to string) (time.Duration, error) {
+	s.Stats.Sent++
+	if s.partition[from] != s.partition[to] {
+		s.Stats.Blocked++
+		return 0, errPartitioned
+	}
+	if s.cfg.DropRate > 0 && s.rng.Float64() < s.cfg.DropRate {
+		s.Stats.Dropped++
+		return 0, errDropped
+	}
+	latency := s.cfg.MinLatency
+	if spread := s.cfg.MaxLatency - s.cfg.MinLatency; spread > 0 {
+		latency += time.Duration(s.rng.Int63n(int64(spread)))
+	}
+	return latency, nil
+}
+
+// exchange admits a request and its reply, failing it if the round trip doesn't fit in the sender's timeout
+func (s *Simulator) exchange(from, to string) error {
+	out, err := s.admit(from, to)
+	if err != nil {
+		return err
+	}
+	back, err := s.admit(to, from)
+	if err != nil {
+		return err
+	}
+	if timeout := s.node(from).Gossip.Timeout; timeout > 0 && out+back > timeout {
+		s.Stats.TimedOut++
+		return errTimedOut
+	}
+	return nil
+}
+
+func (s *Simulator) node(addr string) *server.GameServer {
+	for _, gs := range s.Cluster.Nodes {
+		if gs.Address == addr {
+			return gs
+		}
+	}
+	return nil
+}
+
+// SendGossip queues push messages for delivery after their latency, and runs push-pull exchanges straight away
+// when the round trip fits within the timeout, as the sender expects the reply within the round
+func (s *Simulator) SendGossip(ctx context.Context, peerAddr string, msg server.GossipMessage, mode server.GossipMode) (server.GossipMessage, error) {
+	if mode == server.GossipPushPull {
+		if err := s.exchange(msg.From, peerAddr); err != nil {
+			return server.GossipMessage{}, err
+		}
+		return s.Cluster.Network.SendGossip(ctx, peerAddr, msg, mode)
+	}
+
+	latency, err := s.admit(msg.From, peerAddr)
+	if err != nil {
+		return server.GossipMessage{}, err
+	}
+	s.pending = append(s.pending, delivery{
+		at:  s.Cluster.Clock.Now().Add(latency),
+		seq: s.Stats.Sent,
+		to:  peerAddr,
+		msg: msg,
+	})
+	return server.GossipMessage{}, nil
+}
+
+func (s *Simulator) SendDigest(ctx context.Context, peerAddr string, msg server.DigestMessage) (server.DigestReply, error) {
+	if err := s.exchange(msg.From, peerAddr); err != nil {
+		return server.DigestReply{}, err
+	}
+	return s.Cluster.Network.SendDigest(ctx, peerAddr, msg)
+}
@@ -0,0 +1,343 @@
+// Package metrics is a small, dependency free implementation of Prometheus counters, gauges and histograms,
+// exposed in the Prometheus text format
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefBuckets are the default histogram buckets, suitable for request latencies in seconds
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// SizeBuckets are exponential buckets suitable for payload sizes in bytes, from 64B to 16MiB
+var SizeBuckets = []float64{64, 256, 1024, 4096, 16384, 65536, 262144, 1048576, 4194304, 16777216}
+
+type collector interface {
+	write(w io.Writer)
+}
+
+// Registry holds a set of metrics and renders them for scraping
+type Registry struct {
+	mu         sync.Mutex
+	collectors []collector
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors = append(r.collectors, c)
+}
+
+// Render writes every registered metric in the Prometheus text exposition format
+func (r *Registry) Render(w io.Writer) {
+	r.mu.Lock()
+	collectors := append([]collector(nil), r.collectors...)
+	r.mu.Unlock()
+
+	for _, c := range collectors {
+		c.write(w)
+	}
+}
+
+// ServeHTTP makes the registry usable as a /metrics handler
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.Render(w)
+}
+
+// vec tracks one child per distinct set of label values
+type vec[T any] struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+
+	mu       sync.Mutex
+	children map[string]*T
+	values   map[string][]string
+	newChild func() *T
+}
+
+func newVec[T any](name, help, kind string, labels []string, newChild func() *T) *vec[T] {
+	return &vec[T]{
+		name:     name,
+		help:     help,
+		kind:     kind,
+		labels:   labels,
+		children: make(map[string]*T),
+		values:   make(map[string][]string),
+		newChild: newChild,
+	}
+}
+
+func (v *vec[T]) with(values []string) *T {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metric %s expects %d label values, got %d", v.name, len(v.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	child, ok := v.children[key]
+	if !ok {
+		child = v.newChild()
+		v.children[key] = child
+		v.values[key] = append([]string(nil), values...)
+	}
+	return child
+}
+
+// each visits children in a stable order so scrapes are easy to diff
+func (v *vec[T]) each(fn func(values []string, child *T)) {
+	v.mu.Lock()
+	keys := make([]string, 0, len(v.children))
+	for key := range v.children {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	children := make([]*T, len(keys))
+	values := make([][]string, len(keys))
+	for i, key := range keys {
+		children[i] = v.children[key]
+		values[i] = v.values[key]
+	}
+	v.mu.Unlock()
+
+	for i := range keys {
+		fn(values[i], children[i])
+	}
+}
+
+func (v *vec[T]) writeHeader(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", v.name, v.help, v.name, v.kind)
+}
+
+func formatLabels(names, values []string, extra ...string) string {
+	if len(names) == 0 && len(extra) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%s=%s", name, strconv.Quote(values[i]))
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		if b.Len() > 1 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%s=%s", extra[i], strconv.Quote(extra[i+1]))
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// Counter is a monotonically increasing value
+type Counter struct {
+	mu    sync.Mutex
+	value float64
+}
+
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+func (c *Counter) Add(v float64) {
+	c.mu.Lock()
+	c.value += v
+	c.mu.Unlock()
+}
+
+func (c *Counter) Value() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.value
+}
+
+type CounterVec struct {
+	*vec[Counter]
+}
+
+// NewCounter registers a counter partitioned by the given label names
+func (r *Registry) NewCounter(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{newVec(name, help, "counter", labels, func() *Counter { return &Counter{} })}
+	r.register(c)
+	return c
+}
+
+func (c *CounterVec) With(values ...string) *Counter {
+	return c.with(values)
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	c.writeHeader(w)
+	c.each(func(values []string, child *Counter) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, formatLabels(c.labels, values), formatFloat(child.Value()))
+	})
+}
+
+// Gauge is a value that can go up and down
+type Gauge struct {
+	mu    sync.Mutex
+	value float64
+}
+
+func (g *Gauge) Set(v float64) {
+	g.mu.Lock()
+	g.value = v
+	g.mu.Unlock()
+}
+
+func (g *Gauge) Add(v float64) {
+	g.mu.Lock()
+	g.value += v
+	g.mu.Unlock()
+}
+
+func (g *Gauge) Value() float64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.value
+}
+
+type GaugeVec struct {
+	*vec[Gauge]
+}
+
+// NewGauge registers a gauge partitioned by the given label names
+func (r *Registry) NewGauge(name, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{newVec(name, help, "gauge", labels, func() *Gauge { return &Gauge{} })}
+	r.register(g)
+	return g
+}
+
+func (g *GaugeVec) With(values ...string) *Gauge {
+	return g.with(values)
+}
+
+func (g *GaugeVec) write(w io.Writer) {
+	g.writeHeader(w)
+	g.each(func(values []string, child *Gauge) {
+		fmt.Fprintf(w, "%s%s %s\n", g.name, formatLabels(g.labels, values), formatFloat(child.Value()))
+	})
+}
+
+type gaugeFunc struct {
+	name string
+	help string
+	fn   func() float64
+}
+
+// NewGaugeFunc registers an unlabelled gauge whose value is computed at scrape time
+func (r *Registry) NewGaugeFunc(name, help string, fn func() float64) {
+	r.register(&gaugeFunc{name: name, help: help, fn: fn})
+}
+
+func (g *gaugeFunc) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %s\n", g.name, g.help, g.name, g.name, formatFloat(g.fn()))
+}
+
+type gaugeFuncVec struct {
+	name  string
+	help  string
+	label string
+	fn    func() map[string]float64
+}
+
+// NewGaugeFuncVec registers a gauge with one label whose values, by label value, are computed at scrape time
+func (r *Registry) NewGaugeFuncVec(name, help, label string, fn func() map[string]float64) {
+	r.register(&gaugeFuncVec{name: name, help: help, label: label, fn: fn})
+}
+
+func (g *gaugeFuncVec) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", g.name, g.help, g.name)
+	values := g.fn()
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s%s %s\n", g.name, formatLabels([]string{g.label}, []string{k}), formatFloat(values[k]))
+	}
+}
+
+// Histogram counts observations into cumulative buckets
+type Histogram struct {
+	mu      sync.Mutex
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+func (h *Histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i, upper := range h.buckets {
+		if v <= upper {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+type HistogramVec struct {
+	*vec[Histogram]
+	buckets []float64
+}
+
+// NewHistogram registers a histogram with the given upper bucket bounds, partitioned by the given label names
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	h := &HistogramVec{buckets: buckets}
+	h.vec = newVec(name, help, "histogram", labels, func() *Histogram {
+		return &Histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+	})
+	r.register(h)
+	return h
+}
+
+func (h *HistogramVec) With(values ...string) *Histogram {
+	return h.with(values)
+}
+
+func (h *HistogramVec) write(w io.Writer) {
+	h.writeHeader(w)
+	h.each(func(values []string, child *Histogram) {
+		child.mu.Lock()
+		counts := append([]uint64(nil), child.counts...)
+		sum, count := child.sum, child.count
+		child.mu.Unlock()
+
+		for i, upper := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, values, "le", formatFloat(upper)), counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, values, "le", "+Inf"), count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labels, values), formatFloat(sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labels, values), count)
+	})
+}
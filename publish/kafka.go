@@ -0,0 +1,80 @@
+package publish
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// KafkaPublisher publishes messages to a Kafka topic through the Kafka REST Proxy (v2 API), each keyed so a
+// player's changes land in one partition in order
+type KafkaPublisher struct {
+	URL     string // base URL of the REST proxy
+	Topic   string
+	Headers http.Header // added to every request, e.g. for the proxy's credentials
+	Client  *http.Client
+}
+
+type kafkaRecord struct {
+	Key   string          `json:"key"`
+	Value json.RawMessage `json:"value"`
+}
+
+func (p *KafkaPublisher) Publish(ctx context.Context, msgs []Message) error {
+	records := make([]kafkaRecord, len(msgs))
+	for i, msg := range msgs {
+		records[i] = kafkaRecord{Key: msg.Key, Value: msg.Value}
+	}
+	body, err := json.Marshal(map[string]any{"records": records})
+	if err != nil {
+		return err
+	}
+	endpoint := strings.TrimSuffix(p.URL, "/") + "/topics/" + url.PathEscape(p.Topic)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for name, values := range p.Headers {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", "application/vnd.kafka.json.v2+json")
+	req.Header.Set("Accept", "application/vnd.kafka.v2+json")
+
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		return fmt.Errorf("publishing %d messages to %s returned %s", len(msgs), endpoint, resp.Status)
+	}
+
+	// The proxy answers 200 even when some records failed, with an error for each of them
+	var result struct {
+		Offsets []struct {
+			ErrorCode *int   `json:"error_code"`
+			Error     string `json:"error"`
+		} `json:"offsets"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("invalid response from %s: %w", endpoint, err)
+	}
+	for _, offset := range result.Offsets {
+		if offset.ErrorCode != nil {
+			return fmt.Errorf("publishing to %s failed for some messages: %s", endpoint, offset.Error)
+		}
+	}
+	return nil
+}
+
+func (p *KafkaPublisher) Close() error { return nil }
@@ -0,0 +1,171 @@
+package publish
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// NATSPublisher publishes messages to a NATS subject. It connects on the first Publish and again after any
+// error, and waits for the server to acknowledge each batch with a PONG, so a batch that returns nil has reached
+// the server
+type NATSPublisher struct {
+	Addr    string // host:port
+	Subject string
+	User    string // credentials, if the server requires them
+	Pass    string
+	Token   string
+	Timeout time.Duration // for connecting and for each batch
+
+	conn net.Conn
+	r    *bufio.Reader
+	w    *bufio.Writer
+}
+
+// NewNATSPublisher returns a publisher for the server at a nats:// URL, taking credentials from its user info: a
+// user and password, or a token alone
+func NewNATSPublisher(rawURL, subject string) (*NATSPublisher, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme != "nats" || u.Host == "" {
+		return nil, fmt.Errorf("invalid nats url %q", rawURL)
+	}
+	if strings.ContainsAny(subject, " \t\r\n") {
+		return nil, fmt.Errorf("invalid nats subject %q", subject)
+	}
+	p := &NATSPublisher{Addr: u.Host, Subject: subject, Timeout: 10 * time.Second}
+	if u.Port() == "" {
+		p.Addr = net.JoinHostPort(u.Hostname(), "4222")
+	}
+	if u.User != nil {
+		if pass, ok := u.User.Password(); ok {
+			p.User, p.Pass = u.User.Username(), pass
+		} else {
+			p.Token = u.User.Username()
+		}
+	}
+	return p, nil
+}
+
+func (p *NATSPublisher) Publish(ctx context.Context, msgs []Message) error {
+	err := p.publish(ctx, msgs)
+	if err != nil && p.conn != nil {
+		p.conn.Close()
+		p.conn = nil
+	}
+	return err
+}
+
+func (p *NATSPublisher) publish(ctx context.Context, msgs []Message) error {
+	deadline := time.Now().Add(p.Timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if p.conn == nil {
+		if err := p.connect(ctx, deadline); err != nil {
+			return err
+		}
+	}
+	p.conn.SetDeadline(deadline)
+
+	for _, msg := range msgs {
+		fmt.Fprintf(p.w, "PUB %s %d\r\n", p.Subject, len(msg.Value))
+		p.w.Write(msg.Value)
+		p.w.WriteString("\r\n")
+	}
+	p.w.WriteString("PING\r\n")
+	if err := p.w.Flush(); err != nil {
+		return err
+	}
+	return p.awaitPong()
+}
+
+// connect dials the server, reads its INFO and introduces itself
+func (p *NATSPublisher) connect(ctx context.Context, deadline time.Time) error {
+	dialer := net.Dialer{Deadline: deadline}
+	conn, err := dialer.DialContext(ctx, "tcp", p.Addr)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(deadline)
+	p.conn, p.r, p.w = conn, bufio.NewReader(conn), bufio.NewWriter(conn)
+
+	line, err := p.readLine()
+	if err != nil {
+		return err
+	}
+	info, ok := strings.CutPrefix(line, "INFO ")
+	if !ok {
+		return fmt.Errorf("nats server %s sent %q instead of INFO", p.Addr, line)
+	}
+	var serverInfo struct {
+		TLSRequired bool `json:"tls_required"`
+	}
+	if err := json.Unmarshal([]byte(info), &serverInfo); err != nil {
+		return fmt.Errorf("invalid INFO from nats server %s: %w", p.Addr, err)
+	}
+	if serverInfo.TLSRequired {
+		return fmt.Errorf("nats server %s requires TLS, which isn't supported", p.Addr)
+	}
+
+	connect, err := json.Marshal(struct {
+		Verbose  bool   `json:"verbose"`
+		Pedantic bool   `json:"pedantic"`
+		Name     string `json:"name"`
+		Lang     string `json:"lang"`
+		Version  string `json:"version"`
+		User     string `json:"user,omitempty"`
+		Pass     string `json:"pass,omitempty"`
+		Token    string `json:"auth_token,omitempty"`
+	}{Name: "gossiper", Lang: "go", Version: "1", User: p.User, Pass: p.Pass, Token: p.Token})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(p.w, "CONNECT %s\r\nPING\r\n", connect)
+	if err := p.w.Flush(); err != nil {
+		return err
+	}
+	return p.awaitPong()
+}
+
+// awaitPong reads until the server answers the last PING, answering the server's own PINGs on the way
+func (p *NATSPublisher) awaitPong() error {
+	for {
+		line, err := p.readLine()
+		if err != nil {
+			return err
+		}
+		switch {
+		case line == "PONG":
+			return nil
+		case line == "PING":
+			p.w.WriteString("PONG\r\n")
+			if err := p.w.Flush(); err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			return fmt.Errorf("nats server %s: %s", p.Addr, strings.Trim(strings.TrimPrefix(line, "-ERR"), " '"))
+		}
+	}
+}
+
+func (p *NATSPublisher) readLine() (string, error) {
+	line, err := p.r.ReadString('\n')
+	if err != nil {
+		return "", fmt.Errorf("reading from nats server %s: %w", p.Addr, err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func (p *NATSPublisher) Close() error {
+	if p.conn == nil {
+		return nil
+	}
+	err := p.conn.Close()
+	p.conn = nil
+	return err
+}
@@ -0,0 +1,42 @@
+// Package publish sends messages to message brokers, so services downstream of a cluster can react to changes
+// without polling it. It speaks the NATS client protocol and the Kafka REST Proxy API directly, without any
+// client libraries
+package publish
+
+import (
+	"context"
+	"fmt"
+)
+
+// Message is a message to publish. Brokers that partition their topics, such as Kafka, place messages with the
+// same key in the same partition, so they are kept in order
+type Message struct {
+	Key   string
+	Value []byte // JSON
+}
+
+// Publisher sends messages to a broker. Publish is called from one goroutine at a time, and either every message
+// of the batch has been accepted by the broker when it returns nil or the batch should be published again
+type Publisher interface {
+	Publish(ctx context.Context, msgs []Message) error
+	Close() error
+}
+
+// New builds the publisher named by kind: "nats" publishes to subject on the server at url, such as
+// nats://localhost:4222, and "kafka" to topic through the Kafka REST Proxy at url, such as http://localhost:8082
+func New(kind, url, topic string) (Publisher, error) {
+	if url == "" {
+		return nil, fmt.Errorf("the %s publisher needs a url", kind)
+	}
+	if topic == "" {
+		return nil, fmt.Errorf("the %s publisher needs a topic", kind)
+	}
+	switch kind {
+	case "nats":
+		return NewNATSPublisher(url, topic)
+	case "kafka":
+		return &KafkaPublisher{URL: url, Topic: topic}, nil
+	default:
+		return nil, fmt.Errorf("unknown publisher %q", kind)
+	}
+}
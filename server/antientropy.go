@@ -0,0 +1,138 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+
+	"gmathur.dev/gossiper/gossip"
+	"gmathur.dev/gossiper/tracing"
+)
+
+// AntiEntropyConfig schedules anti-entropy: every Interval the node reconciles its complete state with one random
+// alive peer, in both directions and whatever the gossip mode. Gossip only sends what changed since the exchange
+// it last believes went through, so an update it misses stays missed; anti-entropy compares everything, which
+// bounds how long any difference between two nodes can last
+type AntiEntropyConfig struct {
+	Interval time.Duration // time between syncs, each with one peer; 0 disables anti-entropy
+}
+
+// DefaultAntiEntropyConfig syncs with one peer every minute
+func DefaultAntiEntropyConfig() AntiEntropyConfig {
+	return AntiEntropyConfig{Interval: time.Minute}
+}
+
+// SyncTransport is implemented by transports that can carry anti-entropy syncs. Unlike a push, every sync
+// message is answered with the receiver's state
+type SyncTransport interface {
+	SendSync(ctx context.Context, peerAddr string, msg GossipMessage) (GossipMessage, error)
+}
+
+func (gs *GameServer) antiEntropyLoop(ctx context.Context) {
+	for {
+		// Jittered so that nodes started together don't all sync at once
+		timer := time.NewTimer(backoff(gs.AntiEntropy.Interval, 0, 0))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		peers := gs.Membership.Peers()
+		rand.Shuffle(len(peers), func(i, j int) { peers[i], peers[j] = peers[j], peers[i] })
+		now := time.Now()
+		for _, peerAddr := range peers {
+			if gs.breakers.allow(peerAddr, now) {
+				gs.AntiEntropyWithPeer(ctx, peerAddr)
+				break
+			}
+		}
+	}
+}
+
+// AntiEntropyWithPeer reconciles the complete state with a peer: each side sends the other every entry it holds,
+// oldest first, in as many messages as the size limit takes, and merges what it receives. Unlike a full sync it
+// gets the peer's state back in push mode too
+func (gs *GameServer) AntiEntropyWithPeer(ctx context.Context, peerAddr string) (err error) {
+	syncs, ok := gs.Transport.(SyncTransport)
+	if !ok {
+		return errors.New("transport does not support anti-entropy syncs")
+	}
+	ctx, span := gs.Tracer.Start(ctx, "gossip.anti_entropy", tracing.KindClient, tracing.Attr("peer.address", peerAddr))
+	repaired, messages := 0, 0
+	defer func() {
+		span.SetAttributes(tracing.Attr("gossip.messages", messages), tracing.Attr("gossip.repaired", repaired))
+		span.SetError(err)
+		span.End()
+	}()
+
+	// sent is how far through our versions the peer has got and seen how far through its versions we have
+	var sent, seen uint64
+	ours, theirs := true, true
+	for ours || theirs {
+		msg := GossipMessage{From: gs.Address, Version: sent}
+		if ours {
+			msg = gs.chunkSince(sent)
+			gs.filterForPeer(peerAddr, &msg)
+		}
+		msg.Since = seen
+		stampProtocol(&msg, max(gs.peerProtocol(peerAddr), MinProtocolVersion))
+
+		reply, err := syncs.SendSync(ctx, peerAddr, msg)
+		if errors.Is(err, context.Canceled) {
+			return err
+		}
+		if err == nil {
+			err = CheckProtocol(reply)
+		}
+		if err != nil {
+			gs.Metrics.AntiEntropySyncs.With("failed").Inc()
+			gs.gossipFailed(peerAddr, err)
+			return err
+		}
+		repaired += repairs(gs.MergeState(reply.State))
+		messages++
+		sent, ours = msg.Version, msg.More
+		seen, theirs = reply.Version, reply.More
+	}
+
+	gs.Metrics.AntiEntropySyncs.With("ok").Inc()
+	gs.Metrics.AntiEntropyRepairs.With().Add(float64(repaired))
+	gs.mu.Lock()
+	gs.peerSent[peerAddr] = max(gs.peerSent[peerAddr], sent)
+	gs.peerSeen[peerAddr] = seen
+	gs.lastAntiEntropy = time.Now()
+	gs.mu.Unlock()
+	gs.gossipSucceeded(peerAddr)
+
+	log := gs.Logger.Debug
+	if repaired > 0 {
+		// Gossip should have brought these over already
+		log = gs.Logger.Info
+	}
+	log("anti-entropy sync with peer", "peer", peerAddr, "messages", messages, "repaired", repaired)
+	return nil
+}
+
+// ReceiveSync merges a sync message from a peer and replies with the local entries above the version the peer
+// has got to, as much as fits under the size limit
+func (gs *GameServer) ReceiveSync(msg GossipMessage) GossipMessage {
+	gs.Metrics.AntiEntropyRepairs.With().Add(float64(repairs(gs.MergeState(msg.State))))
+
+	// A watermark ahead of our own version means we restarted partway through; start over
+	since := msg.Since
+	if since > gs.State.Version() {
+		since = 0
+	}
+	reply := gs.chunkSince(since)
+	gs.filterForPeer(msg.From, &reply)
+	stampProtocol(&reply, msg.Protocol)
+	return reply
+}
+
+// repairs counts the entries a merge changed
+func repairs(stats gossip.MergeStats) int {
+	return stats.Added + stats.TookIncoming + stats.Combined
+}
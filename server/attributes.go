@@ -0,0 +1,160 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"time"
+
+	"gmathur.dev/gossiper/crdt"
+	"gmathur.dev/gossiper/gossip"
+)
+
+// Limits on the attributes set in one write. They bound each write rather than the player, whose attributes are
+// the union of every write that reached the node
+const (
+	MaxAttributes        = 32
+	MaxAttributeNameLen  = 64
+	MaxAttributeValueLen = 1024
+)
+
+// Attribute is one field of a player's state besides the score, such as a level, an inventory hash or presence.
+// Each is stamped with the write that last set it and merged on its own by last-write-wins, so writes to
+// different fields made on different nodes at the same time are all kept. A removed attribute is kept with a null
+// value, so that the removal wins over older writes wherever they arrive from
+type Attribute = crdt.LWWRegister[json.RawMessage]
+
+// removed reports whether a is the marker left by removing the attribute
+func removed(a Attribute) bool {
+	return len(a.Value) == 0 || bytes.Equal(a.Value, []byte("null"))
+}
+
+// liveAttributes returns the values of the attributes that haven't been removed, nil if there are none
+func liveAttributes(attrs map[string]Attribute) map[string]json.RawMessage {
+	var live map[string]json.RawMessage
+	for name, a := range attrs {
+		if removed(a) {
+			continue
+		}
+		if live == nil {
+			live = make(map[string]json.RawMessage, len(attrs))
+		}
+		live[name] = a.Value
+	}
+	return live
+}
+
+// ValidateAttributes checks attributes about to be written against the limits. A null value removes the attribute
+func ValidateAttributes(attrs map[string]json.RawMessage) error {
+	if len(attrs) > MaxAttributes {
+		return fmt.Errorf("%d attributes given, at most %d are allowed", len(attrs), MaxAttributes)
+	}
+	for name, value := range attrs {
+		switch {
+		case name == "":
+			return errors.New("attribute name is empty")
+		case len(name) > MaxAttributeNameLen:
+			return fmt.Errorf("attribute name %q is longer than %d bytes", name, MaxAttributeNameLen)
+		case len(value) > MaxAttributeValueLen:
+			return fmt.Errorf("attribute %q is longer than %d bytes", name, MaxAttributeValueLen)
+		case !json.Valid(value):
+			return fmt.Errorf("attribute %q is not valid JSON", name)
+		}
+	}
+	return nil
+}
+
+// SetPlayerAttributes sets some of a player's attributes, leaving its score and other attributes as they are.
+// A null value removes the attribute. A player that doesn't exist yet is created with a score of 0, which any
+// score written anywhere wins over
+func (gs *GameServer) SetPlayerAttributes(playerId string, attrs map[string]json.RawMessage) error {
+	return gs.UpdatePlayer(playerId, nil, attrs, gs.EntryTTL)
+}
+
+// UpdatePlayer sets a player's score, unless score is nil, and some of its attributes in a single write, and
+// expires the player cluster-wide if it isn't updated again within ttl. The score is stamped by the entry it is
+// written in; when only attributes change it keeps the stamp it had, so that merges still order it against
+// scores written elsewhere by when it was set. opts, such as WithPriority, change how the write is made
+func (gs *GameServer) UpdatePlayer(playerId string, score *int64, attrs map[string]json.RawMessage, ttl time.Duration,
+	opts ...WriteOption) error {
+	_, err := gs.updatePlayer(playerId, nil, score, attrs, ttl, opts)
+	return err
+}
+
+// ConflictError is returned by UpdatePlayerIf when the player isn't at the version the caller expected
+type ConflictError struct {
+	PlayerId string
+	Clock    uint64 // clock of the player's entry on this node, 0 if it doesn't exist
+}
+
+func (e *ConflictError) Error() string {
+	if e.Clock == 0 {
+		return fmt.Sprintf("player %s doesn't exist", e.PlayerId)
+	}
+	return fmt.Sprintf("player %s is at clock %d", e.PlayerId, e.Clock)
+}
+
+// UpdatePlayerIf is UpdatePlayer as a compare-and-set: the write is only made if the player's entry on this node
+// is still at clock, the Clock of the PlayerState the caller read, or doesn't exist if clock is 0. It returns the
+// player as written, whose Clock the next compare-and-set expects. Otherwise nothing is written and a
+// *ConflictError reports the clock the player is at. The check is against this node's state only; a write made on
+// another node that hasn't arrived yet is merged later like any other
+func (gs *GameServer) UpdatePlayerIf(playerId string, clock uint64, score *int64, attrs map[string]json.RawMessage,
+	ttl time.Duration, opts ...WriteOption) (PlayerState, error) {
+	e, err := gs.updatePlayer(playerId, &clock, score, attrs, ttl, opts)
+	if err != nil {
+		return PlayerState{}, err
+	}
+	var p Player
+	if err := json.Unmarshal(e.Value, &p); err != nil {
+		return PlayerState{}, err
+	}
+	return newPlayerState(p, e), nil
+}
+
+// updatePlayer writes the player, if it is at the expected clock when expected isn't nil
+func (gs *GameServer) updatePlayer(playerId string, expected *uint64, score *int64, attrs map[string]json.RawMessage,
+	ttl time.Duration, opts []WriteOption) (gossip.Entry, error) {
+	if err := ValidateAttributes(attrs); err != nil {
+		return gossip.Entry{}, err
+	}
+	var o writeOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	write := func(current gossip.Entry, ok bool, clock uint64) ([]byte, error) {
+		if expected != nil && current.Clock != *expected {
+			return nil, &ConflictError{PlayerId: playerId, Clock: current.Clock}
+		}
+		var p Player
+		if ok {
+			// A value that can't be decoded is replaced outright, as Set would
+			_ = json.Unmarshal(current.Value, &p)
+		}
+		switch {
+		case score != nil:
+			p.Score, p.ScoreStamp, p.Increments = *score, nil, crdt.PNCounter{}
+		case !ok:
+			p.ScoreStamp = &crdt.Stamp{}
+		case p.ScoreStamp == nil:
+			p.ScoreStamp = &crdt.Stamp{Clock: current.Clock, Origin: current.Origin}
+		}
+		if len(attrs) > 0 {
+			p.Attributes = maps.Clone(p.Attributes)
+			if p.Attributes == nil {
+				p.Attributes = make(map[string]Attribute, len(attrs))
+			}
+			stamp := crdt.Stamp{Clock: clock, Origin: gs.State.Origin}
+			for name, value := range attrs {
+				p.Attributes[name] = Attribute{Value: value, Stamp: stamp}
+			}
+		}
+		return json.Marshal(p)
+	}
+	if o.priority != nil {
+		return gs.State.UpdateEntryPriority(playerId, ttl, *o.priority, write)
+	}
+	return gs.State.UpdateEntry(playerId, ttl, write)
+}
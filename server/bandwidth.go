@@ -0,0 +1,136 @@
+package server
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"gmathur.dev/gossiper/gossip"
+)
+
+// bandwidthBudget is a token bucket of bytes for the entries of outbound gossip rounds, see
+// GossipConfig.BytesPerSecond
+type bandwidthBudget struct {
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// take reports how many bytes a round may send now, up to want, and counts them as sent. The bucket refills at
+// rate bytes a second and holds a round's worth of them, interval, or a second's if that is longer
+func (b *bandwidthBudget) take(want, rate int, interval time.Duration, now time.Time) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	capacity := float64(rate) * max(interval, time.Second).Seconds()
+	if b.last.IsZero() {
+		b.tokens = capacity
+	}
+	b.tokens = min(b.tokens+now.Sub(b.last).Seconds()*float64(rate), capacity)
+	b.last = now
+	n := min(want, int(b.tokens))
+	b.tokens -= float64(n)
+	return n
+}
+
+// giveBack returns bytes taken but not sent
+func (b *bandwidthBudget) giveBack(n int) {
+	b.mu.Lock()
+	b.tokens += float64(n)
+	b.mu.Unlock()
+}
+
+// holdToBudget fits a round's message to a peer, holding the entries above since, under
+// GossipConfig.BytesPerSecond. High-priority entries the message left for later rounds are added to it, see
+// sendHighPriority, and entries already sent to the peer ahead of their turn are left out, and returned as covered,
+// since the message's versions take in theirs. If the rest is over the budget, the most pressing entries that fit
+// are kept, see morePressing, along with every high-priority one, and the rest are deferred to later rounds: the
+// message then leaves the peer's watermark at since, as a watermark only ever covers a contiguous run of versions,
+// and it reports deferred. The entries sent ahead are returned, to be recorded once the message has been sent, see
+// recordAheadLocked
+func (gs *GameServer) holdToBudget(peerAddr string, msg *GossipMessage,
+	since uint64) (ahead map[string]uint64, covered []string, deferred bool) {
+	ahead = gs.sendHighPriority(peerAddr, msg)
+	gs.mu.Lock()
+	for key, clock := range gs.peerAhead[peerAddr] {
+		if e, ok := msg.State[key]; ok {
+			if _, added := ahead[key]; !added {
+				covered = append(covered, key)
+			}
+			if e.Clock == clock {
+				delete(msg.State, key)
+			}
+		}
+	}
+	gs.mu.Unlock()
+
+	cfg := gs.gossipConfig()
+	if cfg.BytesPerSecond <= 0 || len(msg.State) == 0 {
+		return ahead, covered, false
+	}
+	size := estimateSize(msg.State)
+	allowed := gs.bandwidth.take(size, cfg.BytesPerSecond, gs.GossipInterval(), time.Now())
+	if allowed == size {
+		return ahead, covered, false
+	}
+
+	keys := make([]string, 0, len(msg.State))
+	for key := range msg.State {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return morePressing(keys[i], msg.State[keys[i]], keys[j], msg.State[keys[j]])
+	})
+	kept := make(map[string]gossip.Entry)
+	ahead = make(map[string]uint64)
+	used := 0
+	for _, key := range keys {
+		e := msg.State[key]
+		if n := entrySize(key, e); used+n <= allowed || e.Priority >= gossip.PriorityHigh {
+			kept[key] = e
+			ahead[key] = e.Clock
+			used += n
+		}
+	}
+	// High-priority entries beyond the budget are paid for by the rounds after
+	gs.bandwidth.giveBack(allowed - used)
+	gs.Metrics.GossipDeferred.With().Add(float64(size - used))
+	gs.Logger.Debug("gossip over the bandwidth budget, deferring entries", "peer", peerAddr,
+		"sent", len(kept), "deferred", len(msg.State)-len(kept), "deferredBytes", size-used)
+	msg.State, msg.Version, msg.Full, msg.More = kept, since, false, false
+	return ahead, nil, true
+}
+
+// recordAheadLocked records what a message holdToBudget fitted took to a peer: the entries sent ahead of their
+// turn, until the peer's watermark covers them, when they are forgotten
+func (gs *GameServer) recordAheadLocked(peerAddr string, ahead map[string]uint64, covered []string) {
+	sent := gs.peerAhead[peerAddr]
+	for _, key := range covered {
+		delete(sent, key)
+	}
+	if len(ahead) == 0 {
+		if len(sent) == 0 {
+			delete(gs.peerAhead, peerAddr)
+		}
+		return
+	}
+	if sent == nil {
+		sent = make(map[string]uint64)
+		gs.peerAhead[peerAddr] = sent
+	}
+	for key, clock := range ahead {
+		sent[key] = clock
+	}
+}
+
+// morePressing reports whether entry a should go out before entry b when a round can't send both: the one of
+// higher priority, so low-priority entries only get what is left over, or else the one changed last, so that
+// latecomers to a backlog aren't stuck behind it
+func morePressing(keyA string, a gossip.Entry, keyB string, b gossip.Entry) bool {
+	if a.Priority != b.Priority {
+		return a.Priority > b.Priority
+	}
+	if a.Clock != b.Clock {
+		return a.Clock > b.Clock
+	}
+	return keyA < keyB
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"context"
+	"math/rand"
+	"slices"
+	"time"
+)
+
+// BootstrapConfig controls the pull of a peer's state when the node starts, see Bootstrapped
+type BootstrapConfig struct {
+	Peers   []string      // peers to pull the state from, any member if empty
+	Timeout time.Duration // how long to keep trying before serving whatever gossip has brought; 0 disables bootstrap
+}
+
+// DefaultBootstrapConfig pulls the state from any member, for up to 30 seconds
+func DefaultBootstrapConfig() BootstrapConfig {
+	return BootstrapConfig{Timeout: 30 * time.Second}
+}
+
+// bootstrapCheck is the readiness check that fails while the node pulls its state, which also tells a peer that
+// is bootstrapping itself that this node has no state to offer yet
+const bootstrapCheck = "bootstrap"
+
+// Bootstrapped is closed once the node has pulled the state of a ready peer, found that no peer has any state to
+// offer, as when the whole cluster starts at once, or given up after BootstrapConfig.Timeout. Until then it fails
+// its readiness check and the client API answers 503, so that it doesn't serve an empty state, or base increments
+// on one, before gossip catches up
+func (gs *GameServer) Bootstrapped() <-chan struct{} {
+	return gs.bootstrapped
+}
+
+// bootstrapping reports whether Bootstrapped is still open
+func (gs *GameServer) bootstrapping() bool {
+	select {
+	case <-gs.bootstrapped:
+		return false
+	default:
+		return true
+	}
+}
+
+// bootstrapLoop pulls the state from a peer, retrying with backoff while none is ready, until it has the state,
+// finds there is none to pull, or BootstrapConfig.Timeout runs out
+func (gs *GameServer) bootstrapLoop(ctx context.Context) {
+	defer close(gs.bootstrapped)
+	if _, ok := gs.Transport.(SyncTransport); !ok {
+		gs.Logger.Warn("transport does not support anti-entropy syncs, not bootstrapping the state")
+		return
+	}
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(ctx, gs.Bootstrap.Timeout)
+	defer cancel()
+
+	backoff := gs.Membership.ProbeInterval
+	for !gs.bootstrap(ctx, start) {
+		select {
+		case <-ctx.Done():
+			gs.Logger.Warn("gave up bootstrapping the state, serving what gossip has brought",
+				"after", time.Since(start).Round(time.Millisecond))
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, maxJoinBackoff)
+	}
+}
+
+// bootstrap makes one attempt at pulling the state from a ready peer, reporting whether bootstrap is over
+func (gs *GameServer) bootstrap(ctx context.Context, start time.Time) bool {
+	peers := gs.Membership.Registry.Peers(gs.Bootstrap.Peers)
+	if len(peers) == 0 {
+		for _, m := range gs.Membership.Members() {
+			if m.Address != gs.Address && (m.Status == MemberAlive || m.Status == MemberSuspect) {
+				peers = append(peers, m.Address)
+			}
+		}
+	}
+	if len(peers) == 0 {
+		gs.mu.Lock()
+		joining := len(gs.Seeds) > 0 && !gs.joined
+		gs.mu.Unlock()
+		if joining {
+			return false
+		}
+		gs.Logger.Info("no peer to bootstrap the state from, starting empty")
+		return true
+	}
+	peers = slices.Clone(peers)
+	rand.Shuffle(len(peers), func(i, j int) { peers[i], peers[j] = peers[j], peers[i] })
+
+	// A peer that isn't up, or is bootstrapping itself, has no state to offer; one that is up but not ready, say
+	// with its storage failing, may have once it recovers
+	waiting := false
+	for _, peer := range peers {
+		health, err := gs.peerReadiness(ctx, peer)
+		switch {
+		case err != nil:
+			gs.Logger.Debug("failed to reach peer to bootstrap from", "peer", peer, "err", err)
+			continue
+		case health.Checks[bootstrapCheck] != "" && health.Checks[bootstrapCheck] != "ok":
+			continue
+		case !health.OK():
+			waiting = true
+			continue
+		}
+		if err := gs.AntiEntropyWithPeer(ctx, peer); err != nil {
+			gs.Logger.Warn("failed to bootstrap the state from peer", "peer", peer, "err", err)
+			waiting = true
+			continue
+		}
+		gs.Logger.Info("bootstrapped the state from peer", "peer", peer, "entries", gs.State.Len(),
+			"took", time.Since(start).Round(time.Millisecond))
+		return true
+	}
+	if !waiting {
+		gs.Logger.Info("no peer has state to bootstrap from, starting empty", "peers", len(peers))
+	}
+	return !waiting
+}
+
+// peerReadiness fetches a peer's readiness check
+func (gs *GameServer) peerReadiness(ctx context.Context, peer string) (Health, error) {
+	ctx, cancel := context.WithTimeout(ctx, gs.gossipConfig().Timeout)
+	defer cancel()
+	return gs.peerTransport().FetchReadiness(ctx, peer)
+}
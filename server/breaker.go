@@ -0,0 +1,143 @@
+package server
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// BreakerConfig tunes the per-peer circuit breakers of the gossip loop. A peer whose exchanges keep failing or
+// timing out has its breaker opened and is left out of rounds, so that it stops taking up a worker and a slot in
+// the fanout that a healthy peer could use. Once the cooldown has passed, one trial exchange is let through: it
+// closes the breaker if it succeeds and opens it again if it fails, for twice as long each time up to MaxCooldown
+type BreakerConfig struct {
+	Failures    int           // consecutive failed exchanges that open a peer's breaker, 0 disables breakers
+	Cooldown    time.Duration // how long an open breaker first keeps the peer out of rounds
+	MaxCooldown time.Duration // cap on the doubled cooldowns of a peer that keeps failing; 0 never doubles
+}
+
+// DefaultBreakerConfig opens a peer's breaker after 5 failures in a row, for 30 seconds the first time and up to
+// 10 minutes for a peer that keeps failing
+func DefaultBreakerConfig() BreakerConfig {
+	return BreakerConfig{Failures: 5, Cooldown: 30 * time.Second, MaxCooldown: 10 * time.Minute}
+}
+
+// backoff returns base doubled attempt times, capped at limit, and spread by up to a fifth either way so that
+// nodes which failed together don't all retry together. A limit of 0 or less never doubles
+func backoff(base, limit time.Duration, attempt int) time.Duration {
+	d := base
+	for range attempt {
+		if d >= limit {
+			break
+		}
+		d *= 2
+	}
+	if limit > base {
+		d = min(d, limit)
+	}
+	if spread := int64(d) / 5; spread > 0 {
+		d += time.Duration(rand.Int63n(2*spread+1) - spread)
+	}
+	return d
+}
+
+// BreakerState is the state of a peer's circuit breaker
+type BreakerState string
+
+const (
+	BreakerClosed   BreakerState = "closed"    // exchanges go ahead
+	BreakerOpen     BreakerState = "open"      // the peer is left out until the cooldown has passed
+	BreakerHalfOpen BreakerState = "half-open" // a trial exchange is under way
+)
+
+// circuitBreakers holds the breaker of every peer that has failed recently. Peers without an entry are closed
+type circuitBreakers struct {
+	mu    sync.Mutex
+	peers map[string]*peerBreaker
+}
+
+type peerBreaker struct {
+	failures  int           // consecutive failed exchanges
+	openUntil time.Time     // zero while closed
+	cooldown  time.Duration // of the last opening
+	trips     int           // openings since the peer last succeeded, which the cooldown doubles with
+	trial     bool          // a trial exchange has been let through since the last opening
+}
+
+func newCircuitBreakers() *circuitBreakers {
+	return &circuitBreakers{peers: make(map[string]*peerBreaker)}
+}
+
+// allow reports whether an exchange with peer may go ahead. Past an open breaker's cooldown the first caller
+// gets the trial exchange, and the peer is refused for another cooldown while it runs. A trial that never
+// reports back, such as one cut short by shutdown, simply lets the next one through after that
+func (c *circuitBreakers) allow(peer string, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	b := c.peers[peer]
+	if b == nil || b.openUntil.IsZero() {
+		return true
+	}
+	if now.Before(b.openUntil) {
+		return false
+	}
+	b.trial = true
+	b.openUntil = now.Add(b.cooldown)
+	return true
+}
+
+// success closes the peer's breaker
+func (c *circuitBreakers) success(peer string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.peers, peer)
+}
+
+// failure records a failed exchange, opening the peer's breaker once there have been cfg.Failures in a row or a
+// trial exchange failed. It reports whether the breaker was opened, and for how long
+func (c *circuitBreakers) failure(peer string, cfg BreakerConfig, now time.Time) (bool, time.Duration) {
+	if cfg.Failures <= 0 {
+		return false, 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	b := c.peers[peer]
+	if b == nil {
+		b = &peerBreaker{}
+		c.peers[peer] = b
+	}
+	b.failures++
+	if b.failures < cfg.Failures && !b.trial {
+		return false, 0
+	}
+	b.cooldown = backoff(cfg.Cooldown, cfg.MaxCooldown, b.trips)
+	b.openUntil, b.trial = now.Add(b.cooldown), false
+	b.trips++
+	return true, b.cooldown
+}
+
+// forget drops the peer's breaker, for a peer that has gone for good
+func (c *circuitBreakers) forget(peer string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.peers, peer)
+}
+
+// state returns the state of the peer's breaker
+func (c *circuitBreakers) state(peer string, now time.Time) BreakerState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	b := c.peers[peer]
+	switch {
+	case b == nil || b.openUntil.IsZero():
+		return BreakerClosed
+	case b.trial && now.Before(b.openUntil):
+		return BreakerHalfOpen
+	default:
+		// Past the cooldown the breaker is still open until the next round tries the peer
+		return BreakerOpen
+	}
+}
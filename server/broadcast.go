@@ -0,0 +1,284 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	mathrand "math/rand"
+	"sync"
+	"time"
+)
+
+// Limits on a broadcast message
+const (
+	MaxBroadcastSize = 64 << 10 // bytes of data
+	MaxTopicLen      = 128
+	MaxMessageIdLen  = 128
+)
+
+// BroadcastConfig controls the spreading of broadcast messages, see Broadcast
+type BroadcastConfig struct {
+	Interval    time.Duration // time between rounds of sending the messages still being spread
+	Fanout      int           // peers sent the messages every round
+	Retransmits int           // rounds each node that hears of a message sends it in, before it stops spreading it
+	SeenTTL     time.Duration // how long message IDs are remembered to drop duplicates, and the oldest a message may arrive
+	MaxQueue    int           // messages being spread at once, past which the oldest is given up on
+}
+
+// DefaultBroadcastConfig sends the messages being spread to 3 peers every 200ms, for 4 rounds
+func DefaultBroadcastConfig() BroadcastConfig {
+	return BroadcastConfig{Interval: 200 * time.Millisecond, Fanout: 3, Retransmits: 4, SeenTTL: time.Minute, MaxQueue: 1024}
+}
+
+// Message is an ephemeral application message, such as "match started", spread to every node by Broadcast
+type Message struct {
+	Id     string          `json:"id"`
+	Topic  string          `json:"topic,omitempty"`
+	Data   json.RawMessage `json:"data,omitempty"`
+	Origin string          `json:"origin"` // ID of the node it was broadcast from
+	Sent   time.Time       `json:"sent"`
+}
+
+// rumor is a message this node is still spreading
+type rumor struct {
+	msg  Message
+	from string // the peer it was heard from, which isn't sent it back
+	left int    // rounds left to send it in
+}
+
+// rumorMill is what the node keeps about broadcast messages: the ones it has heard of, the ones it is still
+// spreading and the callbacks they are delivered to
+type rumorMill struct {
+	mu         sync.Mutex
+	seen       map[string]time.Time // IDs of the messages heard of, and when they are forgotten
+	rumors     []*rumor
+	subs       []broadcastSubscriber
+	nextId     uint64
+	deliveries []Message
+	wake       chan struct{}
+}
+
+type broadcastSubscriber struct {
+	id uint64
+	fn func(Message)
+}
+
+func newRumorMill() *rumorMill {
+	return &rumorMill{seen: make(map[string]time.Time), wake: make(chan struct{}, 1)}
+}
+
+// Broadcast spreads a message to every node by rumor mongering: each node that hears of it sends it to
+// BroadcastConfig.Fanout random peers a round for BroadcastConfig.Retransmits rounds, and delivers it once to its
+// OnBroadcast callbacks, this node's included. Delivery is best-effort: messages aren't stored, a node that is
+// down while one spreads never gets it, and they may arrive out of order. An empty Id is filled in with a random
+// one; broadcasting an ID this node has already heard of does nothing, so a client can retry with the same ID.
+// It returns the message with its Id, Origin and Sent set
+func (gs *GameServer) Broadcast(msg Message) (Message, error) {
+	if msg.Id == "" {
+		id := make([]byte, 16)
+		rand.Read(id)
+		msg.Id = hex.EncodeToString(id)
+	}
+	msg.Origin, msg.Sent = gs.ID, gs.State.Now().UTC()
+	if err := ValidateMessage(msg); err != nil {
+		return Message{}, err
+	}
+	if gs.hearRumor(msg, "") {
+		gs.Metrics.Broadcasts.With("originated").Inc()
+		gs.Logger.Debug("broadcasting message", "id", msg.Id, "topic", msg.Topic)
+	}
+	return msg, nil
+}
+
+// OnBroadcast calls fn for every broadcast message this node hears of, once each. Calls are made one at a time
+// from a goroutine run by Start, so a slow callback delays the messages after it but not their spreading. Call the
+// returned function to unsubscribe; a message already being delivered may still reach fn
+func (gs *GameServer) OnBroadcast(fn func(Message)) (unsubscribe func()) {
+	m := gs.rumors
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.nextId++
+	id := m.nextId
+	m.subs = append(m.subs, broadcastSubscriber{id: id, fn: fn})
+
+	return func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		for i, sub := range m.subs {
+			if sub.id == id {
+				m.subs = append(m.subs[:i:i], m.subs[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// MergeRumors takes broadcast messages sent by a peer, spreading and delivering the ones this node hadn't heard of
+func (gs *GameServer) MergeRumors(from string, msgs []Message) {
+	cutoff := gs.State.Now().Add(-gs.Broadcasts.SeenTTL)
+	for _, msg := range msgs {
+		switch {
+		case ValidateMessage(msg) != nil:
+			gs.Metrics.Broadcasts.With("invalid").Inc()
+		case msg.Sent.Before(cutoff):
+			// Its ID may have been forgotten already, so it can't be told from a duplicate
+			gs.Metrics.Broadcasts.With("expired").Inc()
+		case !gs.hearRumor(msg, from):
+			gs.Metrics.Broadcasts.With("duplicate").Inc()
+		}
+	}
+}
+
+// hearRumor queues a message for spreading and delivery, reporting false if the node had already heard of it
+func (gs *GameServer) hearRumor(msg Message, from string) bool {
+	m := gs.rumors
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.seen[msg.Id]; ok {
+		return false
+	}
+	m.seen[msg.Id] = gs.State.Now().Add(gs.Broadcasts.SeenTTL)
+
+	if max := gs.Broadcasts.MaxQueue; max > 0 && len(m.rumors) >= max {
+		m.rumors = m.rumors[len(m.rumors)-max+1:]
+		gs.Metrics.Broadcasts.With("dropped").Inc()
+	}
+	if gs.Broadcasts.Retransmits > 0 {
+		m.rumors = append(m.rumors, &rumor{msg: msg, from: from, left: gs.Broadcasts.Retransmits})
+	}
+	if len(m.subs) > 0 {
+		m.deliveries = append(m.deliveries, msg)
+		select {
+		case m.wake <- struct{}{}:
+		default:
+		}
+	}
+	return true
+}
+
+// activeRumors returns the number of messages still being spread
+func (m *rumorMill) activeRumors() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.rumors)
+}
+
+// forgetSeen forgets the message IDs that are past SeenTTL
+func (m *rumorMill) forgetSeen(now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, forget := range m.seen {
+		if now.After(forget) {
+			delete(m.seen, id)
+		}
+	}
+}
+
+// nextRound returns the messages to send this round, counting the round against them
+func (m *rumorMill) nextRound() []rumor {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	round := make([]rumor, 0, len(m.rumors))
+	kept := m.rumors[:0]
+	for _, r := range m.rumors {
+		round = append(round, *r)
+		if r.left--; r.left > 0 {
+			kept = append(kept, r)
+		}
+	}
+	clear(m.rumors[len(kept):])
+	m.rumors = kept
+	return round
+}
+
+// broadcastLoop sends the messages being spread to a few random peers every interval
+func (gs *GameServer) broadcastLoop(ctx context.Context) {
+	ticker := time.NewTicker(gs.Broadcasts.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		gs.rumors.forgetSeen(gs.State.Now())
+		peers := gs.Membership.Peers()
+		if len(peers) == 0 {
+			// Keep the messages for when there is someone to tell
+			continue
+		}
+		round := gs.rumors.nextRound()
+		if len(round) == 0 {
+			continue
+		}
+		mathrand.Shuffle(len(peers), func(i, j int) { peers[i], peers[j] = peers[j], peers[i] })
+		for _, peer := range peers[:min(gs.Broadcasts.Fanout, len(peers))] {
+			msgs := make([]Message, 0, len(round))
+			for _, r := range round {
+				if r.from != peer {
+					msgs = append(msgs, r.msg)
+				}
+			}
+			if len(msgs) == 0 {
+				continue
+			}
+			if err := gs.sendRumors(ctx, peer, msgs); err != nil {
+				gs.Metrics.BroadcastPushes.With("failed").Inc()
+				gs.Logger.Debug("failed to send broadcast messages", "peer", peer, "err", err)
+				continue
+			}
+			gs.Metrics.BroadcastPushes.With("ok").Inc()
+		}
+	}
+}
+
+func (gs *GameServer) sendRumors(ctx context.Context, peer string, msgs []Message) error {
+	ctx, cancel := context.WithTimeout(ctx, gs.gossipConfig().Timeout)
+	defer cancel()
+	return gs.peerTransport().SendBroadcasts(ctx, peer, msgs)
+}
+
+// deliverBroadcasts runs OnBroadcast callbacks until ctx is done
+func (gs *GameServer) deliverBroadcasts(ctx context.Context) {
+	m := gs.rumors
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-m.wake:
+		}
+
+		m.mu.Lock()
+		deliveries, subs := m.deliveries, m.subs
+		m.deliveries = nil
+		m.mu.Unlock()
+
+		for _, msg := range deliveries {
+			gs.Metrics.Broadcasts.With("delivered").Inc()
+			for _, sub := range subs {
+				sub.fn(msg)
+			}
+		}
+	}
+}
+
+// ValidateMessage checks a broadcast message against the limits
+func ValidateMessage(msg Message) error {
+	switch {
+	case msg.Id == "":
+		return errors.New("id is empty")
+	case len(msg.Id) > MaxMessageIdLen:
+		return fmt.Errorf("id must be at most %d bytes", MaxMessageIdLen)
+	case len(msg.Topic) > MaxTopicLen:
+		return fmt.Errorf("topic must be at most %d bytes", MaxTopicLen)
+	case len(msg.Data) > MaxBroadcastSize:
+		return fmt.Errorf("data must be at most %d bytes", MaxBroadcastSize)
+	case len(msg.Data) > 0 && !json.Valid(msg.Data):
+		return errors.New("data is not valid JSON")
+	}
+	return nil
+}
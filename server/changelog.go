@@ -0,0 +1,164 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"gmathur.dev/gossiper/gossip"
+)
+
+// ChangeLog is an append-only log numbering events in the order they were appended, such as store.EventLog
+type ChangeLog interface {
+	Append(payload []byte) (seq uint64, err error)
+	// Read calls fn with the events after since, in order, until fn returns false or limit events have been read
+	Read(since uint64, limit int, fn func(seq uint64, payload []byte) bool) error
+	// Bounds returns the oldest event still kept and the newest, 0 and 0 while the log is empty
+	Bounds() (first, last uint64)
+	Healthy() error
+	Close() error
+}
+
+// LoggedChange is an entry of the node's change log: a change to a player accepted by this node, made here or
+// merged from a peer. Sequence numbers are this node's own; every node logs the changes it accepts in the order
+// it accepted them
+type LoggedChange struct {
+	Seq      uint64       `json:"seq"`
+	Time     time.Time    `json:"time"`     // when the node accepted the change
+	Type     string       `json:"type"`     // PlayerUpdated or PlayerDeleted
+	Source   ChangeType   `json:"source"`   // local or remote
+	PlayerId string       `json:"playerId"` // player changed
+	State    *PlayerState `json:"state,omitempty"`
+}
+
+// ChangeFeed is a page of the change log, see ReadChanges
+type ChangeFeed struct {
+	Changes []LoggedChange `json:"changes"`
+	// Oldest change still logged. A reader whose since is below First-1 has missed the changes in between to
+	// retention and should read the state again
+	First uint64 `json:"first"`
+	Last  uint64 `json:"last"` // newest change logged
+	Next  uint64 `json:"next"` // since to pass for the following page
+}
+
+// maxQueuedChanges caps the changes waiting to be appended to the change log; the oldest are dropped beyond it
+const maxQueuedChanges = 100000
+
+// changeQueue holds the encoded changes waiting to be appended to the change log, see changeLogLoop
+type changeQueue struct {
+	mu      sync.Mutex
+	queue   [][]byte
+	dropped int // changes dropped since the last append
+	wake    chan struct{}
+}
+
+func newChangeQueue() *changeQueue {
+	return &changeQueue{wake: make(chan struct{}, 1)}
+}
+
+// logChange is a gossip.Store OnChange observer queueing every change to a player for the change log. It runs
+// while the store is locked, so the append, with any flush or segment rotation it brings, is left to
+// changeLogLoop. Restored and dropped entries are left out: they change what the node holds, not the player
+func (gs *GameServer) logChange(c gossip.Change) {
+	if gs.ChangeLog == nil || c.Source == gossip.ChangeRestored || c.Source == gossip.ChangeDropped {
+		return
+	}
+	if c.New.Deleted && (!c.Existed || c.Old.Deleted) {
+		// A tombstone for a player the node never had, or replacing a tombstone
+		return
+	}
+
+	change := LoggedChange{Time: time.Now(), Type: PlayerUpdated, Source: ChangeLocal, PlayerId: c.Key,
+		State: playerStateOf(c.New)}
+	if c.New.Deleted {
+		change.Type = PlayerDeleted
+	}
+	if c.Source == gossip.ChangeRemote {
+		change.Source = ChangeRemote
+	}
+	payload, err := json.Marshal(change)
+	if err != nil {
+		gs.Logger.Error("failed to encode change for the change log", "player", c.Key, "err", err)
+		return
+	}
+
+	q := gs.changes
+	q.mu.Lock()
+	q.queue = append(q.queue, payload)
+	if over := len(q.queue) - maxQueuedChanges; over > 0 {
+		q.queue = q.queue[over:]
+		q.dropped += over
+	}
+	q.mu.Unlock()
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// changeLogLoop appends queued changes to the change log until ctx is done. Close appends what is queued after
+func (gs *GameServer) changeLogLoop(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			gs.appendChanges()
+			return
+		case <-gs.changes.wake:
+		}
+		gs.appendChanges()
+	}
+}
+
+// appendChanges appends every queued change to the change log, in the order they were queued
+func (gs *GameServer) appendChanges() {
+	q := gs.changes
+	q.mu.Lock()
+	queue, dropped := q.queue, q.dropped
+	q.queue, q.dropped = nil, 0
+	q.mu.Unlock()
+
+	if dropped > 0 {
+		gs.Metrics.ChangeLogDropped.With().Add(float64(dropped))
+		gs.Logger.Warn("dropped changes, the change log queue was full", "count", dropped)
+	}
+	failed := 0
+	var lastErr error
+	for _, payload := range queue {
+		if _, err := gs.ChangeLog.Append(payload); err != nil {
+			failed, lastErr = failed+1, err
+		}
+	}
+	if failed > 0 {
+		gs.Logger.Error("failed to log changes", "count", failed, "err", lastErr)
+	}
+}
+
+// ReadChanges returns up to limit changes after since from the change log, oldest first, or nil if the node has
+// none
+func (gs *GameServer) ReadChanges(since uint64, limit int) (*ChangeFeed, error) {
+	if gs.ChangeLog == nil {
+		return nil, nil
+	}
+	feed := &ChangeFeed{Changes: []LoggedChange{}, Next: since}
+	feed.First, feed.Last = gs.ChangeLog.Bounds()
+
+	var decodeErr error
+	err := gs.ChangeLog.Read(since, limit, func(seq uint64, payload []byte) bool {
+		var change LoggedChange
+		if decodeErr = json.Unmarshal(payload, &change); decodeErr != nil {
+			return false
+		}
+		change.Seq = seq
+		feed.Changes = append(feed.Changes, change)
+		feed.Next = seq
+		return true
+	})
+	if err == nil {
+		err = decodeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return feed, nil
+}
@@ -0,0 +1,149 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"slices"
+	"sync"
+	"time"
+
+	"gmathur.dev/gossiper/metrics"
+)
+
+// ErrChaos is returned for peer requests that chaos injection dropped
+var ErrChaos = errors.New("chaos")
+
+// ChaosConfig describes the faults injected into the traffic between this node and its peers, for checking how
+// the cluster converges and detects failures when the network misbehaves. The zero value injects none
+type ChaosConfig struct {
+	Drop    float64       // share of peer requests, sent or received, that are dropped; 0 to 1
+	Delay   time.Duration // added to every peer request sent and to every response to one
+	Jitter  time.Duration // up to this much more delay, at random
+	Corrupt float64       // share of peer requests sent with a byte of their body flipped; 0 to 1
+	Isolate []string      // peers cut off entirely, in both directions
+}
+
+// Validate checks the shares are between 0 and 1 and the delays aren't negative
+func (c ChaosConfig) Validate() error {
+	if c.Drop < 0 || c.Drop > 1 {
+		return fmt.Errorf("drop rate %v is not between 0 and 1", c.Drop)
+	}
+	if c.Corrupt < 0 || c.Corrupt > 1 {
+		return fmt.Errorf("corrupt rate %v is not between 0 and 1", c.Corrupt)
+	}
+	if c.Delay < 0 || c.Jitter < 0 {
+		return errors.New("delay and jitter can't be negative")
+	}
+	return nil
+}
+
+// Chaos injects the faults of a ChaosConfig that can be changed while the node runs. A nil Chaos injects nothing,
+// so the peer paths need no checks of their own
+type Chaos struct {
+	faults *metrics.CounterVec
+
+	mu  sync.Mutex
+	cfg ChaosConfig
+}
+
+// EnableChaos turns on fault injection for the node's peer traffic, with no faults until they are set on the Chaos
+// returned. Call it before Start
+func (gs *GameServer) EnableChaos() *Chaos {
+	gs.Chaos = &Chaos{faults: gs.Metrics.ChaosFaults}
+	return gs.Chaos
+}
+
+// Config returns the faults being injected
+func (c *Chaos) Config() ChaosConfig {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cfg := c.cfg
+	cfg.Isolate = slices.Clone(cfg.Isolate)
+	return cfg
+}
+
+// Set replaces the faults being injected
+func (c *Chaos) Set(cfg ChaosConfig) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	cfg.Isolate = slices.Clone(cfg.Isolate)
+	c.mu.Lock()
+	c.cfg = cfg
+	c.mu.Unlock()
+	return nil
+}
+
+// Refuses reports whether a request to or from the peer at addr is to be dropped, because the peer is isolated
+// or by chance
+func (c *Chaos) Refuses(addr string) bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	cfg := c.cfg
+	c.mu.Unlock()
+
+	if slices.Contains(cfg.Isolate, addr) {
+		c.faults.With("isolated").Inc()
+		return true
+	}
+	if cfg.Drop > 0 && rand.Float64() < cfg.Drop {
+		c.faults.With("dropped").Inc()
+		return true
+	}
+	return false
+}
+
+// Wait sleeps for the configured delay and jitter, or until ctx is done
+func (c *Chaos) Wait(ctx context.Context) error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	delay, jitter := c.cfg.Delay, c.cfg.Jitter
+	c.mu.Unlock()
+
+	if jitter > 0 {
+		delay += time.Duration(rand.Int63n(int64(jitter)))
+	}
+	if delay <= 0 {
+		return nil
+	}
+	c.faults.With("delayed").Inc()
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Outgoing applies the faults to a request body about to be sent to the peer at addr: it fails dropped requests
+// with ErrChaos, delays the rest and returns the body to send, corrupted by chance. The body passed in is never
+// modified
+func (c *Chaos) Outgoing(ctx context.Context, addr string, body []byte) ([]byte, error) {
+	if c == nil {
+		return body, nil
+	}
+	if c.Refuses(addr) {
+		return nil, fmt.Errorf("%w: dropped request to %s", ErrChaos, addr)
+	}
+	if err := c.Wait(ctx); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	corrupt := c.cfg.Corrupt
+	c.mu.Unlock()
+	if len(body) == 0 || corrupt <= 0 || rand.Float64() >= corrupt {
+		return body, nil
+	}
+	c.faults.With("corrupted").Inc()
+	corrupted := slices.Clone(body)
+	corrupted[rand.Intn(len(corrupted))] ^= byte(1 + rand.Intn(255))
+	return corrupted, nil
+}
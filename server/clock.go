@@ -0,0 +1,194 @@
+package server
+
+import (
+	"slices"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gmathur.dev/gossiper/gossip"
+)
+
+// ClockConfig controls the detection of clock skew between nodes, see Clocks
+type ClockConfig struct {
+	WarnSkew time.Duration // a peer's clock, or the node's own, off by more than this from the cluster's is logged
+	Correct  bool          // stamp writes with the cluster's time rather than the node's own while it is that far off
+	MaxDrift time.Duration // how far ahead of the node's clock entries from peers may move it, see gossip.HLC.MaxDrift
+}
+
+// DefaultClockConfig warns about clocks more than a second apart, without correcting them, and holds the node's
+// clock back from entries more than gossip.DefaultMaxDrift ahead
+func DefaultClockConfig() ClockConfig {
+	return ClockConfig{WarnSkew: time.Second, MaxDrift: gossip.DefaultMaxDrift}
+}
+
+// stores returns every store of the node, whose clocks ClockConfig applies to
+func (gs *GameServer) stores() []*gossip.Store {
+	return []*gossip.Store{gs.State, gs.sessions, gs.leases, gs.settings, gs.idempotency}
+}
+
+// ClockReport is the clock skew between this node and its peers, as measured by probes
+type ClockReport struct {
+	Offset     string      `json:"offset"`     // how far the cluster's median clock is ahead of this node's
+	Correction string      `json:"correction"` // added to this node's clock for write stamps, see ClockConfig.Correct
+	Peers      []PeerClock `json:"peers"`
+}
+
+// PeerClock is the skew between a peer's clock and this node's
+type PeerClock struct {
+	Address string    `json:"address"`
+	Offset  string    `json:"offset"` // how far the peer's clock is ahead of this node's, negative if behind
+	RTT     string    `json:"rtt"`    // round trip of the probes measuring it; the offset is within about half of it
+	Samples int       `json:"samples"`
+	Updated time.Time `json:"updated"`
+	Skewed  bool      `json:"skewed"` // off by more than ClockConfig.WarnSkew
+}
+
+// clockSkewSmoothing is the weight of a new sample in a peer's offset and round trip
+const clockSkewSmoothing = 0.2
+
+// clockTracker keeps the offset of every peer's clock from this node's
+type clockTracker struct {
+	mu         sync.Mutex
+	peers      map[string]*peerClock
+	skewed     bool         // the node's own clock is off from the cluster's by more than WarnSkew
+	correction atomic.Int64 // nanoseconds added to the node's clock, see ClockConfig.Correct
+}
+
+type peerClock struct {
+	offset, rtt time.Duration
+	samples     int
+	updated     time.Time
+	skewed      bool
+}
+
+func newClockTracker() *clockTracker {
+	return &clockTracker{peers: make(map[string]*peerClock)}
+}
+
+// now returns the time to stamp writes with: the wall clock, corrected towards the cluster's with
+// ClockConfig.Correct
+func (c *clockTracker) now(wall func() time.Time) func() time.Time {
+	return func() time.Time {
+		return wall().Add(time.Duration(c.correction.Load()))
+	}
+}
+
+// observeClock takes a sample of a peer's clock from a probe: the peer's wall clock when it answered, and when the
+// probe was sent and its ack came back by ours. The peer is taken to have answered halfway through the round trip
+func (gs *GameServer) observeClock(addr string, peerTime, sent, acked time.Time) {
+	rtt := acked.Sub(sent)
+	offset := peerTime.Sub(sent.Add(rtt / 2))
+	warn := gs.ClockSkew.WarnSkew
+	alive := gs.Membership.Peers()
+
+	c := gs.clocks
+	c.mu.Lock()
+	p, ok := c.peers[addr]
+	if !ok {
+		p = &peerClock{offset: offset, rtt: rtt}
+		c.peers[addr] = p
+	}
+	p.offset += time.Duration(clockSkewSmoothing * float64(offset-p.offset))
+	p.rtt += time.Duration(clockSkewSmoothing * float64(rtt-p.rtt))
+	p.samples++
+	p.updated = acked
+	// A skew can't be told apart from the probe's own delay, so only one beyond half the round trip counts
+	skewed := warn > 0 && abs(p.offset) > warn+p.rtt/2
+	changed := skewed != p.skewed
+	p.skewed = skewed
+	peerOffset := p.offset
+
+	// The node only judges its own clock once it has heard from every alive peer, so that the first peer it hears
+	// from being off doesn't make it think itself off
+	clusterOffset := c.clusterOffsetLocked()
+	selfSkewed, selfChanged := c.skewed, false
+	if !slices.ContainsFunc(alive, func(peer string) bool { return c.peers[peer] == nil }) {
+		selfSkewed = warn > 0 && abs(clusterOffset) > warn
+		selfChanged = selfSkewed != c.skewed
+		c.skewed = selfSkewed
+	}
+	correction := time.Duration(0)
+	if gs.ClockSkew.Correct && selfSkewed {
+		correction = clusterOffset
+	}
+	c.correction.Store(int64(correction))
+	c.mu.Unlock()
+
+	switch {
+	case changed && skewed:
+		gs.Logger.Warn("peer clock is skewed", "peer", addr, "offset", peerOffset.Round(time.Millisecond))
+	case changed:
+		gs.Logger.Info("peer clock is back in step", "peer", addr, "offset", peerOffset.Round(time.Millisecond))
+	}
+	switch {
+	case selfChanged && selfSkewed:
+		gs.Logger.Warn("clock is off from the cluster's, last-write-wins may pick the wrong write",
+			"offset", clusterOffset.Round(time.Millisecond), "correction", correction.Round(time.Millisecond))
+	case selfChanged:
+		gs.Logger.Info("clock is back in step with the cluster's", "offset", clusterOffset.Round(time.Millisecond))
+	}
+}
+
+// clusterOffsetLocked returns the median offset of the peers' clocks, this node's own counting as 0, so that
+// a node alone in being off finds itself off, and the rest don't
+func (c *clockTracker) clusterOffsetLocked() time.Duration {
+	offsets := []time.Duration{0}
+	for _, p := range c.peers {
+		offsets = append(offsets, p.offset)
+	}
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+	n := len(offsets)
+	if n%2 == 1 {
+		return offsets[n/2]
+	}
+	return (offsets[n/2-1] + offsets[n/2]) / 2
+}
+
+// forget drops what the tracker keeps about a peer
+func (c *clockTracker) forget(addr string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.peers, addr)
+}
+
+// Clocks reports the skew between this node's clock and its peers', measured by the probes of the failure
+// detector. Last-write-wins orders writes by hybrid logical clocks, which keep causality but not real time across
+// nodes: of two writes made before either node heard of the other, the one from the clock that runs ahead wins,
+// even if it was made last, and entries stamped by it expire late. ClockConfig.Correct has a node off from the
+// cluster stamp its writes with the cluster's time instead
+func (gs *GameServer) Clocks() ClockReport {
+	c := gs.clocks
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	report := ClockReport{
+		Offset:     c.clusterOffsetLocked().Round(time.Microsecond).String(),
+		Correction: time.Duration(c.correction.Load()).Round(time.Microsecond).String(),
+		Peers:      []PeerClock{},
+	}
+	for addr, p := range c.peers {
+		report.Peers = append(report.Peers, PeerClock{Address: addr, Offset: p.offset.Round(time.Microsecond).String(),
+			RTT: p.rtt.Round(time.Microsecond).String(), Samples: p.samples, Updated: p.updated, Skewed: p.skewed})
+	}
+	sort.Slice(report.Peers, func(i, j int) bool { return report.Peers[i].Address < report.Peers[j].Address })
+	return report
+}
+
+// clockOffsets returns the offset of every peer's clock in seconds, for metrics
+func (c *clockTracker) clockOffsets() map[string]float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	offsets := make(map[string]float64, len(c.peers))
+	for addr, p := range c.peers {
+		offsets[addr] = p.offset.Seconds()
+	}
+	return offsets
+}
+
+func abs(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
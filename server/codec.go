@@ -0,0 +1,348 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"strings"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"gmathur.dev/gossiper/gossip"
+)
+
+const (
+	ContentTypeJSON     = "application/json"
+	ContentTypeMsgpack  = "application/msgpack"
+	ContentTypeProtobuf = "application/x-protobuf"
+)
+
+// Codec encodes gossip messages for the wire. Every node decodes all codecs, picking one by the request's
+// Content-Type, and replies in the codec it was sent; the codec a node sends with is only used for peers that
+// have advertised it, so JSON stays the common denominator
+type Codec interface {
+	ContentType() string
+	Marshal(msg GossipMessage) ([]byte, error)
+	Unmarshal(data []byte, msg *GossipMessage) error
+}
+
+// bufferMarshaler is implemented by codecs that can encode a message straight into a buffer, see MarshalTo
+type bufferMarshaler interface {
+	MarshalTo(buf *bytes.Buffer, msg GossipMessage) error
+}
+
+// MarshalTo appends msg encoded with codec to buf, such as a pooled buffer. The built-in codecs encode
+// into it directly; others are marshalled and copied
+func MarshalTo(buf *bytes.Buffer, codec Codec, msg GossipMessage) error {
+	if m, ok := codec.(bufferMarshaler); ok {
+		return m.MarshalTo(buf, msg)
+	}
+	data, err := codec.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	buf.Write(data)
+	return nil
+}
+
+// Codecs lists every supported codec, in the order they are advertised
+var Codecs = []Codec{JSONCodec{}, MsgpackCodec{}, ProtobufCodec{}}
+
+// ParseCodec converts a codec name (as given on the command line) into a Codec
+func ParseCodec(s string) (Codec, error) {
+	switch s {
+	case "json":
+		return JSONCodec{}, nil
+	case "msgpack":
+		return MsgpackCodec{}, nil
+	case "protobuf":
+		return ProtobufCodec{}, nil
+	default:
+		return nil, fmt.Errorf("unknown codec %q", s)
+	}
+}
+
+// CodecFor returns the codec for a Content-Type header, defaulting to JSON when it is empty
+func CodecFor(contentType string) (Codec, bool) {
+	if contentType == "" {
+		return JSONCodec{}, true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, false
+	}
+	for _, c := range Codecs {
+		if c.ContentType() == mediaType {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+// AcceptedContentTypes is the header value advertising every supported codec
+func AcceptedContentTypes() string {
+	types := make([]string, len(Codecs))
+	for i, c := range Codecs {
+		types[i] = c.ContentType()
+	}
+	return strings.Join(types, ", ")
+}
+
+// JSONCodec is the default, human-readable codec
+type JSONCodec struct{}
+
+func (JSONCodec) ContentType() string { return ContentTypeJSON }
+
+func (JSONCodec) Marshal(msg GossipMessage) ([]byte, error) { return json.Marshal(msg) }
+
+func (JSONCodec) MarshalTo(buf *bytes.Buffer, msg GossipMessage) error {
+	if err := json.NewEncoder(buf).Encode(msg); err != nil {
+		return err
+	}
+	// Encode ends the message with a newline that Marshal doesn't
+	buf.Truncate(buf.Len() - 1)
+	return nil
+}
+
+func (JSONCodec) Unmarshal(data []byte, msg *GossipMessage) error { return json.Unmarshal(data, msg) }
+
+// MsgpackCodec encodes messages as MessagePack, using the same field names as JSON
+type MsgpackCodec struct{}
+
+func (MsgpackCodec) ContentType() string { return ContentTypeMsgpack }
+
+func (c MsgpackCodec) Marshal(msg GossipMessage) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := c.MarshalTo(&buf, msg); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// MarshalTo and Unmarshal take their encoder and decoder from msgpack's pools
+func (MsgpackCodec) MarshalTo(buf *bytes.Buffer, msg GossipMessage) error {
+	enc := msgpack.GetEncoder()
+	defer msgpack.PutEncoder(enc)
+	enc.Reset(buf)
+	enc.SetCustomStructTag("json")
+	return enc.Encode(msg)
+}
+
+func (MsgpackCodec) Unmarshal(data []byte, msg *GossipMessage) error {
+	dec := msgpack.GetDecoder()
+	defer msgpack.PutDecoder(dec)
+	dec.Reset(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+	return dec.Decode(msg)
+}
+
+// ProtobufCodec encodes messages as protocol buffers, following the schema in gossip.proto. Entry values are
+// carried as opaque bytes, so they stay whatever the application stored
+type ProtobufCodec struct{}
+
+func (ProtobufCodec) ContentType() string { return ContentTypeProtobuf }
+
+// Field numbers from gossip.proto
+const (
+	pbMessageFrom     = 1
+	pbMessageVersion  = 2
+	pbMessageSince    = 3
+	pbMessageFull     = 4
+	pbMessageState    = 5
+	pbMessageWant     = 6
+	pbMessageProtocol = 7
+	pbMessageMore     = 8
+
+	pbStateKey   = 1
+	pbStateEntry = 2
+
+	pbEntryValue     = 1
+	pbEntryTimestamp = 2
+	pbEntryClock     = 3
+	pbEntryOrigin    = 4
+	pbEntryDeleted   = 5
+	pbEntryTTL       = 6
+	pbEntryPriority  = 7
+)
+
+func (ProtobufCodec) Marshal(msg GossipMessage) ([]byte, error) {
+	return appendMessage(nil, msg), nil
+}
+
+func (ProtobufCodec) MarshalTo(buf *bytes.Buffer, msg GossipMessage) error {
+	buf.Write(appendMessage(buf.AvailableBuffer(), msg))
+	return nil
+}
+
+// appendMessage appends msg encoded as protobuf to b. Entries are encoded in scratch space shared by all of them,
+// so a message costs about as many allocations as it takes b to grow
+func appendMessage(b []byte, msg GossipMessage) []byte {
+	var entry, pair []byte
+	b = appendString(b, pbMessageFrom, msg.From)
+	b = appendVarint(b, pbMessageVersion, msg.Version)
+	b = appendVarint(b, pbMessageSince, msg.Since)
+	b = appendBool(b, pbMessageFull, msg.Full)
+	for key, e := range msg.State {
+		entry = appendBytes(entry[:0], pbEntryValue, e.Value)
+		entry = appendVarint(entry, pbEntryTimestamp, uint64(e.Timestamp))
+		entry = appendVarint(entry, pbEntryClock, e.Clock)
+		entry = appendString(entry, pbEntryOrigin, e.Origin)
+		entry = appendBool(entry, pbEntryDeleted, e.Deleted)
+		entry = appendVarint(entry, pbEntryTTL, uint64(e.TTL))
+		entry = appendVarint(entry, pbEntryPriority, protowire.EncodeZigZag(int64(e.Priority)))
+
+		pair = protowire.AppendTag(pair[:0], pbStateKey, protowire.BytesType)
+		pair = protowire.AppendString(pair, key)
+		pair = protowire.AppendTag(pair, pbStateEntry, protowire.BytesType)
+		pair = protowire.AppendBytes(pair, entry)
+
+		b = protowire.AppendTag(b, pbMessageState, protowire.BytesType)
+		b = protowire.AppendBytes(b, pair)
+	}
+	for _, key := range msg.Want {
+		b = protowire.AppendTag(b, pbMessageWant, protowire.BytesType)
+		b = protowire.AppendString(b, key)
+	}
+	b = appendVarint(b, pbMessageProtocol, uint64(msg.Protocol))
+	return appendBool(b, pbMessageMore, msg.More)
+}
+
+func (ProtobufCodec) Unmarshal(data []byte, msg *GossipMessage) error {
+	*msg = GossipMessage{}
+	// Entries mostly come from a few origins, so each is only copied out of data once
+	var origin string
+	return consumeFields(data, func(num protowire.Number, v uint64, b []byte) error {
+		switch num {
+		case pbMessageFrom:
+			msg.From = string(b)
+		case pbMessageVersion:
+			msg.Version = v
+		case pbMessageSince:
+			msg.Since = v
+		case pbMessageFull:
+			msg.Full = v != 0
+		case pbMessageState:
+			key, e, err := consumeStateEntry(b, &origin)
+			if err != nil {
+				return err
+			}
+			if msg.State == nil {
+				msg.State = make(map[string]gossip.Entry)
+			}
+			msg.State[key] = e
+		case pbMessageWant:
+			msg.Want = append(msg.Want, string(b))
+		case pbMessageProtocol:
+			msg.Protocol = uint32(v)
+		case pbMessageMore:
+			msg.More = v != 0
+		}
+		return nil
+	})
+}
+
+// consumeStateEntry decodes one entry of a message, reusing origin, the origin of the last entry, if it has the same
+func consumeStateEntry(data []byte, origin *string) (string, gossip.Entry, error) {
+	var key string
+	var e gossip.Entry
+	err := consumeFields(data, func(num protowire.Number, _ uint64, b []byte) error {
+		switch num {
+		case pbStateKey:
+			key = string(b)
+		case pbStateEntry:
+			return consumeFields(b, func(num protowire.Number, v uint64, b []byte) error {
+				switch num {
+				case pbEntryValue:
+					e.Value = append(json.RawMessage(nil), b...)
+				case pbEntryTimestamp:
+					e.Timestamp = int64(v)
+				case pbEntryClock:
+					e.Clock = v
+				case pbEntryOrigin:
+					if string(b) != *origin {
+						*origin = string(b)
+					}
+					e.Origin = *origin
+				case pbEntryDeleted:
+					e.Deleted = v != 0
+				case pbEntryTTL:
+					e.TTL = int64(v)
+				case pbEntryPriority:
+					e.Priority = gossip.Priority(protowire.DecodeZigZag(v))
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	return key, e, err
+}
+
+// consumeFields calls fn for every field in a protobuf message, with the value of varint fields or the contents
+// of length-delimited ones. Fields of any other wire type are skipped
+func consumeFields(data []byte, fn func(num protowire.Number, v uint64, b []byte) error) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		var v uint64
+		var b []byte
+		switch typ {
+		case protowire.VarintType:
+			v, n = protowire.ConsumeVarint(data)
+		case protowire.BytesType:
+			b, n = protowire.ConsumeBytes(data)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, data)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		if typ == protowire.VarintType || typ == protowire.BytesType {
+			if err := fn(num, v, b); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Zero values are left out, as proto3 does
+
+func appendVarint(b []byte, num protowire.Number, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+func appendBool(b []byte, num protowire.Number, v bool) []byte {
+	if !v {
+		return b
+	}
+	return appendVarint(b, num, 1)
+}
+
+func appendString(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+func appendBytes(b []byte, num protowire.Number, v []byte) []byte {
+	if len(v) == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, v)
+}
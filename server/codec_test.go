@@ -0,0 +1,95 @@
+package server_test
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"gmathur.dev/gossiper/gossip"
+	"gmathur.dev/gossiper/server"
+)
+
+func TestCodecRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  server.GossipMessage
+	}{
+		{"empty", server.GossipMessage{}},
+		{"header", server.GossipMessage{From: "localhost:8081", Version: 1 << 40, Since: 7, Full: true,
+			Protocol: server.ProtocolVersion, More: true}},
+		{"entries", server.GossipMessage{From: "localhost:8081", Version: 3, State: map[string]gossip.Entry{
+			"alice": {Value: []byte(`{"score":100}`), Timestamp: 1_700_000_000_000, Clock: 1<<63 + 5, Origin: "node1"},
+			"bob":   {Timestamp: 1_700_000_000_001, Clock: 2, Origin: "node2", Deleted: true},
+			"room:r1/carol": {Value: []byte(`{"score":-3,"attributes":{"ü":"✓"}}`), Clock: 3, Origin: "node1",
+				TTL: 3600, Priority: gossip.PriorityHigh},
+			"dave": {Value: []byte(`1`), Clock: 4, Origin: "node3", Priority: gossip.PriorityLow},
+			"":     {Value: []byte(`null`), Clock: 5, Origin: "node1"},
+		}}},
+		{"want", server.GossipMessage{From: "localhost:8082", Want: []string{"alice", "bob", ""}}},
+	}
+	for _, codec := range server.Codecs {
+		for _, tt := range tests {
+			data, err := codec.Marshal(tt.msg)
+			if err != nil {
+				t.Fatalf("%s %s: %v", codec.ContentType(), tt.name, err)
+			}
+			var buf bytes.Buffer
+			buf.WriteString("prefix")
+			if err := server.MarshalTo(&buf, codec, tt.msg); err != nil {
+				t.Fatalf("%s %s: %v", codec.ContentType(), tt.name, err)
+			}
+			if len(tt.msg.State) <= 1 && !bytes.Equal(buf.Bytes()[len("prefix"):], data) {
+				t.Errorf("%s %s: MarshalTo wrote %q, Marshal %q", codec.ContentType(), tt.name, buf.Bytes(), data)
+			}
+
+			var got server.GossipMessage
+			if err := codec.Unmarshal(data, &got); err != nil {
+				t.Fatalf("%s %s: %v", codec.ContentType(), tt.name, err)
+			}
+			if !reflect.DeepEqual(got, tt.msg) {
+				t.Errorf("%s %s: got %+v, want %+v", codec.ContentType(), tt.name, got, tt.msg)
+			}
+		}
+	}
+}
+
+func TestCodecRejectsTruncated(t *testing.T) {
+	msg := server.GossipMessage{From: "localhost:8081", State: map[string]gossip.Entry{
+		"alice": {Value: []byte(`{"score":100}`), Clock: 1, Origin: "node1"},
+	}}
+	for _, codec := range server.Codecs {
+		data, err := codec.Marshal(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got server.GossipMessage
+		if err := codec.Unmarshal(data[:len(data)-3], &got); err == nil {
+			t.Errorf("%s: decoded a truncated message as %+v", codec.ContentType(), got)
+		}
+	}
+}
+
+func TestParseCodec(t *testing.T) {
+	tests := []struct {
+		name, contentType string
+	}{
+		{"json", server.ContentTypeJSON},
+		{"msgpack", server.ContentTypeMsgpack},
+		{"protobuf", server.ContentTypeProtobuf},
+	}
+	for _, tt := range tests {
+		codec, err := server.ParseCodec(tt.name)
+		if err != nil || codec.ContentType() != tt.contentType {
+			t.Errorf("%s: got %v, %v", tt.name, codec, err)
+		}
+		if c, ok := server.CodecFor(tt.contentType + "; charset=utf-8"); !ok || c.ContentType() != tt.contentType {
+			t.Errorf("%s: content type picked %v, %v", tt.name, c, ok)
+		}
+	}
+	if _, err := server.ParseCodec("xml"); err == nil {
+		t.Error("parsed an unknown codec")
+	}
+	if _, ok := server.CodecFor("text/xml"); ok {
+		t.Error("picked a codec for an unknown content type")
+	}
+}
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/golang/snappy"
+)
+
+const (
+	EncodingGzip   = "gzip"
+	EncodingSnappy = "snappy" // snappy framing format
+)
+
+// Compression controls how peer request and response bodies are compressed. Encodings are negotiated per peer:
+// every request advertises ours in Accept-Encoding, every peer answers with its own, and a body is only ever
+// compressed with an encoding the other side has said it accepts, so nodes without compression keep working
+type Compression struct {
+	Encodings []string // supported encodings in order of preference; empty disables compression
+	Threshold int      // bodies smaller than this many bytes are sent uncompressed
+}
+
+func DefaultCompression() Compression {
+	return Compression{Encodings: []string{EncodingSnappy, EncodingGzip}, Threshold: 1024}
+}
+
+// ParseEncodings parses a comma-separated list of encodings, as given on the command line
+func ParseEncodings(s string) ([]string, error) {
+	var encodings []string
+	for _, enc := range strings.Split(s, ",") {
+		switch enc = strings.TrimSpace(enc); enc {
+		case "":
+		case EncodingGzip, EncodingSnappy:
+			encodings = append(encodings, enc)
+		default:
+			return nil, fmt.Errorf("unknown compression encoding %q", enc)
+		}
+	}
+	return encodings, nil
+}
+
+// AcceptEncoding is the header value advertising c's encodings
+func (c Compression) AcceptEncoding() string {
+	return strings.Join(c.Encodings, ", ")
+}
+
+// Negotiate picks the first of c's encodings listed in an Accept-Encoding header, or "" if there is none
+func (c Compression) Negotiate(acceptEncoding string) string {
+	accepted := make(map[string]bool)
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if strings.TrimSpace(params) != "q=0" {
+			accepted[strings.TrimSpace(name)] = true
+		}
+	}
+	for _, enc := range c.Encodings {
+		if accepted[enc] {
+			return enc
+		}
+	}
+	return ""
+}
+
+// Compressors and decompressors hold buffers and tables of tens to hundreds of kilobytes each, so they are reused
+// across messages rather than made for every one
+var (
+	gzipWriters   = sync.Pool{New: func() any { return gzip.NewWriter(nil) }}
+	snappyWriters = sync.Pool{New: func() any { return snappy.NewBufferedWriter(nil) }}
+	gzipReaders   = sync.Pool{New: func() any { return new(gzip.Reader) }}
+	snappyReaders = sync.Pool{New: func() any { return snappy.NewReader(nil) }}
+)
+
+// Compress encodes data with the given encoding
+func Compress(encoding string, data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := CompressTo(&buf, encoding, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// CompressTo is Compress appending to dst, such as a pooled buffer
+func CompressTo(dst *bytes.Buffer, encoding string, data []byte) error {
+	switch encoding {
+	case EncodingGzip:
+		w := gzipWriters.Get().(*gzip.Writer)
+		defer gzipWriters.Put(w)
+		w.Reset(dst)
+		return writeAndClose(w, data)
+	case EncodingSnappy:
+		w := snappyWriters.Get().(*snappy.Writer)
+		defer snappyWriters.Put(w)
+		w.Reset(dst)
+		return writeAndClose(w, data)
+	default:
+		return fmt.Errorf("unsupported encoding %q", encoding)
+	}
+}
+
+func writeAndClose(w io.WriteCloser, data []byte) error {
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+// Decompress wraps a body compressed with the given Content-Encoding. An empty or identity encoding returns the
+// body unchanged. Closing the reader gives its decompressor back to be reused, without closing body, so it must
+// not be read afterwards
+func Decompress(encoding string, body io.Reader) (io.ReadCloser, error) {
+	switch encoding {
+	case "", "identity":
+		return io.NopCloser(body), nil
+	case EncodingGzip:
+		r := gzipReaders.Get().(*gzip.Reader)
+		if err := r.Reset(body); err != nil {
+			gzipReaders.Put(r)
+			return nil, err
+		}
+		return &pooledReader{Reader: r, pool: &gzipReaders}, nil
+	case EncodingSnappy:
+		r := snappyReaders.Get().(*snappy.Reader)
+		r.Reset(body)
+		return &pooledReader{Reader: r, pool: &snappyReaders}, nil
+	default:
+		return nil, fmt.Errorf("unsupported encoding %q", encoding)
+	}
+}
+
+// pooledReader is a decompressor that goes back to its pool when closed
+type pooledReader struct {
+	io.Reader
+	pool *sync.Pool
+}
+
+func (r *pooledReader) Close() error {
+	if r.Reader != nil {
+		r.pool.Put(r.Reader)
+		r.Reader = nil
+	}
+	return nil
+}
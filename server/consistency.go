@@ -0,0 +1,141 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+
+	"gmathur.dev/gossiper/gossip"
+)
+
+// Consistency says how many of the nodes holding a player a read consults, see GetPlayerWithConsistency
+type Consistency string
+
+const (
+	// ConsistencyLocal reads this node's state only. It is the cheapest, and may be behind the rest of the cluster
+	ConsistencyLocal Consistency = "local"
+	// ConsistencyQuorum reads a majority of the player's replicas, so it sees any write a majority has received
+	ConsistencyQuorum Consistency = "quorum"
+	// ConsistencyAll reads every replica, and fails if any of them doesn't answer
+	ConsistencyAll Consistency = "all"
+)
+
+// ParseConsistency converts a consistency level (as given in a query parameter) into a Consistency. The empty
+// string is ConsistencyLocal
+func ParseConsistency(s string) (Consistency, error) {
+	switch c := Consistency(s); c {
+	case "":
+		return ConsistencyLocal, nil
+	case ConsistencyLocal, ConsistencyQuorum, ConsistencyAll:
+		return c, nil
+	default:
+		return "", fmt.Errorf("unknown consistency level %q", s)
+	}
+}
+
+// ErrNotEnoughReplicas is returned by a read for which fewer replicas answered than its consistency level needs
+var ErrNotEnoughReplicas = errors.New("not enough replicas answered")
+
+// replicas returns the nodes holding a player: the shard's holders while sharding is on, every alive or suspect
+// member otherwise
+func (gs *GameServer) replicas(playerId string) []string {
+	if owners := gs.ShardOwners(playerId); owners != nil {
+		return owners
+	}
+	return gs.Membership.ringMembers()
+}
+
+// GetPlayerWithConsistency returns a player's state read from as many of its replicas as the consistency level
+// needs, this node counting as one if it holds the player. The copies are combined with the store's merge
+// function, the same way gossip would combine them, so the result is at least as fresh as any copy read
+func (gs *GameServer) GetPlayerWithConsistency(ctx context.Context, playerId string, c Consistency) (PlayerState, bool, error) {
+	if c == ConsistencyLocal || c == "" {
+		p, ok := gs.GetPlayer(playerId)
+		return p, ok, nil
+	}
+
+	replicas := gs.replicas(playerId)
+	needed := len(replicas)
+	if c == ConsistencyQuorum {
+		needed = len(replicas)/2 + 1
+	}
+
+	var found []gossip.Entry
+	answered := 0
+	if slices.Contains(replicas, gs.Address) {
+		answered++
+		if e, ok := gs.State.Entries([]string{playerId})[playerId]; ok {
+			found = append(found, e)
+		}
+	}
+
+	// Ask the other replicas at once, and stop waiting as soon as enough have answered
+	if timeout := gs.gossipConfig().Timeout; timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	type answer struct {
+		entry gossip.Entry
+		found bool
+		err   error
+	}
+	answers := make(chan answer, len(replicas))
+	asked := 0
+	for _, peerAddr := range replicas {
+		if peerAddr == gs.Address {
+			continue
+		}
+		asked++
+		go func() {
+			e, ok, err := gs.readFromPeer(ctx, peerAddr, playerId)
+			answers <- answer{e, ok, err}
+		}()
+	}
+	var errs []error
+	for ; asked > 0 && answered < needed; asked-- {
+		a := <-answers
+		if a.err != nil {
+			errs = append(errs, a.err)
+			continue
+		}
+		answered++
+		if a.found {
+			found = append(found, a.entry)
+		}
+	}
+	if answered < needed {
+		return PlayerState{}, false, fmt.Errorf("%w: %d of %d needed: %w", ErrNotEnoughReplicas, answered, needed,
+			errors.Join(errs...))
+	}
+
+	if len(found) == 0 {
+		return PlayerState{}, false, nil
+	}
+	e := found[0]
+	for _, other := range found[1:] {
+		e = gs.State.Resolve(playerId, e, other)
+	}
+	var p Player
+	if e.Deleted || json.Unmarshal(e.Value, &p) != nil {
+		return PlayerState{}, false, nil
+	}
+	return newPlayerState(p, e), true, nil
+}
+
+// readFromPeer asks a peer for its entry for key, deleted or not, with a digest repair message
+func (gs *GameServer) readFromPeer(ctx context.Context, peerAddr, key string) (gossip.Entry, bool, error) {
+	msg := GossipMessage{From: gs.Address, Version: gs.State.Version(), Want: []string{key}}
+	stampProtocol(&msg, max(gs.peerProtocol(peerAddr), MinProtocolVersion))
+	reply, err := gs.Transport.SendGossip(ctx, peerAddr, msg, GossipPushPull)
+	if err == nil {
+		err = CheckProtocol(reply)
+	}
+	if err != nil {
+		return gossip.Entry{}, false, fmt.Errorf("read from %s: %w", peerAddr, err)
+	}
+	e, ok := reply.State[key]
+	return e, ok, nil
+}
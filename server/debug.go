@@ -0,0 +1,51 @@
+package server
+
+import (
+	"runtime"
+
+	"gmathur.dev/gossiper/gossip"
+)
+
+// DebugVars are the gossip statistics published on /debug/vars alongside the Go runtime's, for profiling a node
+// whose state has grown large
+type DebugVars struct {
+	ID           string            `json:"id"`
+	Players      int               `json:"players"`      // live players
+	Entries      int               `json:"entries"`      // entries in the state map, tombstones included
+	StateBytes   int               `json:"stateBytes"`   // approximate size of the live players on the wire
+	StateVersion uint64            `json:"stateVersion"` // version of the local store, see gossip.Store.Version
+	Rounds       uint64            `json:"rounds"`
+	AlivePeers   int               `json:"alivePeers"`
+	PeerLag      map[string]uint64 `json:"peerLag"` // local versions not yet pushed to each peer
+	Hints        int               `json:"hints"`   // keys waiting to be handed off to peers that are down
+	Goroutines   int               `json:"goroutines"`
+}
+
+// DebugVars reports the node's gossip statistics. It walks the whole state to size it, so it is meant for
+// occasional use while diagnosing a node, not for every scrape
+func (gs *GameServer) DebugVars() DebugVars {
+	stateBytes := 0
+	gs.State.Range(func(key string, e gossip.Entry) bool {
+		stateBytes += entrySize(key, e)
+		return true
+	})
+	v := DebugVars{
+		ID:           gs.ID,
+		Players:      gs.index.len(),
+		Entries:      gs.State.Len(),
+		StateBytes:   stateBytes,
+		StateVersion: gs.State.Version(),
+		AlivePeers:   len(gs.Membership.Peers()),
+		PeerLag:      make(map[string]uint64),
+		Hints:        gs.hints.count(""),
+		Goroutines:   runtime.NumGoroutine(),
+	}
+
+	gs.mu.Lock()
+	v.Rounds = gs.round
+	for peerAddr, sent := range gs.peerSent {
+		v.PeerLag[peerAddr] = v.StateVersion - min(sent, v.StateVersion)
+	}
+	gs.mu.Unlock()
+	return v
+}
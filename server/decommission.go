@@ -0,0 +1,141 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	mathrand "math/rand"
+	"sync"
+	"time"
+)
+
+// DecommissionTopic is the broadcast topic a decommissioned node announces its departure on, with a
+// DecommissionNotice as the data
+const DecommissionTopic = "gossiper.decommission"
+
+// ErrDecommissioning is returned by Decommission while another call is under way or has finished
+var ErrDecommissioning = errors.New("node is already being decommissioned")
+
+// DecommissionNotice is the data of the message broadcast on DecommissionTopic
+type DecommissionNotice struct {
+	Node    string `json:"node"`    // ID of the node going away
+	Address string `json:"address"` // and its address
+}
+
+// DecommissionReport is the outcome of Decommission
+type DecommissionReport struct {
+	Peers   map[string]string `json:"peers"`             // the peers handed the state, "ok" or why the handoff failed
+	Message string            `json:"message,omitempty"` // ID of the departure broadcast
+}
+
+// decommission is what the node keeps about being decommissioned
+type decommission struct {
+	mu      sync.Mutex
+	started time.Time // zero unless a Decommission is under way or done
+	done    chan struct{}
+}
+
+// Decommission is the graceful way of taking a node out of the cluster for good, such as when scaling down. It
+// marks the node as leaving, which fails its readiness check so load balancers stop sending it traffic, then hands
+// its full state to replicas random alive peers, or to every alive peer while sharding or rooms leave each peer
+// with only part of it, along with its leases and cluster settings. It fails, and the node carries on, unless
+// every one of them took the state; with force a node without alive peers is decommissioned anyway, losing
+// whatever nobody else has. It then broadcasts its departure on DecommissionTopic, tells every member it has left
+// and waits for their acks or for ctx, and closes Decommissioned so the process can exit
+func (gs *GameServer) Decommission(ctx context.Context, replicas int, force bool) (DecommissionReport, error) {
+	d := gs.decommission
+	d.mu.Lock()
+	if !d.started.IsZero() {
+		d.mu.Unlock()
+		return DecommissionReport{}, ErrDecommissioning
+	}
+	d.started = gs.State.Now()
+	d.mu.Unlock()
+	gs.Logger.Info("decommissioning", "replicas", replicas)
+
+	report, err := gs.handOff(ctx, replicas, force)
+	if err != nil {
+		gs.Logger.Error("decommission failed, staying in the cluster", "err", err)
+		d.mu.Lock()
+		d.started = time.Time{}
+		d.mu.Unlock()
+		return report, err
+	}
+
+	data, _ := json.Marshal(DecommissionNotice{Node: gs.ID, Address: gs.Address})
+	msg, err := gs.Broadcast(Message{Topic: DecommissionTopic, Data: data})
+	if err == nil {
+		report.Message = msg.Id
+		// Straight to the peers handed the state rather than at the next broadcast round, which may never come
+		for peer, result := range report.Peers {
+			if result != "ok" {
+				continue
+			}
+			if err := gs.sendRumors(ctx, peer, []Message{msg}); err != nil {
+				gs.Logger.Warn("failed to announce departure", "peer", peer, "err", err)
+			}
+		}
+	}
+	gs.Membership.Leave(ctx)
+
+	gs.Logger.Info("decommissioned", "peers", len(report.Peers))
+	close(d.done)
+	return report, nil
+}
+
+// handOff pushes the node's full state, leases and settings to the peers picked for Decommission
+func (gs *GameServer) handOff(ctx context.Context, replicas int, force bool) (DecommissionReport, error) {
+	report := DecommissionReport{Peers: make(map[string]string)}
+	peers := gs.Membership.Peers()
+	if len(peers) == 0 {
+		if force {
+			return report, nil
+		}
+		return report, errors.New("no alive peer to hand the state to")
+	}
+	mathrand.Shuffle(len(peers), func(i, j int) { peers[i], peers[j] = peers[j], peers[i] })
+	partial := gs.sharded()
+	for _, peer := range peers {
+		partial = partial || gs.roomsFiltered(peer)
+	}
+	if !partial {
+		peers = peers[:min(max(replicas, 1), len(peers))]
+	}
+
+	failed := 0
+	for _, peer := range peers {
+		err := gs.SyncWithPeer(ctx, peer)
+		for _, p := range []*storeGossip{gs.leasePeers, gs.settingsPeers} {
+			if err != nil {
+				break
+			}
+			if delta, _ := p.store.Delta(0); len(delta) > 0 {
+				err = gs.sendStore(ctx, peer, p.kind, delta)
+			}
+		}
+		report.Peers[peer] = "ok"
+		if err != nil {
+			report.Peers[peer] = err.Error()
+			failed++
+		}
+	}
+	if failed > 0 {
+		return report, fmt.Errorf("failed to hand the state to %d of %d peers", failed, len(peers))
+	}
+	return report, nil
+}
+
+// Decommissioning reports whether the node is being decommissioned, or has been, and since when
+func (gs *GameServer) Decommissioning() (time.Time, bool) {
+	d := gs.decommission
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.started, !d.started.IsZero()
+}
+
+// Decommissioned is closed once Decommission has handed off the node's state and left the cluster, when the
+// process should shut down
+func (gs *GameServer) Decommissioned() <-chan struct{} {
+	return gs.decommission.done
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"context"
+	"fmt"
+
+	"gmathur.dev/gossiper/gossip"
+)
+
+// digestBuckets is the number of buckets in the digests this node sends. More buckets mean fewer keys compared
+// one by one for each difference, at the cost of a bigger digest
+const digestBuckets = 256
+
+// DigestMessage opens a digest reconciliation: the sender's digest of its whole state
+type DigestMessage struct {
+	From   string        `json:"from"`
+	Digest gossip.Digest `json:"digest"`
+}
+
+// DigestReply holds the buckets whose digest differs on the receiver, along with the receiver's hash of every
+// entry in those buckets so the sender can work out exactly which keys differ
+type DigestReply struct {
+	Differ []int             `json:"differ,omitempty"`
+	Keys   map[string]uint64 `json:"keys,omitempty"`
+}
+
+// DigestTransport is implemented by transports that can carry digest exchanges. With DigestSync set, a full sync
+// over such a transport compares digests instead of sending the whole map
+type DigestTransport interface {
+	SendDigest(ctx context.Context, peerAddr string, msg DigestMessage) (DigestReply, error)
+}
+
+// ReceiveDigest compares a peer's digest against the local state
+func (gs *GameServer) ReceiveDigest(msg DigestMessage) (DigestReply, error) {
+	buckets := len(msg.Digest)
+	if buckets == 0 || buckets > gossip.MaxDigestBuckets {
+		return DigestReply{}, fmt.Errorf("digest of %d buckets out of range", buckets)
+	}
+
+	local, _ := gs.State.Digest(buckets)
+	differ := local.Diff(msg.Digest)
+	if len(differ) == 0 {
+		return DigestReply{}, nil
+	}
+	return DigestReply{Differ: differ, Keys: gs.State.KeyHashes(buckets, differ)}, nil
+}
+
+// digestSyncWithPeer reconciles with a peer by comparing digests, then exchanging only the entries that differ:
+// ours are pushed, and theirs are asked for in the same message. When the maps are mostly identical this costs a
+// digest and a handful of entries rather than both complete maps. Differences too big for one message under the
+// size limit are exchanged over several
+func (gs *GameServer) digestSyncWithPeer(ctx context.Context, peerAddr string, transport DigestTransport, round uint64) error {
+	digest, version := gs.State.Digest(digestBuckets)
+	reply, err := transport.SendDigest(ctx, peerAddr, DigestMessage{From: gs.Address, Digest: digest})
+	if err != nil {
+		return err
+	}
+
+	send, want := gs.State.Reconcile(digestBuckets, reply.Differ, reply.Keys)
+	pending, wanted := gs.State.Entries(send), want
+	for len(pending) > 0 || len(wanted) > 0 {
+		// Half the message is kept for the keys asked for, the rest filled with entries
+		budget := gs.entryBudget()
+		asked := takeKeys(&wanted, budget/2)
+		for _, key := range asked {
+			budget -= len(key) + 4
+		}
+		msg := GossipMessage{From: gs.Address, Version: version, State: takeEntries(pending, budget), Want: asked}
+		mode := GossipPush
+		if len(asked) > 0 {
+			mode = GossipPushPull
+		}
+		repaired, err := gs.Transport.SendGossip(ctx, peerAddr, msg, mode)
+		if err != nil {
+			return err
+		}
+		gs.MergeState(repaired.State)
+
+		// A reply cut short by the peer's size limit is asked for again without what it did carry
+		if repaired.More && len(repaired.State) > 0 {
+			for _, key := range asked {
+				if _, ok := repaired.State[key]; !ok {
+					wanted = append(wanted, key)
+				}
+			}
+		}
+	}
+	gs.Metrics.DigestRepairs.With("sent").Add(float64(len(send)))
+	gs.Metrics.DigestRepairs.With("received").Add(float64(len(want)))
+	gs.Logger.Debug("reconciled digests with peer", "peer", peerAddr, "round", round,
+		"buckets", len(reply.Differ), "sent", len(send), "wanted", len(want))
+
+	// Everything we had when the digest was taken is now on the peer
+	gs.mu.Lock()
+	gs.peerSent[peerAddr] = max(gs.peerSent[peerAddr], version)
+	gs.mu.Unlock()
+	return nil
+}
+
+// takeKeys removes keys from the front of *keys until they add up to about maxBytes, and returns them. At least
+// one is taken if there are any; nil is returned if there are none
+func takeKeys(keys *[]string, maxBytes int) []string {
+	n, size := 0, 0
+	for n < len(*keys) {
+		size += len((*keys)[n]) + 4
+		if size > maxBytes && n > 0 {
+			break
+		}
+		n++
+	}
+	if n == 0 {
+		return nil
+	}
+	taken := (*keys)[:n:n]
+	*keys = (*keys)[n:]
+	return taken
+}
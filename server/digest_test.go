@@ -0,0 +1,60 @@
+package server_test
+
+import (
+	"slices"
+	"testing"
+
+	"gmathur.dev/gossiper/gossip"
+	"gmathur.dev/gossiper/server"
+)
+
+func TestDigestReconcile(t *testing.T) {
+	tests := []struct {
+		name       string
+		change     func(a, b *gossip.Store)
+		send, want []string // by a, on comparing its digest with b's
+	}{
+		{"identical", func(a, b *gossip.Store) {}, nil, nil},
+		{"only on a", func(a, b *gossip.Store) { a.Set("carol", []byte("3"), 0) }, []string{"carol"}, nil},
+		{"only on b", func(a, b *gossip.Store) { b.Set("carol", []byte("3"), 0) }, nil, []string{"carol"}},
+		{"newer on a", func(a, b *gossip.Store) { a.Set("alice", []byte("10"), 0) }, []string{"alice"}, []string{"alice"}},
+		{"deleted on b", func(a, b *gossip.Store) { b.Delete("bob") }, []string{"bob"}, []string{"bob"}},
+		{"both sides", func(a, b *gossip.Store) {
+			a.Set("carol", []byte("3"), 0)
+			b.Set("dave", []byte("4"), 0)
+		}, []string{"carol"}, []string{"dave"}},
+	}
+	for _, tt := range tests {
+		a := server.NewGameServer("a", "localhost:7001", nil)
+		b := server.NewGameServer("b", "localhost:7002", nil)
+		a.State.Set("alice", []byte("1"), 0)
+		a.State.Set("bob", []byte("2"), 0)
+		delta, _ := a.State.Delta(0)
+		b.State.Merge(delta)
+		tt.change(a.State, b.State)
+
+		digest, _ := a.State.Digest(64)
+		reply, err := b.ReceiveDigest(server.DigestMessage{From: a.Address, Digest: digest})
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if len(tt.send) == 0 && len(tt.want) == 0 && len(reply.Differ) > 0 {
+			t.Errorf("%s: buckets %v differ", tt.name, reply.Differ)
+		}
+		send, want := a.State.Reconcile(64, reply.Differ, reply.Keys)
+		slices.Sort(send)
+		slices.Sort(want)
+		if !slices.Equal(send, tt.send) || !slices.Equal(want, tt.want) {
+			t.Errorf("%s: got send %v want %v, expected send %v want %v", tt.name, send, want, tt.send, tt.want)
+		}
+	}
+}
+
+func TestReceiveDigestBounds(t *testing.T) {
+	gs := server.NewGameServer("a", "localhost:7001", nil)
+	for _, buckets := range []int{0, gossip.MaxDigestBuckets + 1} {
+		if _, err := gs.ReceiveDigest(server.DigestMessage{Digest: make(gossip.Digest, buckets)}); err == nil {
+			t.Errorf("accepted a digest of %d buckets", buckets)
+		}
+	}
+}
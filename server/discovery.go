@@ -0,0 +1,173 @@
+package server
+
+import (
+	"context"
+	"slices"
+	"time"
+)
+
+// Discoverer finds the cluster's nodes in an external registry, such as DNS or a cloud provider's API, so that
+// peers don't have to be listed up front
+type Discoverer interface {
+	// Discover returns the host:port address of every node currently registered, this one included if it is
+	Discover(ctx context.Context) ([]string, error)
+}
+
+// Advertiser is implemented by discovery providers that nodes register themselves with, such as mDNS, rather than
+// an external registry that lists them. Discovery runs Advertise alongside the polling loop
+type Advertiser interface {
+	// Advertise makes the node with this ID and address discoverable until ctx is done
+	Advertise(ctx context.Context, id, addr string) error
+}
+
+// DiscoveryConfig enables polling a Discoverer for peers. Nodes that appear are added to the membership list and
+// nodes that disappear are removed, as if /join and /leave had been called for them
+type DiscoveryConfig struct {
+	Provider Discoverer    // discovery is disabled when nil
+	Interval time.Duration // how often Provider is polled; DefaultDiscoveryInterval if not positive
+}
+
+// DefaultDiscoveryInterval is how often the provider is polled when DiscoveryConfig.Interval isn't set
+const DefaultDiscoveryInterval = 30 * time.Second
+
+// maxJoinBackoff caps the wait between attempts to reach the seeds
+const maxJoinBackoff = 30 * time.Second
+
+// joinLoop fetches the membership list from the first seed that answers, retrying with backoff until one does.
+// After that the node keeps its view up to date like any other member, from the lists piggybacked on probes
+func (gs *GameServer) joinLoop(ctx context.Context) {
+	// A seed is a member of the cluster by definition: it is ready without reaching the other seeds, which may
+	// not have started yet, and stops trying once some other node has joined through it
+	isSeed := slices.ContainsFunc(gs.Seeds, gs.Membership.Registry.IsSelf)
+	if isSeed {
+		gs.mu.Lock()
+		gs.joined = true
+		gs.mu.Unlock()
+		if len(gs.Seeds) == 1 {
+			return
+		}
+	}
+
+	backoff := gs.Membership.ProbeInterval
+	for {
+		if gs.joinSeeds(ctx) || isSeed && len(gs.Membership.Peers()) > 0 {
+			return
+		}
+		gs.Logger.Warn("no seed reachable, retrying", "seeds", gs.Seeds, "in", backoff)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, maxJoinBackoff)
+	}
+}
+
+// fetchMembers asks the member at addr for its membership list and merges it, so a node that only knows one
+// member learns the whole cluster at once rather than over several probes. It returns the size of the list
+func (gs *GameServer) fetchMembers(ctx context.Context, addr string) (int, error) {
+	members, err := gs.peerTransport().FetchMembers(ctx, addr)
+	if err != nil {
+		return 0, err
+	}
+	gs.Membership.Merge(members)
+	return len(members), nil
+}
+
+func (gs *GameServer) joinSeeds(ctx context.Context) bool {
+	for _, seed := range gs.Seeds {
+		if gs.Membership.Registry.IsSelf(seed) {
+			continue
+		}
+		n, err := gs.fetchMembers(ctx, seed)
+		if err != nil {
+			gs.Logger.Debug("failed to fetch members from seed", "seed", seed, "err", err)
+			continue
+		}
+		// Ping the seed straight away so that it, and through it the rest of the cluster, learns about us
+		// without waiting for it to be picked by the failure detector
+		if _, err := gs.Membership.ping(ctx, seed); err != nil {
+			gs.Logger.Debug("failed to ping seed", "seed", seed, "err", err)
+		}
+
+		gs.mu.Lock()
+		gs.joined = true
+		gs.mu.Unlock()
+		gs.Logger.Info("joined cluster", "seed", seed, "members", n)
+		return true
+	}
+	return false
+}
+
+// discoveryLoop polls the discovery provider every interval, adding nodes that appear to the membership list and
+// removing nodes that disappear. Only nodes it added itself are ever removed, so peers given by Peers or Seeds, or
+// learnt from other members, are left alone
+func (gs *GameServer) discoveryLoop(ctx context.Context) {
+	interval := gs.Discovery.Interval
+	if interval <= 0 {
+		interval = DefaultDiscoveryInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	discovered := make(map[string]bool)
+	for {
+		gs.discover(ctx, discovered)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// advertiseLoop keeps the node advertised through the discovery provider, restarting the advertiser with backoff
+// if it fails, e.g. because the network interface went away
+func (gs *GameServer) advertiseLoop(ctx context.Context) {
+	advertiser := gs.Discovery.Provider.(Advertiser)
+	backoff := time.Second
+	for {
+		err := advertiser.Advertise(ctx, gs.ID, gs.Address)
+		if ctx.Err() != nil {
+			return
+		}
+		gs.Logger.Warn("advertising for discovery failed, retrying", "err", err, "in", backoff)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, maxJoinBackoff)
+	}
+}
+
+func (gs *GameServer) discover(ctx context.Context, discovered map[string]bool) {
+	addrs, err := gs.Discovery.Provider.Discover(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			gs.Logger.Warn("peer discovery failed", "err", err)
+		}
+		return
+	}
+
+	current := make(map[string]bool, len(addrs))
+	for _, addr := range addrs {
+		addr = CanonicalAddr(addr)
+		if gs.Membership.Registry.IsSelf(addr) {
+			continue
+		}
+		current[addr] = true
+		if !discovered[addr] {
+			gs.Logger.Info("discovered peer", "peer", addr)
+			gs.Membership.Add(addr)
+			discovered[addr] = true
+		}
+	}
+	for addr := range discovered {
+		if !current[addr] {
+			gs.Logger.Info("discovered peer is gone", "peer", addr)
+			gs.RemovePeer(addr)
+			delete(discovered, addr)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"gmathur.dev/gossiper/gossip"
+)
+
+// ChangeType says where a change to a player came from
+type ChangeType string
+
+const (
+	ChangeLocal  ChangeType = "local"  // made on this node: an update, a deletion or an expiry
+	ChangeRemote ChangeType = "remote" // merged from a peer's gossip
+)
+
+// PlayerChange is passed to Subscribe callbacks
+type PlayerChange struct {
+	PlayerId string
+	Type     ChangeType
+	Old      *PlayerState // nil if the player didn't exist or was deleted
+	New      *PlayerState // nil if the player was deleted or expired
+}
+
+// eventBus delivers player changes to Subscribe callbacks. Changes are queued from the store's change feed,
+// which runs with the store locked, and delivered in order from a goroutine run by Start, so callbacks are free to
+// call back into the GameServer
+type eventBus struct {
+	mu     sync.Mutex
+	subs   []subscriber
+	nextId uint64
+	queue  []PlayerChange
+	wake   chan struct{}
+}
+
+type subscriber struct {
+	id uint64
+	fn func(PlayerChange)
+}
+
+func newEventBus() *eventBus {
+	return &eventBus{wake: make(chan struct{}, 1)}
+}
+
+// Subscribe calls fn for every change made to a player, by UpdatePlayerScore, DeletePlayer and expiry on this node
+// or by MergeState with a peer's state. Calls are made one at a time, in the order the changes happened, from a
+// goroutine run by Start; a slow callback delays the ones after it but never the node itself. Call the returned
+// function to unsubscribe; a change already being delivered may still reach fn
+func (gs *GameServer) Subscribe(fn func(PlayerChange)) (unsubscribe func()) {
+	b := gs.events
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.nextId++
+	id := b.nextId
+	b.subs = append(b.subs, subscriber{id: id, fn: fn})
+
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		for i, sub := range b.subs {
+			if sub.id == id {
+				b.subs = append(b.subs[:i:i], b.subs[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// observe is a gossip.Store OnChange observer
+func (b *eventBus) observe(c gossip.Change) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.subs) == 0 || c.Source == gossip.ChangeDropped {
+		return
+	}
+
+	change := PlayerChange{PlayerId: c.Key, Type: ChangeLocal, New: playerStateOf(c.New)}
+	if c.Source == gossip.ChangeRemote {
+		change.Type = ChangeRemote
+	}
+	if c.Existed {
+		change.Old = playerStateOf(c.Old)
+	}
+	if change.Old == nil && change.New == nil {
+		// A tombstone replacing a tombstone
+		return
+	}
+
+	b.queue = append(b.queue, change)
+	select {
+	case b.wake <- struct{}{}:
+	default:
+	}
+}
+
+// playerStateOf decodes a live entry, returning nil for tombstones
+func playerStateOf(e gossip.Entry) *PlayerState {
+	var p Player
+	if e.Deleted || json.Unmarshal(e.Value, &p) != nil {
+		return nil
+	}
+	state := newPlayerState(p, e)
+	return &state
+}
+
+// deliverLoop runs Subscribe callbacks until ctx is done
+func (gs *GameServer) deliverLoop(ctx context.Context) {
+	b := gs.events
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-b.wake:
+		}
+
+		b.mu.Lock()
+		queue, subs := b.queue, b.subs
+		b.queue = nil
+		b.mu.Unlock()
+
+		for _, change := range queue {
+			for _, sub := range subs {
+				sub.fn(change)
+			}
+		}
+	}
+}
@@ -0,0 +1,571 @@
+// Package server is a gossip node that replicates player scores: a GameServer keeps its state in a gossip.Store,
+// gossips it to its peers, detects failed peers and persists its state. Pair it with transport.NewServer to serve
+// its HTTP API, or drive it from your own transport
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"iter"
+	"log/slog"
+	"maps"
+	"sync"
+	"time"
+
+	"gmathur.dev/gossiper/crdt"
+	"gmathur.dev/gossiper/gossip"
+	"gmathur.dev/gossiper/publish"
+	"gmathur.dev/gossiper/tracing"
+)
+
+// PlayerState represents the state of a player in the game. It is the API view of a player's entry in the
+// replicated store that game servers sync via gossip
+type PlayerState struct {
+	Score     int64  `json:"score"`
+	Timestamp int64  `json:"timestamp"`     // wall-clock time of the update, informational only
+	Clock     uint64 `json:"clock"`         // hybrid logical clock timestamp used to order updates
+	Origin    string `json:"origin"`        // ID of the game server that made the update, breaks clock ties
+	TTL       int64  `json:"ttl,omitempty"` // seconds after Clock at which the player expires, 0 never
+
+	Attributes map[string]json.RawMessage `json:"attributes,omitempty"` // see Attribute
+	Priority   gossip.Priority            `json:"priority,omitzero"`    // see gossip.Priority
+}
+
+// Player is the value stored for every player in the replicated store
+type Player struct {
+	Score      int64                `json:"score"`                // the score last set plus Increments
+	ScoreStamp *crdt.Stamp          `json:"scoreStamp,omitempty"` // when the score was set, if before the entry's last write
+	Increments crdt.PNCounter       `json:"increments,omitzero"`  // made since the score was set, see IncrementPlayerScore
+	Attributes map[string]Attribute `json:"attributes,omitempty"`
+}
+
+func newPlayerState(p Player, e gossip.Entry) PlayerState {
+	return PlayerState{Score: p.Score, Timestamp: e.Timestamp, Clock: e.Clock, Origin: e.Origin, TTL: e.TTL,
+		Attributes: liveAttributes(p.Attributes), Priority: e.Priority}
+}
+
+// GameServer is a gossip node that syncs player scores. The replicated state, conflict resolution, expiry and
+// persistence live in State, a generic gossip.Store; GameServer adds the game-specific API on top and moves
+// state between nodes
+type GameServer struct {
+	ID             string               // unique ID of the game server
+	Address        string               // address of the game server. host:port format
+	ListenAddress  string               // address the node listens on, if peers reach it on Address some other way
+	Peers          []string             // seed list of peer addresses, used to bootstrap Membership
+	Seeds          []string             // nodes to fetch the membership list from on Start, see joinLoop
+	Discovery      DiscoveryConfig      // optional registry polled for peers
+	Membership     *Membership          // live view of which peers are alive, suspect or dead
+	State          *gossip.Store        // replicated player state, keyed by player ID
+	Players        gossip.Typed[Player] // typed view of State
+	Mode           GossipMode           // how state is exchanged with peers each round
+	Gossip         GossipConfig         // round interval, fanout and payload caps; use SetGossipConfig once started
+	FullSyncEvery  int                  // every Nth round to a peer sends the full map instead of a delta
+	DigestSync     bool                 // full syncs compare digests and exchange only differing entries
+	AntiEntropy    AntiEntropyConfig    // periodic complete reconciliation with one random peer
+	OnPartition    func(PartitionEvent) // called when the node loses or regains a majority of the cluster
+	Breaker        BreakerConfig        // per-peer circuit breakers that leave failing peers out of rounds
+	Selector       PeerSelector         // which peers each round goes to, least recently picked first by default
+	Zones          ZoneConfig           // keeps most gossip within the node's zone, see ZoneConfig
+	Hints          HintConfig           // hinted handoff of local writes to peers that are down, see HintConfig
+	Shards         ShardConfig          // optional partitioning of the state across the cluster, see ShardConfig
+	Presence       PresenceConfig       // expiry and gossip of the players' sessions, see Connect
+	Broadcasts     BroadcastConfig      // spreading of broadcast messages, see Broadcast
+	Election       ElectionConfig       // how the node follows the cluster's leader, see Leader
+	Leases         LeaseConfig          // limits and gossip of leases, see AcquireLease
+	SettingsGossip SettingsConfig       // gossip of cluster-wide settings, see SetSetting
+	Bootstrap      BootstrapConfig      // pull of a peer's state on Start, see Bootstrapped
+	ClockSkew      ClockConfig          // detection and correction of skew between the nodes' clocks, see Clocks
+	Idempotency    IdempotencyConfig    // deduplication of retried client requests, see Idempotent
+	Rooms          []string             // rooms whose players this node holds from Start, besides those outside any room; empty holds every room, see SetRooms
+	Transport      GossipTransport      // how gossip reaches peers, see WithTransport; a Transport carries all peer traffic and receives it too
+	Keyring        *Keyring             // when set, peer traffic is signed with the cluster key and checked against it
+	Compression    Compression          // encodings peer requests and responses may be compressed with
+	Webhooks       WebhookSender        // sends the requests of the webhooks added with AddWebhook
+	Metrics        *Metrics             // Prometheus metrics, served by the transport on /metrics
+	Tracer         *tracing.Tracer      // records spans of gossip rounds and exchanges when set
+	Chaos          *Chaos               // faults injected into peer traffic for testing, see EnableChaos
+	Snapshots      SnapshotConfig       // optional periodic snapshots, see LoadSnapshot
+	ChangeLog      ChangeLog            // optional log of every change accepted, see ReadChanges; set before Start
+	Publisher      publish.Publisher    // optional broker local changes are published to, see PublishedChange
+	Publishing     PublishConfig        // batching of changes for Publisher
+	Logger         *slog.Logger         // tagged with the node ID; replace before Start to change handler or level
+	TombstoneTTL   time.Duration        // how long deleted players are remembered, see DeletePlayer
+	EntryTTL       time.Duration        // default expiry for updates that don't set one, 0 never expires
+
+	mu       sync.Mutex
+	gossipMu sync.RWMutex // guards Gossip and gossipOverrides, see SetGossipConfig
+
+	gossipOverrides GossipConfig // fields set by cluster-wide settings, which override Gossip's, see SetSetting
+	round           uint64       // gossip rounds run so far, for log context
+	pacing          *gossipPacing
+	failures        *peerFailureLog
+	drops           *peerFailureLog // messages from peers that were dropped, see DroppedMessage
+	breakers        *circuitBreakers
+	loops           sync.WaitGroup // background loops started by Start
+
+	// Per-peer delta watermarks, in terms of State versions
+	peerSent   map[string]uint64    // highest local version successfully pushed to each peer
+	peerSeen   map[string]uint64    // highest version of each peer's state we have received
+	peerRounds map[string]int       // number of rounds gossiped with each peer, to schedule full syncs
+	peerPicked map[string]time.Time // last time a round picked each peer, for Selector
+	peerRooms  map[string][]string  // rooms each peer advertised at its last successful exchange, see SetRooms
+
+	// Entries sent to each peer above its watermark, by clock, while rounds are over the bandwidth budget, see
+	// holdToBudget
+	peerAhead map[string]map[string]uint64
+	bandwidth bandwidthBudget
+
+	// Gossip loop status, for health checks
+	startedAt  time.Time
+	lastTick   time.Time            // last time the gossip loop ran, whether or not it had anyone to gossip with
+	peerSynced map[string]time.Time // last successful gossip exchange with each peer
+	peerFailed map[string]int       // failed gossip exchanges with each peer
+	joined     bool                 // the membership list has been fetched from one of Seeds
+
+	lastAntiEntropy time.Time      // last successful anti-entropy sync with any peer
+	partition       partitionState // set while the node can't reach a majority of the cluster
+	ring            *shardRing     // placement of the shards while sharding is on, see shardRing
+
+	index     *playerIndex  // players by score and by ID, fed by State's change feed
+	hints     *hintLog      // keys waiting for peers that were down when they were written
+	watchers  *watchHub     // subscribers to State's change feed, see Watch
+	events    *eventBus     // callbacks for State's change feed, see Subscribe
+	published *publishQueue // changes waiting for Publisher
+	changes   *changeQueue  // changes waiting to be appended to ChangeLog
+	webhooks  []*webhook    // see AddWebhook
+
+	sessions      *gossip.Store // which node hosts each player's session, see Connect
+	presencePeers *storeGossip  // pushes of sessions to each peer
+	rumors        *rumorMill    // broadcast messages heard of and being spread, see Broadcast
+	election      *election     // the leader as this node sees it, see Leader
+	leases        *gossip.Store // leases by name, see AcquireLease
+	leasePeers    *storeGossip  // pushes of leases to each peer
+	settings      *gossip.Store // cluster-wide settings by key, see SetSetting
+	settingsPeers *storeGossip  // pushes of settings to each peer
+	settingsWatch *settingsWatch
+	decommission  *decommission
+	bootstrapped  chan struct{} // closed once the state has been pulled from a peer, see Bootstrapped
+	clocks        *clockTracker // skew of the peers' clocks from ours, see Clocks
+
+	idempotency      *gossip.Store // responses to requests made with an idempotency key, see Idempotent
+	idempotencyPeers *storeGossip  // pushes of responses to each peer
+	idempotencyLocks *idempotencyLocks
+}
+
+// NewGameServer creates a node with the given ID, serving at addr, that bootstraps its membership from peers.
+// Options override the defaults
+func NewGameServer(id, addr string, peers []string, opts ...Option) *GameServer {
+	o := options{gossip: DefaultGossipConfig(), logger: slog.Default().With("node", id)}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	logger := o.logger
+	// The node and its peers go by their canonical addresses from here on, see PeerRegistry
+	membership := NewMembership(addr, peers, logger)
+	addr = membership.Self()
+	state := o.store
+	if state == nil {
+		state = gossip.NewStore(id, logger)
+	}
+	sessions := gossip.NewStore(id, logger)
+	leases := gossip.NewStore(id, logger)
+	settings := gossip.NewStore(id, logger)
+	idempotency := gossip.NewStore(id, logger)
+	if o.now != nil {
+		state.Now = o.now
+		sessions.Now = o.now
+		leases.Now = o.now
+		settings.Now = o.now
+		idempotency.Now = o.now
+	}
+	// Writes are stamped with the wall clock corrected towards the cluster's, see ClockConfig.Correct
+	clocks := newClockTracker()
+	for _, s := range []*gossip.Store{state, sessions, leases, settings, idempotency} {
+		s.Now = clocks.now(s.Now)
+	}
+	gs := &GameServer{
+		ID:             id,
+		Address:        addr,
+		Peers:          membership.Registry.Peers(peers),
+		Membership:     membership,
+		State:          state,
+		Players:        gossip.NewTyped[Player](state),
+		Mode:           GossipPush,
+		Gossip:         o.gossip,
+		FullSyncEvery:  10,
+		Compression:    DefaultCompression(),
+		Logger:         logger,
+		TombstoneTTL:   time.Hour,
+		Discovery:      DiscoveryConfig{Interval: 30 * time.Second},
+		Breaker:        DefaultBreakerConfig(),
+		AntiEntropy:    DefaultAntiEntropyConfig(),
+		Selector:       LeastRecentSelector{},
+		Zones:          DefaultZoneConfig(),
+		Shards:         DefaultShardConfig(),
+		Hints:          DefaultHintConfig(),
+		Presence:       DefaultPresenceConfig(),
+		Broadcasts:     DefaultBroadcastConfig(),
+		Election:       DefaultElectionConfig(),
+		Leases:         DefaultLeaseConfig(),
+		SettingsGossip: DefaultSettingsConfig(),
+		Idempotency:    DefaultIdempotencyConfig(),
+		Bootstrap:      DefaultBootstrapConfig(),
+		ClockSkew:      DefaultClockConfig(),
+		Publishing:     DefaultPublishConfig(),
+		failures:       newPeerFailureLog("gossip with peer failed", 30*time.Second),
+		drops:          newPeerFailureLog("dropping peer message", 30*time.Second),
+		breakers:       newCircuitBreakers(),
+		peerSent:       make(map[string]uint64),
+		peerSeen:       make(map[string]uint64),
+		peerRooms:      make(map[string][]string),
+		peerAhead:      make(map[string]map[string]uint64),
+		peerRounds:     make(map[string]int),
+		peerPicked:     make(map[string]time.Time),
+		peerSynced:     make(map[string]time.Time),
+		peerFailed:     make(map[string]int),
+		index:          newPlayerIndex(),
+		hints:          newHintLog(),
+		watchers:       newWatchHub(),
+		events:         newEventBus(),
+		published:      newPublishQueue(),
+		changes:        newChangeQueue(),
+		sessions:       sessions,
+		presencePeers:  newStoreGossip("sessions", StoreSessions, sessions),
+		rumors:         newRumorMill(),
+		election:       &election{},
+		leases:         leases,
+		leasePeers:     newStoreGossip("leases", StoreLeases, leases),
+		settings:       settings,
+		settingsPeers:  newStoreGossip("settings", StoreSettings, settings),
+		settingsWatch:  newSettingsWatch(),
+		decommission:   &decommission{done: make(chan struct{})},
+		bootstrapped:   make(chan struct{}),
+		clocks:         clocks,
+		pacing:         newGossipPacing(),
+
+		idempotency:      idempotency,
+		idempotencyPeers: newStoreGossip("idempotent responses", StoreIdempotency, idempotency),
+		idempotencyLocks: &idempotencyLocks{inFlight: make(map[string]bool)},
+	}
+	gs.Membership.OnRetire = gs.forgetPeer
+	gs.Membership.OnClockSample = gs.observeClock
+	state.SetMergeFunc("", PlayerLastWriteWins)
+	state.OnChange(gs.index.observe)
+	state.OnChange(gs.watchers.observe)
+	settings.OnChange(gs.settingsWatch.observe)
+	state.OnChange(gs.hints.observe)
+	state.OnChange(gs.events.observe)
+	state.OnChange(gs.logChange)
+	state.OnChange(gs.queueChange)
+	state.OnChange(gs.queueWebhooks)
+	state.OnChange(gs.pacing.observe)
+	gs.Metrics = newMetrics(gs)
+	for _, s := range gs.stores() {
+		s.Clock.OnClamp = func(uint64) { gs.Metrics.ClockClamped.With().Inc() }
+	}
+	gs.Transport = o.transport
+	if gs.Transport == nil {
+		gs.Transport = noTransport{}
+	}
+	gs.bindTransport()
+	gs.Membership.Probes = noTransport{}
+	if probes, ok := gs.Transport.(ProbeTransport); ok {
+		gs.Membership.Probes = probes
+	}
+	return gs
+}
+
+// Start runs the node's background loops (gossip, failure detection, snapshots, expiry) until ctx is done
+func (gs *GameServer) Start(ctx context.Context) {
+	gs.mu.Lock()
+	gs.startedAt = time.Now()
+	gs.lastTick = gs.startedAt
+	gs.mu.Unlock()
+
+	// The probe loop, hints and partition detection all tick at the probe interval
+	if gs.Membership.ProbeInterval <= 0 {
+		gs.Membership.ProbeInterval = DefaultProbeInterval
+	}
+	if gs.ListenAddress != "" {
+		gs.Membership.listenOn(gs.ListenAddress)
+	}
+	for _, s := range gs.stores() {
+		s.Clock.MaxDrift = gs.ClockSkew.MaxDrift
+	}
+	gs.bindTransport()
+	if probes, ok := gs.Transport.(ProbeTransport); ok {
+		gs.Membership.Probes = probes
+	}
+	gs.goLoop(ctx, gs.serveTransport)
+
+	if len(gs.Seeds) > 0 {
+		gs.goLoop(ctx, gs.joinLoop)
+	}
+	if gs.Bootstrap.Timeout > 0 {
+		gs.goLoop(ctx, gs.bootstrapLoop)
+	} else {
+		close(gs.bootstrapped)
+	}
+	if gs.Discovery.Provider != nil {
+		gs.goLoop(ctx, gs.discoveryLoop)
+		if _, ok := gs.Discovery.Provider.(Advertiser); ok {
+			gs.goLoop(ctx, gs.advertiseLoop)
+		}
+	}
+	gs.goLoop(ctx, gs.gossipLoop)
+	gs.goLoop(ctx, gs.Membership.probeLoop)
+	gs.goLoop(ctx, gs.electionLoop)
+	gs.goLoop(ctx, gs.partitionLoop)
+	if gs.Hints.MaxPerPeer > 0 {
+		gs.goLoop(ctx, gs.hintLoop)
+	}
+	if gs.AntiEntropy.Interval > 0 {
+		if _, ok := gs.Transport.(SyncTransport); ok {
+			gs.goLoop(ctx, gs.antiEntropyLoop)
+		} else {
+			gs.Logger.Warn("transport does not support anti-entropy syncs, leaving anti-entropy off")
+		}
+	}
+	// Peers gossip this node only the rooms it advertises
+	gs.Membership.setRooms(sortedRooms(gs.Rooms))
+	if gs.sharded() {
+		// Places the shards, so the ones this node holds are advertised from the first probe
+		gs.shardRing()
+		gs.goLoop(ctx, gs.shardGCLoop)
+	}
+	if gs.Snapshots.Path != "" {
+		gs.goLoop(ctx, gs.snapshotLoop)
+	}
+	if gs.TombstoneTTL > 0 {
+		gs.goLoop(ctx, gs.tombstoneGCLoop)
+	}
+	gs.goLoop(ctx, gs.expiryLoop)
+	if gs.Presence.Interval > 0 {
+		gs.goLoop(ctx, gs.presenceLoop)
+	}
+	if gs.SettingsGossip.Interval > 0 {
+		gs.goLoop(ctx, gs.settingsLoop)
+	}
+	gs.goLoop(ctx, gs.applySettingsLoop)
+	if gs.Leases.Interval > 0 {
+		gs.goLoop(ctx, gs.leaseLoop)
+	}
+	if gs.Idempotency.Window > 0 && gs.Idempotency.Interval > 0 {
+		gs.goLoop(ctx, gs.idempotencyLoop)
+	}
+	if gs.Broadcasts.Interval > 0 {
+		gs.goLoop(ctx, gs.broadcastLoop)
+	}
+	gs.goLoop(ctx, gs.closeWatchersOnDone)
+	gs.goLoop(ctx, gs.deliverLoop)
+	gs.goLoop(ctx, gs.deliverBroadcasts)
+	if gs.Publisher != nil {
+		gs.goLoop(ctx, gs.publishLoop)
+	}
+	if gs.ChangeLog != nil {
+		gs.goLoop(ctx, gs.changeLogLoop)
+	}
+	for _, w := range gs.webhooks {
+		gs.goLoop(ctx, func(ctx context.Context) { gs.webhookLoop(ctx, w) })
+	}
+}
+
+func (gs *GameServer) goLoop(ctx context.Context, loop func(context.Context)) {
+	gs.loops.Add(1)
+	go func() {
+		defer gs.loops.Done()
+		loop(ctx)
+	}()
+}
+
+// Leave tells the rest of the cluster that this node is going away, see Membership.Leave
+func (gs *GameServer) Leave(ctx context.Context) {
+	gs.Membership.Leave(ctx)
+}
+
+// Close waits for the background loops to return, which they do once the context given to Start is done, then
+// takes a final snapshot, if snapshots are enabled, and closes the store's backend. Stop serving requests first
+// so that no change is lost after the flush
+func (gs *GameServer) Close() error {
+	gs.loops.Wait()
+
+	var errs []error
+	if gs.Snapshots.Path != "" {
+		if err := gs.State.SaveSnapshot(gs.Snapshots.Path, gs.Snapshots.Format); err != nil {
+			errs = append(errs, fmt.Errorf("failed to save final snapshot: %w", err))
+		}
+	}
+	if err := gs.State.Close(); err != nil {
+		errs = append(errs, fmt.Errorf("failed to close store: %w", err))
+	}
+	if gs.ChangeLog != nil {
+		// Changes made after the loop stopped, or on a node never started
+		gs.appendChanges()
+		if err := gs.ChangeLog.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to close change log: %w", err))
+		}
+	}
+	if gs.Publisher != nil {
+		if err := gs.Publisher.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to close publisher: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// AddPeer adds a peer to a running node. The peer learns about the rest of the cluster from the membership
+// list piggybacked on the next probe it receives
+func (gs *GameServer) AddPeer(addr string) {
+	gs.Membership.Add(addr)
+	gs.Logger.Info("added peer", "peer", addr)
+}
+
+// RemovePeer removes a peer from a running node. The removal spreads to the rest of the cluster via probes
+func (gs *GameServer) RemovePeer(addr string) {
+	gs.Membership.Remove(addr)
+	gs.forgetPeer(addr)
+	gs.Logger.Info("removed peer", "peer", addr)
+}
+
+// forgetPeer drops everything the node keeps about gossiping with a peer
+func (gs *GameServer) forgetPeer(addr string) {
+	gs.mu.Lock()
+	delete(gs.peerSent, addr)
+	delete(gs.peerRooms, addr)
+	delete(gs.peerAhead, addr)
+	delete(gs.peerSeen, addr)
+	delete(gs.peerRounds, addr)
+	delete(gs.peerPicked, addr)
+	delete(gs.peerSynced, addr)
+	delete(gs.peerFailed, addr)
+	delete(gs.partition.unsynced, addr)
+	gs.presencePeers.forget(addr)
+	gs.clocks.forget(addr)
+	gs.leasePeers.forget(addr)
+	gs.settingsPeers.forget(addr)
+	gs.idempotencyPeers.forget(addr)
+	gs.mu.Unlock()
+	gs.breakers.forget(addr)
+	gs.hints.forget(addr)
+}
+
+// MergeState merges entries received from a peer into the local state
+func (gs *GameServer) MergeState(incoming map[string]gossip.Entry) gossip.MergeStats {
+	incoming = gs.dropUnheldRooms(incoming)
+	gs.countRooms("received", incoming)
+	stats := gs.State.Merge(incoming)
+	gs.Metrics.MergeConflicts.With("local").Add(float64(stats.KeptLocal))
+	gs.Metrics.MergeConflicts.With("incoming").Add(float64(stats.TookIncoming))
+	gs.Metrics.MergeConflicts.With("merged").Add(float64(stats.Combined))
+	return stats
+}
+
+func (gs *GameServer) UpdatePlayerScore(playerId string, score int64) {
+	gs.UpdatePlayerScoreWithTTL(playerId, score, gs.EntryTTL)
+}
+
+// UpdatePlayerScoreWithTTL updates a player's score, keeping its attributes, and expires the player cluster-wide if
+// it isn't updated again within ttl. A ttl of 0 never expires
+func (gs *GameServer) UpdatePlayerScoreWithTTL(playerId string, score int64, ttl time.Duration) {
+	if err := gs.UpdatePlayer(playerId, &score, nil, ttl); err != nil {
+		gs.Logger.Error("failed to update player score", "player", playerId, "err", err)
+		return
+	}
+	gs.Logger.Debug("updated player score", "player", playerId, "score", score)
+}
+
+// IncrementPlayerScore adds delta, which may be negative, to a player's score and returns the new score. Unlike
+// reading the score and writing it back, increments made on different nodes at the same time are all counted: each
+// node counts its own in a CRDT counter that merges by adding up. A score set with UpdatePlayerScore supersedes the
+// increments made before it, including ones concurrent with it on other nodes. A player that doesn't exist yet
+// starts from 0
+func (gs *GameServer) IncrementPlayerScore(playerId string, delta int64) (int64, error) {
+	var score int64
+	_, err := gs.State.UpdateEntry(playerId, gs.EntryTTL, func(current gossip.Entry, ok bool, clock uint64) ([]byte, error) {
+		var p Player
+		if ok {
+			if err := json.Unmarshal(current.Value, &p); err != nil {
+				return nil, fmt.Errorf("failed to decode player: %w", err)
+			}
+		}
+		switch {
+		case !ok:
+			p.ScoreStamp = &crdt.Stamp{}
+		case p.ScoreStamp == nil:
+			p.ScoreStamp = &crdt.Stamp{Clock: current.Clock, Origin: current.Origin}
+		}
+		p.Increments = p.Increments.Add(gs.State.Origin, delta)
+		p.Score += delta
+		score = p.Score
+		return json.Marshal(p)
+	})
+	if err != nil {
+		return 0, err
+	}
+	gs.Logger.Debug("incremented player score", "player", playerId, "delta", delta, "score", score)
+	return score, nil
+}
+
+// DeletePlayer removes a player cluster-wide. The deletion is gossiped as a tombstone that is remembered for
+// TombstoneTTL
+func (gs *GameServer) DeletePlayer(playerId string) {
+	gs.Players.Delete(playerId)
+	gs.Logger.Debug("deleted player", "player", playerId)
+}
+
+// GetPlayer returns the state of a single player, if it exists and hasn't been deleted
+func (gs *GameServer) GetPlayer(playerId string) (PlayerState, bool) {
+	p, e, ok := gs.Players.Get(playerId)
+	if !ok {
+		return PlayerState{}, false
+	}
+	return newPlayerState(p, e), true
+}
+
+// Leaderboard returns up to n players outside any room with the highest scores, highest first
+func (gs *GameServer) Leaderboard(n int) []RankedPlayer {
+	return gs.index.top("", n)
+}
+
+// RoomLeaderboard is Leaderboard for the players of a room, by their ID within the room
+func (gs *GameServer) RoomLeaderboard(roomId string, n int) []RankedPlayer {
+	return gs.index.top(roomId, n)
+}
+
+// QueryPlayers returns the page of players selected by q, by their ID within q.Room. next is the ID to pass as
+// q.After for the following page, or "" if this is the last one
+func (gs *GameServer) QueryPlayers(q PlayerQuery) (players map[string]PlayerState, next string) {
+	page, next := gs.ScanPlayers(q)
+	return maps.Collect(page), next
+}
+
+// ScanPlayers is QueryPlayers for large pages: the players are read one at a time as page is iterated, in ID order,
+// rather than copied into a map at once, so a caller streaming them out holds no more than one
+func (gs *GameServer) ScanPlayers(q PlayerQuery) (page iter.Seq2[string, PlayerState], next string) {
+	ids, more := gs.index.page(q)
+	if more {
+		next = ids[len(ids)-1]
+	}
+	return func(yield func(string, PlayerState) bool) {
+		for _, playerId := range ids {
+			// The player may have been deleted since the index was read
+			if p, ok := gs.GetPlayer(RoomKey(q.Room, playerId)); ok && !yield(playerId, p) {
+				return
+			}
+		}
+	}, next
+}
+
+// GetPlayerState returns a copy of the state of every player, leaving out deleted players
+func (gs *GameServer) GetPlayerState() map[string]PlayerState {
+	result := make(map[string]PlayerState)
+	gs.Players.Range(func(playerId string, p Player, e gossip.Entry) bool {
+		result[playerId] = newPlayerState(p, e)
+		return true
+	})
+
+	return result
+}
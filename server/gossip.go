@@ -0,0 +1,521 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"slices"
+	"sync"
+	"time"
+
+	"gmathur.dev/gossiper/gossip"
+	"gmathur.dev/gossiper/tracing"
+)
+
+// GossipMode controls how state is exchanged with a peer during a gossip round
+type GossipMode string
+
+const (
+	// GossipPush sends the local state to the peer and ignores whatever it responds with
+	GossipPush GossipMode = "push"
+	// GossipPushPull sends the local state and merges the peer's state from the response, so both sides
+	// converge in a single round
+	GossipPushPull GossipMode = "push-pull"
+)
+
+// ParseGossipMode converts a mode name (as given on the command line) into a GossipMode
+func ParseGossipMode(s string) (GossipMode, error) {
+	switch mode := GossipMode(s); mode {
+	case GossipPush, GossipPushPull:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown gossip mode %q", s)
+	}
+}
+
+// GossipMessage is the payload exchanged between game servers on every gossip round. Most rounds carry only
+// the entries that changed since the last successful exchange with the receiving peer
+type GossipMessage struct {
+	From     string                  `json:"from"`    // address of the sending game server
+	Version  uint64                  `json:"version"` // sender's state version at the time the message was built
+	Since    uint64                  `json:"since"`   // push-pull only: reply with entries above this version
+	Full     bool                    `json:"full"`    // full sync: State starts from version 0 rather than a watermark
+	State    map[string]gossip.Entry `json:"state"`
+	Want     []string                `json:"want,omitempty"`     // digest repair: reply with exactly these entries
+	Protocol uint32                  `json:"protocol,omitempty"` // protocol version of the message, see ProtocolVersion
+	More     bool                    `json:"more,omitempty"`     // cut short by the size limit: the rest follows, see GossipConfig.MaxBytes
+}
+
+// GossipConfig tunes how often and how widely a node gossips
+type GossipConfig struct {
+	Interval   time.Duration // time between gossip rounds
+	Fanout     int           // number of peers gossiped with each round
+	Workers    int           // most exchanges of a round run at once; 0 runs all Fanout of them at once
+	Jitter     time.Duration // each interval is randomly lengthened or shortened by up to this much
+	MaxPayload int           // approximate cap on the entries in one message, in bytes; 0 is unlimited
+	MaxBytes   int           // hard cap on an encoded message sent or accepted, in bytes; 0 is DefaultMaxGossipBytes
+	Timeout    time.Duration // how long an exchange waits for a peer before giving up on it
+
+	// Bounds of an adaptive interval, which shortens while many entries change and lengthens while none do, see
+	// pacedInterval. Rounds stay Interval apart while both are 0
+	MinInterval time.Duration
+	MaxInterval time.Duration
+	BusyChanges int // entries changed per Interval at which rounds come faster, 100 if 0
+
+	// Budget of the rounds' messages, in estimated bytes of entries a second across every peer; 0 is unlimited.
+	// Rounds over it send the most pressing entries and defer the rest, see holdToBudget
+	BytesPerSecond int
+}
+
+// DefaultMaxGossipBytes is the largest gossip message a node sends or accepts unless GossipConfig.MaxBytes says
+// otherwise. It is what keeps a peer from making a node buffer an arbitrarily large body
+const DefaultMaxGossipBytes = 64 << 20
+
+// ErrGossipTooLarge is returned for a gossip message or reply over the size limit
+var ErrGossipTooLarge = errors.New("gossip message too large")
+
+// MaxGossipBytes returns the largest encoded gossip message the node sends or accepts, see GossipConfig.MaxBytes
+func (gs *GameServer) MaxGossipBytes() int {
+	if maxBytes := gs.gossipConfig().MaxBytes; maxBytes > 0 {
+		return maxBytes
+	}
+	return DefaultMaxGossipBytes
+}
+
+// entryBudget is how many bytes of entries go in one message. Entry sizes are estimates and leave out the
+// message framing, so an eighth of the limit is kept back for both
+func (gs *GameServer) entryBudget() int {
+	return gs.MaxGossipBytes() / 8 * 7
+}
+
+// DefaultGossipConfig gossips with one peer every 2 seconds
+func DefaultGossipConfig() GossipConfig {
+	return GossipConfig{Interval: 2 * time.Second, Fanout: 1, Timeout: 5 * time.Second}
+}
+
+// SetGossipConfig replaces the gossip settings of a running node, such as on a config reload. Rounds and exchanges
+// already under way finish with the old settings; the gossip loop picks up a new interval after its next round
+func (gs *GameServer) SetGossipConfig(cfg GossipConfig) {
+	gs.gossipMu.Lock()
+	gs.Gossip = cfg
+	gs.gossipMu.Unlock()
+}
+
+// gossipConfig returns the gossip settings in use: the node's own, see SetGossipConfig, with the cluster-wide
+// settings that override them, see SetSetting
+func (gs *GameServer) gossipConfig() GossipConfig {
+	gs.gossipMu.RLock()
+	defer gs.gossipMu.RUnlock()
+	return gs.Gossip.withOverrides(gs.gossipOverrides)
+}
+
+// GossipTransport carries gossip messages to peers. In push-pull mode it must return the peer's reply; in push
+// mode the returned message is ignored. Sends must give up once ctx is done
+type GossipTransport interface {
+	SendGossip(ctx context.Context, peerAddr string, msg GossipMessage, mode GossipMode) (GossipMessage, error)
+}
+
+func (gs *GameServer) gossipLoop(ctx context.Context) {
+	timer := time.NewTimer(gs.nextGossipInterval())
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-gs.pacing.wake:
+			if wait, ok := gs.pacing.hurry(gs.gossipConfig()); ok {
+				timer.Reset(wait)
+			}
+			continue
+		case <-timer.C:
+		}
+		gs.mu.Lock()
+		gs.lastTick = time.Now()
+		gs.mu.Unlock()
+		gs.gossipRound(ctx)
+		timer.Reset(gs.nextGossipInterval())
+	}
+}
+
+// nextGossipInterval returns the interval of the next round, see pacedInterval, with jitter applied, so that nodes
+// started together don't keep gossiping in lockstep
+func (gs *GameServer) nextGossipInterval() time.Duration {
+	cfg := gs.gossipConfig()
+	interval := gs.pacing.pacedInterval(cfg, time.Now())
+	if cfg.Jitter > 0 {
+		interval += time.Duration(rand.Int63n(int64(2*cfg.Jitter))) - cfg.Jitter
+	}
+	return max(interval, 10*time.Millisecond)
+}
+
+// gossipRound gossips with up to Fanout distinct peers chosen by Selector, Workers of them at a time, or with
+// the peers of each zone's fanout when zone awareness is on, see ZoneConfig. Peers whose circuit breaker is open
+// are passed over for others, and every exchange has its own timeout, so one slow or hung peer holds up neither
+// the rest of the round nor the next one
+func (gs *GameServer) gossipRound(ctx context.Context) {
+	// Only gossip with members the failure detector believes are alive
+	peers := gs.Membership.Peers()
+	if len(peers) == 0 {
+		return
+	}
+	gs.mu.Lock()
+	gs.round++
+	round := gs.round
+	gs.mu.Unlock()
+
+	ctx, span := gs.Tracer.Start(ctx, "gossip.round", tracing.KindInternal, tracing.Attr("gossip.round", round),
+		tracing.Attr("gossip.alive_peers", len(peers)))
+	defer span.End()
+
+	groups := gs.peerGroups(peers, round)
+	total := 0
+	for _, g := range groups {
+		total += g.fanout
+	}
+	selector := gs.Selector
+	if selector == nil {
+		selector = RandomSelector{}
+	}
+
+	work := make(chan string, total)
+	now := time.Now()
+	for _, g := range groups {
+		candidates := make([]PeerCandidate, len(g.peers))
+		gs.mu.Lock()
+		for i, peerAddr := range g.peers {
+			candidates[i] = PeerCandidate{Address: peerAddr, LastPicked: gs.peerPicked[peerAddr]}
+		}
+		gs.mu.Unlock()
+		order := selector.Select(candidates, g.fanout)
+
+		picked := 0
+		gs.mu.Lock()
+		for _, peerAddr := range order {
+			if picked == g.fanout {
+				break
+			}
+			if gs.breakers.allow(peerAddr, now) {
+				work <- peerAddr
+				gs.peerPicked[peerAddr] = now
+				picked++
+			}
+		}
+		gs.mu.Unlock()
+		if g.scope != "" {
+			gs.Metrics.ZoneExchanges.With(g.scope).Add(float64(picked))
+		}
+	}
+	close(work)
+	span.SetAttributes(tracing.Attr("gossip.picked_peers", len(work)))
+
+	workers := len(work)
+	if limit := gs.gossipConfig().Workers; limit > 0 {
+		workers = min(workers, limit)
+	}
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for peerAddr := range work {
+				gs.gossipWithTimeout(ctx, peerAddr)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// gossipWithTimeout runs GossipWithPeer bounded by the exchange timeout
+func (gs *GameServer) gossipWithTimeout(ctx context.Context, peerAddr string) {
+	if timeout := gs.gossipConfig().Timeout; timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	gs.GossipWithPeer(ctx, peerAddr)
+}
+
+// GossipWithPeer runs one gossip exchange with a single peer. Start does this for Fanout peers every
+// Interval; harnesses that drive time and peer selection themselves call it directly instead
+func (gs *GameServer) GossipWithPeer(ctx context.Context, peerAddr string) {
+	gs.mu.Lock()
+	gs.peerRounds[peerAddr]++
+	full := gs.FullSyncEvery > 0 && gs.peerRounds[peerAddr]%gs.FullSyncEvery == 0
+	gs.mu.Unlock()
+
+	gs.exchange(ctx, peerAddr, full)
+}
+
+// GossipNow runs a gossip round straight away, without waiting for the next tick of the gossip loop
+func (gs *GameServer) GossipNow(ctx context.Context) {
+	gs.gossipRound(ctx)
+}
+
+// SyncWithPeer runs a full sync with a peer straight away, outside the regular FullSyncEvery schedule
+func (gs *GameServer) SyncWithPeer(ctx context.Context, peerAddr string) error {
+	return gs.exchange(ctx, peerAddr, true)
+}
+
+// exchange sends a peer either the full state or the changes since the last exchange with it, and merges its
+// reply in push-pull mode. Changes that don't fit in one message under the size limit are sent in as many as it
+// takes, and the peer's reply is asked for again while it says there is more
+func (gs *GameServer) exchange(ctx context.Context, peerAddr string, full bool) (err error) {
+	ctx, span := gs.Tracer.Start(ctx, "gossip.exchange", tracing.KindClient, tracing.Attr("peer.address", peerAddr),
+		tracing.Attr("gossip.full", full), tracing.Attr("gossip.mode", string(gs.Mode)))
+	// The span records the players of the first message and the totals over all of them
+	var players []tracing.Attribute
+	chunks, received := 0, 0
+	defer func() {
+		span.SetAttributes(players...)
+		span.SetAttributes(tracing.Attr("gossip.chunks", chunks), tracing.Attr("gossip.received", received))
+		span.SetError(err)
+		span.End()
+	}()
+
+	rooms := gs.advertisedRooms(peerAddr)
+	gs.mu.Lock()
+	// A peer that has changed the rooms it holds since the last exchange is sent all of the ones it now holds
+	if last, ok := gs.peerRooms[peerAddr]; ok && !slices.Equal(last, rooms) {
+		full = true
+	}
+	since := gs.peerSent[peerAddr]
+	if full {
+		since = 0
+	}
+	seen := gs.peerSeen[peerAddr]
+	round := gs.round
+	gs.mu.Unlock()
+
+	// Digests cover the whole state, not just the shards or rooms the peer holds
+	digests, canDigest := gs.Transport.(DigestTransport)
+	canDigest = canDigest && !gs.sharded() && !gs.roomsFiltered(peerAddr)
+	if canDigest && full && gs.DigestSync {
+		return gs.digestExchange(ctx, peerAddr, digests, round)
+	}
+
+	msg := gs.messageFor(peerAddr, since)
+	// A full state too big for one message is cheaper to reconcile by digest than to send in pieces
+	if canDigest && full && msg.More {
+		return gs.digestExchange(ctx, peerAddr, digests, round)
+	}
+	ahead, covered, deferred := gs.holdToBudget(peerAddr, &msg, since)
+
+	// Nothing changed since the last exchange; a push would be a no-op. Full syncs are sent regardless, so that
+	// one really reaches the peer
+	if len(msg.State) == 0 && !full && gs.Mode != GossipPushPull {
+		// Left with nothing once the peer's shards were picked out; it needn't see those changes again
+		gs.mu.Lock()
+		gs.peerSent[peerAddr] = max(gs.peerSent[peerAddr], msg.Version)
+		gs.peerRooms[peerAddr] = rooms
+		gs.recordAheadLocked(peerAddr, nil, covered)
+		gs.mu.Unlock()
+		return nil
+	}
+
+	gs.Metrics.GossipRounds.With(peerAddr).Inc()
+	for chunk := 1; ; chunk++ {
+		msg.Since = seen
+		// Until a peer has answered we don't know its versions, so the first message is in the oldest one we speak
+		stampProtocol(&msg, max(gs.peerProtocol(peerAddr), MinProtocolVersion))
+
+		reply, err := gs.Transport.SendGossip(ctx, peerAddr, msg, gs.Mode)
+		if errors.Is(err, context.Canceled) {
+			// Shutting down; not the peer's fault
+			return err
+		}
+		if err == nil && gs.Mode == GossipPushPull {
+			err = CheckProtocol(reply)
+		}
+		if err != nil {
+			// A peer that is down fails every round, so the failure log rate-limits these warnings
+			gs.gossipFailed(peerAddr, err)
+			return err
+		}
+		gs.Logger.Debug("gossiped with peer", "peer", peerAddr, "round", round, "entries", len(msg.State),
+			"full", msg.Full, "chunk", chunk)
+		if chunk == 1 {
+			players = TracedPlayers(msg.State)
+		}
+		chunks, received = chunk, received+len(reply.State)
+
+		// In push-pull mode the peer answers with its own changes, which we merge so that both sides converge
+		if gs.Mode == GossipPushPull {
+			gs.MergeState(reply.State)
+		}
+
+		gs.mu.Lock()
+		if msg.Full {
+			// A full sync capped by MaxPayload only covers the oldest entries; restarting the watermark from it
+			// makes the following rounds carry on with the rest
+			gs.peerSent[peerAddr] = msg.Version
+		} else {
+			gs.peerSent[peerAddr] = max(gs.peerSent[peerAddr], msg.Version)
+		}
+		if gs.Mode == GossipPushPull {
+			gs.peerSeen[peerAddr] = reply.Version
+			seen = reply.Version
+		}
+		gs.recordAheadLocked(peerAddr, ahead, covered)
+		gs.mu.Unlock()
+
+		switch {
+		case deferred:
+			// Over the bandwidth budget; the rest waits for the next round
+			gs.mu.Lock()
+			gs.peerRooms[peerAddr] = rooms
+			gs.mu.Unlock()
+			gs.gossipSucceeded(peerAddr)
+			return nil
+		case msg.More:
+			since := msg.Version
+			msg = gs.messageFor(peerAddr, since)
+			ahead, covered, deferred = gs.holdToBudget(peerAddr, &msg, since)
+		case gs.Mode == GossipPushPull && reply.More:
+			// Only the reply is unfinished. What we just merged from it can wait for the next round rather than
+			// being echoed straight back
+			msg = GossipMessage{From: gs.Address, Version: msg.Version}
+		default:
+			gs.mu.Lock()
+			gs.peerRooms[peerAddr] = rooms
+			gs.mu.Unlock()
+			gs.gossipSucceeded(peerAddr)
+			return nil
+		}
+	}
+}
+
+// digestExchange runs a digest reconciliation with a peer as one gossip round
+func (gs *GameServer) digestExchange(ctx context.Context, peerAddr string, digests DigestTransport, round uint64) error {
+	gs.Metrics.GossipRounds.With(peerAddr).Inc()
+	err := gs.digestSyncWithPeer(ctx, peerAddr, digests, round)
+	if errors.Is(err, context.Canceled) {
+		return err
+	}
+	if err != nil {
+		gs.gossipFailed(peerAddr, err)
+		return err
+	}
+	gs.gossipSucceeded(peerAddr)
+	return nil
+}
+
+// gossipSucceeded records a successful exchange with a peer
+func (gs *GameServer) gossipSucceeded(peerAddr string) {
+	gs.breakers.success(peerAddr)
+	gs.mu.Lock()
+	gs.peerSynced[peerAddr] = time.Now()
+	round := gs.round
+	gs.mu.Unlock()
+	gs.failures.success(gs.Logger.With("round", round), peerAddr)
+}
+
+// gossipFailed records a failed exchange with a peer
+func (gs *GameServer) gossipFailed(peerAddr string, err error) {
+	gs.Metrics.GossipFailures.With(peerAddr).Inc()
+	gs.mu.Lock()
+	gs.peerFailed[peerAddr]++
+	round := gs.round
+	gs.mu.Unlock()
+	gs.failures.failure(gs.Logger.With("round", round), peerAddr, err)
+	if opened, cooldown := gs.breakers.failure(peerAddr, gs.Breaker, time.Now()); opened {
+		gs.Metrics.BreakerTrips.With(peerAddr).Inc()
+		gs.Logger.Warn("circuit breaker opened, leaving peer out of gossip rounds", "peer", peerAddr,
+			"round", round, "cooldown", cooldown.Round(time.Second))
+	}
+}
+
+// ReceiveGossip merges a message from a peer and returns the reply expected by a push-pull sender: every local
+// entry above the version the sender says it has already seen, or the entries it asked for by key when the
+// message is a digest repair. A reply over the size limit carries what fits and sets More. The reply is in the
+// message's protocol version
+func (gs *GameServer) ReceiveGossip(msg GossipMessage) GossipMessage {
+	gs.MergeState(msg.State)
+
+	var reply GossipMessage
+	if msg.Want != nil {
+		wanted := gs.State.Entries(msg.Want)
+		state := takeEntries(wanted, gs.entryBudget())
+		reply = GossipMessage{From: gs.Address, Version: gs.State.Version(), State: state, More: len(wanted) > 0}
+	} else {
+		// A watermark ahead of our own version means we restarted since the sender last heard from us, so the
+		// versions it knows about no longer mean anything
+		since := msg.Since
+		if msg.Full || since > gs.State.Version() {
+			since = 0
+		}
+		reply = gs.messageSince(since)
+		// Entries asked for by key are sent whatever the sender holds, since it may be reading them for a client
+		gs.filterForPeer(msg.From, &reply)
+	}
+	stampProtocol(&reply, msg.Protocol)
+	return reply
+}
+
+// messageSince builds a message holding the entries changed after the given version, up to MaxPayload. Without
+// a MaxPayload under the size limit it is cut by the limit instead, see chunkSince
+func (gs *GameServer) messageSince(since uint64) GossipMessage {
+	if paced := gs.gossipConfig().MaxPayload; paced > 0 && paced < gs.entryBudget() {
+		delta, version := gs.State.DeltaWithin(since, paced)
+		return GossipMessage{From: gs.Address, Version: version, Full: since == 0, State: delta}
+	}
+	return gs.chunkSince(since)
+}
+
+// messageFor is messageSince with only the entries the peer holds while sharding is on
+func (gs *GameServer) messageFor(peerAddr string, since uint64) GossipMessage {
+	msg := gs.messageSince(since)
+	gs.filterForPeer(peerAddr, &msg)
+	return msg
+}
+
+// chunkSince returns the entries above since that fit under the size limit, setting More if there are others
+func (gs *GameServer) chunkSince(since uint64) GossipMessage {
+	limit := gs.entryBudget()
+	delta, version := gs.State.Delta(since)
+	more := false
+	if estimateSize(delta) > limit {
+		var complete uint64
+		delta, complete = gs.State.DeltaWithin(since, limit)
+		more = complete < version
+		version = complete
+	}
+	return GossipMessage{
+		From:    gs.Address,
+		Version: version,
+		Full:    since == 0,
+		State:   delta,
+		More:    more,
+	}
+}
+
+// entrySize is an upper estimate of the encoded size of one entry, cheaper than encoding it. Besides the key,
+// value and origin it allows generously for the field names and numbers
+func entrySize(key string, e gossip.Entry) int {
+	return len(key) + len(e.Value) + len(e.Origin) + 96
+}
+
+func estimateSize(entries map[string]gossip.Entry) int {
+	size := 0
+	for key, e := range entries {
+		size += entrySize(key, e)
+	}
+	return size
+}
+
+// takeEntries moves entries out of from until they add up to about maxBytes, and returns them. At least one is
+// taken, however large, if from isn't empty
+func takeEntries(from map[string]gossip.Entry, maxBytes int) map[string]gossip.Entry {
+	taken := make(map[string]gossip.Entry)
+	size := 0
+	for key, e := range from {
+		size += entrySize(key, e)
+		if size > maxBytes && len(taken) > 0 {
+			break
+		}
+		taken[key] = e
+		delete(from, key)
+	}
+	return taken
+}
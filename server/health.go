@@ -0,0 +1,130 @@
+package server
+
+import (
+	"sort"
+	"time"
+)
+
+// Health is the result of a liveness or readiness check. Checks maps the name of every check that was run to "ok"
+// or to the reason it failed
+type Health struct {
+	Status          string            `json:"status"` // "ok" if every check passed, "failing" otherwise
+	Checks          map[string]string `json:"checks"`
+	LastGossipRound time.Time         `json:"lastGossipRound,omitzero"`
+	Peers           []PeerHealth      `json:"peers"`
+}
+
+// PeerHealth is what this node knows about a peer
+type PeerHealth struct {
+	Address    string       `json:"address"`
+	Status     MemberStatus `json:"status"`              // as seen by the failure detector
+	LastGossip time.Time    `json:"lastGossip,omitzero"` // last successful gossip exchange
+}
+
+// OK reports whether every check passed
+func (h Health) OK() bool {
+	return h.Status == "ok"
+}
+
+// Liveness checks that the node's gossip loop is still running. A node that fails it is stuck and should be
+// restarted
+func (gs *GameServer) Liveness() Health {
+	h := gs.health()
+	h.Checks["gossipLoop"] = gs.checkGossipLoop(h.LastGossipRound)
+	return h.finish()
+}
+
+// Readiness checks that the node can serve requests: its gossip loop is running, its storage is healthy, it
+// hasn't left the cluster, it has fetched the membership list from a seed if it was given any, and it has reached
+// at least one peer since it started, by gossip or by probe, so that it isn't serving a state nobody else can see. A node none of whose peers are alive is ready on its own, so the
+// first node of a new cluster doesn't wait for the others forever
+func (gs *GameServer) Readiness() Health {
+	h := gs.health()
+	h.Checks["gossipLoop"] = gs.checkGossipLoop(h.LastGossipRound)
+
+	h.Checks["storage"] = "ok"
+	if err := gs.State.Healthy(); err != nil {
+		h.Checks["storage"] = err.Error()
+	} else if gs.ChangeLog != nil {
+		if err := gs.ChangeLog.Healthy(); err != nil {
+			h.Checks["storage"] = err.Error()
+		}
+	}
+
+	h.Checks[bootstrapCheck] = "ok"
+	if gs.bootstrapping() {
+		h.Checks[bootstrapCheck] = "pulling the state from a peer"
+	}
+
+	h.Checks["membership"] = "ok"
+	if _, ok := gs.Decommissioning(); ok {
+		h.Checks["membership"] = "decommissioning"
+	}
+	if gs.Membership.Left() {
+		h.Checks["membership"] = "left the cluster"
+	}
+
+	h.Checks["cluster"] = "ok"
+	reached, alive := len(gs.Membership.LastAcks()) > 0, false
+	for _, p := range h.Peers {
+		reached = reached || !p.LastGossip.IsZero()
+		alive = alive || p.Status == MemberAlive
+	}
+	if alive && !reached {
+		h.Checks["cluster"] = "not yet reached any peer"
+	}
+	gs.mu.Lock()
+	joining := len(gs.Seeds) > 0 && !gs.joined
+	gs.mu.Unlock()
+	if joining {
+		h.Checks["cluster"] = "not yet joined through any seed"
+	}
+	return h.finish()
+}
+
+// health collects the gossip loop status and the peer list shared by both checks
+func (gs *GameServer) health() Health {
+	gs.mu.Lock()
+	lastTick := gs.lastTick
+	synced := make(map[string]time.Time, len(gs.peerSynced))
+	for addr, t := range gs.peerSynced {
+		synced[addr] = t
+	}
+	gs.mu.Unlock()
+
+	var peers []PeerHealth
+	for _, m := range gs.Membership.Members() {
+		if m.Address != gs.Address {
+			peers = append(peers, PeerHealth{Address: m.Address, Status: m.Status, LastGossip: synced[m.Address]})
+		}
+	}
+	sort.Slice(peers, func(i, j int) bool { return peers[i].Address < peers[j].Address })
+
+	return Health{Checks: make(map[string]string), LastGossipRound: lastTick, Peers: peers}
+}
+
+func (gs *GameServer) checkGossipLoop(lastTick time.Time) string {
+	gs.mu.Lock()
+	startedAt := gs.startedAt
+	gs.mu.Unlock()
+	if startedAt.IsZero() {
+		return "not started"
+	}
+
+	// Allow a few missed rounds: a round can take up to Timeout on top of the interval
+	cfg := gs.gossipConfig()
+	if stale := 3*(cfg.Interval+cfg.Jitter) + cfg.Timeout; time.Since(lastTick) > stale {
+		return "no gossip round for " + time.Since(lastTick).Round(time.Second).String()
+	}
+	return "ok"
+}
+
+func (h Health) finish() Health {
+	h.Status = "ok"
+	for _, result := range h.Checks {
+		if result != "ok" {
+			h.Status = "failing"
+		}
+	}
+	return h
+}
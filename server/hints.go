@@ -0,0 +1,221 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"gmathur.dev/gossiper/gossip"
+	"gmathur.dev/gossiper/tracing"
+)
+
+// HintConfig tunes hinted handoff: while a peer that should get a local write is suspect or dead, the node
+// remembers the write's key for it, and once the peer is alive again pushes it the current entry for every key
+// remembered, straight away rather than whenever the next rounds happen to pick it
+type HintConfig struct {
+	MaxPerPeer int           // most keys remembered for one peer, later ones are dropped; 0 disables hinted handoff
+	TTL        time.Duration // how long a key is remembered before it is left to gossip and anti-entropy
+}
+
+// DefaultHintConfig remembers up to 10000 keys per peer for an hour
+func DefaultHintConfig() HintConfig {
+	return HintConfig{MaxPerPeer: 10000, TTL: time.Hour}
+}
+
+// hintLog holds the keys written locally since the last pass of the hint loop, and the keys waiting for each
+// peer that was down when they were written
+type hintLog struct {
+	mu      sync.Mutex
+	pending map[string]bool
+	hints   map[string]map[string]time.Time // peer to key to when the key was hinted
+}
+
+func newHintLog() *hintLog {
+	return &hintLog{pending: make(map[string]bool), hints: make(map[string]map[string]time.Time)}
+}
+
+// observe is a gossip.Store OnChange observer. It only notes the key, since it runs with the store locked
+func (h *hintLog) observe(c gossip.Change) {
+	if c.Source != gossip.ChangeLocal {
+		return
+	}
+	h.mu.Lock()
+	h.pending[c.Key] = true
+	h.mu.Unlock()
+}
+
+// count returns the keys waiting for a peer, or for every peer if addr is empty
+func (h *hintLog) count(addr string) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if addr != "" {
+		return len(h.hints[addr])
+	}
+	n := 0
+	for _, keys := range h.hints {
+		n += len(keys)
+	}
+	return n
+}
+
+func (h *hintLog) forget(addr string) {
+	h.mu.Lock()
+	delete(h.hints, addr)
+	h.mu.Unlock()
+}
+
+func (gs *GameServer) hintLoop(ctx context.Context) {
+	ticker := time.NewTicker(gs.Membership.ProbeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		gs.recordHints()
+		gs.deliverHints(ctx)
+	}
+}
+
+// recordHints hints the keys written since the last pass for every peer that should hold them but is suspect or
+// dead: the holders of the key's shard while sharding is on, every peer otherwise. It also forgets hints that
+// have outlived the TTL
+func (gs *GameServer) recordHints() {
+	var down []string
+	for _, m := range gs.Membership.Members() {
+		if m.Address != gs.Address && (m.Status == MemberSuspect || m.Status == MemberDead) {
+			down = append(down, m.Address)
+		}
+	}
+	h := gs.hints
+	h.mu.Lock()
+	keys := h.pending
+	h.pending = make(map[string]bool)
+	h.mu.Unlock()
+
+	// Placing the shards takes the server's lock, so the owners are looked up before taking the log's
+	owners := make(map[string][]string)
+	if len(down) > 0 && gs.sharded() {
+		for key := range keys {
+			owners[key] = gs.ShardOwners(key)
+		}
+	}
+
+	now := time.Now()
+	full, expired := 0, 0
+	h.mu.Lock()
+	for _, peerAddr := range down {
+		for key := range keys {
+			if gs.sharded() && !slices.Contains(owners[key], peerAddr) {
+				continue
+			}
+			hinted := h.hints[peerAddr]
+			if hinted == nil {
+				hinted = make(map[string]time.Time)
+				h.hints[peerAddr] = hinted
+			}
+			if _, ok := hinted[key]; !ok && len(hinted) >= gs.Hints.MaxPerPeer {
+				full++
+				continue
+			}
+			hinted[key] = now
+		}
+	}
+	for peerAddr, hinted := range h.hints {
+		for key, at := range hinted {
+			if gs.Hints.TTL > 0 && now.Sub(at) > gs.Hints.TTL {
+				delete(hinted, key)
+				expired++
+			}
+		}
+		if len(hinted) == 0 {
+			delete(h.hints, peerAddr)
+		}
+	}
+	h.mu.Unlock()
+
+	gs.Metrics.HintsDropped.With("full").Add(float64(full))
+	gs.Metrics.HintsDropped.With("expired").Add(float64(expired))
+}
+
+// deliverHints pushes their hinted entries to the peers that are alive again and whose circuit breaker lets them
+// through
+func (gs *GameServer) deliverHints(ctx context.Context) {
+	h := gs.hints
+	h.mu.Lock()
+	waiting := slices.Collect(maps.Keys(h.hints))
+	h.mu.Unlock()
+	if len(waiting) == 0 {
+		return
+	}
+
+	alive := gs.Membership.Peers()
+	now := time.Now()
+	for _, peerAddr := range waiting {
+		if !slices.Contains(alive, peerAddr) || !gs.breakers.allow(peerAddr, now) {
+			continue
+		}
+		if err := gs.deliverHintsTo(ctx, peerAddr); errors.Is(err, context.Canceled) {
+			return
+		}
+	}
+}
+
+// deliverHintsTo pushes a peer the current entry for each key hinted for it, as many entries a message as fit
+// under the size limit. Until every message has gone through, all of the keys stay hinted
+func (gs *GameServer) deliverHintsTo(ctx context.Context, peerAddr string) (err error) {
+	ctx, span := gs.Tracer.Start(ctx, "gossip.hints", tracing.KindClient, tracing.Attr("peer.address", peerAddr))
+	delivered := 0
+	defer func() {
+		span.SetAttributes(tracing.Attr("gossip.entries", delivered))
+		span.SetError(err)
+		span.End()
+	}()
+
+	h := gs.hints
+	h.mu.Lock()
+	keys := slices.Collect(maps.Keys(h.hints[peerAddr]))
+	h.mu.Unlock()
+
+	entries := gs.State.Entries(keys)
+	for len(entries) > 0 {
+		chunk := takeEntries(entries, gs.entryBudget())
+		msg := GossipMessage{From: gs.Address, Version: gs.State.Version(), State: chunk}
+		stampProtocol(&msg, max(gs.peerProtocol(peerAddr), MinProtocolVersion))
+
+		err = gs.pushWithTimeout(ctx, peerAddr, msg)
+		if errors.Is(err, context.Canceled) {
+			return err
+		}
+		if err != nil {
+			gs.gossipFailed(peerAddr, err)
+			return err
+		}
+		delivered += len(chunk)
+		gs.Metrics.HintsDelivered.With().Add(float64(len(chunk)))
+	}
+
+	// Hints for keys that no longer exist, such as collected tombstones, are done with as well
+	h.mu.Lock()
+	delete(h.hints, peerAddr)
+	h.mu.Unlock()
+	gs.gossipSucceeded(peerAddr)
+	gs.Logger.Info("delivered hinted handoff to peer", "peer", peerAddr, "entries", delivered)
+	return nil
+}
+
+// pushWithTimeout pushes a message to a peer, bounded by the exchange timeout
+func (gs *GameServer) pushWithTimeout(ctx context.Context, peerAddr string, msg GossipMessage) error {
+	if timeout := gs.gossipConfig().Timeout; timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	_, err := gs.Transport.SendGossip(ctx, peerAddr, msg, GossipPush)
+	return err
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"gmathur.dev/gossiper/gossip"
+)
+
+// IdempotencyConfig controls the deduplication of client requests retried with the same idempotency key, see
+// Idempotent
+type IdempotencyConfig struct {
+	Window        time.Duration // how long the response to a request is kept for its retries; 0 disables deduplication
+	Interval      time.Duration // time between pushes of new responses to peers
+	Fanout        int           // peers pushed to every interval
+	FullSyncEvery int           // every Nth push to a peer sends every response instead of the new ones
+}
+
+// DefaultIdempotencyConfig keeps responses for 10 minutes and pushes new ones to 3 peers twice a second
+func DefaultIdempotencyConfig() IdempotencyConfig {
+	return IdempotencyConfig{Window: 10 * time.Minute, Interval: 500 * time.Millisecond, Fanout: 3, FullSyncEvery: 10}
+}
+
+// MaxIdempotencyKeyLen is the longest idempotency key accepted
+const MaxIdempotencyKeyLen = 255
+
+// IdempotentResponse is the response to a request made with an idempotency key, replayed to its retries
+type IdempotentResponse struct {
+	Fingerprint string `json:"fingerprint"` // hash of the request, telling a retry from another request with the key
+	Status      int    `json:"status"`
+	ContentType string `json:"contentType,omitempty"`
+	Body        []byte `json:"body,omitempty"`
+}
+
+// Errors of Idempotent
+var (
+	ErrRequestInProgress    = errors.New("a request with this idempotency key is still in progress")
+	ErrIdempotencyKeyReused = errors.New("idempotency key was already used for a different request")
+)
+
+// idempotencyLocks are the idempotency keys of the requests this node is handling
+type idempotencyLocks struct {
+	mu       sync.Mutex
+	inFlight map[string]bool
+}
+
+// Idempotent handles a client request carrying an idempotency key, such as a game client's retry of an increment
+// whose answer it never got, at most once within IdempotencyConfig.Window. The first request with the key runs fn,
+// which handles it and returns its response, and whether to keep it: only responses to requests that took effect
+// are worth replaying, so one that failed is run again when it is retried. Retries with the same fingerprint get
+// the kept response back, reporting true, without running fn; a request with another fingerprint fails with
+// ErrIdempotencyKeyReused, and one made while the key's first request is still being handled on this node with
+// ErrRequestInProgress.
+// Responses are gossiped, so a retry sent to another node is deduplicated too once the response has reached it,
+// within a few IdempotencyConfig.Interval; a retry that gets there first, or arrives while the key's first request
+// is being handled on another node, is handled again
+func (gs *GameServer) Idempotent(key, fingerprint string,
+	fn func() (IdempotentResponse, bool)) (IdempotentResponse, bool, error) {
+	if resp, ok := gs.idempotentResponse(key); ok {
+		return gs.replay(resp, fingerprint)
+	}
+	l := gs.idempotencyLocks
+	l.mu.Lock()
+	if l.inFlight[key] {
+		l.mu.Unlock()
+		gs.Metrics.IdempotentRequests.With("in_progress").Inc()
+		return IdempotentResponse{}, false, ErrRequestInProgress
+	}
+	l.inFlight[key] = true
+	l.mu.Unlock()
+	defer func() {
+		l.mu.Lock()
+		delete(l.inFlight, key)
+		l.mu.Unlock()
+	}()
+	// The response may have been merged from a peer, or kept by a request that has just finished, since
+	if resp, ok := gs.idempotentResponse(key); ok {
+		return gs.replay(resp, fingerprint)
+	}
+
+	resp, keep := fn()
+	resp.Fingerprint = fingerprint
+	gs.Metrics.IdempotentRequests.With("handled").Inc()
+	if keep {
+		data, err := json.Marshal(resp)
+		if err != nil {
+			return resp, false, err
+		}
+		gs.idempotency.Set(key, data, gs.Idempotency.Window)
+	}
+	return resp, false, nil
+}
+
+// replay returns the response kept for a request if fingerprint is the request's
+func (gs *GameServer) replay(resp IdempotentResponse, fingerprint string) (IdempotentResponse, bool, error) {
+	if resp.Fingerprint != fingerprint {
+		gs.Metrics.IdempotentRequests.With("reused").Inc()
+		return IdempotentResponse{}, false, ErrIdempotencyKeyReused
+	}
+	gs.Metrics.IdempotentRequests.With("replayed").Inc()
+	return resp, true, nil
+}
+
+// idempotentResponse returns the response kept for the request with an idempotency key, if it hasn't expired
+func (gs *GameServer) idempotentResponse(key string) (IdempotentResponse, bool) {
+	e, ok := gs.idempotency.Get(key)
+	if !ok || !gossip.HLCWallTime(e.Clock).Add(time.Duration(e.TTL)*time.Second).After(gs.idempotency.Now()) {
+		return IdempotentResponse{}, false
+	}
+	var resp IdempotentResponse
+	if err := json.Unmarshal(e.Value, &resp); err != nil {
+		return IdempotentResponse{}, false
+	}
+	return resp, true
+}
+
+// MergeIdempotency merges responses to idempotent requests pushed by a peer
+func (gs *GameServer) MergeIdempotency(incoming map[string]gossip.Entry) gossip.MergeStats {
+	return gs.idempotency.Merge(incoming)
+}
+
+// idempotencyLoop expires kept responses and pushes new ones to a few random peers every interval
+func (gs *GameServer) idempotencyLoop(ctx context.Context) {
+	cfg := gs.Idempotency
+	gs.storeGossipLoop(ctx, gs.idempotencyPeers, cfg.Interval, cfg.Fanout, cfg.FullSyncEvery, gs.Metrics.IdempotencyPushes)
+}
@@ -0,0 +1,166 @@
+package server
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+
+	"github.com/google/btree"
+
+	"gmathur.dev/gossiper/gossip"
+)
+
+// RankedPlayer is a player's position on the leaderboard
+type RankedPlayer struct {
+	Rank     int    `json:"rank"` // 1 for the highest score
+	PlayerId string `json:"playerId"`
+	Score    int64  `json:"score"`
+}
+
+// playerIndex keeps every live player ordered by score, for the leaderboard, and by ID, for paging through the
+// state, so neither has to sort the whole map. Each room has its own of both, so that a room's leaderboard and
+// pages never look at other rooms' players. It is updated from the store's change feed, which covers local
+// updates, gossip merges, deletions and expiry alike
+type playerIndex struct {
+	mu     sync.RWMutex
+	rooms  map[string]*roomIndex // by room ID, "" for the players outside any room
+	scores map[string]int64      // current score of every indexed player by key, to find its old position on a change
+}
+
+// roomIndex indexes the players of one room, by their player ID within the room
+type roomIndex struct {
+	byScore *btree.BTreeG[rankKey]
+	byId    *btree.BTreeG[string]
+}
+
+type rankKey struct {
+	score    int64
+	playerId string
+}
+
+// Highest score first; equal scores are ordered by player ID so that ranks are stable
+func rankLess(a, b rankKey) bool {
+	if a.score != b.score {
+		return a.score > b.score
+	}
+	return a.playerId < b.playerId
+}
+
+func newPlayerIndex() *playerIndex {
+	return &playerIndex{rooms: make(map[string]*roomIndex), scores: make(map[string]int64)}
+}
+
+func newRoomIndex() *roomIndex {
+	return &roomIndex{
+		byScore: btree.NewG(32, rankLess),
+		byId:    btree.NewG(32, func(a, b string) bool { return a < b }),
+	}
+}
+
+// len returns the number of indexed players
+func (l *playerIndex) len() int {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return len(l.scores)
+}
+
+// roomSizes returns the number of indexed players of each room
+func (l *playerIndex) roomSizes() map[string]int {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	sizes := make(map[string]int, len(l.rooms))
+	for room, ri := range l.rooms {
+		sizes[room] = ri.byId.Len()
+	}
+	return sizes
+}
+
+// observe is a gossip.Store OnChange observer
+func (l *playerIndex) observe(c gossip.Change) {
+	key, e := c.Key, c.New
+	room, playerId := SplitRoomKey(key)
+	var p Player
+	live := !e.Deleted && json.Unmarshal(e.Value, &p) == nil
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	old, indexed := l.scores[key]
+	switch {
+	case indexed && live && old == p.Score:
+	case live:
+		ri := l.rooms[room]
+		if ri == nil {
+			ri = newRoomIndex()
+			l.rooms[room] = ri
+		}
+		if indexed {
+			ri.byScore.Delete(rankKey{old, playerId})
+		}
+		ri.byScore.ReplaceOrInsert(rankKey{p.Score, playerId})
+		ri.byId.ReplaceOrInsert(playerId)
+		l.scores[key] = p.Score
+	case indexed:
+		ri := l.rooms[room]
+		ri.byScore.Delete(rankKey{old, playerId})
+		ri.byId.Delete(playerId)
+		delete(l.scores, key)
+		if ri.byId.Len() == 0 {
+			delete(l.rooms, room)
+		}
+	}
+}
+
+// top returns up to n players of a room with the highest scores
+func (l *playerIndex) top(room string, n int) []RankedPlayer {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	ri := l.rooms[room]
+	if ri == nil || n <= 0 {
+		return []RankedPlayer{}
+	}
+	ranked := make([]RankedPlayer, 0, min(n, ri.byScore.Len()))
+	ri.byScore.Ascend(func(k rankKey) bool {
+		ranked = append(ranked, RankedPlayer{Rank: len(ranked) + 1, PlayerId: k.playerId, Score: k.score})
+		return len(ranked) < n
+	})
+	return ranked
+}
+
+// PlayerQuery selects a page of players of a room, in player ID order
+type PlayerQuery struct {
+	Room     string // the room the players are in, "" for the players outside any room
+	Prefix   string // only players whose ID starts with this
+	MinScore *int64 // only players with at least this score, if set
+	After    string // start after this player ID, the last one of the previous page
+	Limit    int    // maximum number of players, 0 for all of them
+}
+
+// page returns the IDs, within the room, of the players matching q, and whether there are more after them
+func (l *playerIndex) page(q PlayerQuery) ([]string, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	ri := l.rooms[q.Room]
+	if ri == nil {
+		return nil, false
+	}
+	var ids []string
+	more := false
+	ri.byId.AscendGreaterOrEqual(max(q.Prefix, q.After), func(id string) bool {
+		if !strings.HasPrefix(id, q.Prefix) {
+			return false
+		}
+		if id == q.After || (q.MinScore != nil && l.scores[RoomKey(q.Room, id)] < *q.MinScore) {
+			return true
+		}
+		if q.Limit > 0 && len(ids) == q.Limit {
+			more = true
+			return false
+		}
+		ids = append(ids, id)
+		return true
+	})
+	return ids, more
+}
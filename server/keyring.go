@@ -0,0 +1,207 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SignatureHeader carries the HMAC of a request sent between game servers, see SignRequest
+const SignatureHeader = "X-Gossiper-Signature"
+
+// TimestampHeader carries the time a request between game servers was signed at, in Unix seconds
+const TimestampHeader = "X-Gossiper-Timestamp"
+
+// MaxSignatureSkew is how far the timestamp of a signed request may be from the receiver's clock. A captured
+// request can only be replayed within it, so node clocks should agree to well within it
+const MaxSignatureSkew = 30 * time.Second
+
+// MinKeyLength is the shortest cluster key accepted, in bytes
+const MinKeyLength = 16
+
+// Keyring holds the shared cluster secrets used to authenticate messages between game servers. Messages are
+// signed with the primary key and accepted if they verify against any key on the ring, which allows keys to be
+// rotated without downtime: install the new key everywhere, make it primary everywhere, then remove the old one
+type Keyring struct {
+	mu   sync.RWMutex
+	keys [][]byte // keys[0] is the primary
+}
+
+func NewKeyring(primary []byte, others ...[]byte) (*Keyring, error) {
+	k := &Keyring{}
+	for _, key := range append([][]byte{primary}, others...) {
+		if err := k.AddKey(key); err != nil {
+			return nil, err
+		}
+	}
+	return k, nil
+}
+
+// LoadKeyring reads base64 encoded keys from a file, one per line. The first key is the primary
+func LoadKeyring(path string) (*Keyring, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var keys [][]byte
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, err := base64.StdEncoding.DecodeString(line)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key in %s: %w", path, err)
+		}
+		keys = append(keys, key)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no keys found in %s", path)
+	}
+	return NewKeyring(keys[0], keys[1:]...)
+}
+
+// AddKey adds a key that is accepted for verification but not used for signing
+func (k *Keyring) AddKey(key []byte) error {
+	if len(key) < MinKeyLength {
+		return fmt.Errorf("cluster key must be at least %d bytes", MinKeyLength)
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for _, existing := range k.keys {
+		if bytes.Equal(existing, key) {
+			return nil
+		}
+	}
+	k.keys = append(k.keys, bytes.Clone(key))
+	return nil
+}
+
+// UseKey makes an already installed key the primary
+func (k *Keyring) UseKey(key []byte) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	for i, existing := range k.keys {
+		if bytes.Equal(existing, key) {
+			k.keys[0], k.keys[i] = k.keys[i], k.keys[0]
+			return nil
+		}
+	}
+	return errors.New("key is not installed")
+}
+
+// RemoveKey uninstalls a key. The primary key cannot be removed
+func (k *Keyring) RemoveKey(key []byte) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	for i, existing := range k.keys {
+		if bytes.Equal(existing, key) {
+			if i == 0 {
+				return errors.New("cannot remove the primary key")
+			}
+			k.keys = append(k.keys[:i], k.keys[i+1:]...)
+			return nil
+		}
+	}
+	return nil
+}
+
+// Sign returns the raw HMAC-SHA256 of payload under the primary key
+func (k *Keyring) Sign(payload []byte) []byte {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	return computeMAC(k.keys[0], payload)
+}
+
+// Verify reports whether mac is a valid HMAC of payload under any installed key
+func (k *Keyring) Verify(payload, mac []byte) bool {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	for _, key := range k.keys {
+		if hmac.Equal(mac, computeMAC(key, payload)) {
+			return true
+		}
+	}
+	return false
+}
+
+// SignRequest returns the values of TimestampHeader and SignatureHeader for a request signed at now. The
+// signature covers the method, the target, such as "/leave?addr=host:port", the timestamp and the body, as
+//
+//	POST /leave?addr=host:port\n1700000000\n<body>
+//
+// so it can't be replayed on another request, nor on the same one once MaxSignatureSkew has passed
+func (k *Keyring) SignRequest(method, target string, body []byte, now time.Time) (timestamp, signature string) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	timestamp = strconv.FormatInt(now.Unix(), 10)
+	return timestamp, hex.EncodeToString(requestMAC(k.keys[0], method, target, timestamp, body))
+}
+
+// VerifyRequest checks the headers SignRequest returned against a request received at now: the signature has to
+// verify under an installed key, and the timestamp be within MaxSignatureSkew of now
+func (k *Keyring) VerifyRequest(method, target string, body []byte, timestamp, signature string, now time.Time) error {
+	signed, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.New("missing or malformed signature timestamp")
+	}
+	mac, err := hex.DecodeString(signature)
+	if err != nil {
+		return errors.New("invalid message signature")
+	}
+
+	k.mu.RLock()
+	verified := false
+	for _, key := range k.keys {
+		if hmac.Equal(mac, requestMAC(key, method, target, timestamp, body)) {
+			verified = true
+			break
+		}
+	}
+	k.mu.RUnlock()
+	if !verified {
+		return errors.New("invalid message signature")
+	}
+	// Checked once the signature is known to be good, so a forged timestamp gets the same answer as any forgery
+	if skew := now.Sub(time.Unix(signed, 0)).Abs(); skew > MaxSignatureSkew {
+		return fmt.Errorf("message signed %s away from this node's clock, over %s", skew.Truncate(time.Second),
+			MaxSignatureSkew)
+	}
+	return nil
+}
+
+func computeMAC(key, payload []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write(payload)
+	return h.Sum(nil)
+}
+
+// requestMAC is the HMAC SignRequest describes, computed without copying the body
+func requestMAC(key []byte, method, target, timestamp string, body []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(method + " " + target + "\n" + timestamp + "\n"))
+	h.Write(body)
+	return h.Sum(nil)
+}
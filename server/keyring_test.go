@@ -0,0 +1,60 @@
+package server_test
+
+import (
+	"testing"
+	"time"
+
+	"gmathur.dev/gossiper/server"
+)
+
+func TestVerifyRequest(t *testing.T) {
+	old := []byte("an old cluster key")
+	keyring, err := server.NewKeyring([]byte("the primary cluster key"), old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := server.NewKeyring([]byte("another cluster's key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	signedWithOld, err := server.NewKeyring(old)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Unix(1700000000, 0)
+	body := []byte(`{"from":"a"}`)
+	timestamp, signature := keyring.SignRequest("POST", "/leave?addr=a:1", body, now)
+	oldTimestamp, oldSignature := signedWithOld.SignRequest("POST", "/leave?addr=a:1", body, now)
+	otherTimestamp, otherSignature := other.SignRequest("POST", "/leave?addr=a:1", body, now)
+	tests := []struct {
+		name                 string
+		method, target       string
+		body                 string
+		timestamp, signature string
+		at                   time.Time
+		ok                   bool
+	}{
+		{"as signed", "POST", "/leave?addr=a:1", string(body), timestamp, signature, now, true},
+		{"signed with an older key", "POST", "/leave?addr=a:1", string(body), oldTimestamp, oldSignature, now, true},
+		{"a little later", "POST", "/leave?addr=a:1", string(body), timestamp, signature, now.Add(server.MaxSignatureSkew), true},
+		{"a little earlier", "POST", "/leave?addr=a:1", string(body), timestamp, signature, now.Add(-server.MaxSignatureSkew), true},
+		{"replayed too late", "POST", "/leave?addr=a:1", string(body), timestamp, signature,
+			now.Add(server.MaxSignatureSkew + time.Second), false},
+		{"signed in the future", "POST", "/leave?addr=a:1", string(body), timestamp, signature,
+			now.Add(-server.MaxSignatureSkew - time.Second), false},
+		{"another method", "GET", "/leave?addr=a:1", string(body), timestamp, signature, now, false},
+		{"another target", "POST", "/leave?addr=b:1", string(body), timestamp, signature, now, false},
+		{"another body", "POST", "/leave?addr=a:1", `{"from":"b"}`, timestamp, signature, now, false},
+		{"a moved timestamp", "POST", "/leave?addr=a:1", string(body), "1700000001", signature, now, false},
+		{"no timestamp", "POST", "/leave?addr=a:1", string(body), "", signature, now, false},
+		{"no signature", "POST", "/leave?addr=a:1", string(body), timestamp, "", now, false},
+		{"another cluster's key", "POST", "/leave?addr=a:1", string(body), otherTimestamp, otherSignature, now, false},
+	}
+	for _, tt := range tests {
+		err := keyring.VerifyRequest(tt.method, tt.target, []byte(tt.body), tt.timestamp, tt.signature, tt.at)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: got %v, want ok %v", tt.name, err, tt.ok)
+		}
+	}
+}
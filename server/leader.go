@@ -0,0 +1,212 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// ElectionConfig controls the election of a cluster leader, see Leader
+type ElectionConfig struct {
+	Interval time.Duration // how often the node looks at the membership for a change of leader; 0 is the default
+	Settle   time.Duration // how long the node must be the leader by the membership before it takes the lead itself
+}
+
+// DefaultElectionConfig looks for a new leader twice a second, and has a node wait 3 seconds before leading, so
+// that a node that has just started or returned hears of the members ahead of it first
+func DefaultElectionConfig() ElectionConfig {
+	return ElectionConfig{Interval: 500 * time.Millisecond, Settle: 3 * time.Second}
+}
+
+// LeaderChange is passed to OnLeaderChange callbacks
+type LeaderChange struct {
+	Leader   string // address of the new leader, "" while there is none
+	Previous string // address of the previous leader, "" if there was none
+	Elected  bool   // this node became the leader
+	Deposed  bool   // this node stopped being the leader
+}
+
+// election is what the node keeps about the leader
+type election struct {
+	mu      sync.Mutex
+	leader  string
+	since   time.Time // when leader took the lead, as this node saw it
+	leading time.Time // when this node first found itself ahead of every other member, zero while it isn't
+	subs    []leaderSubscriber
+	nextId  uint64
+}
+
+type leaderSubscriber struct {
+	id uint64
+	fn func(LeaderChange)
+}
+
+// Leader returns the address of the cluster's leader as this node sees it, and since when: the alive or suspect
+// member with the lowest address, this node included. The election is best-effort, with no votes or leases: nodes
+// that see the membership differently, such as both sides of a partition, each have a leader of their own until
+// they hear of each other again, so a task run by the leader must be safe to run twice. It returns "" while there
+// is no leader, such as while this node waits out ElectionConfig.Settle before leading
+func (gs *GameServer) Leader() (string, time.Time) {
+	e := gs.election
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.leader, e.since
+}
+
+// IsLeader reports whether this node is the leader, see Leader
+func (gs *GameServer) IsLeader() bool {
+	leader, _ := gs.Leader()
+	return leader == gs.Address
+}
+
+// OnLeaderChange calls fn whenever the leader changes as this node sees it, see Leader. Calls are made one at a
+// time from the goroutine that runs the election, so a callback that takes long delays noticing the next change;
+// start a goroutine for slow work, or use RunAsLeader. Call the returned function to unsubscribe
+func (gs *GameServer) OnLeaderChange(fn func(LeaderChange)) (unsubscribe func()) {
+	e := gs.election
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.nextId++
+	id := e.nextId
+	e.subs = append(e.subs, leaderSubscriber{id: id, fn: fn})
+
+	return func() {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		for i, sub := range e.subs {
+			if sub.id == id {
+				e.subs = append(e.subs[:i:i], e.subs[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// RunAsLeader runs task, in a goroutine of its own, every time this node becomes the leader, with a context that is
+// cancelled once it stops being the leader or ctx is done. It is meant for cluster singletons, such as publishing
+// the leaderboard periodically. It returns once ctx is done and the last run of task has returned
+func (gs *GameServer) RunAsLeader(ctx context.Context, task func(ctx context.Context)) {
+	changes := make(chan bool, 1)
+	unsubscribe := gs.OnLeaderChange(func(c LeaderChange) {
+		if !c.Elected && !c.Deposed {
+			return
+		}
+		// Only the latest change matters
+		select {
+		case <-changes:
+		default:
+		}
+		changes <- c.Elected
+	})
+	defer unsubscribe()
+
+	var (
+		cancel context.CancelFunc
+		done   chan struct{}
+	)
+	stop := func() {
+		if cancel != nil {
+			cancel()
+			<-done
+			cancel = nil
+		}
+	}
+	defer stop()
+	start := func() {
+		var leaderCtx context.Context
+		leaderCtx, cancel = context.WithCancel(ctx)
+		done = make(chan struct{})
+		go func() {
+			defer close(done)
+			task(leaderCtx)
+		}()
+	}
+	if gs.IsLeader() {
+		start()
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case elected := <-changes:
+			stop()
+			if elected && gs.IsLeader() {
+				start()
+			}
+		}
+	}
+}
+
+// candidate returns the member that should lead by the membership: the alive or suspect one with the lowest
+// address, this node unless it has left
+func (gs *GameServer) candidate() string {
+	best := ""
+	for _, m := range gs.Membership.Members() {
+		if m.Status != MemberAlive && m.Status != MemberSuspect {
+			continue
+		}
+		if best == "" || m.Address < best {
+			best = m.Address
+		}
+	}
+	return best
+}
+
+// electionLoop follows the leader until ctx is done
+func (gs *GameServer) electionLoop(ctx context.Context) {
+	interval := gs.Election.Interval
+	if interval <= 0 {
+		interval = DefaultElectionConfig().Interval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		gs.elect()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// elect updates the leader from the membership, calling OnLeaderChange callbacks if it changed
+func (gs *GameServer) elect() {
+	e := gs.election
+	now := gs.State.Now()
+	leader := gs.candidate()
+
+	e.mu.Lock()
+	if leader == gs.Address {
+		if e.leading.IsZero() {
+			e.leading = now
+		}
+		if e.leader != gs.Address && now.Sub(e.leading) < gs.Election.Settle {
+			leader = ""
+		}
+	} else {
+		e.leading = time.Time{}
+	}
+	if leader == e.leader {
+		e.mu.Unlock()
+		return
+	}
+	change := LeaderChange{Leader: leader, Previous: e.leader, Elected: leader == gs.Address, Deposed: e.leader == gs.Address}
+	e.leader, e.since = leader, now
+	subs := e.subs
+	e.mu.Unlock()
+
+	gs.Metrics.LeaderChanges.With().Inc()
+	switch {
+	case change.Elected:
+		gs.Logger.Info("became the leader", "previous", change.Previous)
+	case change.Deposed:
+		gs.Logger.Info("no longer the leader", "leader", leader)
+	default:
+		gs.Logger.Debug("leader changed", "leader", leader, "previous", change.Previous)
+	}
+	for _, sub := range subs {
+		sub.fn(change)
+	}
+}
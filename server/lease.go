@@ -0,0 +1,138 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"gmathur.dev/gossiper/gossip"
+)
+
+// LeaseConfig controls the leases nodes take and gossip, see AcquireLease
+type LeaseConfig struct {
+	MaxTTL        time.Duration // the longest a lease may be taken or renewed for
+	Interval      time.Duration // time between pushes of changed leases to peers
+	Fanout        int           // peers pushed to every interval
+	FullSyncEvery int           // every Nth push to a peer sends every lease instead of the changes
+}
+
+// DefaultLeaseConfig lets leases be taken for up to 10 minutes and pushes changes to 3 peers twice a second
+func DefaultLeaseConfig() LeaseConfig {
+	return LeaseConfig{MaxTTL: 10 * time.Minute, Interval: 500 * time.Millisecond, Fanout: 3, FullSyncEvery: 10}
+}
+
+// Lease is a named, time-limited claim, such as on running the tournament scheduler, held by one holder at a time
+type Lease struct {
+	Name     string    `json:"name"`
+	Holder   string    `json:"holder"`   // who took it, such as a game server's ID
+	Node     string    `json:"node"`     // ID of the node it was last taken or renewed through
+	Token    uint64    `json:"token"`    // fencing token, higher for every lease taken after this one
+	Acquired time.Time `json:"acquired"` // when the holder took it; renewals keep it
+	Expires  time.Time `json:"expires"`  // when it runs out unless it is renewed
+}
+
+// lease is the value the lease store holds for a name; the expiry is the entry's TTL
+type lease struct {
+	Holder   string    `json:"holder"`
+	Node     string    `json:"node"`
+	Token    uint64    `json:"token"`
+	Acquired time.Time `json:"acquired"`
+}
+
+// LeaseHeldError is returned by AcquireLease when another holder has the lease
+type LeaseHeldError struct {
+	Lease Lease
+}
+
+func (e *LeaseHeldError) Error() string {
+	return fmt.Sprintf("lease %s is held by %s until %s", e.Lease.Name, e.Lease.Holder, e.Lease.Expires.Format(time.RFC3339))
+}
+
+// AcquireLease takes the named lease for holder, for ttl, or renews it if holder already has it. It returns a
+// *LeaseHeldError if another holder has a lease that hasn't expired yet. Leases are best-effort: the check is
+// against this node's view, and two holders taking the same lease through different nodes at once both succeed
+// until the leases meet, when the one taken last wins. Its Token is the higher, so a resource that remembers the
+// highest token it was given and refuses lower ones is never acted on by the loser, which finds out at its next
+// renewal.
+// A renewal keeps the token; a lease taken anew, even by the same holder once it has expired, gets a higher one
+func (gs *GameServer) AcquireLease(name, holder string, ttl time.Duration) (Lease, error) {
+	if ttl < time.Second || ttl > gs.Leases.MaxTTL {
+		return Lease{}, fmt.Errorf("ttl must be between 1s and %s", gs.Leases.MaxTTL)
+	}
+	now := gs.leases.Now()
+	e, err := gs.leases.UpdateEntry(name, ttl, func(current gossip.Entry, ok bool, clock uint64) ([]byte, error) {
+		l := lease{Holder: holder, Node: gs.ID, Token: clock, Acquired: now}
+		if ok {
+			if held, err := newLease(name, current); err == nil && held.Expires.After(now) {
+				if held.Holder != holder {
+					return nil, &LeaseHeldError{Lease: held}
+				}
+				l.Token, l.Acquired = held.Token, held.Acquired
+			}
+		}
+		return json.Marshal(l)
+	})
+	if err != nil {
+		return Lease{}, err
+	}
+	return newLease(name, e)
+}
+
+// ReleaseLease gives up the named lease before it expires. It reports false, and does nothing, if holder doesn't
+// have the lease as far as this node knows
+func (gs *GameServer) ReleaseLease(name, holder string) bool {
+	now := gs.leases.Now()
+	_, ok := gs.leases.DeleteIf(name, func(current gossip.Entry) bool {
+		l, err := newLease(name, current)
+		return err == nil && l.Holder == holder && l.Expires.After(now)
+	})
+	return ok
+}
+
+// GetLease returns the named lease as far as this node has heard, if it is held
+func (gs *GameServer) GetLease(name string) (Lease, bool) {
+	e, ok := gs.leases.Get(name)
+	if !ok {
+		return Lease{}, false
+	}
+	l, err := newLease(name, e)
+	if err != nil || !l.Expires.After(gs.leases.Now()) {
+		return Lease{}, false
+	}
+	return l, true
+}
+
+// HeldLeases returns the leases that are held as far as this node has heard, by name
+func (gs *GameServer) HeldLeases() []Lease {
+	now := gs.leases.Now()
+	leases := []Lease{}
+	gs.leases.Range(func(name string, e gossip.Entry) bool {
+		if l, err := newLease(name, e); err == nil && l.Expires.After(now) {
+			leases = append(leases, l)
+		}
+		return true
+	})
+	sort.Slice(leases, func(i, j int) bool { return leases[i].Name < leases[j].Name })
+	return leases
+}
+
+func newLease(name string, e gossip.Entry) (Lease, error) {
+	var l lease
+	if err := json.Unmarshal(e.Value, &l); err != nil {
+		return Lease{}, err
+	}
+	return Lease{Name: name, Holder: l.Holder, Node: l.Node, Token: l.Token, Acquired: l.Acquired,
+		Expires: gossip.HLCWallTime(e.Clock).Add(time.Duration(e.TTL) * time.Second)}, nil
+}
+
+// MergeLeases merges leases pushed by a peer
+func (gs *GameServer) MergeLeases(incoming map[string]gossip.Entry) gossip.MergeStats {
+	return gs.leases.Merge(incoming)
+}
+
+// leaseLoop expires leases and pushes the ones that changed to a few random peers every interval
+func (gs *GameServer) leaseLoop(ctx context.Context) {
+	gs.storeGossipLoop(ctx, gs.leasePeers, gs.Leases.Interval, gs.Leases.Fanout, gs.Leases.FullSyncEvery, gs.Metrics.LeasePushes)
+}
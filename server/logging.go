@@ -0,0 +1,86 @@
+package server
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// peerFailureLog reports repeated failures to reach a peer without flooding the log. The first failure is logged
+// straight away, further ones at most once per interval along with how many were suppressed in between, and the
+// peer's recovery is logged once
+type peerFailureLog struct {
+	msg      string // logged with each failure
+	interval time.Duration
+	mu       sync.Mutex
+	peers    map[string]*peerFailures
+}
+
+// maxFailingPeers bounds the peers a peerFailureLog tracks, which for dropped datagrams are whatever sources
+// they claim to come from
+const maxFailingPeers = 1024
+
+type peerFailures struct {
+	since      time.Time // first failure of the current streak
+	lastLogged time.Time
+	suppressed int
+	total      int
+}
+
+func newPeerFailureLog(msg string, interval time.Duration) *peerFailureLog {
+	return &peerFailureLog{msg: msg, interval: interval, peers: make(map[string]*peerFailures)}
+}
+
+func (l *peerFailureLog) failure(logger *slog.Logger, peer string, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	f, failing := l.peers[peer]
+	if !failing {
+		if len(l.peers) >= maxFailingPeers {
+			if l.pruneLocked(now); len(l.peers) >= maxFailingPeers {
+				return
+			}
+		}
+		f = &peerFailures{since: now}
+		l.peers[peer] = f
+	}
+	f.total++
+	if failing && now.Sub(f.lastLogged) < l.interval {
+		f.suppressed++
+		return
+	}
+
+	logger.Warn(l.msg, "peer", peer, "err", err, "failures", f.total,
+		"suppressed", f.suppressed, "failing_for", now.Sub(f.since).Round(time.Second))
+	f.lastLogged = now
+	f.suppressed = 0
+}
+
+func (l *peerFailureLog) success(logger *slog.Logger, peer string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if f, failing := l.peers[peer]; failing {
+		logger.Info("gossip with peer recovered", "peer", peer, "failures", f.total,
+			"failing_for", time.Since(f.since).Round(time.Second))
+		delete(l.peers, peer)
+	}
+}
+
+// pruneLocked forgets the peers not logged within the interval, whose next failure is logged anyway. Failures of
+// new peers while it is still full aren't logged. Caller must hold l.mu
+func (l *peerFailureLog) pruneLocked(now time.Time) {
+	for peer, f := range l.peers {
+		if now.Sub(f.lastLogged) >= l.interval {
+			delete(l.peers, peer)
+		}
+	}
+}
+
+// DroppedMessage logs a message from a peer that was dropped, such as a datagram that failed to decode or verify,
+// at most once per source every 30 seconds, so a misconfigured or hostile sender can't flood the log
+func (gs *GameServer) DroppedMessage(from string, err error) {
+	gs.drops.failure(gs.Logger, from, err)
+}
@@ -0,0 +1,623 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"maps"
+	"math/rand"
+	"slices"
+	"sync"
+	"time"
+)
+
+// MemberStatus is the failure detector's view of a member
+type MemberStatus string
+
+const (
+	MemberAlive   MemberStatus = "alive"
+	MemberSuspect MemberStatus = "suspect"
+	MemberDead    MemberStatus = "dead"
+	MemberLeft    MemberStatus = "left" // removed on purpose; never probed and never gossiped with
+)
+
+// DefaultProbeInterval is the time between probes of a new Membership, and the one used when ProbeInterval isn't
+// positive
+const DefaultProbeInterval = time.Second
+
+// Member is a single entry in the membership list. Incarnation is only ever bumped by the member itself, to
+// refute a suspicion about it or to publish new metadata or shards, and is used to order conflicting reports about
+// the same member
+type Member struct {
+	Address     string            `json:"address"`
+	Status      MemberStatus      `json:"status"`
+	Incarnation uint64            `json:"incarnation"`
+	Meta        map[string]string `json:"meta,omitempty"`   // set by the member itself, see Membership.SetMeta
+	Shards      []int             `json:"shards,omitempty"` // shards the member holds while sharding is on, see ShardConfig
+	Rooms       []string          `json:"rooms,omitempty"`  // rooms the member holds, none if it holds every room, see GameServer.Rooms
+}
+
+// PingMessage is sent by the failure detector for direct and indirect probes, and returned as the ack. Members
+// piggybacks the sender's membership view so that changes spread with every probe
+type PingMessage struct {
+	From    string   `json:"from"`
+	Target  string   `json:"target,omitempty"` // ping-req only: the member to probe on the sender's behalf
+	Members []Member `json:"members"`
+	Time    int64    `json:"time,omitempty"` // acks only: the sender's wall clock in Unix nanoseconds, for clock skew
+}
+
+// Membership is a SWIM-style failure detector. Every ProbeInterval one member is pinged directly; if it does not
+// ack within ProbeTimeout, IndirectProbes other members are asked to ping it on our behalf. A member nobody can
+// reach becomes suspect, and a suspect that does not refute the suspicion within SuspectTimeout is declared dead.
+// Dead members are retried with a direct ping now and then, less often the longer they stay dead, so a node that
+// comes back from a partition is found again even if it doesn't rejoin by itself; after RetireAfter they are
+// forgotten
+type Membership struct {
+	ProbeInterval  time.Duration
+	ProbeTimeout   time.Duration
+	IndirectProbes int
+	SuspectTimeout time.Duration
+	DeadRetry      time.Duration     // wait before the first retry of a dead member, 0 never retries
+	MaxDeadRetry   time.Duration     // cap on the wait, which doubles after every failed retry
+	RetireAfter    time.Duration     // how long a dead or left member is kept before it is forgotten, 0 forever
+	OnRetire       func(addr string) // called for every retired member
+	Probes         ProbeTransport    // carries the probes: HTTP, or the node's transport if it can, see GameServer.Start
+	Logger         *slog.Logger
+	Registry       *PeerRegistry // canonical forms of member addresses, and which of them are this node's
+
+	// OnClockSample is called for every direct ack that carries the peer's wall clock, with when the probe was sent
+	// and acked by ours
+	OnClockSample func(addr string, peerTime, sent, acked time.Time)
+
+	self        string
+	mu          sync.Mutex
+	incarnation uint64
+	left        bool              // set by Leave; we advertise ourselves as left from then on
+	meta        map[string]string // advertised with our own entry, never modified once set
+	shards      []int             // shards we hold, advertised with our own entry; never modified once set
+	rooms       []string          // rooms we hold, advertised with our own entry; never modified once set
+	members     map[string]*memberEntry
+	retired     map[string]time.Time // members forgotten recently, whose dead and left reports are ignored
+	probeOrder  []string
+}
+
+type memberEntry struct {
+	Member
+	suspectedAt time.Time
+	lastAck     time.Time // last time the member answered one of our pings
+	downAt      time.Time // when the member was declared dead or left
+	retries     int       // failed retries since it was declared dead
+	nextRetry   time.Time
+}
+
+// NewMembership creates a membership list for the node at self, seeded with the given peers which are assumed
+// alive until probed. Addresses are kept in canonical form, so the node is self's canonical form, and seeds that
+// reach the node itself or repeat another are left out, see PeerRegistry
+func NewMembership(self string, seeds []string, logger *slog.Logger) *Membership {
+	registry := NewPeerRegistry(self)
+	ms := &Membership{
+		Logger:         logger,
+		ProbeInterval:  DefaultProbeInterval,
+		ProbeTimeout:   500 * time.Millisecond,
+		IndirectProbes: 3,
+		SuspectTimeout: 5 * time.Second,
+		DeadRetry:      10 * time.Second,
+		MaxDeadRetry:   5 * time.Minute,
+		RetireAfter:    time.Hour,
+		Registry:       registry,
+		self:           registry.Self(),
+		members:        make(map[string]*memberEntry),
+		retired:        make(map[string]time.Time),
+	}
+	for _, addr := range registry.Peers(seeds) {
+		ms.members[addr] = &memberEntry{Member: Member{Address: addr, Status: MemberAlive}}
+	}
+	return ms
+}
+
+// Self returns the node's own address, in canonical form
+func (ms *Membership) Self() string {
+	return ms.self
+}
+
+// Peers returns the addresses of all members currently believed to be alive
+func (ms *Membership) Peers() []string {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	return ms.peersLocked(MemberAlive)
+}
+
+// Left reports whether Leave has been called
+func (ms *Membership) Left() bool {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	return ms.left
+}
+
+// LastAcks returns when each member last answered one of our pings, leaving out members that never have
+func (ms *Membership) LastAcks() map[string]time.Time {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	result := make(map[string]time.Time)
+	for addr, m := range ms.members {
+		if !m.lastAck.IsZero() {
+			result[addr] = m.lastAck
+		}
+	}
+	return result
+}
+
+// Members returns a snapshot of the membership list, including this node
+func (ms *Membership) Members() []Member {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	members := ms.membersLocked()
+	for i := range members {
+		members[i].Meta = maps.Clone(members[i].Meta)
+		members[i].Shards = slices.Clone(members[i].Shards)
+		members[i].Rooms = slices.Clone(members[i].Rooms)
+	}
+	return members
+}
+
+func (ms *Membership) membersLocked() []Member {
+	result := make([]Member, 0, len(ms.members)+1)
+	status := MemberAlive
+	if ms.left {
+		status = MemberLeft
+	}
+	result = append(result, Member{Address: ms.self, Status: status, Incarnation: ms.incarnation, Meta: ms.meta,
+		Shards: ms.shards, Rooms: ms.rooms})
+	for _, m := range ms.members {
+		result = append(result, m.Member)
+	}
+	return result
+}
+
+func (ms *Membership) peersLocked(status MemberStatus) []string {
+	result := make([]string, 0, len(ms.members))
+	for addr, m := range ms.members {
+		if m.Status == status {
+			result = append(result, addr)
+		}
+	}
+	return result
+}
+
+// Add introduces a member to the list, or brings back one that was dead or had left. A returning member gets a
+// bumped incarnation so that stale dead/left reports still circulating in the cluster don't evict it again
+func (ms *Membership) Add(addr string) {
+	addr = CanonicalAddr(addr)
+	if ms.Registry.IsSelf(addr) {
+		return
+	}
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	cur, exists := ms.members[addr]
+	if !exists {
+		delete(ms.retired, addr)
+		ms.applyLocked(Member{Address: addr, Status: MemberAlive})
+		return
+	}
+	if cur.Status == MemberDead || cur.Status == MemberLeft {
+		ms.applyLocked(Member{Address: addr, Status: MemberAlive, Incarnation: cur.Incarnation + 1})
+	}
+}
+
+// Remove marks a member as having left. The entry is kept, rather than deleted, so the removal spreads to the
+// rest of the cluster and older reports of the member being alive can't resurrect it
+func (ms *Membership) Remove(addr string) {
+	addr = CanonicalAddr(addr)
+	if ms.Registry.IsSelf(addr) {
+		return
+	}
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	var incarnation uint64
+	if cur, exists := ms.members[addr]; exists {
+		incarnation = cur.Incarnation
+	}
+	ms.applyLocked(Member{Address: addr, Status: MemberLeft, Incarnation: incarnation})
+}
+
+// Merge applies a membership view received from another node
+func (ms *Membership) Merge(members []Member) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	for _, m := range members {
+		ms.applyLocked(m)
+	}
+}
+
+func (ms *Membership) applyLocked(m Member) {
+	m.Address = CanonicalAddr(m.Address)
+	if _, ok := ms.Registry.Alias(m.Address); ok || m.Address != ms.self && ms.Registry.IsSelf(m.Address) {
+		// Another address of ours or of a member, which other members may know it by as well: every node only
+		// goes by its own address
+		return
+	}
+	if m.Address == ms.self {
+		// Someone thinks we are suspect or dead. Refute by advertising a newer incarnation of ourselves
+		if m.Status != MemberAlive && m.Incarnation >= ms.incarnation {
+			ms.incarnation = m.Incarnation + 1
+			ms.Logger.Info("refuting report about self", "status", m.Status, "incarnation", ms.incarnation)
+		} else if (!maps.Equal(m.Meta, ms.meta) || !slices.Equal(m.Shards, ms.shards) || !slices.Equal(m.Rooms, ms.rooms)) &&
+			m.Incarnation >= ms.incarnation {
+			// Such as our metadata, shards or rooms from before a restart, or none at all from the seed list
+			ms.incarnation = m.Incarnation + 1
+			ms.Logger.Debug("refuting stale metadata about self", "incarnation", ms.incarnation)
+		}
+		return
+	}
+
+	down := m.Status == MemberDead || m.Status == MemberLeft
+	cur, exists := ms.members[m.Address]
+	if !exists {
+		// Other members retire the same member at slightly different times; without this they would keep
+		// handing its dead entry back to each other
+		if _, retired := ms.retired[m.Address]; retired && down {
+			return
+		}
+		delete(ms.retired, m.Address)
+		entry := &memberEntry{Member: m, suspectedAt: time.Now()}
+		if down {
+			ms.markDownLocked(entry)
+		}
+		ms.members[m.Address] = entry
+		if !down {
+			ms.Logger.Info("member joined", "peer", m.Address, "status", m.Status)
+		}
+		return
+	}
+	if !supersedes(m, cur.Member) {
+		return
+	}
+
+	if m.Status != cur.Status {
+		ms.Logger.Info("member status changed", "peer", m.Address, "status", m.Status, "incarnation", m.Incarnation)
+	}
+	if m.Status == MemberSuspect && cur.Status != MemberSuspect {
+		cur.suspectedAt = time.Now()
+	}
+	if down && cur.Status != MemberDead && cur.Status != MemberLeft {
+		ms.markDownLocked(cur)
+	}
+	cur.Member = m
+}
+
+// markDownLocked starts the retry and retirement clocks of a member that was declared dead or left
+func (ms *Membership) markDownLocked(m *memberEntry) {
+	m.downAt = time.Now()
+	m.retries = 0
+	m.nextRetry = m.downAt.Add(backoff(ms.DeadRetry, ms.MaxDeadRetry, 0))
+}
+
+// supersedes reports whether the update should replace what we currently know about a member, following the
+// SWIM precedence rules: a higher incarnation always wins, and at the same incarnation left beats dead beats
+// suspect beats alive
+func supersedes(update, cur Member) bool {
+	if update.Incarnation != cur.Incarnation {
+		return update.Incarnation > cur.Incarnation
+	}
+	return statusRank(update.Status) > statusRank(cur.Status)
+}
+
+func statusRank(s MemberStatus) int {
+	switch s {
+	case MemberSuspect:
+		return 1
+	case MemberDead:
+		return 2
+	case MemberLeft:
+		return 3
+	default:
+		return 0
+	}
+}
+
+// HandlePing answers a direct probe from another member
+func (ms *Membership) HandlePing(msg PingMessage) PingMessage {
+	ms.Merge(msg.Members)
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	return PingMessage{From: ms.self, Members: ms.membersLocked(), Time: time.Now().UnixNano()}
+}
+
+// HandlePingReq probes msg.Target on behalf of the sender, returning the target's ack if it answered
+func (ms *Membership) HandlePingReq(ctx context.Context, msg PingMessage) (PingMessage, error) {
+	ms.Merge(msg.Members)
+	return ms.ping(ctx, msg.Target)
+}
+
+// Leave announces that this node is leaving the cluster on purpose, so that peers mark it as left straight away
+// instead of suspecting it and eventually declaring it dead. Every reachable member is pinged with our own entry
+// marked left; Leave returns once they have all answered or ctx is done. A node that later restarts refutes the
+// left entry like any other report about itself and rejoins
+func (ms *Membership) Leave(ctx context.Context) {
+	ms.mu.Lock()
+	ms.left = true
+	targets := append(ms.peersLocked(MemberAlive), ms.peersLocked(MemberSuspect)...)
+	msg := PingMessage{From: ms.self, Members: ms.membersLocked()}
+	ms.mu.Unlock()
+
+	acked := make(chan bool, len(targets))
+	for _, target := range targets {
+		go func() {
+			_, err := ms.send(ctx, target, msg, ms.ProbeTimeout)
+			acked <- err == nil
+		}()
+	}
+
+	notified := 0
+	for range targets {
+		select {
+		case ok := <-acked:
+			if ok {
+				notified++
+			}
+		case <-ctx.Done():
+			ms.Logger.Warn("leave interrupted", "notified", notified, "members", len(targets))
+			return
+		}
+	}
+	ms.Logger.Info("left cluster", "notified", notified, "members", len(targets))
+}
+
+func (ms *Membership) probeLoop(ctx context.Context) {
+	ticker := time.NewTicker(ms.ProbeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		ms.reapSuspects()
+		if target, ok := ms.nextProbeTarget(); ok {
+			ms.probe(ctx, target)
+		}
+		if target, ok := ms.nextDeadRetry(); ok {
+			ms.retryDead(ctx, target)
+		}
+		ms.retire()
+	}
+}
+
+// nextDeadRetry returns the dead member whose retry is most overdue, if any is due
+func (ms *Membership) nextDeadRetry() (string, bool) {
+	if ms.DeadRetry <= 0 {
+		return "", false
+	}
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	now := time.Now()
+	target, due := "", now
+	for addr, m := range ms.members {
+		if m.Status == MemberDead && !m.nextRetry.After(due) {
+			target, due = addr, m.nextRetry
+		}
+	}
+	return target, target != ""
+}
+
+// retryDead pings a dead member directly. The ping carries our view, in which it is dead, so a member that is
+// back refutes it and its ack brings it back to life. A member that doesn't answer is retried later, after twice
+// as long as last time
+func (ms *Membership) retryDead(ctx context.Context, target string) {
+	_, err := ms.ping(ctx, target)
+	if err == nil || ctx.Err() != nil {
+		return
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if m, ok := ms.members[target]; ok && m.Status == MemberDead {
+		m.retries++
+		m.nextRetry = time.Now().Add(backoff(ms.DeadRetry, ms.MaxDeadRetry, m.retries))
+		ms.Logger.Debug("dead member still unreachable", "peer", target, "retries", m.retries,
+			"next", m.nextRetry.Sub(time.Now()).Round(time.Second))
+	}
+}
+
+// retire forgets members that have been dead or left for RetireAfter, calling OnRetire for each
+func (ms *Membership) retire() {
+	if ms.RetireAfter <= 0 {
+		return
+	}
+	ms.mu.Lock()
+	now := time.Now()
+	var retired []string
+	for addr, m := range ms.members {
+		if (m.Status == MemberDead || m.Status == MemberLeft) && now.Sub(m.downAt) > ms.RetireAfter {
+			delete(ms.members, addr)
+			ms.retired[addr] = now
+			retired = append(retired, addr)
+			ms.Logger.Info("retired member", "peer", addr, "status", m.Status, "retries", m.retries)
+		}
+	}
+	for addr, at := range ms.retired {
+		if now.Sub(at) > ms.RetireAfter {
+			delete(ms.retired, addr)
+		}
+	}
+	onRetire := ms.OnRetire
+	ms.mu.Unlock()
+
+	if onRetire != nil {
+		for _, addr := range retired {
+			onRetire(addr)
+		}
+	}
+}
+
+// nextProbeTarget walks the membership list in a shuffled round-robin order, as SWIM does, so every member is
+// probed within a bounded number of rounds
+func (ms *Membership) nextProbeTarget() (string, bool) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	for len(ms.probeOrder) > 0 {
+		target := ms.probeOrder[0]
+		ms.probeOrder = ms.probeOrder[1:]
+		if m, ok := ms.members[target]; ok && (m.Status == MemberAlive || m.Status == MemberSuspect) {
+			return target, true
+		}
+	}
+
+	ms.probeOrder = append(ms.peersLocked(MemberAlive), ms.peersLocked(MemberSuspect)...)
+	rand.Shuffle(len(ms.probeOrder), func(i, j int) {
+		ms.probeOrder[i], ms.probeOrder[j] = ms.probeOrder[j], ms.probeOrder[i]
+	})
+	return "", false
+}
+
+func (ms *Membership) probe(ctx context.Context, target string) {
+	if _, err := ms.ping(ctx, target); err == nil {
+		return
+	}
+
+	ms.mu.Lock()
+	helpers := ms.peersLocked(MemberAlive)
+	msg := PingMessage{From: ms.self, Target: target, Members: ms.membersLocked()}
+	ms.mu.Unlock()
+
+	rand.Shuffle(len(helpers), func(i, j int) { helpers[i], helpers[j] = helpers[j], helpers[i] })
+	acks := make(chan bool, len(helpers))
+	asked := 0
+	for _, helper := range helpers {
+		if asked == ms.IndirectProbes {
+			break
+		}
+		if helper == target {
+			continue
+		}
+		asked++
+		go func() {
+			reply, err := ms.send(ctx, helper, msg, 2*ms.ProbeTimeout)
+			if err == nil {
+				ms.Merge(reply.Members)
+			}
+			acks <- err == nil
+		}()
+	}
+	for range asked {
+		if <-acks {
+			return
+		}
+	}
+
+	// Don't blame the target for probes cut short by shutdown
+	if ctx.Err() == nil {
+		ms.suspect(target)
+	}
+}
+
+func (ms *Membership) ping(ctx context.Context, target string) (PingMessage, error) {
+	ms.mu.Lock()
+	msg := PingMessage{From: ms.self, Members: ms.membersLocked()}
+	ms.mu.Unlock()
+
+	sent := time.Now()
+	reply, err := ms.send(ctx, target, msg, ms.ProbeTimeout)
+	if err != nil {
+		return PingMessage{}, err
+	}
+	acked := time.Now()
+	if from := CanonicalAddr(reply.From); from != "" && from != target && ms.reachable(from) {
+		ms.dropAlias(target, from)
+		if from == ms.self {
+			return reply, nil
+		}
+	}
+	ms.Merge(reply.Members)
+	ms.mu.Lock()
+	if m, ok := ms.members[target]; ok {
+		m.lastAck = acked
+	}
+	onClockSample := ms.OnClockSample
+	ms.mu.Unlock()
+	if onClockSample != nil && reply.Time != 0 {
+		onClockSample(target, time.Unix(0, reply.Time), sent, acked)
+	}
+	return reply, nil
+}
+
+// listenOn records an address the node listens on besides its own, see PeerRegistry.Listen, dropping members that
+// turn out to be the node itself
+func (ms *Membership) listenOn(addr string) {
+	ms.Registry.Listen(addr)
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	for member := range ms.members {
+		if ms.Registry.IsSelf(member) {
+			delete(ms.members, member)
+			ms.Logger.Warn("peer address reaches this node itself, dropping it", "peer", member)
+		}
+	}
+}
+
+// reachable reports whether addr is this node, or a member that is alive and has answered our probes
+func (ms *Membership) reachable(addr string) bool {
+	if addr == ms.self {
+		return true
+	}
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	m, ok := ms.members[addr]
+	return ok && m.Status == MemberAlive && !m.lastAck.IsZero()
+}
+
+// dropAlias forgets a member whose probe was answered by another node that goes by of, this node or a member we
+// already reach: a host name for it that CanonicalAddr can't tell from others without resolving it, say. It is
+// remembered as an alias, so reports of it from other members are ignored from then on, rather than every node
+// being probed and gossiped with twice
+func (ms *Membership) dropAlias(addr, of string) {
+	if !ms.Registry.AddAlias(addr, of) {
+		return
+	}
+	ms.mu.Lock()
+	delete(ms.members, addr)
+	ms.mu.Unlock()
+	if of == ms.self {
+		ms.Logger.Warn("peer address reaches this node itself, dropping it", "peer", addr)
+	} else {
+		ms.Logger.Info("peer address reaches another member, dropping it", "peer", addr, "member", of)
+	}
+}
+
+// send sends a probe message over Probes and waits up to timeout for the reply
+func (ms *Membership) send(ctx context.Context, addr string, msg PingMessage, timeout time.Duration) (PingMessage, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return ms.Probes.SendProbe(ctx, addr, msg)
+}
+
+func (ms *Membership) suspect(addr string) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	m, ok := ms.members[addr]
+	if !ok || m.Status != MemberAlive {
+		return
+	}
+	ms.applyLocked(Member{Address: addr, Status: MemberSuspect, Incarnation: m.Incarnation})
+}
+
+func (ms *Membership) reapSuspects() {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	for addr, m := range ms.members {
+		if m.Status == MemberSuspect && time.Since(m.suspectedAt) > ms.SuspectTimeout {
+			ms.applyLocked(Member{Address: addr, Status: MemberDead, Incarnation: m.Incarnation})
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"maps"
+
+	"gmathur.dev/gossiper/crdt"
+	"gmathur.dev/gossiper/gossip"
+)
+
+// PlayerLastWriteWins keeps the score written last and merges attributes field by field, see MergePlayers. It
+// is the default strategy of a GameServer's State
+var PlayerLastWriteWins = MergePlayers(gossip.LastWriteWins)
+
+// MaxScoreWins keeps the higher score, falling back to last-write-wins when the scores are equal. Useful for
+// high-score tables where a later but lower score must not replace a personal best
+var MaxScoreWins = MergePlayers(gossip.PreferValue(func(a, b Player) int { return cmp.Compare(a.Score, b.Score) }))
+
+// MergeStrategy looks up a built-in merge function by the name used on the command line
+func MergeStrategy(name string) (gossip.MergeFunc, error) {
+	switch name {
+	case "lww":
+		return PlayerLastWriteWins, nil
+	case "max-score":
+		return MaxScoreWins, nil
+	default:
+		return nil, fmt.Errorf("unknown merge strategy %q", name)
+	}
+}
+
+// MergePlayers wraps the strategy for scores so that it decides only the score, as if every score had been written
+// in an entry of its own, while attributes are merged field by field and increments are added up. Increments
+// build on the score last set: when both sides build on the same one their counters are merged, and otherwise the
+// winning side's score is kept with its increments, so a score set on one node supersedes increments made
+// elsewhere on the score before it. The merged entry takes its metadata from whichever entry was written last,
+// like crdt.MergeFunc. Players that were only ever set merge exactly as scores alone would. MergeStrategy's
+// strategies are already wrapped
+func MergePlayers(scores gossip.MergeFunc) gossip.MergeFunc {
+	return func(local, incoming gossip.Entry) gossip.Entry {
+		var l, in Player
+		if json.Unmarshal(local.Value, &l) != nil || json.Unmarshal(incoming.Value, &in) != nil ||
+			l.ScoreStamp == nil && in.ScoreStamp == nil && len(l.Attributes) == 0 && len(in.Attributes) == 0 {
+			return scores(local, incoming)
+		}
+
+		winner := scores(scoreEntry(local, l), scoreEntry(incoming, in))
+		lStamp, inStamp := scoreStamp(local, l), scoreStamp(incoming, in)
+		won := crdt.Stamp{Clock: winner.Clock, Origin: winner.Origin}
+		p := Player{Attributes: mergeAttributes(l.Attributes, in.Attributes)}
+		switch {
+		case lStamp == inStamp:
+			p.Increments = l.Increments.Merge(in.Increments)
+			p.Score = l.Score - l.Increments.Value() + p.Increments.Value()
+		case won == lStamp:
+			p.Score, p.Increments = l.Score, l.Increments
+		case won == inStamp:
+			p.Score, p.Increments = in.Score, in.Increments
+		default:
+			// The strategy combined the scores rather than picking one
+			var combined Player
+			if json.Unmarshal(winner.Value, &combined) != nil {
+				return scores(local, incoming)
+			}
+			p.Score = combined.Score
+		}
+
+		merged := gossip.LastWriteWins(local, incoming)
+		if won != (crdt.Stamp{Clock: merged.Clock, Origin: merged.Origin}) {
+			p.ScoreStamp = &won
+		}
+		value, err := json.Marshal(p)
+		if err != nil {
+			return merged
+		}
+		if !bytes.Equal(merged.Value, value) {
+			merged.Value = value
+		}
+		return merged
+	}
+}
+
+// scoreStamp is when the player's score was last set: its ScoreStamp, or the entry's own stamp when the score was
+// set by the entry's write
+func scoreStamp(e gossip.Entry, p Player) crdt.Stamp {
+	if p.ScoreStamp != nil {
+		return *p.ScoreStamp
+	}
+	return crdt.Stamp{Clock: e.Clock, Origin: e.Origin}
+}
+
+// scoreEntry is e as it would be had the player's score, increments included, been written on its own when it was
+// last set
+func scoreEntry(e gossip.Entry, p Player) gossip.Entry {
+	stamp := scoreStamp(e, p)
+	e.Clock, e.Origin = stamp.Clock, stamp.Origin
+	e.Value, _ = json.Marshal(Player{Score: p.Score})
+	return e
+}
+
+func mergeAttributes(a, b map[string]Attribute) map[string]Attribute {
+	if len(a) == 0 && len(b) == 0 {
+		return nil
+	}
+	merged := maps.Clone(a)
+	if merged == nil {
+		merged = make(map[string]Attribute, len(b))
+	}
+	for name, attr := range b {
+		merged[name] = merged[name].Merge(attr)
+	}
+	return merged
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"strings"
+)
+
+// Limits on a node's metadata, which travels with its entry in every membership list piggybacked on probes
+const (
+	MaxMetaKeys     = 32
+	MaxMetaKeyLen   = 64
+	MaxMetaValueLen = 256
+)
+
+// SetMeta replaces the metadata this node advertises with its membership entry, such as its region, build
+// version or shard. It bumps the node's incarnation, so the new metadata supersedes the old wherever it has got to
+func (ms *Membership) SetMeta(meta map[string]string) error {
+	if err := ValidateMeta(meta); err != nil {
+		return err
+	}
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.meta = maps.Clone(meta)
+	ms.incarnation++
+	return nil
+}
+
+// Meta returns the metadata this node advertises
+func (ms *Membership) Meta() map[string]string {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	return maps.Clone(ms.meta)
+}
+
+// ValidateMeta checks metadata against the limits. Keys may not contain '=' or ',' so that any metadata can be
+// given as a -meta flag
+func ValidateMeta(meta map[string]string) error {
+	if len(meta) > MaxMetaKeys {
+		return fmt.Errorf("metadata has %d keys, at most %d are allowed", len(meta), MaxMetaKeys)
+	}
+	for key, value := range meta {
+		switch {
+		case key == "":
+			return errors.New("metadata key is empty")
+		case len(key) > MaxMetaKeyLen:
+			return fmt.Errorf("metadata key %q is longer than %d bytes", key, MaxMetaKeyLen)
+		case strings.ContainsAny(key, "=,"):
+			return fmt.Errorf("metadata key %q may not contain '=' or ','", key)
+		case len(value) > MaxMetaValueLen:
+			return fmt.Errorf("metadata value of %q is longer than %d bytes", key, MaxMetaValueLen)
+		}
+	}
+	return nil
+}
+
+// ParseMeta parses a comma separated list of key=value pairs
+func ParseMeta(s string) (map[string]string, error) {
+	meta := make(map[string]string)
+	if s == "" {
+		return meta, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			return nil, fmt.Errorf("metadata %q is not key=value", pair)
+		}
+		meta[key] = value
+	}
+	return meta, ValidateMeta(meta)
+}
+
+// MatchesMeta reports whether the member's metadata has every key in filter with the same value
+func (m Member) MatchesMeta(filter map[string]string) bool {
+	for key, value := range filter {
+		if got, ok := m.Meta[key]; !ok || got != value {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"gmathur.dev/gossiper/metrics"
+)
+
+// Metrics are the Prometheus metrics recorded by a game server and its transports
+type Metrics struct {
+	Registry           *metrics.Registry
+	GossipRounds       *metrics.CounterVec   // gossip rounds attempted, by peer
+	GossipDeferred     *metrics.CounterVec   // estimated bytes of entries deferred by rounds over the bandwidth budget
+	GossipFailures     *metrics.CounterVec   // gossip rounds that failed, by peer
+	BreakerTrips       *metrics.CounterVec   // times a peer's circuit breaker opened, by peer
+	MergeConflicts     *metrics.CounterVec   // merges where both sides had the key, by which side won
+	PayloadBytes       *metrics.HistogramVec // gossip payload sizes, by direction (sent/received)
+	DigestRepairs      *metrics.CounterVec   // entries exchanged by digest reconciliation, by direction (sent/received)
+	AntiEntropySyncs   *metrics.CounterVec   // anti-entropy syncs started by this node, by result (ok/failed)
+	AntiEntropyRepairs *metrics.CounterVec   // entries anti-entropy changed locally, i.e. that gossip had missed
+	Partitions         *metrics.CounterVec   // times the node lost contact with a majority of the cluster
+	ZoneExchanges      *metrics.CounterVec   // peers picked by zone-aware gossip rounds, by scope (local/cross)
+	Forwards           *metrics.CounterVec   // client requests forwarded to a holder of the player's shard or room, by handler and result
+	HintsDelivered     *metrics.CounterVec   // hinted entries pushed to peers back from an outage
+	HintsDropped       *metrics.CounterVec   // hints given up on, by reason (full/expired)
+	CompressionRatio   *metrics.HistogramVec // compressed size over original size of peer bodies, by encoding
+	HTTPDuration       *metrics.HistogramVec // HTTP handler latencies, by handler and status code
+	RateLimited        *metrics.CounterVec   // requests rejected by the rate limiter, by handler and limit (ip/global)
+	Published          *metrics.CounterVec   // changes handed to the publisher, by result (ok/failed)
+	PublishDropped     *metrics.CounterVec   // changes dropped because the publish queue was full
+	ChangeLogDropped   *metrics.CounterVec   // changes dropped because the change log queue was full
+	WebhookEvents      *metrics.CounterVec   // changes queued for webhooks, by webhook and result (delivered/failed/dropped)
+	ChaosFaults        *metrics.CounterVec   // faults injected by chaos testing, by fault (dropped/isolated/delayed/corrupted)
+	PresencePushes     *metrics.CounterVec   // pushes of changed sessions to peers, by result (ok/failed)
+	RoomEntries        *metrics.CounterVec   // entries gossiped, by room and direction (sent/received/filtered/dropped)
+	Broadcasts         *metrics.CounterVec   // broadcast messages, by event (originated/delivered/duplicate/expired/invalid/dropped)
+	BroadcastPushes    *metrics.CounterVec   // sends of broadcast messages to peers, by result (ok/failed)
+	LeaderChanges      *metrics.CounterVec   // times the leader changed as this node saw it
+	ClockClamped       *metrics.CounterVec   // peer timestamps held back for being over ClockConfig.MaxDrift ahead
+	LeasePushes        *metrics.CounterVec   // pushes of changed leases to peers, by result (ok/failed)
+	SettingsPushes     *metrics.CounterVec   // pushes of changed cluster-wide settings to peers, by result (ok/failed)
+	IdempotentRequests *metrics.CounterVec   // requests with an idempotency key, by result (handled/replayed/reused/in_progress)
+	IdempotencyPushes  *metrics.CounterVec   // pushes of responses to idempotent requests to peers, by result (ok/failed)
+}
+
+func newMetrics(gs *GameServer) *Metrics {
+	r := metrics.NewRegistry()
+	m := &Metrics{
+		Registry:           r,
+		GossipRounds:       r.NewCounter("gossiper_gossip_rounds_total", "Gossip rounds attempted.", "peer"),
+		GossipDeferred:     r.NewCounter("gossiper_gossip_deferred_bytes_total", "Estimated bytes of entries deferred to later rounds for being over the gossip bandwidth budget."),
+		GossipFailures:     r.NewCounter("gossiper_gossip_failures_total", "Gossip rounds that failed.", "peer"),
+		BreakerTrips:       r.NewCounter("gossiper_breaker_trips_total", "Times a peer's circuit breaker opened.", "peer"),
+		MergeConflicts:     r.NewCounter("gossiper_merge_conflicts_total", "Incoming entries that conflicted with a local entry.", "winner"),
+		PayloadBytes:       r.NewHistogram("gossiper_gossip_payload_bytes", "Size of gossip payloads.", metrics.SizeBuckets, "direction"),
+		DigestRepairs:      r.NewCounter("gossiper_digest_repairs_total", "Entries exchanged by digest reconciliation.", "direction"),
+		AntiEntropySyncs:   r.NewCounter("gossiper_anti_entropy_syncs_total", "Anti-entropy syncs started by this node.", "result"),
+		AntiEntropyRepairs: r.NewCounter("gossiper_anti_entropy_repairs_total", "Entries changed by anti-entropy syncs, which gossip had missed."),
+		Partitions:         r.NewCounter("gossiper_partitions_total", "Times the node lost contact with a majority of the cluster."),
+		ZoneExchanges:      r.NewCounter("gossiper_zone_exchanges_total", "Gossip exchanges picked by zone-aware rounds, within the zone or across zones.", "scope"),
+		Forwards:           r.NewCounter("gossiper_forwarded_requests_total", "Client requests forwarded to a node holding the player's shard or room.", "handler", "result"),
+		HintsDelivered:     r.NewCounter("gossiper_hints_delivered_total", "Hinted entries pushed to peers once they were alive again."),
+		HintsDropped:       r.NewCounter("gossiper_hints_dropped_total", "Hints given up on, because the peer had too many or they outlived the TTL.", "reason"),
+		CompressionRatio: r.NewHistogram("gossiper_compression_ratio", "Compressed size of peer message bodies relative to their original size.",
+			[]float64{.05, .1, .2, .3, .4, .5, .6, .8, 1}, "encoding"),
+		HTTPDuration:     r.NewHistogram("gossiper_http_request_duration_seconds", "HTTP handler latency.", metrics.DefBuckets, "handler", "code"),
+		RateLimited:      r.NewCounter("gossiper_rate_limited_total", "Requests rejected by the rate limiter.", "handler", "limit"),
+		Published:        r.NewCounter("gossiper_published_changes_total", "Changes handed to the publisher, by whether the broker took them.", "result"),
+		PublishDropped:   r.NewCounter("gossiper_publish_dropped_total", "Changes dropped because the publish queue was full while the broker was failing."),
+		ChangeLogDropped: r.NewCounter("gossiper_changelog_dropped_total", "Changes dropped because the change log queue was full while appends fell behind."),
+		WebhookEvents:    r.NewCounter("gossiper_webhook_events_total", "Changes queued for webhooks, by whether they were delivered or given up on.", "webhook", "result"),
+		ChaosFaults:      r.NewCounter("gossiper_chaos_faults_total", "Faults injected into peer traffic by chaos testing.", "fault"),
+		PresencePushes:   r.NewCounter("gossiper_presence_pushes_total", "Pushes of changed sessions to peers.", "result"),
+		RoomEntries:      r.NewCounter("gossiper_room_entries_total", "Entries gossiped with peers, by room.", "room", "direction"),
+		Broadcasts:       r.NewCounter("gossiper_broadcast_messages_total", "Broadcast messages originated on or heard of by this node, by what became of them.", "event"),
+		BroadcastPushes:  r.NewCounter("gossiper_broadcast_pushes_total", "Sends of broadcast messages being spread to peers.", "result"),
+		LeaderChanges:    r.NewCounter("gossiper_leader_changes_total", "Times the cluster's leader changed as this node saw it."),
+		ClockClamped:     r.NewCounter("gossiper_clock_clamped_total", "Timestamps from peers further ahead of this node's clock than -clock-max-drift, which the clock was held back from."),
+		LeasePushes:      r.NewCounter("gossiper_lease_pushes_total", "Pushes of changed leases to peers.", "result"),
+		SettingsPushes:   r.NewCounter("gossiper_settings_pushes_total", "Pushes of changed cluster-wide settings to peers.", "result"),
+
+		IdempotentRequests: r.NewCounter("gossiper_idempotent_requests_total", "Client requests made with an idempotency key, by whether they were handled or answered from an earlier one.", "result"),
+		IdempotencyPushes:  r.NewCounter("gossiper_idempotency_pushes_total", "Pushes of responses to idempotent requests to peers.", "result"),
+	}
+
+	r.NewGaugeFunc("gossiper_players", "Number of entries in the state map, tombstones included.", func() float64 {
+		return float64(gs.State.Len())
+	})
+	r.NewGaugeFunc("gossiper_alive_peers", "Number of peers the failure detector believes are alive.", func() float64 {
+		return float64(len(gs.Membership.Peers()))
+	})
+	r.NewGaugeFunc("gossiper_partitioned", "1 while the node can reach no more than half of the cluster, 0 otherwise.", func() float64 {
+		gs.mu.Lock()
+		defer gs.mu.Unlock()
+		if gs.partition.since.IsZero() {
+			return 0
+		}
+		return 1
+	})
+	r.NewGaugeFunc("gossiper_hints", "Keys waiting to be handed off to peers that are down.", func() float64 {
+		return float64(gs.hints.count(""))
+	})
+	r.NewGaugeFunc("gossiper_shards_held", "Number of shards this node holds, 0 while sharding is off.", func() float64 {
+		ring := gs.shardRing()
+		if ring == nil {
+			return 0
+		}
+		return float64(len(ring.held(gs.Address)))
+	})
+	r.NewGaugeFuncVec("gossiper_room_players", "Number of live players in each room, the players outside any room under an empty room.", "room", func() map[string]float64 {
+		sizes := make(map[string]float64)
+		for room, n := range gs.index.roomSizes() {
+			sizes[room] = float64(n)
+		}
+		return sizes
+	})
+	r.NewGaugeFunc("gossiper_presence_sessions", "Number of live player sessions this node knows of, on any node.", func() float64 {
+		return float64(len(gs.Sessions("")))
+	})
+	r.NewGaugeFunc("gossiper_leader", "1 while this node is the cluster's leader, 0 otherwise.", func() float64 {
+		if gs.IsLeader() {
+			return 1
+		}
+		return 0
+	})
+	r.NewGaugeFunc("gossiper_leases", "Number of leases held as far as this node knows.", func() float64 {
+		return float64(len(gs.HeldLeases()))
+	})
+	r.NewGaugeFuncVec("gossiper_clock_skew_seconds", "How far each peer's clock is ahead of this node's, negative if behind.", "peer", gs.clocks.clockOffsets)
+	r.NewGaugeFunc("gossiper_clock_correction_seconds", "Correction added to this node's clock for write stamps, see -clock-skew-correct.", func() float64 {
+		return float64(gs.clocks.correction.Load()) / 1e9
+	})
+	r.NewGaugeFunc("gossiper_gossip_interval_seconds", "Time between this node's gossip rounds, see -gossip-min-interval and -gossip-max-interval.", func() float64 {
+		return gs.GossipInterval().Seconds()
+	})
+	r.NewGaugeFunc("gossiper_settings", "Number of cluster-wide settings this node knows of.", func() float64 {
+		return float64(len(gs.Settings()))
+	})
+	r.NewGaugeFunc("gossiper_broadcast_rumors", "Number of broadcast messages this node is still spreading.", func() float64 {
+		return float64(gs.rumors.activeRumors())
+	})
+	return m
+}
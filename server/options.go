@@ -0,0 +1,51 @@
+package server
+
+import (
+	"log/slog"
+	"time"
+
+	"gmathur.dev/gossiper/gossip"
+)
+
+// Option configures a GameServer as it is created by NewGameServer. Options cover what is awkward to change
+// afterwards because other parts of the node are built from it; everything else can equally be set on the
+// GameServer's fields before Start
+type Option func(*options)
+
+type options struct {
+	gossip    GossipConfig
+	transport GossipTransport
+	store     *gossip.Store
+	logger    *slog.Logger
+	now       func() time.Time
+}
+
+// WithGossipInterval sets the time between gossip rounds, see GossipConfig
+func WithGossipInterval(d time.Duration) Option {
+	return func(o *options) { o.gossip.Interval = d }
+}
+
+// WithTransport sets how the node reaches its peers, such as transport.HTTPGossipTransport or one of the other
+// transports of package transport. A node created without one reaches none: its peer traffic fails with
+// ErrNoTransport until Transport is set
+func WithTransport(t GossipTransport) Option {
+	return func(o *options) { o.transport = t }
+}
+
+// WithStore makes the node replicate an existing store, for example one already restored from a backend, instead
+// of a new empty one
+func WithStore(s *gossip.Store) Option {
+	return func(o *options) { o.store = s }
+}
+
+// WithLogger sets the logger used by the node, its membership list and its store. The default logger is
+// slog.Default tagged with the node ID; a logger given here is used as is
+func WithLogger(l *slog.Logger) Option {
+	return func(o *options) { o.logger = l }
+}
+
+// WithClock replaces the wall clock used to stamp writes and to expire entries and tombstones, for tests and
+// simulations that control time
+func WithClock(now func() time.Time) Option {
+	return func(o *options) { o.now = now }
+}
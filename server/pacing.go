@@ -0,0 +1,96 @@
+package server
+
+import (
+	"cmp"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gmathur.dev/gossiper/gossip"
+)
+
+// defaultBusyChanges is GossipConfig.BusyChanges when it is 0
+const defaultBusyChanges = 100
+
+// gossipPacing adapts the time between gossip rounds to how much of the state is changing, see pacedInterval
+type gossipPacing struct {
+	changes atomic.Int64  // entries changed since the last round
+	wake    chan struct{} // signalled on changes, for hurry
+
+	mu       sync.Mutex
+	interval time.Duration // of the next round, while the interval is adaptive
+	last     time.Time     // when the interval was last paced, about when the last round ran
+}
+
+func newGossipPacing() *gossipPacing {
+	return &gossipPacing{wake: make(chan struct{}, 1)}
+}
+
+// observe is a gossip.Store OnChange observer for the state, counting changes that peers may not have yet:
+// expiries and drops happen on every node by itself
+func (p *gossipPacing) observe(c gossip.Change) {
+	if c.Source == gossip.ChangeExpired || c.Source == gossip.ChangeDropped {
+		return
+	}
+	p.changes.Add(1)
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// adaptive reports whether cfg asks for an adaptive interval
+func adaptive(cfg GossipConfig) bool {
+	return cfg.MinInterval > 0 || cfg.MaxInterval > 0
+}
+
+// pacedInterval returns the time until the next round, cfg.Interval unless the interval is adaptive. Then it
+// halves, down to MinInterval, every round after which entries changed at BusyChanges per Interval or more, so a
+// hot state converges quickly; lengthens by a quarter, up to MaxInterval, every round after which none changed, so
+// an idle cluster sends fewer messages; and goes back to Interval after a round with a few changes
+func (p *gossipPacing) pacedInterval(cfg GossipConfig, now time.Time) time.Duration {
+	changes := p.changes.Swap(0)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	elapsed := now.Sub(p.last)
+	p.last = now
+	if !adaptive(cfg) {
+		p.interval = cfg.Interval
+		return cfg.Interval
+	}
+
+	lo := min(cmp.Or(cfg.MinInterval, cfg.Interval), cfg.Interval)
+	hi := max(cmp.Or(cfg.MaxInterval, cfg.Interval), cfg.Interval)
+	busy := float64(cmp.Or(cfg.BusyChanges, defaultBusyChanges))
+	interval := cmp.Or(p.interval, cfg.Interval)
+	switch {
+	case changes == 0:
+		interval += interval / 4
+	case elapsed > 0 && float64(changes)*float64(cfg.Interval)/float64(elapsed) >= busy:
+		interval /= 2
+	default:
+		interval = cfg.Interval
+	}
+	p.interval = min(max(interval, lo), hi)
+	return p.interval
+}
+
+// hurry cuts short a wait that an idle cluster lengthened beyond cfg.Interval once an entry changes, so the change
+// goes out within Interval of the last round rather than MaxInterval. It returns the new wait, if it cut one short
+func (p *gossipPacing) hurry(cfg GossipConfig) (time.Duration, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !adaptive(cfg) || p.interval <= cfg.Interval {
+		return 0, false
+	}
+	p.interval = cfg.Interval
+	return max(time.Until(p.last.Add(cfg.Interval)), 0), true
+}
+
+// GossipInterval returns the time between the node's gossip rounds, which changes with the load while it is
+// adaptive, see GossipConfig.MinInterval
+func (gs *GameServer) GossipInterval() time.Duration {
+	gs.pacing.mu.Lock()
+	defer gs.pacing.mu.Unlock()
+	return cmp.Or(gs.pacing.interval, gs.gossipConfig().Interval)
+}
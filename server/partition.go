@@ -0,0 +1,170 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"slices"
+	"time"
+)
+
+// PartitionEvent reports that the node lost or regained contact with a majority of the cluster, see OnPartition
+type PartitionEvent struct {
+	Partitioned bool      // true when the majority was lost, false when it was regained
+	Since       time.Time // when the majority was lost
+	Reachable   int       // members the failure detector believes alive, this node included
+	Members     int       // members known, this node included and departed members not
+	// Peers that weren't alive: at detection those unreachable then, on healing every peer that was unreachable
+	// at some point during the partition
+	Unreachable []string
+	// Healing only: the outcome of the anti-entropy sync with each peer in Unreachable, "ok", the reason it failed
+	// or "pending" for a peer not alive yet. Those not synced with are tried again until they are
+	Synced map[string]string
+}
+
+// PartitionStatus is Status's view of a partition under way
+type PartitionStatus struct {
+	Since       time.Time `json:"since"`
+	Reachable   int       `json:"reachable"`
+	Members     int       `json:"members"`
+	Unreachable []string  `json:"unreachable"`
+}
+
+// partitionState tracks a partition from the moment the node loses the majority until every peer it lost has
+// been synced with again
+type partitionState struct {
+	since     time.Time       // zero while not partitioned
+	unsynced  map[string]bool // peers unreachable at some point during the partition and not synced with since
+	reachable int
+	members   int
+}
+
+// reachability counts the members the failure detector believes alive and the members it knows of, both with
+// this node, and lists the peers that are suspect or dead
+func (ms *Membership) reachability() (reachable, members int, unreachable []string) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	unreachable = append(ms.peersLocked(MemberSuspect), ms.peersLocked(MemberDead)...)
+	reachable = 1 + len(ms.peersLocked(MemberAlive))
+	return reachable, reachable + len(unreachable), unreachable
+}
+
+func (gs *GameServer) partitionLoop(ctx context.Context) {
+	ticker := time.NewTicker(gs.Membership.ProbeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		gs.checkPartition(ctx)
+	}
+}
+
+// checkPartition detects the node losing contact with a majority of the cluster, which for a 2 node cluster is
+// losing its only peer. Once the majority is back it runs anti-entropy with every peer that was unreachable in the
+// meantime, as soon as each is alive again, so that whatever the two sides wrote apart is reconciled straight away
+// rather than at the next full sync
+func (gs *GameServer) checkPartition(ctx context.Context) {
+	if gs.Membership.Left() {
+		return
+	}
+	reachable, members, unreachable := gs.Membership.reachability()
+	partitioned := members > 1 && reachable*2 <= members
+
+	gs.mu.Lock()
+	p := &gs.partition
+	was, since := !p.since.IsZero(), p.since
+	p.reachable, p.members = reachable, members
+	if partitioned {
+		if !was {
+			since = time.Now()
+			p.since = since
+		}
+		if p.unsynced == nil {
+			p.unsynced = make(map[string]bool)
+		}
+		for _, addr := range unreachable {
+			p.unsynced[addr] = true
+		}
+	} else {
+		p.since = time.Time{}
+	}
+	lost := slices.Sorted(maps.Keys(p.unsynced))
+	gs.mu.Unlock()
+
+	switch {
+	case partitioned && !was:
+		slices.Sort(unreachable)
+		gs.Metrics.Partitions.With().Inc()
+		gs.Logger.Warn("partitioned: only a minority of the cluster is reachable", "reachable", reachable,
+			"members", members, "unreachable", unreachable)
+		gs.reportPartition(PartitionEvent{Partitioned: true, Since: since, Reachable: reachable, Members: members,
+			Unreachable: unreachable})
+	case partitioned:
+	case was:
+		synced := gs.syncLost(ctx, lost)
+		for _, peerAddr := range lost {
+			if _, ok := synced[peerAddr]; !ok {
+				synced[peerAddr] = "pending"
+			}
+		}
+		gs.Logger.Info("partition healed", "after", time.Since(since).Round(time.Second), "reachable", reachable,
+			"members", members, "synced", synced)
+		gs.reportPartition(PartitionEvent{Since: since, Reachable: reachable, Members: members, Unreachable: lost,
+			Synced: synced})
+	case len(lost) > 0:
+		for peerAddr, result := range gs.syncLost(ctx, lost) {
+			gs.Logger.Info("synced with peer back from partition", "peer", peerAddr, "result", result)
+		}
+	}
+}
+
+// syncLost runs anti-entropy with each of the peers that is alive and whose circuit breaker lets it through,
+// returning the outcome per peer. Peers synced with are no longer tracked; the others are tried again later
+func (gs *GameServer) syncLost(ctx context.Context, peers []string) map[string]string {
+	alive := gs.Membership.Peers()
+	now := time.Now()
+	synced := make(map[string]string)
+	for _, peerAddr := range peers {
+		if !slices.Contains(alive, peerAddr) || !gs.breakers.allow(peerAddr, now) {
+			continue
+		}
+		err := gs.AntiEntropyWithPeer(ctx, peerAddr)
+		if errors.Is(err, context.Canceled) {
+			break
+		}
+		if err != nil {
+			synced[peerAddr] = err.Error()
+			continue
+		}
+		synced[peerAddr] = "ok"
+		gs.mu.Lock()
+		delete(gs.partition.unsynced, peerAddr)
+		gs.mu.Unlock()
+	}
+	return synced
+}
+
+func (gs *GameServer) reportPartition(event PartitionEvent) {
+	if gs.OnPartition != nil {
+		gs.OnPartition(event)
+	}
+}
+
+// partitionStatusLocked returns the partition under way, or nil
+func (gs *GameServer) partitionStatusLocked() *PartitionStatus {
+	p := gs.partition
+	if p.since.IsZero() {
+		return nil
+	}
+	return &PartitionStatus{
+		Since:       p.since,
+		Reachable:   p.reachable,
+		Members:     p.members,
+		Unreachable: slices.Sorted(maps.Keys(p.unsynced)),
+	}
+}
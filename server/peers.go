@@ -0,0 +1,244 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// PeerRegistry tells the addresses a node is given for its peers apart from each other and from its own. Every
+// address is put in one canonical form, see CanonicalAddr, so a peer listed twice, or written two ways, is one
+// peer, and addresses that reach the node itself, such as its own address in -peers, are kept out of its peer
+// lists rather than gossiped and probed. Names that only resolving them would tell apart are found out by probes
+// instead, and recorded as aliases, see AddAlias
+type PeerRegistry struct {
+	self string
+
+	mu      sync.Mutex
+	listen  []string          // addresses the node listens on other than self, see Listen
+	aliases map[string]string // other addresses of this node or of a peer, to the address it goes by
+	checked map[string]bool   // whether each address IsSelf has looked at reaches this node
+}
+
+func NewPeerRegistry(self string) *PeerRegistry {
+	return &PeerRegistry{self: CanonicalAddr(self), aliases: make(map[string]string), checked: make(map[string]bool)}
+}
+
+// CanonicalAddr returns a host:port address in the form peer lists keep it in: host names are lower-cased and lose
+// a trailing dot, IP addresses are written the way net/netip does, so every spelling of an IPv6 address is the
+// same and IPv4 ones mapped into IPv6 are plain IPv4, and the port is written in decimal without leading zeroes.
+// Host names aren't resolved, so a peer's address stays the one it was given. An address that isn't host:port is
+// only trimmed
+func CanonicalAddr(addr string) string {
+	addr = strings.TrimSpace(addr)
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	if ip, err := netip.ParseAddr(host); err == nil {
+		host = ip.Unmap().String()
+	} else {
+		host = strings.TrimSuffix(strings.ToLower(host), ".")
+	}
+	if n, err := strconv.ParseUint(port, 10, 16); err == nil {
+		port = strconv.FormatUint(n, 10)
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// Self returns the node's own address, in canonical form
+func (r *PeerRegistry) Self() string {
+	return r.self
+}
+
+// Listen records an address the node listens on that isn't the one it goes by, see GameServer.ListenAddress. The
+// address, and localhost or the machine's IP addresses on its port, reach the node as well as self
+func (r *PeerRegistry) Listen(addr string) {
+	addr = CanonicalAddr(addr)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if addr == r.self || slices.Contains(r.listen, addr) {
+		return
+	}
+	r.listen = append(r.listen, addr)
+	// Addresses looked at before may be on the new port
+	clear(r.checked)
+	for alias, of := range r.aliases {
+		r.checked[alias] = of == r.self
+	}
+}
+
+// AddAlias records that addr reaches the node that goes by of, this node or a peer: a host name of it that a probe
+// found answered under of, say. It reports whether the alias is new
+func (r *PeerRegistry) AddAlias(addr, of string) bool {
+	addr, of = CanonicalAddr(addr), CanonicalAddr(of)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if addr == of || addr == r.self || r.aliases[addr] == of {
+		return false
+	}
+	r.aliases[addr] = of
+	r.checked[addr] = of == r.self
+	return true
+}
+
+// Alias returns the address of the node that addr was recorded by AddAlias as an alias of
+func (r *PeerRegistry) Alias(addr string) (string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	of, ok := r.aliases[CanonicalAddr(addr)]
+	return of, ok
+}
+
+// Aliases returns the aliases recorded by AddAlias, and the addresses of the nodes they reach
+func (r *PeerRegistry) Aliases() map[string]string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return maps.Clone(r.aliases)
+}
+
+// IsSelf reports whether addr reaches this node: it is the node's own address, one it listens on or an alias of
+// it, or names one of this machine's IP addresses, or localhost, on the node's port or that of an address it
+// listens on. Registries and operators often list nodes by IP while a node may have been given a host name as its
+// address, or the other way round
+func (r *PeerRegistry) IsSelf(addr string) bool {
+	addr = CanonicalAddr(addr)
+	if addr == r.self {
+		return true
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if of, ok := r.aliases[addr]; ok {
+		return of == r.self
+	}
+	self, ok := r.checked[addr]
+	if !ok {
+		self = slices.Contains(r.listen, addr) || onLocalHost(addr, r.self) ||
+			slices.ContainsFunc(r.listen, func(listen string) bool { return onLocalHost(addr, listen) })
+		r.checked[addr] = self
+	}
+	return self
+}
+
+// Peers returns addrs in canonical form, in the order given, with aliases replaced by the addresses they reach and
+// without duplicates or addresses that reach this node
+func (r *PeerRegistry) Peers(addrs []string) []string {
+	peers := make([]string, 0, len(addrs))
+	seen := make(map[string]bool, len(addrs))
+	for _, addr := range addrs {
+		addr = CanonicalAddr(addr)
+		if of, ok := r.Alias(addr); ok {
+			addr = of
+		}
+		if addr == "" || seen[addr] || r.IsSelf(addr) {
+			continue
+		}
+		seen[addr] = true
+		peers = append(peers, addr)
+	}
+	return peers
+}
+
+// onLocalHost reports whether addr is on self's port at localhost or an IP of one of this machine's interfaces.
+// Both are canonical
+func onLocalHost(addr, self string) bool {
+	host, port, err := net.SplitHostPort(addr)
+	_, selfPort, selfErr := net.SplitHostPort(self)
+	if err != nil || selfErr != nil || port != selfPort {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	ifaceAddrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return false
+	}
+	for _, a := range ifaceAddrs {
+		if ipNet, ok := a.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// AdvertiseAddr returns the address a node listening on listen should go by, the one peers reach it on: advertise,
+// if it is set, with listen's port if it has none. A node behind NAT or in a container listens on an address peers
+// can't reach, and advertises the one they can. Without advertise, a listen address with no host or an
+// unspecified one, such as ":8081" or "0.0.0.0:8081", is advertised on the first IP address of the machine's
+// interfaces that isn't a loopback one, IPv4 first, since peers can't reach the node on either
+func AdvertiseAddr(advertise, listen string) (string, error) {
+	listenHost, listenPort, err := net.SplitHostPort(listen)
+	if err != nil {
+		return "", fmt.Errorf("invalid listen address %q: %w", listen, err)
+	}
+	if advertise != "" {
+		if _, _, err := net.SplitHostPort(advertise); err != nil {
+			advertise = net.JoinHostPort(strings.Trim(advertise, "[]"), listenPort)
+		}
+		host, port, err := net.SplitHostPort(advertise)
+		switch {
+		case err != nil:
+			return "", fmt.Errorf("invalid advertise address %q: %w", advertise, err)
+		case host == "" || port == "":
+			return "", fmt.Errorf("advertise address %q needs a host and a port", advertise)
+		case isUnspecified(host):
+			return "", fmt.Errorf("advertise address %q is unspecified, peers can't reach it", advertise)
+		}
+		return CanonicalAddr(advertise), nil
+	}
+	if !isUnspecified(listenHost) {
+		return CanonicalAddr(listen), nil
+	}
+	ip, err := interfaceIP()
+	if err != nil {
+		return "", fmt.Errorf("can't tell which address to advertise for %q, set one: %w", listen, err)
+	}
+	return CanonicalAddr(net.JoinHostPort(ip.String(), listenPort)), nil
+}
+
+// isUnspecified reports whether a host is empty or an unspecified IP address, which listens on every interface
+func isUnspecified(host string) bool {
+	ip, err := netip.ParseAddr(host)
+	return host == "" || err == nil && ip.IsUnspecified()
+}
+
+// interfaceIP returns the first IP address of the machine's interfaces that isn't a loopback or link-local one,
+// preferring IPv4
+func interfaceIP() (netip.Addr, error) {
+	ifaceAddrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	var v6 netip.Addr
+	for _, a := range ifaceAddrs {
+		ipNet, ok := a.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		ip, ok := netip.AddrFromSlice(ipNet.IP)
+		if !ok || ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsMulticast() {
+			continue
+		}
+		if ip = ip.Unmap(); ip.Is4() {
+			return ip, nil
+		}
+		if !v6.IsValid() {
+			v6 = ip
+		}
+	}
+	if !v6.IsValid() {
+		return netip.Addr{}, errors.New("no interface has an address other than loopback")
+	}
+	return v6, nil
+}
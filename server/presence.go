@@ -0,0 +1,127 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+
+	"gmathur.dev/gossiper/gossip"
+)
+
+// PresenceConfig controls the tracking of which node hosts each player's session, see Connect
+type PresenceConfig struct {
+	TTL           time.Duration // a session that isn't heartbeated again within this long expires
+	Interval      time.Duration // time between pushes of changed sessions to peers
+	Fanout        int           // peers pushed to every interval
+	FullSyncEvery int           // every Nth push to a peer sends every session instead of the changes
+}
+
+// DefaultPresenceConfig expires sessions after 30 seconds without a heartbeat and pushes changes to 2 peers a second
+func DefaultPresenceConfig() PresenceConfig {
+	return PresenceConfig{TTL: 30 * time.Second, Interval: time.Second, Fanout: 2, FullSyncEvery: 10}
+}
+
+// Session is a player's connection to a game server, and the node that hosts it
+type Session struct {
+	PlayerId string    `json:"playerId"`
+	Node     string    `json:"node"`              // ID of the node hosting the session
+	Address  string    `json:"address"`           // address of the node hosting the session
+	Session  string    `json:"session,omitempty"` // the game server's ID for the connection
+	Since    time.Time `json:"since"`             // when the session connected to its node
+	Expires  time.Time `json:"expires"`           // when the session expires unless it is heartbeated again
+}
+
+// session is the value the presence store holds for a player; the expiry is the entry's TTL
+type session struct {
+	Node    string    `json:"node"`
+	Address string    `json:"address"`
+	Session string    `json:"session,omitempty"`
+	Since   time.Time `json:"since"`
+}
+
+// Connect records that a player's session is hosted by this node, for PresenceConfig.TTL. Call it again, as a
+// heartbeat, before the TTL runs out to keep the session; without heartbeats the session expires everywhere.
+// A player is hosted by one node at a time: connecting it on another node moves it there, and the latest
+// connection wins wherever they meet
+func (gs *GameServer) Connect(playerId, sessionId string) (Session, error) {
+	e, err := gs.sessions.Update(playerId, gs.Presence.TTL, func(current []byte, clock uint64) ([]byte, error) {
+		s := session{Node: gs.ID, Address: gs.Address, Session: sessionId, Since: gs.sessions.Now()}
+		var prev session
+		if current != nil && json.Unmarshal(current, &prev) == nil && prev.Node == s.Node && prev.Session == s.Session {
+			s.Since = prev.Since
+		}
+		return json.Marshal(s)
+	})
+	if err != nil {
+		return Session{}, err
+	}
+	return newSession(playerId, e)
+}
+
+// Disconnect ends a player's session on this node before it expires. Given a sessionId it only ends that
+// session. It reports false if the node doesn't host the session
+func (gs *GameServer) Disconnect(playerId, sessionId string) bool {
+	s, ok := gs.Lookup(playerId)
+	if !ok || s.Node != gs.ID || sessionId != "" && s.Session != sessionId {
+		return false
+	}
+	gs.sessions.Delete(playerId)
+	return true
+}
+
+// Lookup returns the session of a player, and so which node hosts it, as far as this node has heard
+func (gs *GameServer) Lookup(playerId string) (Session, bool) {
+	e, ok := gs.sessions.Get(playerId)
+	if !ok {
+		return Session{}, false
+	}
+	s, err := newSession(playerId, e)
+	if err != nil || !s.Expires.After(gs.sessions.Now()) {
+		return Session{}, false
+	}
+	return s, true
+}
+
+// Sessions returns the sessions hosted by the node with the given ID, or every session if node is empty, by
+// player ID
+func (gs *GameServer) Sessions(node string) []Session {
+	now := gs.sessions.Now()
+	var sessions []Session
+	gs.sessions.Range(func(playerId string, e gossip.Entry) bool {
+		if s, err := newSession(playerId, e); err == nil && (node == "" || s.Node == node) && s.Expires.After(now) {
+			sessions = append(sessions, s)
+		}
+		return true
+	})
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].PlayerId < sessions[j].PlayerId })
+	return sessions
+}
+
+func newSession(playerId string, e gossip.Entry) (Session, error) {
+	var s session
+	if err := json.Unmarshal(e.Value, &s); err != nil {
+		return Session{}, err
+	}
+	return Session{PlayerId: playerId, Node: s.Node, Address: s.Address, Session: s.Session, Since: s.Since,
+		Expires: gossip.HLCWallTime(e.Clock).Add(time.Duration(e.TTL) * time.Second)}, nil
+}
+
+// MergePresence merges sessions pushed by a peer
+func (gs *GameServer) MergePresence(incoming map[string]gossip.Entry) gossip.MergeStats {
+	return gs.sessions.Merge(incoming)
+}
+
+// presenceLoop expires sessions and pushes the ones that changed to a few random peers every interval
+func (gs *GameServer) presenceLoop(ctx context.Context) {
+	gs.storeGossipLoop(ctx, gs.presencePeers, gs.Presence.Interval, gs.Presence.Fanout, gs.Presence.FullSyncEvery, gs.Metrics.PresencePushes)
+}
+
+// ValidateSessionId checks a session ID given to Connect
+func ValidateSessionId(sessionId string) error {
+	if len(sessionId) > 256 {
+		return errors.New("session must be at most 256 bytes")
+	}
+	return nil
+}
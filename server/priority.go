@@ -0,0 +1,46 @@
+package server
+
+import (
+	"gmathur.dev/gossiper/gossip"
+)
+
+// WriteOption changes how UpdatePlayer and UpdatePlayerIf write a player
+type WriteOption func(*writeOptions)
+
+type writeOptions struct {
+	priority *gossip.Priority
+}
+
+// WithPriority sets the player's priority, see gossip.Priority. Writes without it keep the priority the player has
+func WithPriority(priority gossip.Priority) WriteOption {
+	return func(o *writeOptions) { o.priority = &priority }
+}
+
+// SetPlayerPriority sets a player's priority, leaving its score and attributes as they are. A player that doesn't
+// exist yet is created with a score of 0, as by SetPlayerAttributes
+func (gs *GameServer) SetPlayerPriority(playerId string, priority gossip.Priority) error {
+	return gs.UpdatePlayer(playerId, nil, nil, gs.EntryTTL, WithPriority(priority))
+}
+
+// sendHighPriority adds to a message the high-priority entries changed above its version, which a MaxPayload or
+// chunk limit left for later rounds, so that they reach the peer in this one. They are returned as sent ahead of
+// their turn, see recordAheadLocked
+func (gs *GameServer) sendHighPriority(peerAddr string, msg *GossipMessage) map[string]uint64 {
+	if msg.Version >= gs.State.Version() {
+		return nil
+	}
+	extra := GossipMessage{State: gs.State.PriorityDelta(msg.Version, gossip.PriorityHigh)}
+	if len(extra.State) == 0 {
+		return nil
+	}
+	gs.filterForPeer(peerAddr, &extra)
+	ahead := make(map[string]uint64, len(extra.State))
+	for key, e := range extra.State {
+		if msg.State == nil {
+			msg.State = make(map[string]gossip.Entry)
+		}
+		msg.State[key] = e
+		ahead[key] = e.Clock
+	}
+	return ahead
+}
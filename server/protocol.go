@@ -0,0 +1,84 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Gossip protocol versions. A node speaks every version from MinProtocolVersion to ProtocolVersion and talks to
+// each peer in the highest version both sides speak, so a cluster can be upgraded one node at a time as long as
+// consecutive releases share a version. Bump ProtocolVersion for any change older nodes would misread, and raise
+// MinProtocolVersion only once no release still in use needs the old one
+//
+// Version 1 is the original protocol, from before versions were negotiated: its messages carry no version.
+// Version 2 stamps every gossip message with the version it was encoded in
+const (
+	ProtocolVersion    = 2
+	MinProtocolVersion = 1
+)
+
+// ProtocolHeader carries the range of protocol versions a node speaks, as "min-max", on every peer request and
+// response. Exchanging it on the first request to a peer is the handshake; a peer that doesn't send it speaks
+// version 1
+const ProtocolHeader = "X-Gossiper-Protocol"
+
+// ErrIncompatibleProtocol is returned when a peer shares no protocol version with this node
+var ErrIncompatibleProtocol = errors.New("incompatible gossip protocol")
+
+// ProtocolRange is the range of versions a node speaks
+type ProtocolRange struct {
+	Min, Max uint32
+}
+
+// LocalProtocols is the range of versions this node speaks
+var LocalProtocols = ProtocolRange{Min: MinProtocolVersion, Max: ProtocolVersion}
+
+func (r ProtocolRange) String() string {
+	return fmt.Sprintf("%d-%d", r.Min, r.Max)
+}
+
+// ParseProtocolRange parses a ProtocolHeader value. An empty value is a node that predates versioning
+func ParseProtocolRange(s string) (ProtocolRange, error) {
+	if s == "" {
+		return ProtocolRange{Min: 1, Max: 1}, nil
+	}
+	lo, hi, found := strings.Cut(strings.TrimSpace(s), "-")
+	if !found {
+		hi = lo
+	}
+	from, err1 := strconv.ParseUint(lo, 10, 32)
+	to, err2 := strconv.ParseUint(hi, 10, 32)
+	if err1 != nil || err2 != nil || from == 0 || from > to {
+		return ProtocolRange{}, fmt.Errorf("invalid protocol range %q", s)
+	}
+	return ProtocolRange{Min: uint32(from), Max: uint32(to)}, nil
+}
+
+// NegotiateProtocol returns the highest version both this node and a peer speaking the given range speak
+func NegotiateProtocol(peer ProtocolRange) (uint32, error) {
+	version := min(peer.Max, ProtocolVersion)
+	if version < max(peer.Min, MinProtocolVersion) {
+		return 0, fmt.Errorf("%w: peer speaks %s, this node speaks %s", ErrIncompatibleProtocol, peer, LocalProtocols)
+	}
+	return version, nil
+}
+
+// CheckProtocol returns an error if a message's Protocol field is a version this node can't read. Version 1
+// messages have none
+func CheckProtocol(msg GossipMessage) error {
+	version := max(msg.Protocol, 1)
+	if version < MinProtocolVersion || version > ProtocolVersion {
+		return fmt.Errorf("%w: message is version %d, this node speaks %s", ErrIncompatibleProtocol, version, LocalProtocols)
+	}
+	return nil
+}
+
+// stampProtocol marks a message with the version it is sent in. Version 1 predates the field, so it is left out
+func stampProtocol(msg *GossipMessage, version uint32) {
+	msg.Protocol = 0
+	if version >= 2 {
+		msg.Protocol = version
+	}
+}
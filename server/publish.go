@@ -0,0 +1,158 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"sync"
+	"time"
+
+	"gmathur.dev/gossiper/gossip"
+	"gmathur.dev/gossiper/publish"
+)
+
+// PublishConfig tunes how changes are handed to the Publisher: in batches of up to BatchSize, every FlushInterval
+// or as soon as a batch is full. A batch the broker fails to take is retried every FlushInterval, and while it is
+// down changes wait in a queue that drops the oldest beyond MaxQueue
+type PublishConfig struct {
+	BatchSize     int
+	FlushInterval time.Duration // a second if not positive
+	MaxQueue      int
+}
+
+// DefaultPublishConfig publishes every second in batches of up to 100, holding up to 100000 changes while the
+// broker is down
+func DefaultPublishConfig() PublishConfig {
+	return PublishConfig{BatchSize: 100, FlushInterval: time.Second, MaxQueue: 100000}
+}
+
+// PublishedChange is the value of every message published, keyed by the player's ID. Only changes made on this
+// node are published, so across the cluster each change is published once, by the node it was made on; a
+// deletion is published even if the node hadn't heard of the player yet. Expiry happens on every node holding a
+// player and isn't published; updates carry their TTL instead
+type PublishedChange struct {
+	Type     string       `json:"type"` // PlayerUpdated or PlayerDeleted
+	PlayerId string       `json:"playerId"`
+	State    *PlayerState `json:"state,omitempty"` // new state of an updated player
+	Node     string       `json:"node"`            // ID of the node the change was made on
+	Time     time.Time    `json:"time"`
+}
+
+// publishQueue holds the changes waiting for the publisher
+type publishQueue struct {
+	mu      sync.Mutex
+	queue   []publish.Message
+	dropped int // changes dropped since the last publish
+	wake    chan struct{}
+}
+
+func newPublishQueue() *publishQueue {
+	return &publishQueue{wake: make(chan struct{}, 1)}
+}
+
+// queueChange is a gossip.Store OnChange observer queueing local updates and deletions for the publisher
+func (gs *GameServer) queueChange(c gossip.Change) {
+	if gs.Publisher == nil || c.Source != gossip.ChangeLocal || (c.New.Deleted && c.Existed && c.Old.Deleted) {
+		return
+	}
+	change := PublishedChange{Type: PlayerUpdated, PlayerId: c.Key, State: playerStateOf(c.New), Node: gs.ID,
+		Time: time.Now()}
+	if c.New.Deleted {
+		change.Type = PlayerDeleted
+	}
+	value, err := json.Marshal(change)
+	if err != nil {
+		gs.Logger.Error("failed to encode change for publishing", "player", c.Key, "err", err)
+		return
+	}
+
+	q := gs.published
+	q.mu.Lock()
+	q.queue = append(q.queue, publish.Message{Key: c.Key, Value: value})
+	q.trimLocked(gs.Publishing.MaxQueue)
+	full := len(q.queue) >= gs.Publishing.BatchSize
+	q.mu.Unlock()
+
+	if full {
+		select {
+		case q.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// publishTimeout bounds publishing the changes still queued once the node is shutting down
+const publishTimeout = 5 * time.Second
+
+// publishLoop hands queued changes to the publisher until ctx is done, then publishes what is left
+func (gs *GameServer) publishLoop(ctx context.Context) {
+	interval := gs.Publishing.FlushInterval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			flushCtx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+			defer cancel()
+			if err := gs.publishQueued(flushCtx); err != nil {
+				gs.Logger.Warn("failed to publish changes on shutdown", "queued", gs.published.len(), "err", err)
+			}
+			return
+		case <-ticker.C:
+		case <-gs.published.wake:
+		}
+		if err := gs.publishQueued(ctx); err != nil && ctx.Err() == nil {
+			gs.Logger.Warn("failed to publish changes, retrying", "queued", gs.published.len(), "err", err)
+		}
+	}
+}
+
+// publishQueued publishes the queue a batch at a time. A batch that fails goes back at the head of the queue, to
+// be retried
+func (gs *GameServer) publishQueued(ctx context.Context) error {
+	q := gs.published
+	for {
+		q.mu.Lock()
+		n := min(len(q.queue), max(gs.Publishing.BatchSize, 1))
+		batch := q.queue[:n:n]
+		q.queue = q.queue[n:]
+		dropped := q.dropped
+		q.dropped = 0
+		q.mu.Unlock()
+
+		if dropped > 0 {
+			gs.Metrics.PublishDropped.With().Add(float64(dropped))
+			gs.Logger.Warn("dropped changes, the publish queue was full", "count", dropped)
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := gs.Publisher.Publish(ctx, batch); err != nil {
+			gs.Metrics.Published.With("failed").Add(float64(len(batch)))
+			q.mu.Lock()
+			q.queue = slices.Concat(batch, q.queue)
+			q.trimLocked(gs.Publishing.MaxQueue)
+			q.mu.Unlock()
+			return err
+		}
+		gs.Metrics.Published.With("ok").Add(float64(len(batch)))
+	}
+}
+
+// trimLocked drops the oldest changes beyond max
+func (q *publishQueue) trimLocked(max int) {
+	if over := len(q.queue) - max; max > 0 && over > 0 {
+		q.queue = q.queue[over:]
+		q.dropped += over
+	}
+}
+
+func (q *publishQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.queue)
+}
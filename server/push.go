@@ -0,0 +1,100 @@
+package server
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"gmathur.dev/gossiper/gossip"
+	"gmathur.dev/gossiper/metrics"
+)
+
+// storeGossip spreads one of the node's small side stores, such as the sessions, apart from the players' state:
+// every interval the entries changed since the last successful push to a peer are pushed to a few random peers,
+// which merge them into the store named kind, see PeerTransport.SendStore
+type storeGossip struct {
+	name  string // what the store holds, for logs
+	kind  string // StoreSessions or another of the store names
+	store *gossip.Store
+
+	mu     sync.Mutex
+	sent   map[string]uint64 // highest store version successfully pushed to each peer
+	rounds map[string]int    // pushes to each peer, to schedule full syncs
+}
+
+func newStoreGossip(name, kind string, store *gossip.Store) *storeGossip {
+	return &storeGossip{name: name, kind: kind, store: store, sent: make(map[string]uint64), rounds: make(map[string]int)}
+}
+
+func (p *storeGossip) forget(addr string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.sent, addr)
+	delete(p.rounds, addr)
+}
+
+// storeGossipLoop expires the store's entries and pushes the ones that changed to fanout random peers every
+// interval, every entry to a peer every fullSyncEvery pushes, counting pushes by result in pushes
+func (gs *GameServer) storeGossipLoop(ctx context.Context, p *storeGossip, interval time.Duration, fanout, fullSyncEvery int, pushes *metrics.CounterVec) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		now := p.store.Now()
+		if n := p.store.ExpireEntries(now); n > 0 {
+			gs.Logger.Debug("expired "+p.name, "count", n)
+		}
+		if gs.TombstoneTTL > 0 {
+			p.store.CollectTombstones(now.Add(-gs.TombstoneTTL))
+		}
+
+		peers := gs.Membership.Peers()
+		rand.Shuffle(len(peers), func(i, j int) { peers[i], peers[j] = peers[j], peers[i] })
+		for _, peer := range peers[:min(fanout, len(peers))] {
+			pushed, err := gs.pushStore(ctx, p, peer, fullSyncEvery)
+			switch {
+			case err != nil:
+				pushes.With("failed").Inc()
+				gs.Logger.Debug("failed to push "+p.name, "peer", peer, "err", err)
+			case pushed:
+				pushes.With("ok").Inc()
+			}
+		}
+	}
+}
+
+// pushStore sends a peer the entries that changed since the last successful push, or all of them every
+// fullSyncEvery pushes. It reports false if there was nothing to send
+func (gs *GameServer) pushStore(ctx context.Context, p *storeGossip, peer string, fullSyncEvery int) (bool, error) {
+	p.mu.Lock()
+	since := p.sent[peer]
+	p.rounds[peer]++
+	if fullSyncEvery > 0 && p.rounds[peer]%fullSyncEvery == 0 {
+		since = 0
+	}
+	p.mu.Unlock()
+
+	delta, version := p.store.Delta(since)
+	if len(delta) == 0 {
+		return false, nil
+	}
+	if err := gs.sendStore(ctx, peer, p.kind, delta); err != nil {
+		return false, err
+	}
+	p.mu.Lock()
+	p.sent[peer] = max(p.sent[peer], version)
+	p.mu.Unlock()
+	return true, nil
+}
+
+func (gs *GameServer) sendStore(ctx context.Context, peer, kind string, delta map[string]gossip.Entry) error {
+	ctx, cancel := context.WithTimeout(ctx, gs.gossipConfig().Timeout)
+	defer cancel()
+	return gs.peerTransport().SendStore(ctx, peer, kind, delta)
+}
@@ -0,0 +1,224 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"gmathur.dev/gossiper/gossip"
+)
+
+// RoomSeparator joins a room ID and a player ID into the key of the player's entry. Player IDs can't contain it,
+// so a key without one is a player outside any room
+const RoomSeparator = "/"
+
+// RoomKey returns the key of a player of a room in the state, the player ID itself for the room "", which holds
+// the players outside any room. Every method that takes a player ID takes a key, so rooms need no API of their own
+// besides RoomLeaderboard and PlayerQuery.Room. Each room is a state map of its own: its players, leaderboard and
+// pages are separate from every other room's
+func RoomKey(roomId, playerId string) string {
+	if roomId == "" {
+		return playerId
+	}
+	return roomId + RoomSeparator + playerId
+}
+
+// SplitRoomKey returns the room and player ID of a key
+func SplitRoomKey(key string) (roomId, playerId string) {
+	if room, playerId, ok := strings.Cut(key, RoomSeparator); ok {
+		return room, playerId
+	}
+	return "", key
+}
+
+// RoomOf returns the room of a key
+func RoomOf(key string) string {
+	room, _ := SplitRoomKey(key)
+	return room
+}
+
+// setRooms replaces the rooms this node advertises as holding, bumping its incarnation if they changed
+func (ms *Membership) setRooms(rooms []string) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if !slices.Equal(ms.rooms, rooms) {
+		ms.rooms = rooms
+		ms.incarnation++
+	}
+}
+
+// ownRooms returns the rooms this node advertises as holding
+func (ms *Membership) ownRooms() []string {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	return ms.rooms
+}
+
+// ValidateRooms checks the rooms a node is to hold. A room ending in '*' stands for every room whose ID starts with
+// the rest of it, such as match-* for the rooms of every match
+func ValidateRooms(rooms []string) error {
+	for _, room := range rooms {
+		switch pattern := strings.TrimSuffix(room, "*"); {
+		case room == "":
+			return errors.New("room is empty")
+		case strings.Contains(room, RoomSeparator):
+			return fmt.Errorf("room %q may not contain %q", room, RoomSeparator)
+		case strings.Contains(pattern, "*"):
+			return fmt.Errorf("room %q may only have '*' at the end", room)
+		}
+	}
+	return nil
+}
+
+// roomsMatch reports whether a node holding rooms holds room: the players outside any room are held everywhere,
+// and a node that names no rooms holds every room
+func roomsMatch(rooms []string, room string) bool {
+	if room == "" || len(rooms) == 0 {
+		return true
+	}
+	for _, pattern := range rooms {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok && strings.HasPrefix(room, prefix) || pattern == room {
+			return true
+		}
+	}
+	return false
+}
+
+// HeldRooms returns the rooms this node holds, see SetRooms
+func (gs *GameServer) HeldRooms() []string {
+	return slices.Clone(gs.Membership.ownRooms())
+}
+
+// SetRooms replaces the rooms this node holds, and advertises them with its membership entry: an interest in the
+// state of those rooms. An empty list holds every room. Peers gossip the node only entries of the rooms it holds,
+// and once they hear of a change send it those in full, so a room taken on arrives without waiting for
+// anti-entropy; entries of rooms it has let go are no longer gossiped to it, but the ones it has are kept
+func (gs *GameServer) SetRooms(rooms []string) error {
+	if err := ValidateRooms(rooms); err != nil {
+		return err
+	}
+	rooms = sortedRooms(rooms)
+	gs.Membership.setRooms(rooms)
+	gs.Logger.Info("holding rooms", "rooms", rooms)
+	return nil
+}
+
+// sortedRooms returns a sorted copy of rooms without duplicates, nil for none
+func sortedRooms(rooms []string) []string {
+	if len(rooms) == 0 {
+		return nil
+	}
+	rooms = slices.Clone(rooms)
+	slices.Sort(rooms)
+	return slices.Compact(rooms)
+}
+
+// holdsRoom reports whether this node holds the room's players
+func (gs *GameServer) holdsRoom(room string) bool {
+	return roomsMatch(gs.Membership.ownRooms(), room)
+}
+
+// advertisedRooms returns the rooms a peer advertises holding, nil if it holds all of them or isn't a member we know of
+func (gs *GameServer) advertisedRooms(peerAddr string) []string {
+	for _, m := range gs.Membership.Members() {
+		if m.Address == peerAddr {
+			return m.Rooms
+		}
+	}
+	return nil
+}
+
+// RoomHolders returns the alive and suspect members that hold a room, this node included, or nil if every one of
+// them does
+func (gs *GameServer) RoomHolders(room string) []string {
+	var holders []string
+	restricted := false
+	for _, m := range gs.Membership.Members() {
+		if m.Status != MemberAlive && m.Status != MemberSuspect {
+			continue
+		}
+		if !roomsMatch(m.Rooms, room) {
+			restricted = true
+			continue
+		}
+		holders = append(holders, m.Address)
+	}
+	if !restricted {
+		return nil
+	}
+	slices.Sort(holders)
+	return holders
+}
+
+// roomsFiltered reports whether gossip with a peer leaves out some rooms, because either side holds only some
+func (gs *GameServer) roomsFiltered(peerAddr string) bool {
+	return len(gs.Membership.ownRooms()) > 0 || len(gs.advertisedRooms(peerAddr)) > 0
+}
+
+// PlayerHolders returns the nodes a request for the player of the given key is best served by: the holders of its
+// shard that hold its room as well, the owner first. It returns nil if any node may serve it
+func (gs *GameServer) PlayerHolders(key string) []string {
+	owners := gs.ShardOwners(key)
+	room := RoomOf(key)
+	if gs.holdsRoom(room) && (owners == nil || slices.Contains(owners, gs.Address)) {
+		return owners
+	}
+	holders := gs.RoomHolders(room)
+	switch {
+	case owners == nil:
+		return holders
+	case holders == nil:
+		return owners
+	}
+	return slices.DeleteFunc(owners, func(addr string) bool { return !slices.Contains(holders, addr) })
+}
+
+// dropUnheldRooms leaves out of entries merged from a peer the ones of rooms this node doesn't hold, which a peer
+// sends until it hears which rooms we hold
+func (gs *GameServer) dropUnheldRooms(incoming map[string]gossip.Entry) map[string]gossip.Entry {
+	rooms := gs.Membership.ownRooms()
+	if len(rooms) == 0 {
+		return incoming
+	}
+	kept := make(map[string]gossip.Entry, len(incoming))
+	for key, e := range incoming {
+		if room := RoomOf(key); !roomsMatch(rooms, room) {
+			gs.Metrics.RoomEntries.With(room, "dropped").Inc()
+			continue
+		}
+		kept[key] = e
+	}
+	return kept
+}
+
+// countRooms counts the entries of a message by room
+func (gs *GameServer) countRooms(direction string, state map[string]gossip.Entry) {
+	counts := make(map[string]int)
+	for key := range state {
+		counts[RoomOf(key)]++
+	}
+	for room, n := range counts {
+		gs.Metrics.RoomEntries.With(room, direction).Add(float64(n))
+	}
+}
+
+// RoomSummary is a room this node has players of
+type RoomSummary struct {
+	RoomId  string `json:"roomId"`
+	Players int    `json:"players"`
+	Held    bool   `json:"held"` // false for a room the node has let go of, or whose players were written here while none of its holders answered
+}
+
+// RoomSummaries returns the rooms this node has players of, by room ID, the players outside any room included as
+// the room ""
+func (gs *GameServer) RoomSummaries() []RoomSummary {
+	sizes := gs.index.roomSizes()
+	held := gs.Membership.ownRooms()
+	rooms := make([]RoomSummary, 0, len(sizes))
+	for room, n := range sizes {
+		rooms = append(rooms, RoomSummary{RoomId: room, Players: n, Held: roomsMatch(held, room)})
+	}
+	slices.SortFunc(rooms, func(a, b RoomSummary) int { return strings.Compare(a.RoomId, b.RoomId) })
+	return rooms
+}
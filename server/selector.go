@@ -0,0 +1,146 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PeerCandidate is a peer a gossip round may pick
+type PeerCandidate struct {
+	Address    string
+	LastPicked time.Time // last time a round picked the peer, zero if none has
+}
+
+// PeerSelector decides which peers each gossip round goes to. Select returns the candidates in the order the
+// round should try them; the round gossips with the first n of them that their circuit breakers let through, so
+// a selector should return every candidate rather than only n
+type PeerSelector interface {
+	Select(candidates []PeerCandidate, n int) []string
+}
+
+// ParsePeerSelector converts a strategy name (as given on the command line) into a PeerSelector. Weights are only
+// used by "weighted", see WeightedSelector
+func ParsePeerSelector(name string, weights map[string]float64) (PeerSelector, error) {
+	switch name {
+	case "random":
+		return RandomSelector{}, nil
+	case "round-robin":
+		return &RoundRobinSelector{}, nil
+	case "least-recent":
+		return LeastRecentSelector{}, nil
+	case "weighted":
+		return WeightedSelector{Weights: weights}, nil
+	default:
+		return nil, fmt.Errorf("unknown peer selection strategy %q", name)
+	}
+}
+
+// ParsePeerWeights parses a comma separated list of addr=weight pairs
+func ParsePeerWeights(s string) (map[string]float64, error) {
+	weights := make(map[string]float64)
+	if s == "" {
+		return weights, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		addr, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			return nil, fmt.Errorf("peer weight %q is not addr=weight", pair)
+		}
+		w, err := strconv.ParseFloat(value, 64)
+		if err != nil || w < 0 || math.IsInf(w, 0) {
+			return nil, fmt.Errorf("invalid weight %q for peer %s", value, addr)
+		}
+		weights[addr] = w
+	}
+	return weights, nil
+}
+
+// RandomSelector picks peers uniformly at random. Over many rounds every peer is picked equally often, but any
+// one peer can go a long while without being picked
+type RandomSelector struct{}
+
+func (RandomSelector) Select(candidates []PeerCandidate, n int) []string {
+	peers := addresses(candidates)
+	rand.Shuffle(len(peers), func(i, j int) { peers[i], peers[j] = peers[j], peers[i] })
+	return peers
+}
+
+// RoundRobinSelector walks the peers in address order, carrying on each round after the ones picked last. Every
+// peer is picked once every len(peers)/n rounds, but nodes started together visit peers in the same order
+type RoundRobinSelector struct {
+	mu   sync.Mutex
+	last string // last peer handed out, the next round starts after it
+}
+
+func (s *RoundRobinSelector) Select(candidates []PeerCandidate, n int) []string {
+	peers := addresses(candidates)
+	sort.Strings(peers)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	start, _ := slices.BinarySearch(peers, s.last)
+	if start < len(peers) && peers[start] == s.last {
+		start++
+	}
+	peers = append(peers[start:], peers[:start]...)
+	if len(peers) > 0 {
+		s.last = peers[min(n, len(peers))-1]
+	}
+	return peers
+}
+
+// LeastRecentSelector picks the peers that have gone longest without being picked, so no peer waits more than a
+// few rounds and convergence time stays predictable. Ties, such as peers never picked, are broken at random. It
+// is the default
+type LeastRecentSelector struct{}
+
+func (LeastRecentSelector) Select(candidates []PeerCandidate, n int) []string {
+	shuffled := slices.Clone(candidates)
+	rand.Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+	sort.SliceStable(shuffled, func(i, j int) bool { return shuffled[i].LastPicked.Before(shuffled[j].LastPicked) })
+	return addresses(shuffled)
+}
+
+// WeightedSelector picks peers at random in proportion to their weights, for example to favour peers in the same
+// zone. Peers missing from Weights have weight Default, or 1 if Default is 0; a weight of 0 puts a peer last
+type WeightedSelector struct {
+	Weights map[string]float64
+	Default float64
+}
+
+func (s WeightedSelector) Select(candidates []PeerCandidate, n int) []string {
+	// Each peer draws u^(1/w) for a uniform u; sorting by the draws gives a weighted random order
+	keys := make(map[string]float64, len(candidates))
+	for _, c := range candidates {
+		w, ok := s.Weights[c.Address]
+		if !ok {
+			w = s.Default
+			if w == 0 {
+				w = 1
+			}
+		}
+		if w > 0 {
+			keys[c.Address] = math.Pow(rand.Float64(), 1/w)
+		} else {
+			keys[c.Address] = -1
+		}
+	}
+	peers := addresses(candidates)
+	sort.Slice(peers, func(i, j int) bool { return keys[peers[i]] > keys[peers[j]] })
+	return peers
+}
+
+func addresses(candidates []PeerCandidate) []string {
+	peers := make([]string, len(candidates))
+	for i, c := range candidates {
+		peers[i] = c.Address
+	}
+	return peers
+}
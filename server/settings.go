@@ -0,0 +1,313 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gmathur.dev/gossiper/gossip"
+)
+
+// Limits on a setting
+const (
+	MaxSettingKeyLen   = 128
+	MaxSettingValueLen = 1024
+)
+
+// Settings the node applies itself. They override the node's own GossipConfig for as long as they are set, and it
+// goes back to its own values once they are removed. Other settings, such as feature flags, are left to
+// OnSettingChange callbacks and readers of Setting
+const (
+	SettingGossipInterval = "gossip-interval"
+	SettingGossipFanout   = "gossip-fanout"
+	SettingGossipJitter   = "gossip-jitter"
+	SettingGossipWorkers  = "gossip-workers"
+	SettingGossipTimeout  = "gossip-timeout"
+)
+
+// SettingsConfig controls the gossip of cluster-wide settings, see SetSetting
+type SettingsConfig struct {
+	Interval      time.Duration // time between pushes of changed settings to peers
+	Fanout        int           // peers pushed to every interval
+	FullSyncEvery int           // every Nth push to a peer sends every setting instead of the changes
+}
+
+// DefaultSettingsConfig pushes changed settings to 2 peers a second
+func DefaultSettingsConfig() SettingsConfig {
+	return SettingsConfig{Interval: time.Second, Fanout: 2, FullSyncEvery: 10}
+}
+
+// Setting is a cluster-wide setting
+type Setting struct {
+	Key     string    `json:"key"`
+	Value   string    `json:"value"`
+	Origin  string    `json:"origin"` // ID of the node it was set on
+	Updated time.Time `json:"updated"`
+}
+
+// SettingChange is passed to OnSettingChange callbacks
+type SettingChange struct {
+	Key string
+	Old string // "" if the setting wasn't set
+	New string // "" if the setting was removed
+}
+
+// settingsWatch applies changes to the settings, from whichever node they were made on
+type settingsWatch struct {
+	mu      sync.Mutex
+	applied map[string]string // the settings as of the last change applied
+	subs    []settingSubscriber
+	nextId  uint64
+	wake    chan struct{}
+}
+
+type settingSubscriber struct {
+	id uint64
+	fn func(SettingChange)
+}
+
+func newSettingsWatch() *settingsWatch {
+	return &settingsWatch{applied: make(map[string]string), wake: make(chan struct{}, 1)}
+}
+
+// observe is a gossip.Store OnChange observer for the settings, which only wakes applySettingsLoop as the store is
+// locked while it runs
+func (w *settingsWatch) observe(gossip.Change) {
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// SetSetting sets a cluster-wide setting, such as a feature flag or one of the Setting constants, on this node; it
+// reaches every other node within a few SettingsConfig.Interval, and the last write wins where two nodes set it at
+// once. Values of the Setting constants are checked and applied straight away; other values are free-form
+func (gs *GameServer) SetSetting(key, value string) error {
+	if err := ValidateSetting(key, value); err != nil {
+		return err
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	gs.settings.Set(key, data, 0)
+	gs.Logger.Info("set cluster setting", "key", key, "value", value)
+	return nil
+}
+
+// RemoveSetting removes a cluster-wide setting, reporting false if it wasn't set
+func (gs *GameServer) RemoveSetting(key string) bool {
+	if _, ok := gs.settings.Get(key); !ok {
+		return false
+	}
+	gs.settings.Delete(key)
+	gs.Logger.Info("removed cluster setting", "key", key)
+	return true
+}
+
+// Setting returns the value of a cluster-wide setting as far as this node has heard
+func (gs *GameServer) Setting(key string) (string, bool) {
+	e, ok := gs.settings.Get(key)
+	if !ok {
+		return "", false
+	}
+	var value string
+	if err := json.Unmarshal(e.Value, &value); err != nil {
+		return "", false
+	}
+	return value, true
+}
+
+// Settings returns the cluster-wide settings as far as this node has heard, by key
+func (gs *GameServer) Settings() []Setting {
+	settings := []Setting{}
+	gs.settings.Range(func(key string, e gossip.Entry) bool {
+		var value string
+		if err := json.Unmarshal(e.Value, &value); err == nil {
+			settings = append(settings, Setting{Key: key, Value: value, Origin: e.Origin, Updated: gossip.HLCWallTime(e.Clock)})
+		}
+		return true
+	})
+	sort.Slice(settings, func(i, j int) bool { return settings[i].Key < settings[j].Key })
+	return settings
+}
+
+// OnSettingChange calls fn for every change to a cluster-wide setting, made on this node or any other. Calls are
+// made one at a time from a goroutine run by Start. Call the returned function to unsubscribe
+func (gs *GameServer) OnSettingChange(fn func(SettingChange)) (unsubscribe func()) {
+	w := gs.settingsWatch
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.nextId++
+	id := w.nextId
+	w.subs = append(w.subs, settingSubscriber{id: id, fn: fn})
+
+	return func() {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		for i, sub := range w.subs {
+			if sub.id == id {
+				w.subs = append(w.subs[:i:i], w.subs[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// MergeSettings merges settings pushed by a peer
+func (gs *GameServer) MergeSettings(incoming map[string]gossip.Entry) gossip.MergeStats {
+	return gs.settings.Merge(incoming)
+}
+
+// settingsLoop pushes the settings that changed to a few random peers every interval
+func (gs *GameServer) settingsLoop(ctx context.Context) {
+	cfg := gs.SettingsGossip
+	gs.storeGossipLoop(ctx, gs.settingsPeers, cfg.Interval, cfg.Fanout, cfg.FullSyncEvery, gs.Metrics.SettingsPushes)
+}
+
+// applySettingsLoop applies changes to the settings and calls OnSettingChange callbacks until ctx is done
+func (gs *GameServer) applySettingsLoop(ctx context.Context) {
+	w := gs.settingsWatch
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-w.wake:
+		}
+
+		current := make(map[string]string)
+		for _, s := range gs.Settings() {
+			current[s.Key] = s.Value
+		}
+		w.mu.Lock()
+		var changes []SettingChange
+		for key, value := range current {
+			if old := w.applied[key]; old != value {
+				changes = append(changes, SettingChange{Key: key, Old: old, New: value})
+			}
+		}
+		for key, old := range w.applied {
+			if _, ok := current[key]; !ok {
+				changes = append(changes, SettingChange{Key: key, Old: old})
+			}
+		}
+		w.applied = current
+		subs := w.subs
+		w.mu.Unlock()
+		if len(changes) == 0 {
+			continue
+		}
+
+		gs.applyGossipSettings(current)
+		sort.Slice(changes, func(i, j int) bool { return changes[i].Key < changes[j].Key })
+		for _, c := range changes {
+			gs.Logger.Info("cluster setting changed", "key", c.Key, "old", c.Old, "new", c.New)
+			for _, sub := range subs {
+				sub.fn(c)
+			}
+		}
+	}
+}
+
+// applyGossipSettings replaces the gossip overrides with those among the settings. A value that doesn't parse, only
+// possible from a node that doesn't know the setting, is ignored
+func (gs *GameServer) applyGossipSettings(settings map[string]string) {
+	var o GossipConfig
+	for key, value := range settings {
+		if !strings.HasPrefix(key, "gossip-") {
+			continue
+		}
+		if err := applyGossipSetting(&o, key, value); err != nil {
+			gs.Logger.Warn("ignoring cluster setting", "key", key, "value", value, "err", err)
+		}
+	}
+	gs.gossipMu.Lock()
+	gs.gossipOverrides = o
+	gs.gossipMu.Unlock()
+}
+
+// applyGossipSetting sets the field of o that a gossip setting is for
+func applyGossipSetting(o *GossipConfig, key, value string) error {
+	var err error
+	switch key {
+	case SettingGossipInterval:
+		o.Interval, err = parsePositiveDuration(value)
+	case SettingGossipJitter:
+		o.Jitter, err = parsePositiveDuration(value)
+	case SettingGossipTimeout:
+		o.Timeout, err = parsePositiveDuration(value)
+	case SettingGossipFanout:
+		o.Fanout, err = parsePositiveInt(value)
+	case SettingGossipWorkers:
+		o.Workers, err = parsePositiveInt(value)
+	}
+	return err
+}
+
+func parsePositiveDuration(value string) (time.Duration, error) {
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, errors.New("must be a positive duration such as 500ms")
+	}
+	return d, nil
+}
+
+func parsePositiveInt(value string) (int, error) {
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		return 0, errors.New("must be a positive integer")
+	}
+	return n, nil
+}
+
+// withOverrides returns cfg with the fields set in o replaced
+func (cfg GossipConfig) withOverrides(o GossipConfig) GossipConfig {
+	if o.Interval > 0 {
+		cfg.Interval = o.Interval
+	}
+	if o.Fanout > 0 {
+		cfg.Fanout = o.Fanout
+	}
+	if o.Jitter > 0 {
+		cfg.Jitter = o.Jitter
+	}
+	if o.Workers > 0 {
+		cfg.Workers = o.Workers
+	}
+	if o.Timeout > 0 {
+		cfg.Timeout = o.Timeout
+	}
+	return cfg
+}
+
+// ValidateSetting checks a setting about to be set, and the value of the Setting constants
+func ValidateSetting(key, value string) error {
+	switch {
+	case key == "":
+		return errors.New("key is empty")
+	case len(key) > MaxSettingKeyLen:
+		return fmt.Errorf("key must be at most %d bytes", MaxSettingKeyLen)
+	case value == "":
+		return errors.New("value is empty; remove the setting instead")
+	case len(value) > MaxSettingValueLen:
+		return fmt.Errorf("value must be at most %d bytes", MaxSettingValueLen)
+	}
+	if strings.HasPrefix(key, "gossip-") {
+		switch key {
+		case SettingGossipInterval, SettingGossipFanout, SettingGossipJitter, SettingGossipWorkers, SettingGossipTimeout:
+		default:
+			return fmt.Errorf("unknown gossip setting %q", key)
+		}
+		var o GossipConfig
+		if err := applyGossipSetting(&o, key, value); err != nil {
+			return fmt.Errorf("%s %v", key, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,290 @@
+package server
+
+import (
+	"context"
+	"hash/fnv"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+
+	"gmathur.dev/gossiper/gossip"
+)
+
+// ShardConfig partitions the state across the cluster instead of replicating all of it everywhere. Every player
+// belongs to one of Shards shards by the hash of its ID, and the shards are placed on a consistent hash ring of
+// the alive and suspect members, so a member joining or leaving only moves the shards next to it. A shard is held
+// by Replicas members: its owner and the next ones round the ring. Each node gossips a peer only the shards the
+// peer holds and, once every holder has them, drops the entries of shards it doesn't hold itself. Every node in
+// the cluster must use the same Shards, Replicas and VirtualNodes
+type ShardConfig struct {
+	Shards       int           // number of shards; 0 turns sharding off and every node holds everything
+	Replicas     int           // nodes holding each shard, the owner included; at least 1
+	VirtualNodes int           // points each member has on the ring; more spread the shards more evenly
+	DropAfter    time.Duration // how long after the shards last moved entries of shards not held are kept
+}
+
+// DefaultShardConfig leaves sharding off, with 2 replicas of each shard, 64 virtual nodes per member and a
+// minute's grace once it is on
+func DefaultShardConfig() ShardConfig {
+	return ShardConfig{Replicas: 2, VirtualNodes: 64, DropAfter: time.Minute}
+}
+
+// ShardOf returns the shard a player belongs to
+func (c ShardConfig) ShardOf(playerId string) int {
+	return int(hashString(playerId) % uint64(c.Shards))
+}
+
+// hashString hashes s with FNV-1a, then mixes the bits, since FNV alone leaves strings that differ only in their
+// last characters, like a member's virtual nodes, close together on the ring
+func hashString(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// shardRing is the placement of the shards on the members it was built from
+type shardRing struct {
+	members []string   // sorted addresses of the ring's members, this node included
+	owners  [][]string // nodes holding each shard, its owner first
+	changed time.Time  // when the placement last changed
+}
+
+type ringPoint struct {
+	hash uint64
+	addr string
+}
+
+// newShardRing places every shard on the ring of members: a shard is held by the first Replicas distinct members
+// at or after its hash
+func newShardRing(cfg ShardConfig, members []string) *shardRing {
+	vnodes := max(cfg.VirtualNodes, 1)
+	points := make([]ringPoint, 0, len(members)*vnodes)
+	for _, addr := range members {
+		for i := range vnodes {
+			points = append(points, ringPoint{hashString(addr + "#" + strconv.Itoa(i)), addr})
+		}
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i].hash < points[j].hash })
+
+	ring := &shardRing{members: members, owners: make([][]string, cfg.Shards), changed: time.Now()}
+	replicas := min(max(cfg.Replicas, 1), len(members))
+	for shard := range cfg.Shards {
+		h := hashString("shard#" + strconv.Itoa(shard))
+		i := sort.Search(len(points), func(i int) bool { return points[i].hash >= h })
+		var owners []string
+		for n := 0; len(owners) < replicas; n++ {
+			p := points[(i+n)%len(points)]
+			if !slices.Contains(owners, p.addr) {
+				owners = append(owners, p.addr)
+			}
+		}
+		ring.owners[shard] = owners
+	}
+	return ring
+}
+
+// holds reports whether the node at addr holds the shard
+func (r *shardRing) holds(addr string, shard int) bool {
+	return slices.Contains(r.owners[shard], addr)
+}
+
+// held returns the shards the node at addr holds
+func (r *shardRing) held(addr string) []int {
+	var shards []int
+	for shard := range r.owners {
+		if r.holds(addr, shard) {
+			shards = append(shards, shard)
+		}
+	}
+	return shards
+}
+
+// ringMembers returns the sorted addresses of the members that hold shards: every alive or suspect member and
+// this node. Dead members have their shards taken over, and get them back when they return
+func (ms *Membership) ringMembers() []string {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	members := append(ms.peersLocked(MemberAlive), ms.peersLocked(MemberSuspect)...)
+	if !ms.left {
+		members = append(members, ms.self)
+	}
+	slices.Sort(members)
+	return members
+}
+
+// sharded reports whether sharding is on
+func (gs *GameServer) sharded() bool {
+	return gs.Shards.Shards > 0
+}
+
+// setShards replaces the shards this node advertises as holding, bumping its incarnation if they changed
+func (ms *Membership) setShards(shards []int) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if !slices.Equal(ms.shards, shards) {
+		ms.shards = shards
+		ms.incarnation++
+	}
+}
+
+// shardRing returns the current placement of the shards, placing them again if the members have changed since.
+// When they have, every peer's delta watermark is reset, so that each node is sent the shards it has just taken
+// on from scratch, and the shards this node now holds are advertised with its membership entry. It returns nil
+// while sharding is off
+func (gs *GameServer) shardRing() *shardRing {
+	if !gs.sharded() {
+		return nil
+	}
+	members := gs.Membership.ringMembers()
+
+	gs.mu.Lock()
+	ring := gs.ring
+	if ring != nil && slices.Equal(ring.members, members) {
+		gs.mu.Unlock()
+		return ring
+	}
+	first := ring == nil
+	ring = newShardRing(gs.Shards, members)
+	gs.ring = ring
+	if !first {
+		clear(gs.peerSent)
+	}
+	gs.mu.Unlock()
+
+	held := ring.held(gs.Address)
+	gs.Membership.setShards(held)
+	if !first {
+		gs.Logger.Info("shards moved", "members", len(members), "held", len(held))
+	}
+	return ring
+}
+
+// ShardOwners returns the nodes holding a player's shard, its owner first, or nil while sharding is off
+func (gs *GameServer) ShardOwners(playerId string) []string {
+	ring := gs.shardRing()
+	if ring == nil {
+		return nil
+	}
+	return slices.Clone(ring.owners[gs.Shards.ShardOf(playerId)])
+}
+
+// PlayerOwnership reports where a player's state lives, see WhoIs
+type PlayerOwnership struct {
+	PlayerId string `json:"playerId"`
+	Shard    int    `json:"shard"`  // -1 while sharding is off
+	Shards   int    `json:"shards"` // shards in the cluster, 0 while sharding is off
+	// Nodes that hold the player by this node's placement of the shards, its owner first. While sharding is off
+	// that is every alive or suspect member
+	Owners []string `json:"owners"`
+	// Nodes that advertise holding the player's shard with their membership entry. It differs from Owners while a
+	// change of members is still spreading, or if nodes disagree about the shard settings
+	Advertised []string `json:"advertised,omitempty"`
+	Local      bool     `json:"local"` // this node has the player's state
+}
+
+// WhoIs reports which nodes hold a player's state
+func (gs *GameServer) WhoIs(playerId string) PlayerOwnership {
+	_, local := gs.State.Get(playerId)
+	ring := gs.shardRing()
+	if ring == nil {
+		return PlayerOwnership{PlayerId: playerId, Shard: -1, Owners: gs.Membership.ringMembers(), Local: local}
+	}
+
+	shard := gs.Shards.ShardOf(playerId)
+	return PlayerOwnership{
+		PlayerId:   playerId,
+		Shard:      shard,
+		Shards:     gs.Shards.Shards,
+		Owners:     slices.Clone(ring.owners[shard]),
+		Advertised: gs.Ownership()[shard],
+		Local:      local,
+	}
+}
+
+// Ownership returns the ownership map gossiped with the membership list: the sorted addresses of the alive and
+// suspect members that advertise holding each shard, this node included. It is empty while sharding is off
+func (gs *GameServer) Ownership() map[int][]string {
+	ownership := make(map[int][]string)
+	for _, m := range gs.Membership.Members() {
+		if m.Status != MemberAlive && m.Status != MemberSuspect {
+			continue
+		}
+		for _, shard := range m.Shards {
+			ownership[shard] = append(ownership[shard], m.Address)
+		}
+	}
+	for _, holders := range ownership {
+		slices.Sort(holders)
+	}
+	return ownership
+}
+
+// filterForPeer leaves out of a message the entries of shards and rooms the peer doesn't hold. The message's
+// version is kept, so the peer's watermark moves past the entries left out as well
+func (gs *GameServer) filterForPeer(peerAddr string, msg *GossipMessage) {
+	ring := gs.shardRing()
+	rooms := gs.advertisedRooms(peerAddr)
+	for key := range msg.State {
+		if ring != nil && !ring.holds(peerAddr, gs.Shards.ShardOf(key)) {
+			delete(msg.State, key)
+			continue
+		}
+		if room := RoomOf(key); !roomsMatch(rooms, room) {
+			gs.Metrics.RoomEntries.With(room, "filtered").Inc()
+			delete(msg.State, key)
+		}
+	}
+	gs.countRooms("sent", msg.State)
+}
+
+func (gs *GameServer) shardGCLoop(ctx context.Context) {
+	ticker := time.NewTicker(max(gs.Shards.DropAfter/10, time.Second))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if n := gs.dropUnheldShards(); n > 0 {
+			gs.Logger.Debug("dropped entries of shards held elsewhere", "count", n)
+		}
+	}
+}
+
+// dropUnheldShards forgets the entries of shards this node doesn't hold, once the shards have stayed put for
+// DropAfter and each entry has been pushed to every node that holds it
+func (gs *GameServer) dropUnheldShards() int {
+	ring := gs.shardRing()
+	if ring == nil || time.Since(ring.changed) < gs.Shards.DropAfter {
+		return 0
+	}
+	gs.mu.Lock()
+	sent := make(map[string]uint64, len(gs.peerSent))
+	for peerAddr, version := range gs.peerSent {
+		sent[peerAddr] = version
+	}
+	gs.mu.Unlock()
+
+	return gs.State.DropEntries(func(key string, _ gossip.Entry, version uint64) bool {
+		shard := gs.Shards.ShardOf(key)
+		if ring.holds(gs.Address, shard) {
+			return false
+		}
+		for _, owner := range ring.owners[shard] {
+			if sent[owner] < version {
+				return false
+			}
+		}
+		return true
+	})
+}
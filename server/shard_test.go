@@ -0,0 +1,104 @@
+package server
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+)
+
+func ringAddrs(n int) []string {
+	addrs := make([]string, n)
+	for i := range addrs {
+		addrs[i] = fmt.Sprintf("10.0.0.%d:8081", i+1)
+	}
+	slices.Sort(addrs)
+	return addrs
+}
+
+func TestShardRing(t *testing.T) {
+	tests := []struct {
+		members, replicas, wantReplicas int
+	}{
+		{1, 2, 1},
+		{2, 2, 2},
+		{3, 1, 1},
+		{5, 2, 2},
+		{5, 3, 3},
+		{8, 0, 1},
+	}
+	for _, tt := range tests {
+		cfg := ShardConfig{Shards: 256, Replicas: tt.replicas, VirtualNodes: 64}
+		members := ringAddrs(tt.members)
+		ring := newShardRing(cfg, members)
+		reversed := slices.Clone(members)
+		slices.Reverse(reversed)
+		again := newShardRing(cfg, reversed)
+
+		held := make(map[string]int)
+		for shard, owners := range ring.owners {
+			if len(owners) != tt.wantReplicas {
+				t.Errorf("%d members, %d replicas: shard %d has owners %v, want %d", tt.members, tt.replicas, shard,
+					owners, tt.wantReplicas)
+			}
+			for i, addr := range owners {
+				if !slices.Contains(members, addr) || slices.Index(owners, addr) != i {
+					t.Errorf("%d members: shard %d has owners %v", tt.members, shard, owners)
+				}
+				held[addr]++
+			}
+			if !slices.Equal(owners, again.owners[shard]) {
+				t.Errorf("%d members: shard %d placed on %v and %v by the members' order", tt.members, shard, owners,
+					again.owners[shard])
+			}
+		}
+		// 64 virtual nodes spread the shards to within a factor of 2 of an even share
+		even := cfg.Shards * tt.wantReplicas / tt.members
+		for _, addr := range members {
+			if n := held[addr]; n < even/2 || n > even*2 {
+				t.Errorf("%d members, %d replicas: %s holds %d shards, even is %d", tt.members, tt.replicas, addr, n,
+					even)
+			}
+			if got := len(ring.held(addr)); got != held[addr] {
+				t.Errorf("%d members: held(%s) returned %d shards, want %d", tt.members, addr, got, held[addr])
+			}
+		}
+	}
+}
+
+func TestShardRingMovesLittle(t *testing.T) {
+	cfg := ShardConfig{Shards: 256, Replicas: 2, VirtualNodes: 64}
+	for n := 1; n < 8; n++ {
+		before := newShardRing(cfg, ringAddrs(n))
+		members := ringAddrs(n + 1)
+		joined := members[n]
+		after := newShardRing(cfg, members)
+		for shard := range cfg.Shards {
+			// Only the member joining takes over a shard's place, so the shard's other holders stay
+			for _, addr := range after.owners[shard] {
+				if addr != joined && !slices.Contains(before.owners[shard], addr) {
+					t.Errorf("%d members: shard %d moved from %v to %v on %s joining", n, shard,
+						before.owners[shard], after.owners[shard], joined)
+				}
+			}
+		}
+	}
+}
+
+func TestShardOf(t *testing.T) {
+	cfg := ShardConfig{Shards: 16}
+	seen := make(map[int]bool)
+	for i := range 1000 {
+		id := fmt.Sprintf("player-%d", i)
+		shard := cfg.ShardOf(id)
+		if shard < 0 || shard >= cfg.Shards {
+			t.Fatalf("%s is in shard %d of %d", id, shard, cfg.Shards)
+		}
+		if cfg.ShardOf(id) != shard {
+			t.Fatalf("%s moved shards", id)
+		}
+		seen[shard] = true
+	}
+	if len(seen) != cfg.Shards {
+		t.Errorf("1000 players fell in %d of %d shards", len(seen), cfg.Shards)
+	}
+}
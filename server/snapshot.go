@@ -0,0 +1,95 @@
+package server
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"gmathur.dev/gossiper/gossip"
+)
+
+// SnapshotConfig enables periodic snapshots of the full state to disk, a lighter-weight alternative to a
+// write-ahead log: a crash loses whatever changed since the last snapshot. A snapshot is taken when Interval has
+// passed or EveryChanges changes have been made since the previous one, whichever comes first
+type SnapshotConfig struct {
+	Path         string        // snapshot file; snapshots are disabled when empty
+	Format       string        // "json" or "gob"
+	Interval     time.Duration // 0 disables time based snapshots
+	EveryChanges uint64        // 0 disables change count based snapshots
+}
+
+// LoadSnapshot merges a snapshot file into the player state. A missing file is not an error, it just means no
+// snapshot has been taken yet. Call it before Start
+func (gs *GameServer) LoadSnapshot(path, format string) error {
+	n, err := gs.State.LoadSnapshot(path, format)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		gs.Logger.Info("loaded snapshot", "path", path, "players", n)
+	}
+	return nil
+}
+
+// RestoreSnapshot merges a snapshot written by gossip.Store.WriteSnapshot into the running node, as if its entries
+// had been gossiped by a peer: they are persisted and spread to the rest of the cluster, and conflicts are resolved
+// as usual, so restoring an old snapshot doesn't undo newer updates. It returns how many entries the snapshot held
+func (gs *GameServer) RestoreSnapshot(r io.Reader, format string) (int, error) {
+	entries, err := gossip.ReadSnapshot(r, format)
+	if err != nil {
+		return 0, err
+	}
+	gs.MergeState(entries)
+	gs.Logger.Info("restored snapshot", "players", len(entries))
+	return len(entries), nil
+}
+
+// importBatch is how many imported entries are merged at a time
+const importBatch = 1000
+
+// ImportState merges an export written by gossip.Store.Export into the running node a batch at a time, the same
+// way RestoreSnapshot does, so an import of any size needs little memory. It returns how many entries were merged;
+// if the stream breaks off, the batches before the break stay merged
+func (gs *GameServer) ImportState(r io.Reader, format string) (int, error) {
+	n, err := gossip.ReadExport(r, format, importBatch, func(entries map[string]gossip.Entry) error {
+		gs.MergeState(entries)
+		return nil
+	})
+	if n > 0 {
+		gs.Logger.Info("imported state", "players", n, "complete", err == nil)
+	}
+	return n, err
+}
+
+func (gs *GameServer) snapshotLoop(ctx context.Context) {
+	cfg := gs.Snapshots
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	var lastVersion uint64
+	lastTaken := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		version := gs.State.Version()
+		changes := version - lastVersion
+		if changes == 0 {
+			continue
+		}
+		due := cfg.Interval > 0 && time.Since(lastTaken) >= cfg.Interval
+		due = due || cfg.EveryChanges > 0 && changes >= cfg.EveryChanges
+		if !due {
+			continue
+		}
+
+		if err := gs.State.SaveSnapshot(cfg.Path, cfg.Format); err != nil {
+			gs.Logger.Error("failed to save snapshot", "path", cfg.Path, "err", err)
+			continue
+		}
+		lastVersion, lastTaken = version, time.Now()
+		gs.Logger.Debug("saved snapshot", "path", cfg.Path, "changes", changes)
+	}
+}
@@ -0,0 +1,212 @@
+package server
+
+import (
+	"context"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Version is the version reported by Status. Set it at build time with
+// -ldflags "-X gmathur.dev/gossiper/server.Version=v1.2.3"; by default it comes from the binary's build info
+var Version string
+
+// Status is an operator's view of a node and of the cluster as the node sees it
+type Status struct {
+	ID           string            `json:"id"`
+	Address      string            `json:"address"`
+	Listen       string            `json:"listenAddress,omitempty"` // the address the node listens on, if it advertises another, see GameServer.ListenAddress
+	Version      string            `json:"version"`
+	GoVersion    string            `json:"goVersion"`
+	Protocols    string            `json:"protocols"`      // gossip protocol versions spoken, see ProtocolVersion
+	Meta         map[string]string `json:"meta,omitempty"` // metadata advertised to the cluster, see Membership.SetMeta
+	StartedAt    time.Time         `json:"startedAt,omitzero"`
+	Uptime       string            `json:"uptime"`
+	Players      int               `json:"players"`      // live players
+	Entries      int               `json:"entries"`      // entries in the state map, tombstones included
+	StateVersion uint64            `json:"stateVersion"` // version of the local store, see gossip.Store.Version
+	Gossip       GossipStatus      `json:"gossip"`
+	Partition    *PartitionStatus  `json:"partition,omitempty"` // set while the node can't reach a majority of the cluster
+	Shards       []int             `json:"shards,omitempty"`    // shards this node holds, when sharding is on
+	Leader       string            `json:"leader,omitempty"`    // the cluster's leader as this node sees it, see GameServer.Leader
+	Peers        []PeerStatus      `json:"peers"`
+
+	// Aliases are the other addresses that probes found to reach this node or a peer, to the address it goes by,
+	// see PeerRegistry
+	Aliases map[string]string `json:"aliases,omitempty"`
+}
+
+// GossipStatus summarises the node's gossip rounds
+type GossipStatus struct {
+	Mode      GossipMode `json:"mode"`
+	Interval  string     `json:"interval"`
+	Fanout    int        `json:"fanout"`
+	Zone      string     `json:"zone,omitempty"`     // the node's zone, when zone awareness is on
+	Rounds    uint64     `json:"rounds"`             // rounds that had at least one peer to gossip with
+	LastRound time.Time  `json:"lastRound,omitzero"` // last time the gossip loop ran
+	Failures  int        `json:"failures"`           // failed exchanges with any peer
+
+	LastAntiEntropy time.Time `json:"lastAntiEntropy,omitzero"` // last successful anti-entropy sync
+}
+
+// PeerStatus is what this node knows about a peer
+type PeerStatus struct {
+	Address     string            `json:"address"`
+	Status      MemberStatus      `json:"status"`
+	Incarnation uint64            `json:"incarnation"`
+	LastContact time.Time         `json:"lastContact,omitzero"` // last gossip exchange or probe ack, whichever is later
+	LastGossip  time.Time         `json:"lastGossip,omitzero"`
+	Rounds      int               `json:"rounds"`             // gossip rounds that picked the peer, pushes skipped for having nothing to send included
+	Failures    int               `json:"failures"`           // failed exchanges with the peer
+	Protocol    uint32            `json:"protocol,omitempty"` // protocol version negotiated with the peer, once it has answered
+	Breaker     BreakerState      `json:"breaker"`            // state of the peer's circuit breaker
+	Meta        map[string]string `json:"meta,omitempty"`
+	Hints       int               `json:"hints,omitempty"` // keys waiting to be handed off to the peer, see HintConfig
+}
+
+// Status reports the node's identity, uptime, state size, gossip activity and peers
+func (gs *GameServer) Status() Status {
+	gossipCfg := gs.gossipConfig()
+	st := Status{
+		ID:           gs.ID,
+		Address:      gs.Address,
+		Listen:       gs.ListenAddress,
+		Protocols:    LocalProtocols.String(),
+		Meta:         gs.Membership.Meta(),
+		Players:      gs.index.len(),
+		Entries:      gs.State.Len(),
+		StateVersion: gs.State.Version(),
+		Gossip: GossipStatus{
+			Mode:     gs.Mode,
+			Interval: gossipCfg.Interval.String(),
+			Fanout:   gossipCfg.Fanout,
+		},
+	}
+	if ring := gs.shardRing(); ring != nil {
+		st.Shards = ring.held(gs.Address)
+	}
+	st.Leader, _ = gs.Leader()
+	st.Aliases = gs.Membership.Registry.Aliases()
+	members := gs.Membership.Members()
+	acks := gs.Membership.LastAcks()
+	now := time.Now()
+
+	gs.mu.Lock()
+	st.StartedAt, st.Gossip.Rounds, st.Gossip.LastRound = gs.startedAt, gs.round, gs.lastTick
+	st.Gossip.LastAntiEntropy = gs.lastAntiEntropy
+	st.Partition = gs.partitionStatusLocked()
+	if gs.Zones.Key != "" {
+		st.Gossip.Zone = st.Meta[gs.Zones.Key]
+	}
+	for _, m := range members {
+		if m.Address == gs.Address {
+			continue
+		}
+		p := PeerStatus{
+			Address:     m.Address,
+			Status:      m.Status,
+			Incarnation: m.Incarnation,
+			LastGossip:  gs.peerSynced[m.Address],
+			Rounds:      gs.peerRounds[m.Address],
+			Failures:    gs.peerFailed[m.Address],
+			Protocol:    gs.peerProtocol(m.Address),
+			Breaker:     gs.breakers.state(m.Address, now),
+			Meta:        m.Meta,
+			Hints:       gs.hints.count(m.Address),
+		}
+		p.LastContact = p.LastGossip
+		if ack := acks[m.Address]; ack.After(p.LastContact) {
+			p.LastContact = ack
+		}
+		st.Gossip.Failures += p.Failures
+		st.Peers = append(st.Peers, p)
+	}
+	gs.mu.Unlock()
+
+	sort.Slice(st.Peers, func(i, j int) bool { return st.Peers[i].Address < st.Peers[j].Address })
+	st.Version, st.GoVersion = buildVersion()
+	if !st.StartedAt.IsZero() {
+		st.Uptime = time.Since(st.StartedAt).Round(time.Second).String()
+	}
+	return st
+}
+
+// buildVersion returns Version, or the main module's version and VCS revision if it isn't set, along with the Go
+// version the binary was built with
+// BuildVersion returns the version Status reports, see Version
+func BuildVersion() string {
+	version, _ := buildVersion()
+	return version
+}
+
+func buildVersion() (version, goVersion string) {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return Version, ""
+	}
+	if Version != "" {
+		return Version, info.GoVersion
+	}
+	version = info.Main.Version
+	for _, s := range info.Settings {
+		if s.Key == "vcs.revision" && version == "(devel)" {
+			version = s.Value
+		}
+	}
+	return version, info.GoVersion
+}
+
+// clusterStatusTimeout bounds how long ClusterStatus waits for each member
+const clusterStatusTimeout = 2 * time.Second
+
+// ClusterStatus is the status of every member that answered, as each reports it, see GameServer.ClusterStatus
+type ClusterStatus struct {
+	Nodes  []Status          `json:"nodes"`            // this node first, then the rest by address
+	Errors map[string]string `json:"errors,omitempty"` // why members that didn't answer didn't, by address
+}
+
+// ClusterStatus asks every alive or suspect member for its Status at once, for a view of the whole cluster such as
+// the dashboard's. Dead members aren't asked; they show up in this node's Peers
+func (gs *GameServer) ClusterStatus(ctx context.Context) ClusterStatus {
+	self := gs.Status()
+	var addrs []string
+	for _, m := range gs.Membership.Members() {
+		if m.Address != gs.Address && (m.Status == MemberAlive || m.Status == MemberSuspect) {
+			addrs = append(addrs, m.Address)
+		}
+	}
+
+	statuses := make([]Status, len(addrs))
+	errs := make([]error, len(addrs))
+	var wg sync.WaitGroup
+	for i, addr := range addrs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			statuses[i], errs[i] = gs.fetchStatus(ctx, addr)
+		}()
+	}
+	wg.Wait()
+
+	cs := ClusterStatus{Nodes: []Status{self}}
+	for i, addr := range addrs {
+		if errs[i] != nil {
+			if cs.Errors == nil {
+				cs.Errors = make(map[string]string)
+			}
+			cs.Errors[addr] = errs[i].Error()
+			continue
+		}
+		cs.Nodes = append(cs.Nodes, statuses[i])
+	}
+	sort.Slice(cs.Nodes[1:], func(i, j int) bool { return cs.Nodes[i+1].Address < cs.Nodes[j+1].Address })
+	return cs
+}
+
+// fetchStatus gets a peer's Status
+func (gs *GameServer) fetchStatus(ctx context.Context, addr string) (Status, error) {
+	ctx, cancel := context.WithTimeout(ctx, clusterStatusTimeout)
+	defer cancel()
+	return gs.peerTransport().FetchStatus(ctx, addr)
+}
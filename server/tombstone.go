@@ -0,0 +1,38 @@
+package server
+
+import (
+	"context"
+	"time"
+)
+
+func (gs *GameServer) tombstoneGCLoop(ctx context.Context) {
+	ticker := time.NewTicker(max(gs.TombstoneTTL/10, time.Second))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if n := gs.State.CollectTombstones(gs.State.Now().Add(-gs.TombstoneTTL)); n > 0 {
+			gs.Logger.Debug("garbage collected tombstones", "count", n)
+		}
+	}
+}
+
+func (gs *GameServer) expiryLoop(ctx context.Context) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if n := gs.State.ExpireEntries(gs.State.Now()); n > 0 {
+			gs.Logger.Debug("expired stale players", "count", n)
+		}
+	}
+}
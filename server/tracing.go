@@ -0,0 +1,23 @@
+package server
+
+import (
+	"maps"
+	"slices"
+
+	"gmathur.dev/gossiper/gossip"
+	"gmathur.dev/gossiper/tracing"
+)
+
+// maxTracedPlayers caps the player IDs recorded on a span, so a full sync doesn't make one span megabytes long
+const maxTracedPlayers = 32
+
+// TracedPlayers returns the attributes recording which entries a gossip message carried on its span: how many
+// there were and the first maxTracedPlayers of their player IDs, sorted. Searching a tracing backend for a
+// player's ID then turns up the exchanges that carried its updates across the cluster
+func TracedPlayers(entries map[string]gossip.Entry) []tracing.Attribute {
+	ids := slices.Sorted(maps.Keys(entries))
+	return []tracing.Attribute{
+		tracing.Attr("gossip.entries", len(entries)),
+		tracing.Attr("gossip.players", ids[:min(len(ids), maxTracedPlayers)]),
+	}
+}
@@ -0,0 +1,157 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"gmathur.dev/gossiper/gossip"
+)
+
+// Transport is a gossip transport that receives messages as well as sending them, so that a node can be run on it:
+// transport.HTTPTransport, the UDP and QUIC transports in package transport, the in-memory one of
+// gossiptest.Network, or one of an application's own, such as over gRPC. A node run on a Transport reaches its peers
+// only through it, so besides gossip it carries the failure detector's probes and the rest of the node's peer
+// traffic, see PeerTransport, and syncs and digests if it implements SyncTransport and DigestTransport. Start serves
+// it on the node until the node's context is done, handing what peers send to ReceiveGossip, ReceiveSync,
+// ReceiveDigest, MergeState, Membership.HandlePing and the rest
+type Transport interface {
+	GossipTransport
+	ProbeTransport
+	PeerTransport
+	// Serve receives messages for node until ctx is done, returning nil then, or the transport fails
+	Serve(ctx context.Context, node *GameServer) error
+	// Addr returns the address the transport receives messages on
+	Addr() string
+}
+
+// NodeTransport is implemented by transports that need the node they carry traffic for, for its address, metrics,
+// keyring and the rest, such as transport.HTTPGossipTransport. NewGameServer binds the transport the node is created
+// with, and Start binds Transport again in case it was replaced since, before either sends anything
+type NodeTransport interface {
+	BindNode(node *GameServer)
+}
+
+// ProtocolTransport is implemented by transports that negotiate a protocol version with each peer, see
+// ProtocolHeader. Messages to a peer are stamped with its version; a node on another transport stamps
+// MinProtocolVersion
+type ProtocolTransport interface {
+	// PeerProtocol returns the version negotiated with the peer at peerAddr, or 0 before it has answered
+	PeerProtocol(peerAddr string) uint32
+}
+
+// ErrNoTransport is returned for the peer traffic of a node created without a transport, see WithTransport
+var ErrNoTransport = errors.New("no transport to reach peers")
+
+// ProbeTransport carries the failure detector's probes, see Membership. A message with a Target is an indirect
+// probe, answered by Membership.HandlePingReq, and one without it a direct one, answered by Membership.HandlePing
+type ProbeTransport interface {
+	SendProbe(ctx context.Context, peerAddr string, msg PingMessage) (PingMessage, error)
+}
+
+// PeerTransport carries a node's peer traffic besides gossip, syncs, digests and probes: the member list a node
+// fetches to join, the readiness of the peers it may bootstrap from, their status for ClusterStatus, broadcast
+// messages, and the entries of the stores gossiped apart from the players' state. A peer hands what it is sent to
+// MergeRumors and MergeStore
+type PeerTransport interface {
+	// FetchMembers returns the membership list of the member at peerAddr, for a node joining through it
+	FetchMembers(ctx context.Context, peerAddr string) ([]Member, error)
+	// FetchReadiness returns the peer's readiness check, see GameServer.Readiness
+	FetchReadiness(ctx context.Context, peerAddr string) (Health, error)
+	// FetchStatus returns the peer's Status
+	FetchStatus(ctx context.Context, peerAddr string) (Status, error)
+	// SendBroadcasts sends the peer broadcast messages being spread, see GameServer.Broadcast
+	SendBroadcasts(ctx context.Context, peerAddr string, msgs []Message) error
+	// SendStore sends the peer entries of the side store named store, one of StoreSessions, StoreLeases,
+	// StoreSettings and StoreIdempotency
+	SendStore(ctx context.Context, peerAddr, store string, entries map[string]gossip.Entry) error
+}
+
+// The side stores gossiped apart from the players' state, as named to PeerTransport.SendStore
+const (
+	StoreSessions    = "sessions"
+	StoreLeases      = "leases"
+	StoreSettings    = "settings"
+	StoreIdempotency = "idempotency"
+)
+
+// MergeStore merges entries a peer sent of the side store named store, see PeerTransport.SendStore
+func (gs *GameServer) MergeStore(store string, entries map[string]gossip.Entry) (gossip.MergeStats, error) {
+	switch store {
+	case StoreSessions:
+		return gs.MergePresence(entries), nil
+	case StoreLeases:
+		return gs.MergeLeases(entries), nil
+	case StoreSettings:
+		return gs.MergeSettings(entries), nil
+	case StoreIdempotency:
+		return gs.MergeIdempotency(entries), nil
+	default:
+		return gossip.MergeStats{}, fmt.Errorf("unknown store %q", store)
+	}
+}
+
+// peerTransport returns what carries the node's peer traffic besides gossip: its transport, if that implements
+// PeerTransport, or one failing with ErrNoTransport
+func (gs *GameServer) peerTransport() PeerTransport {
+	if t, ok := gs.Transport.(PeerTransport); ok {
+		return t
+	}
+	return noTransport{}
+}
+
+// peerProtocol returns the protocol version negotiated with a peer by the node's transport, 0 if it hasn't been
+func (gs *GameServer) peerProtocol(addr string) uint32 {
+	if t, ok := gs.Transport.(ProtocolTransport); ok {
+		return t.PeerProtocol(addr)
+	}
+	return 0
+}
+
+// bindTransport hands the node to its transport, if it is a NodeTransport
+func (gs *GameServer) bindTransport() {
+	if t, ok := gs.Transport.(NodeTransport); ok {
+		t.BindNode(gs)
+	}
+}
+
+// noTransport is the transport of a node created without one, which reaches no peer
+type noTransport struct{}
+
+func (noTransport) SendGossip(context.Context, string, GossipMessage, GossipMode) (GossipMessage, error) {
+	return GossipMessage{}, ErrNoTransport
+}
+
+func (noTransport) SendProbe(context.Context, string, PingMessage) (PingMessage, error) {
+	return PingMessage{}, ErrNoTransport
+}
+
+func (noTransport) FetchMembers(context.Context, string) ([]Member, error) {
+	return nil, ErrNoTransport
+}
+
+func (noTransport) FetchReadiness(context.Context, string) (Health, error) {
+	return Health{}, ErrNoTransport
+}
+
+func (noTransport) FetchStatus(context.Context, string) (Status, error) {
+	return Status{}, ErrNoTransport
+}
+
+func (noTransport) SendBroadcasts(context.Context, string, []Message) error { return ErrNoTransport }
+
+func (noTransport) SendStore(context.Context, string, string, map[string]gossip.Entry) error {
+	return ErrNoTransport
+}
+
+// serveTransport serves the node's transport, when it is one that receives messages itself, until ctx is done
+func (gs *GameServer) serveTransport(ctx context.Context) {
+	t, ok := gs.Transport.(Transport)
+	if !ok {
+		return
+	}
+	gs.Logger.Info("gossip transport listening", "addr", t.Addr())
+	if err := t.Serve(ctx, gs); err != nil {
+		gs.Logger.Error("gossip transport stopped", "addr", t.Addr(), "err", err)
+	}
+}
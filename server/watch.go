@@ -0,0 +1,115 @@
+package server
+
+import (
+	"context"
+	"sync"
+
+	"gmathur.dev/gossiper/gossip"
+)
+
+const (
+	PlayerUpdated = "update"
+	PlayerDeleted = "delete" // deleted or expired
+)
+
+// PlayerEvent is a change to a player, made locally or merged from a peer
+type PlayerEvent struct {
+	Type     string       `json:"type"`
+	PlayerId string       `json:"playerId"`
+	State    *PlayerState `json:"state,omitempty"` // new state of an updated player
+}
+
+// watchBuffer is how many events a watcher can fall behind before it is dropped
+const watchBuffer = 256
+
+// Watcher receives player events until it is closed. A watcher that doesn't keep up is closed by the server
+// rather than holding up writes, so C closing before Close is called means events were missed and the caller
+// should read the state again
+type Watcher struct {
+	C <-chan PlayerEvent
+
+	c      chan PlayerEvent
+	hub    *watchHub
+	closed bool // guarded by hub.mu
+}
+
+// Close stops delivery to w and closes C
+func (w *Watcher) Close() {
+	w.hub.remove(w)
+}
+
+// watchHub fans the store's change feed out to watchers
+type watchHub struct {
+	mu       sync.Mutex
+	watchers map[*Watcher]struct{}
+}
+
+func newWatchHub() *watchHub {
+	return &watchHub{watchers: make(map[*Watcher]struct{})}
+}
+
+func (h *watchHub) add() *Watcher {
+	c := make(chan PlayerEvent, watchBuffer)
+	w := &Watcher{C: c, c: c, hub: h}
+	h.mu.Lock()
+	h.watchers[w] = struct{}{}
+	h.mu.Unlock()
+	return w
+}
+
+func (h *watchHub) remove(w *Watcher) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closeLocked(w)
+}
+
+// closeLocked closes a watcher once. Caller must hold h.mu
+func (h *watchHub) closeLocked(w *Watcher) {
+	if w.closed {
+		return
+	}
+	w.closed = true
+	delete(h.watchers, w)
+	close(w.c)
+}
+
+func (h *watchHub) closeAll() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for w := range h.watchers {
+		h.closeLocked(w)
+	}
+}
+
+// observe is a gossip.Store OnChange observer. It runs with the store locked, so it never blocks on a watcher
+func (h *watchHub) observe(c gossip.Change) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	// A shard handed over to other nodes is dropped locally, but its players live on
+	if len(h.watchers) == 0 || c.Source == gossip.ChangeDropped {
+		return
+	}
+
+	event := PlayerEvent{Type: PlayerDeleted, PlayerId: c.Key}
+	if state := playerStateOf(c.New); state != nil {
+		event = PlayerEvent{Type: PlayerUpdated, PlayerId: c.Key, State: state}
+	}
+	for w := range h.watchers {
+		select {
+		case w.c <- event:
+		default:
+			h.closeLocked(w)
+		}
+	}
+}
+
+// Watch returns a Watcher that receives every change to a player from now on. All watchers are closed once the
+// context given to Start is done
+func (gs *GameServer) Watch() *Watcher {
+	return gs.watchers.add()
+}
+
+func (gs *GameServer) closeWatchersOnDone(ctx context.Context) {
+	<-ctx.Done()
+	gs.watchers.closeAll()
+}
@@ -0,0 +1,304 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"gmathur.dev/gossiper/gossip"
+)
+
+// WebhookConfig is an HTTP endpoint that changes made on this node are POSTed to in batches. A change is sent if
+// it matches every filter that is set. Like publishing, webhooks only see changes made on this node, so across
+// the cluster each change is sent once
+type WebhookConfig struct {
+	Name    string            // label of the webhook's metrics and logs, the URL's host by default
+	URL     string            // endpoint the changes are POSTed to
+	Headers map[string]string // added to every request, e.g. for the endpoint's credentials
+	Secret  string            // signs each body with HMAC-SHA256 in the X-Gossiper-Signature header, if set
+	Format  string            // body of each request: json (a WebhookBatch), or discord or slack for a chat message
+
+	Prefix string   // only players whose ID starts with it
+	Events []string // only these change types, PlayerUpdated or PlayerDeleted; empty sends both
+	// Threshold only sends updates that take a player's score from below it to at or above it, or back below,
+	// counting a new player as coming from below. Deletions aren't sent while it is set
+	Threshold *int64
+
+	BatchSize     int           // most changes in one request
+	FlushInterval time.Duration // how long a change waits for its batch to fill
+	Retries       int           // more attempts at a request that failed with a network error, 429 or 5xx
+	Backoff       time.Duration // wait before the first retry, doubled for each retry after it
+	MaxQueue      int           // changes held while the endpoint is failing, the oldest are dropped beyond it
+	Timeout       time.Duration // for each request
+}
+
+// DefaultWebhookConfig sends JSON batches of up to 100 changes every second, retrying a failed request 5 times
+// starting a second apart
+func DefaultWebhookConfig() WebhookConfig {
+	return WebhookConfig{
+		Format:        "json",
+		BatchSize:     100,
+		FlushInterval: time.Second,
+		Retries:       5,
+		Backoff:       time.Second,
+		MaxQueue:      10000,
+		Timeout:       10 * time.Second,
+	}
+}
+
+// WebhookSender sends the requests of a node's webhooks, see GameServer.Webhooks; transport.WebhookClient sends
+// them over HTTP. The node batches, formats and retries the changes, and bounds each request by the webhook's
+// Timeout
+type WebhookSender interface {
+	// SendWebhook POSTs body, JSON in the webhook's format, to cfg.URL with cfg.Headers, signed with cfg.Secret if
+	// it is set. A response other than 2xx fails with a WebhookStatusError
+	SendWebhook(ctx context.Context, cfg WebhookConfig, body []byte) error
+}
+
+// WebhookStatusError is a response other than 2xx from a webhook endpoint
+type WebhookStatusError struct {
+	Status int
+}
+
+func (e WebhookStatusError) Error() string {
+	return fmt.Sprintf("webhook returned status %d", e.Status)
+}
+
+// WebhookEvent is a change sent to a webhook
+type WebhookEvent struct {
+	Type     string       `json:"type"` // PlayerUpdated or PlayerDeleted
+	PlayerId string       `json:"playerId"`
+	Old      *PlayerState `json:"old,omitempty"`   // state before the change, if the player existed
+	State    *PlayerState `json:"state,omitempty"` // new state of an updated player
+	Time     time.Time    `json:"time"`
+}
+
+// WebhookBatch is the body of a json webhook request
+type WebhookBatch struct {
+	Node   string         `json:"node"` // ID of the node the changes were made on
+	Events []WebhookEvent `json:"events"`
+}
+
+// webhook is a webhook's queue of changes waiting to be sent
+type webhook struct {
+	cfg     WebhookConfig
+	mu      sync.Mutex
+	queue   []WebhookEvent
+	dropped int // changes dropped since the last delivery
+	wake    chan struct{}
+}
+
+// AddWebhook sends changes made on this node to a webhook, with Webhooks, which has to be set. Call it before Start
+func (gs *GameServer) AddWebhook(cfg WebhookConfig) error {
+	if gs.Webhooks == nil {
+		return errors.New("no webhook sender, set Webhooks first")
+	}
+	u, err := url.Parse(cfg.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid webhook url %q", cfg.URL)
+	}
+	if cfg.Name == "" {
+		cfg.Name = u.Host
+	}
+	switch cfg.Format {
+	case "":
+		cfg.Format = "json"
+	case "json", "discord", "slack":
+	default:
+		return fmt.Errorf("webhook %s: unknown format %q", cfg.Name, cfg.Format)
+	}
+	for _, t := range cfg.Events {
+		if t != PlayerUpdated && t != PlayerDeleted {
+			return fmt.Errorf("webhook %s: unknown event %q", cfg.Name, t)
+		}
+	}
+	cfg.BatchSize = max(cfg.BatchSize, 1)
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = time.Second
+	}
+	gs.webhooks = append(gs.webhooks, &webhook{cfg: cfg, wake: make(chan struct{}, 1)})
+	return nil
+}
+
+// matches reports whether the webhook wants a change
+func (cfg *WebhookConfig) matches(e *WebhookEvent) bool {
+	if !strings.HasPrefix(e.PlayerId, cfg.Prefix) {
+		return false
+	}
+	if len(cfg.Events) > 0 && !slices.Contains(cfg.Events, e.Type) {
+		return false
+	}
+	if cfg.Threshold != nil {
+		if e.Type != PlayerUpdated {
+			return false
+		}
+		wasBelow := e.Old == nil || e.Old.Score < *cfg.Threshold
+		return wasBelow != (e.State.Score < *cfg.Threshold)
+	}
+	return true
+}
+
+// queueWebhooks is a gossip.Store OnChange observer queueing local updates and deletions for the webhooks that
+// want them
+func (gs *GameServer) queueWebhooks(c gossip.Change) {
+	if len(gs.webhooks) == 0 || c.Source != gossip.ChangeLocal || (c.New.Deleted && c.Existed && c.Old.Deleted) {
+		return
+	}
+	event := WebhookEvent{Type: PlayerUpdated, PlayerId: c.Key, State: playerStateOf(c.New), Time: time.Now()}
+	if c.New.Deleted {
+		event.Type = PlayerDeleted
+	}
+	if c.Existed {
+		event.Old = playerStateOf(c.Old)
+	}
+
+	for _, w := range gs.webhooks {
+		if !w.cfg.matches(&event) {
+			continue
+		}
+		w.mu.Lock()
+		w.queue = append(w.queue, event)
+		w.trimLocked()
+		full := len(w.queue) >= w.cfg.BatchSize
+		w.mu.Unlock()
+		if full {
+			select {
+			case w.wake <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// trimLocked drops the oldest changes beyond MaxQueue
+func (w *webhook) trimLocked() {
+	if over := len(w.queue) - w.cfg.MaxQueue; w.cfg.MaxQueue > 0 && over > 0 {
+		w.queue = w.queue[over:]
+		w.dropped += over
+	}
+}
+
+// webhookFlushTimeout bounds sending the changes still queued once the node is shutting down
+const webhookFlushTimeout = 5 * time.Second
+
+// webhookLoop sends a webhook its queued changes until ctx is done, then makes one attempt at what is left
+func (gs *GameServer) webhookLoop(ctx context.Context, w *webhook) {
+	ticker := time.NewTicker(w.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			flushCtx, cancel := context.WithTimeout(context.Background(), webhookFlushTimeout)
+			defer cancel()
+			gs.deliverWebhook(flushCtx, w, false)
+			return
+		case <-ticker.C:
+		case <-w.wake:
+		}
+		gs.deliverWebhook(ctx, w, true)
+	}
+}
+
+// deliverWebhook sends the queue a batch at a time. A batch that still fails after the retries is dropped, so an
+// endpoint that is down for long loses changes rather than holding them all
+func (gs *GameServer) deliverWebhook(ctx context.Context, w *webhook, retry bool) {
+	for {
+		w.mu.Lock()
+		n := min(len(w.queue), w.cfg.BatchSize)
+		batch := w.queue[:n:n]
+		w.queue = w.queue[n:]
+		dropped := w.dropped
+		w.dropped = 0
+		w.mu.Unlock()
+
+		if dropped > 0 {
+			gs.Metrics.WebhookEvents.With(w.cfg.Name, "dropped").Add(float64(dropped))
+			gs.Logger.Warn("dropped webhook changes, the queue was full", "webhook", w.cfg.Name, "count", dropped)
+		}
+		if len(batch) == 0 {
+			return
+		}
+
+		err := gs.postWebhook(ctx, w, batch)
+		backoff := w.cfg.Backoff
+		for attempt := 0; retry && err != nil && retryable(err) && attempt < w.cfg.Retries; attempt++ {
+			select {
+			case <-ctx.Done():
+				err = ctx.Err()
+			case <-time.After(backoff):
+				backoff *= 2
+				err = gs.postWebhook(ctx, w, batch)
+			}
+		}
+		if err != nil {
+			gs.Metrics.WebhookEvents.With(w.cfg.Name, "failed").Add(float64(len(batch)))
+			gs.Logger.Warn("failed to send webhook, dropping changes", "webhook", w.cfg.Name, "count", len(batch),
+				"err", err)
+			if ctx.Err() != nil {
+				return
+			}
+			continue
+		}
+		gs.Metrics.WebhookEvents.With(w.cfg.Name, "delivered").Add(float64(len(batch)))
+	}
+}
+
+// retryable reports whether a failed webhook request is worth sending again: anything but a 4xx other than 429,
+// Too Many Requests
+func retryable(err error) bool {
+	var statusErr WebhookStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Status == 429 || statusErr.Status >= 500
+	}
+	return !errors.Is(err, context.Canceled)
+}
+
+func (gs *GameServer) postWebhook(ctx context.Context, w *webhook, batch []WebhookEvent) error {
+	body, err := json.Marshal(webhookBody(w.cfg.Format, gs.ID, batch))
+	if err != nil {
+		return err
+	}
+	if w.cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, w.cfg.Timeout)
+		defer cancel()
+	}
+	return gs.Webhooks.SendWebhook(ctx, w.cfg, body)
+}
+
+// discordMaxContent is the longest message Discord accepts
+const discordMaxContent = 2000
+
+// webhookBody builds a request body in the webhook's format: a WebhookBatch, or a chat message with a line per
+// change for discord and slack
+func webhookBody(format, node string, batch []WebhookEvent) any {
+	if format == "json" {
+		return WebhookBatch{Node: node, Events: batch}
+	}
+	var text strings.Builder
+	for _, e := range batch {
+		switch {
+		case e.Type == PlayerDeleted:
+			fmt.Fprintf(&text, "%s was deleted\n", e.PlayerId)
+		case e.Old != nil:
+			fmt.Fprintf(&text, "%s: %d → %d\n", e.PlayerId, e.Old.Score, e.State.Score)
+		default:
+			fmt.Fprintf(&text, "%s: %d\n", e.PlayerId, e.State.Score)
+		}
+	}
+	content := strings.TrimSuffix(text.String(), "\n")
+	if format == "slack" {
+		return map[string]string{"text": content}
+	}
+	if len(content) > discordMaxContent {
+		content = content[:strings.LastIndex(content[:discordMaxContent-1], "\n")+1] + "…"
+	}
+	return map[string]string{"content": content}
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ZoneConfig makes gossip rounds locality aware, to cut cross-zone and cross-datacenter traffic. A member's zone
+// is the value of its Key metadata, see Membership.SetMeta. Every round gossips with Fanout peers in the node's
+// own zone, and every CrossEvery rounds it also gossips with CrossFanout peers in each other zone, so changes still
+// reach every zone but only a fraction of the traffic crosses between them. Zone awareness is off while the node
+// has no zone of its own
+type ZoneConfig struct {
+	Key         string         // metadata key naming a member's zone; "" turns zone awareness off
+	CrossEvery  int            // every Nth round also gossips across zones; 1 or less does so every round
+	CrossFanout int            // peers gossiped with in each other zone on those rounds
+	Fanout      map[string]int // per-zone overrides: the own zone's replaces GossipConfig.Fanout, others' CrossFanout
+}
+
+// DefaultZoneConfig takes zones from the "zone" metadata key and gossips with one peer in every other zone every
+// 5th round
+func DefaultZoneConfig() ZoneConfig {
+	return ZoneConfig{Key: "zone", CrossEvery: 5, CrossFanout: 1}
+}
+
+// ParseZoneFanout parses a comma separated list of zone=fanout pairs
+func ParseZoneFanout(s string) (map[string]int, error) {
+	fanout := make(map[string]int)
+	if s == "" {
+		return fanout, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		zone, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			return nil, fmt.Errorf("zone fanout %q is not zone=fanout", pair)
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid fanout %q for zone %s", value, zone)
+		}
+		fanout[zone] = n
+	}
+	return fanout, nil
+}
+
+// peerGroup is a set of peers a round picks a number of peers from
+type peerGroup struct {
+	peers  []string
+	fanout int
+	scope  string // local or cross, for metrics; empty when zone awareness is off
+}
+
+// zones returns this node's zone and the zone of every member that has one, read from the metadata under key
+func (ms *Membership) zones(key string) (self string, zones map[string]string) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	zones = make(map[string]string)
+	for addr, m := range ms.members {
+		if zone := m.Meta[key]; zone != "" {
+			zones[addr] = zone
+		}
+	}
+	return ms.meta[key], zones
+}
+
+// peerGroups splits the alive peers into the groups a round picks from: the own zone every round, and each
+// other zone on cross-zone rounds. Peers whose zone isn't known yet, such as seeds whose metadata hasn't arrived,
+// count as local. A node alone in its zone crosses every round, since that is the only way its changes get out
+func (gs *GameServer) peerGroups(peers []string, round uint64) []peerGroup {
+	fanout := max(gs.gossipConfig().Fanout, 1)
+	cfg := gs.Zones
+	if cfg.Key == "" {
+		return []peerGroup{{peers: peers, fanout: fanout}}
+	}
+	local, zones := gs.Membership.zones(cfg.Key)
+	if local == "" {
+		return []peerGroup{{peers: peers, fanout: fanout}}
+	}
+
+	byZone := make(map[string][]string)
+	for _, peerAddr := range peers {
+		zone := zones[peerAddr]
+		if zone == "" {
+			zone = local
+		}
+		byZone[zone] = append(byZone[zone], peerAddr)
+	}
+	if n, ok := cfg.Fanout[local]; ok {
+		fanout = n
+	}
+
+	var groups []peerGroup
+	if len(byZone[local]) > 0 && fanout > 0 {
+		groups = append(groups, peerGroup{peers: byZone[local], fanout: fanout, scope: "local"})
+	}
+	if len(groups) > 0 && cfg.CrossEvery > 1 && round%uint64(cfg.CrossEvery) != 0 {
+		return groups
+	}
+	for _, zone := range slices.Sorted(maps.Keys(byZone)) {
+		if zone == local {
+			continue
+		}
+		n := max(cfg.CrossFanout, 1)
+		if override, ok := cfg.Fanout[zone]; ok {
+			n = override
+		}
+		if n > 0 {
+			groups = append(groups, peerGroup{peers: byZone[zone], fanout: n, scope: "cross"})
+		}
+	}
+	return groups
+}
@@ -0,0 +1,343 @@
+package store
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Each event log record is a fixed header followed by the payload:
+//
+//	crc32 (4 bytes) | payload length (4 bytes) | sequence number (8 bytes) | payload
+//
+// The checksum covers the sequence number and payload
+const eventHeaderSize = 16
+
+// EventLog is an append-only log of events numbered from 1 in the order they were appended, kept in a directory
+// of segment files named after the first sequence number they hold. Appends are buffered and flushed to disk every
+// SyncInterval (and on Close), behind the writes that produced them. Once a segment reaches SegmentBytes a new one
+// is started, and beyond MaxSegments the oldest is deleted, so readers asking for events that old find the log
+// starts later
+type EventLog struct {
+	dir          string
+	segmentBytes int64
+	maxSegments  int
+
+	mu       sync.Mutex
+	segments []uint64 // first sequence number of each segment, oldest first
+	f        *os.File // the newest segment, appended to
+	w        *bufio.Writer
+	size     int64 // bytes in the newest segment
+	next     uint64
+	syncErr  error
+	done     chan struct{}
+}
+
+// EventLogOptions size an EventLog's segments
+type EventLogOptions struct {
+	SyncInterval time.Duration // how often the log is flushed to disk, which must be positive
+	SegmentBytes int64         // a segment is closed once it reaches this size
+	MaxSegments  int           // segments kept, the oldest are deleted beyond it; 0 keeps every one
+}
+
+// DefaultEventLogOptions flush every second and keep 16 segments of 16 MiB
+func DefaultEventLogOptions() EventLogOptions {
+	return EventLogOptions{SyncInterval: time.Second, SegmentBytes: 16 << 20, MaxSegments: 16}
+}
+
+// OpenEventLog opens or creates the event log in dir, carrying on from the last event it holds
+func OpenEventLog(dir string, opts EventLogOptions) (*EventLog, error) {
+	if opts.SyncInterval <= 0 {
+		return nil, fmt.Errorf("event log sync interval must be positive, got %s", opts.SyncInterval)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	l := &EventLog{dir: dir, segmentBytes: opts.SegmentBytes, maxSegments: opts.MaxSegments, next: 1,
+		done: make(chan struct{})}
+	for _, e := range entries {
+		if first, ok := segmentSeq(e.Name()); ok {
+			l.segments = append(l.segments, first)
+		}
+	}
+	slices.Sort(l.segments)
+
+	if len(l.segments) == 0 {
+		if err := l.startSegmentLocked(); err != nil {
+			return nil, err
+		}
+	} else if err := l.openLastSegment(); err != nil {
+		return nil, err
+	}
+	go l.syncLoop(opts.SyncInterval)
+	return l, nil
+}
+
+func segmentName(first uint64) string {
+	return fmt.Sprintf("%020d.events", first)
+}
+
+func segmentSeq(name string) (uint64, bool) {
+	digits, ok := strings.CutSuffix(name, ".events")
+	if !ok {
+		return 0, false
+	}
+	first, err := strconv.ParseUint(digits, 10, 64)
+	return first, err == nil
+}
+
+// openLastSegment opens the newest segment for appending, cutting off any torn record at its tail
+func (l *EventLog) openLastSegment() error {
+	first := l.segments[len(l.segments)-1]
+	f, err := os.OpenFile(filepath.Join(l.dir, segmentName(first)), os.O_RDWR, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.next = first
+	var offset int64
+	r := bufio.NewReader(f)
+	for {
+		seq, _, n, err := readEvent(r, info.Size()-offset)
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				if err := f.Truncate(offset); err != nil {
+					f.Close()
+					return err
+				}
+			}
+			break
+		}
+		l.next = seq + 1
+		offset += n
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return err
+	}
+	l.f, l.w, l.size = f, bufio.NewWriter(f), offset
+	return nil
+}
+
+// startSegmentLocked closes the newest segment, if any, and starts one at the next sequence number, deleting the
+// oldest segments beyond MaxSegments
+func (l *EventLog) startSegmentLocked() error {
+	if l.f != nil {
+		if err := l.syncLocked(); err != nil {
+			return err
+		}
+		l.f.Close()
+	}
+	f, err := os.OpenFile(filepath.Join(l.dir, segmentName(l.next)), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	l.f, l.w, l.size = f, bufio.NewWriter(f), 0
+	if len(l.segments) == 0 || l.segments[len(l.segments)-1] != l.next {
+		l.segments = append(l.segments, l.next)
+	}
+	for l.maxSegments > 0 && len(l.segments) > l.maxSegments {
+		if err := os.Remove(filepath.Join(l.dir, segmentName(l.segments[0]))); err != nil {
+			return err
+		}
+		l.segments = l.segments[1:]
+	}
+	return nil
+}
+
+// readEvent reads the next event from r, which has remaining bytes left. A length that runs past them is taken for
+// a torn or corrupt header rather than trusted with an allocation
+func readEvent(r io.Reader, remaining int64) (seq uint64, payload []byte, n int64, err error) {
+	var header [eventHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			err = errors.New("torn record header")
+		}
+		return 0, nil, 0, err
+	}
+	sum := binary.BigEndian.Uint32(header[:4])
+	length := binary.BigEndian.Uint32(header[4:8])
+	if int64(length) > remaining-eventHeaderSize {
+		return 0, nil, 0, fmt.Errorf("torn record: length %d runs past the end of the file", length)
+	}
+
+	payload = make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, 0, fmt.Errorf("torn record: %w", err)
+	}
+	if crc32.Update(crc32.ChecksumIEEE(header[8:]), crc32.IEEETable, payload) != sum {
+		return 0, nil, 0, errors.New("record checksum mismatch")
+	}
+	return binary.BigEndian.Uint64(header[8:]), payload, eventHeaderSize + int64(length), nil
+}
+
+// Append adds an event to the log and returns its sequence number
+func (l *EventLog) Append(payload []byte) (uint64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.segmentBytes > 0 && l.size >= l.segmentBytes {
+		if err := l.startSegmentLocked(); err != nil {
+			return 0, err
+		}
+	}
+	record := make([]byte, eventHeaderSize, eventHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(record[4:8], uint32(len(payload)))
+	binary.BigEndian.PutUint64(record[8:], l.next)
+	binary.BigEndian.PutUint32(record[:4], crc32.Update(crc32.ChecksumIEEE(record[8:]), crc32.IEEETable, payload))
+	record = append(record, payload...)
+	if _, err := l.w.Write(record); err != nil {
+		return 0, err
+	}
+	l.size += int64(len(record))
+	l.next++
+	return l.next - 1, nil
+}
+
+// Bounds returns the sequence numbers of the oldest event still kept and of the newest, 0 if the log is empty
+func (l *EventLog) Bounds() (first, last uint64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.next == l.segments[0] {
+		return 0, 0
+	}
+	return l.segments[0], l.next - 1
+}
+
+// Read calls fn with the events after since, in order, until fn returns false or limit events have been read.
+// Appends carry on while it reads; events appended after Read started aren't included
+func (l *EventLog) Read(since uint64, limit int, fn func(seq uint64, payload []byte) bool) error {
+	l.mu.Lock()
+	err := l.w.Flush()
+	segments, last := slices.Clone(l.segments), l.next-1
+	l.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// Start from the last segment that begins at or before the first event wanted
+	i := max(sort.Search(len(segments), func(i int) bool { return segments[i] > since+1 })-1, 0)
+	read := 0
+	for _, first := range segments[i:] {
+		f, err := os.Open(filepath.Join(l.dir, segmentName(first)))
+		if errors.Is(err, fs.ErrNotExist) {
+			// Deleted for being over MaxSegments since the list was taken
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		done, err := readSegment(f, since, last, func(seq uint64, payload []byte) bool {
+			read++
+			return fn(seq, payload) && (limit <= 0 || read < limit)
+		})
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read event log segment %s: %w", segmentName(first), err)
+		}
+		if done {
+			return nil
+		}
+	}
+	return nil
+}
+
+// readSegment calls fn with the events of a segment after since and up to last. It reports whether fn asked to
+// stop or last was reached
+func readSegment(f *os.File, since, last uint64, fn func(seq uint64, payload []byte) bool) (bool, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+	r := bufio.NewReader(f)
+	var offset int64
+	for {
+		seq, payload, n, err := readEvent(r, info.Size()-offset)
+		if errors.Is(err, io.EOF) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		offset += n
+		if seq > last {
+			return true, nil
+		}
+		if seq > since && !fn(seq, payload) {
+			return true, nil
+		}
+		if seq == last {
+			return true, nil
+		}
+	}
+}
+
+func (l *EventLog) syncLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			l.mu.Lock()
+			l.syncErr = l.syncLocked()
+			if l.syncErr != nil {
+				slog.Error("failed to sync event log", "dir", l.dir, "err", l.syncErr)
+			}
+			l.mu.Unlock()
+		case <-l.done:
+			return
+		}
+	}
+}
+
+func (l *EventLog) syncLocked() error {
+	if err := l.w.Flush(); err != nil {
+		return err
+	}
+	return l.f.Sync()
+}
+
+// Healthy returns the error from the last background flush, if it failed
+func (l *EventLog) Healthy() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.syncErr != nil {
+		return fmt.Errorf("failed to sync event log %s: %w", l.dir, l.syncErr)
+	}
+	return nil
+}
+
+// Close flushes outstanding events to disk and closes the log
+func (l *EventLog) Close() error {
+	close(l.done)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.syncLocked(); err != nil {
+		l.f.Close()
+		return err
+	}
+	return l.f.Close()
+}
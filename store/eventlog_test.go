@@ -0,0 +1,57 @@
+package store
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestEventLogTruncatesAnOversizedRecord corrupts the length of the last event to claim far more bytes than the
+// segment holds, and checks the log reopens without it and carries on from the event before
+func TestEventLogTruncatesAnOversizedRecord(t *testing.T) {
+	dir := t.TempDir()
+	opts := EventLogOptions{SyncInterval: time.Hour}
+	l, err := OpenEventLog(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, payload := range []string{"first", "second"} {
+		if _, err := l.Append([]byte(payload)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, segmentName(1))
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	binary.BigEndian.PutUint32(data[eventHeaderSize+len("first")+4:], 0xffffffff)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	l, err = OpenEventLog(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if first, last := l.Bounds(); first != 1 || last != 1 {
+		t.Errorf("bounds are %d-%d, want 1-1", first, last)
+	}
+	var got []string
+	if err := l.Read(0, 0, func(_ uint64, payload []byte) bool {
+		got = append(got, string(payload))
+		return true
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0] != "first" {
+		t.Errorf("read %q, want only first", got)
+	}
+}
@@ -0,0 +1,234 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"maps"
+	"net"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestParseDataRow(t *testing.T) {
+	body := binary.BigEndian.AppendUint16(nil, 3)
+	body = binary.BigEndian.AppendUint32(body, 5)
+	body = append(body, "alice"...)
+	body = binary.BigEndian.AppendUint32(body, 0xffffffff) // NULL
+	body = binary.BigEndian.AppendUint32(body, 0)
+
+	row, err := parseDataRow(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := [][]byte{[]byte("alice"), nil, {}}; !reflect.DeepEqual(row, want) {
+		t.Errorf("got %q, want %q", row, want)
+	}
+
+	for _, short := range [][]byte{{0}, body[:4], body[:8], body[:len(body)-1]} {
+		if _, err := parseDataRow(short); err == nil {
+			t.Errorf("%v: parsed a short data row", short)
+		}
+	}
+}
+
+func TestParsePgError(t *testing.T) {
+	body := []byte("SERROR\x00C42P01\x00Mrelation \"nope\" does not exist\x00\x00")
+	err := parsePgError(body)
+	if err.code != "42P01" || err.message != `relation "nope" does not exist` {
+		t.Errorf("got %+v", err)
+	}
+}
+
+// TestScram runs the SCRAM-SHA-256 exchange of RFC 7677, section 3
+func TestScram(t *testing.T) {
+	c := &scramClient{password: "pencil", nonce: "rOprNGfwEbeRWgbNEkqO", clientBare: "n=user,r=rOprNGfwEbeRWgbNEkqO"}
+	serverFirst := "r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096"
+
+	final, err := c.clientFinal(serverFirst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ="
+	if final != want {
+		t.Errorf("client final message:\n got %s\nwant %s", final, want)
+	}
+	if !c.verifyServer("v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4=") {
+		t.Error("rejected the server's signature")
+	}
+	if c.verifyServer("v=AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=") {
+		t.Error("accepted a wrong server signature")
+	}
+}
+
+func TestScramRejectsServerFirst(t *testing.T) {
+	for _, serverFirst := range []string{
+		"r=someoneElsesNonce,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096",
+		"r=rOprNGfwEbeRWgbNEkqOmore,s=not base64,i=4096",
+		"r=rOprNGfwEbeRWgbNEkqOmore,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=0",
+	} {
+		c := &scramClient{password: "pencil", nonce: "rOprNGfwEbeRWgbNEkqO", clientBare: "n=,r=rOprNGfwEbeRWgbNEkqO"}
+		if _, err := c.clientFinal(serverFirst); err == nil {
+			t.Errorf("%s: accepted", serverFirst)
+		}
+	}
+}
+
+func TestPostgres(t *testing.T) {
+	srv := newFakePostgres(t)
+	p, err := OpenPostgres("postgres://gossiper:secret@"+srv.addr+"/games", RemoteOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	p.Save("alice", []byte(`{"score":1}`))
+	p.Save("bob", []byte(`{"score":2}`))
+	if err := p.writes.sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	got := make(map[string]string)
+	if err := p.Load(func(key string, value []byte) { got[key] = string(value) }); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"alice": `{"score":1}`, "bob": `{"score":2}`}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("loaded %v, want %v", got, want)
+	}
+	if params := srv.startupParams(); params["user"] != "gossiper" || params["database"] != "games" {
+		t.Errorf("startup parameters %v", params)
+	}
+}
+
+func TestPostgresWrongPassword(t *testing.T) {
+	srv := newFakePostgres(t)
+	if _, err := OpenPostgres("postgres://gossiper:wrong@"+srv.addr, RemoteOptions{}); err == nil {
+		t.Fatal("connected with the wrong password")
+	}
+}
+
+// fakePostgres asks for md5 authentication, letting in user gossiper with password secret, and keeps the rows the
+// Postgres backend upserts, answering a SELECT with them and any other simple query with an empty result
+type fakePostgres struct {
+	addr string
+	hash string // md5(md5(password + user) + salt) in hex, for the salt 1, 2, 3, 4
+
+	mu     sync.Mutex
+	rows   map[string][]byte
+	params map[string]string
+}
+
+func newFakePostgres(t *testing.T) *fakePostgres {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	srv := &fakePostgres{addr: ln.Addr().String(), hash: "md53417e05930d3812d23edea7731247542", rows: make(map[string][]byte)}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go srv.serve(conn)
+		}
+	}()
+	return srv
+}
+
+func (s *fakePostgres) startupParams() map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return maps.Clone(s.params)
+}
+
+func (s *fakePostgres) serve(conn net.Conn) {
+	defer conn.Close()
+	// The server side of the protocol shares the message framing, so the backend's helpers read and write it
+	pc := &Postgres{r: bufio.NewReader(conn), w: bufio.NewWriter(conn)}
+
+	var size uint32
+	if binary.Read(pc.r, binary.BigEndian, &size) != nil || size < 8 {
+		return
+	}
+	startup := make([]byte, size-4)
+	if _, err := io.ReadFull(pc.r, startup); err != nil {
+		return
+	}
+	fields := strings.Split(string(startup[4:]), "\x00")
+	params := make(map[string]string)
+	for i := 0; i+1 < len(fields); i += 2 {
+		params[fields[i]] = fields[i+1]
+	}
+	s.mu.Lock()
+	s.params = params
+	s.mu.Unlock()
+
+	pc.writeMessage('R', []byte{0, 0, 0, 5, 1, 2, 3, 4})
+	pc.w.Flush()
+	typ, body, err := pc.readMessage()
+	if err != nil || typ != 'p' {
+		return
+	}
+	if string(body) != s.hash+"\x00" {
+		pc.writeMessage('E', []byte("SFATAL\x00C28P01\x00Mpassword authentication failed\x00\x00"))
+		pc.w.Flush()
+		return
+	}
+	pc.writeMessage('R', []byte{0, 0, 0, 0})
+	pc.writeMessage('Z', []byte{'I'})
+	pc.w.Flush()
+
+	for {
+		typ, body, err := pc.readMessage()
+		if err != nil {
+			return
+		}
+		switch typ {
+		case 'Q':
+			if bytes.HasPrefix(body, []byte("SELECT")) {
+				s.mu.Lock()
+				for _, key := range slices.Sorted(maps.Keys(s.rows)) {
+					row := binary.BigEndian.AppendUint16(nil, 2)
+					for _, col := range [][]byte{[]byte(key), s.rows[key]} {
+						row = binary.BigEndian.AppendUint32(row, uint32(len(col)))
+						row = append(row, col...)
+					}
+					pc.writeMessage('D', row)
+				}
+				s.mu.Unlock()
+			}
+			pc.writeMessage('C', []byte("OK\x00"))
+			pc.writeMessage('Z', []byte{'I'})
+		case 'B':
+			// Skip the unnamed portal and statement and the parameter format codes, then take (key, value) pairs
+			n := int(binary.BigEndian.Uint16(body[4:]))
+			body = body[6:]
+			params := make([][]byte, n)
+			for i := range params {
+				size := binary.BigEndian.Uint32(body)
+				params[i], body = body[4:4+size], body[4+size:]
+			}
+			s.mu.Lock()
+			for i := 0; i+1 < n; i += 2 {
+				s.rows[string(params[i])] = params[i+1]
+			}
+			s.mu.Unlock()
+		case 'E':
+			pc.writeMessage('C', []byte("INSERT 0 1\x00"))
+		case 'S':
+			pc.writeMessage('Z', []byte{'I'})
+		case 'X':
+			return
+		}
+		if pc.w.Flush() != nil {
+			return
+		}
+	}
+}
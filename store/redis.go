@@ -0,0 +1,243 @@
+package store
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Redis keeps the entries in a Redis hash, one field per key, so nodes can share a Redis server operators
+// already run and a new node can bootstrap from it. Saves are buffered and written every SyncInterval with a
+// single HSET; each node writes what it holds, so the hash follows the cluster's merged state
+type Redis struct {
+	addr     string
+	user     string
+	password string
+	db       int
+	hash     string
+	timeout  time.Duration
+
+	conn   net.Conn // connected on first use and again after an error; guarded by the writeBehind's flushMu
+	r      *bufio.Reader
+	w      *bufio.Writer
+	writes *writeBehind
+}
+
+// OpenRedis connects to the Redis server at a redis://[user:password@]host:port/db URL. Entries are kept in the
+// hash named by opts.Name, gossiper:state by default
+func OpenRedis(rawURL string, opts RemoteOptions) (*Redis, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme != "redis" || u.Host == "" {
+		return nil, fmt.Errorf("invalid redis url %q", rawURL)
+	}
+	opts = opts.withDefaults()
+	r := &Redis{addr: u.Host, hash: opts.Name, timeout: opts.Timeout}
+	if u.Port() == "" {
+		r.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if r.hash == "" {
+		r.hash = "gossiper:state"
+	}
+	if u.User != nil {
+		r.user = u.User.Username()
+		r.password, _ = u.User.Password()
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		if r.db, err = strconv.Atoi(db); err != nil {
+			return nil, fmt.Errorf("invalid redis database %q", db)
+		}
+	}
+
+	// Connect up front, so a server that can't be reached is reported on start
+	if _, err := r.do("PING"); err != nil {
+		r.closeConn()
+		return nil, fmt.Errorf("failed to connect to redis at %s: %w", r.addr, err)
+	}
+	r.writes = newWriteBehind("redis "+r.addr, opts.SyncInterval, r.flush)
+	return r, nil
+}
+
+// Save buffers the new value of key for the next flush
+func (r *Redis) Save(key string, value []byte) error {
+	r.writes.save(key, value)
+	return nil
+}
+
+// Load calls fn with every entry in the hash, scanning it a page at a time
+func (r *Redis) Load(fn func(key string, value []byte)) error {
+	r.writes.flushMu.Lock()
+	defer r.writes.flushMu.Unlock()
+
+	cursor := "0"
+	for {
+		reply, err := r.do("HSCAN", r.hash, cursor, "COUNT", "1000")
+		if err != nil {
+			return err
+		}
+		page, ok := reply.([]any)
+		if !ok || len(page) != 2 {
+			return fmt.Errorf("unexpected HSCAN reply %v", reply)
+		}
+		fields, _ := page[1].([]any)
+		for i := 0; i+1 < len(fields); i += 2 {
+			key, _ := fields[i].([]byte)
+			value, _ := fields[i+1].([]byte)
+			fn(string(key), value)
+		}
+		next, _ := page[0].([]byte)
+		if cursor = string(next); cursor == "0" {
+			return nil
+		}
+	}
+}
+
+// flush writes a batch of values with one HSET. Called by the writeBehind with flushMu held
+func (r *Redis) flush(values map[string][]byte) error {
+	args := make([]any, 0, 2+2*len(values))
+	args = append(args, "HSET", r.hash)
+	for key, value := range values {
+		args = append(args, key, value)
+	}
+	_, err := r.do(args...)
+	return err
+}
+
+// Healthy returns the error from the last background flush, if it failed
+func (r *Redis) Healthy() error {
+	return r.writes.healthy()
+}
+
+// Close writes outstanding values and closes the connection
+func (r *Redis) Close() error {
+	err := r.writes.close()
+	r.closeConn()
+	return err
+}
+
+// do sends a command and reads its reply, connecting first if need be. Any error but one returned by the server
+// drops the connection
+func (r *Redis) do(args ...any) (any, error) {
+	if r.conn == nil {
+		if err := r.connect(); err != nil {
+			return nil, err
+		}
+	}
+	r.conn.SetDeadline(time.Now().Add(r.timeout))
+	reply, err := r.roundTrip(args...)
+	var serverErr redisError
+	if err != nil && !errors.As(err, &serverErr) {
+		r.closeConn()
+	}
+	return reply, err
+}
+
+func (r *Redis) connect() error {
+	conn, err := net.DialTimeout("tcp", r.addr, r.timeout)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(r.timeout))
+	r.conn, r.r, r.w = conn, bufio.NewReader(conn), bufio.NewWriter(conn)
+
+	if r.password != "" {
+		args := []any{"AUTH", r.password}
+		if r.user != "" {
+			args = []any{"AUTH", r.user, r.password}
+		}
+		if _, err := r.roundTrip(args...); err != nil {
+			r.closeConn()
+			return err
+		}
+	}
+	if r.db != 0 {
+		if _, err := r.roundTrip("SELECT", strconv.Itoa(r.db)); err != nil {
+			r.closeConn()
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Redis) closeConn() {
+	if r.conn != nil {
+		r.conn.Close()
+		r.conn = nil
+	}
+}
+
+// roundTrip writes a command as a RESP array of bulk strings and reads the reply
+func (r *Redis) roundTrip(args ...any) (any, error) {
+	fmt.Fprintf(r.w, "*%d\r\n", len(args))
+	for _, arg := range args {
+		var b []byte
+		switch arg := arg.(type) {
+		case string:
+			b = []byte(arg)
+		case []byte:
+			b = arg
+		}
+		fmt.Fprintf(r.w, "$%d\r\n", len(b))
+		r.w.Write(b)
+		r.w.WriteString("\r\n")
+	}
+	if err := r.w.Flush(); err != nil {
+		return nil, err
+	}
+	return readRESP(r.r)
+}
+
+// redisError is an error reply from the server
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+// readRESP reads one reply: a simple string or bulk string as []byte, an integer as int64, an array as []any, or
+// an error reply as a redisError
+func readRESP(r *bufio.Reader) (any, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("redis: empty reply")
+	}
+	switch line[0] {
+	case '+':
+		return []byte(line[1:]), nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		items := make([]any, n)
+		for i := range items {
+			if items[i], err = readRESP(r); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("redis: unexpected reply %q", line)
+	}
+}
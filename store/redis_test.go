@@ -0,0 +1,186 @@
+package store
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestReadRESP(t *testing.T) {
+	tests := []struct {
+		reply string
+		want  any
+	}{
+		{"+OK\r\n", []byte("OK")},
+		{":42\r\n", int64(42)},
+		{"$5\r\nhe\r\no\r\n", []byte("he\r\no")},
+		{"$0\r\n\r\n", []byte{}},
+		{"$-1\r\n", nil},
+		{"*2\r\n$1\r\na\r\n:1\r\n", []any{[]byte("a"), int64(1)}},
+		{"*2\r\n*1\r\n+x\r\n*0\r\n", []any{[]any{[]byte("x")}, []any{}}},
+	}
+	for _, tt := range tests {
+		got, err := readRESP(bufio.NewReader(strings.NewReader(tt.reply)))
+		if err != nil {
+			t.Errorf("%q: %v", tt.reply, err)
+		} else if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: got %#v, want %#v", tt.reply, got, tt.want)
+		}
+	}
+}
+
+func TestReadRESPErrors(t *testing.T) {
+	_, err := readRESP(bufio.NewReader(strings.NewReader("-WRONGPASS invalid password\r\n")))
+	var serverErr redisError
+	if !errors.As(err, &serverErr) || string(serverErr) != "WRONGPASS invalid password" {
+		t.Errorf("error reply: got %v, want a redisError", err)
+	}
+
+	for _, reply := range []string{"\r\n", "?what\r\n", "$5\r\nab\r\n", "*2\r\n:1\r\n", ":x\r\n"} {
+		_, err := readRESP(bufio.NewReader(strings.NewReader(reply)))
+		if err == nil || errors.As(err, &serverErr) {
+			t.Errorf("%q: got %v, want a protocol error", reply, err)
+		}
+	}
+}
+
+func TestRedis(t *testing.T) {
+	srv := newFakeRedis(t, "gossiper", "secret")
+	r, err := OpenRedis("redis://gossiper:secret@"+srv.addr+"/2", RemoteOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	r.Save("alice", []byte(`{"score":1}`))
+	r.Save("bob", []byte(`{"score":2}`))
+	r.Save("alice", []byte(`{"score":3}`))
+	if err := r.writes.sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	got := make(map[string]string)
+	if err := r.Load(func(key string, value []byte) { got[key] = string(value) }); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"alice": `{"score":3}`, "bob": `{"score":2}`}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("loaded %v, want %v", got, want)
+	}
+	if db := srv.selected(); db != "2" {
+		t.Errorf("selected database %q, want 2", db)
+	}
+}
+
+func TestRedisWrongPassword(t *testing.T) {
+	srv := newFakeRedis(t, "gossiper", "secret")
+	if _, err := OpenRedis("redis://gossiper:wrong@"+srv.addr, RemoteOptions{}); err == nil {
+		t.Fatal("connected with the wrong password")
+	}
+}
+
+// fakeRedis serves the commands the Redis backend sends, from one hash, handing out HSCAN pages of one field
+type fakeRedis struct {
+	addr           string
+	user, password string
+
+	mu     sync.Mutex
+	hash   map[string][]byte
+	dbName string
+}
+
+func newFakeRedis(t *testing.T, user, password string) *fakeRedis {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	srv := &fakeRedis{addr: ln.Addr().String(), user: user, password: password, hash: make(map[string][]byte)}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go srv.serve(conn)
+		}
+	}()
+	return srv
+}
+
+func (s *fakeRedis) selected() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dbName
+}
+
+func (s *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	r, w := bufio.NewReader(conn), bufio.NewWriter(conn)
+	authed := s.password == ""
+	for {
+		cmd, err := readRESP(r)
+		if err != nil {
+			return
+		}
+		items, _ := cmd.([]any)
+		args := make([]string, len(items))
+		for i, item := range items {
+			b, _ := item.([]byte)
+			args[i] = string(b)
+		}
+		if len(args) == 0 {
+			return
+		}
+
+		s.mu.Lock()
+		switch {
+		case args[0] == "AUTH":
+			if authed = slices.Equal(args[1:], []string{s.user, s.password}); authed {
+				w.WriteString("+OK\r\n")
+			} else {
+				w.WriteString("-WRONGPASS invalid username-password pair\r\n")
+			}
+		case !authed:
+			w.WriteString("-NOAUTH Authentication required.\r\n")
+		case args[0] == "SELECT":
+			s.dbName = args[1]
+			w.WriteString("+OK\r\n")
+		case args[0] == "PING":
+			w.WriteString("+PONG\r\n")
+		case args[0] == "HSET":
+			for i := 2; i+1 < len(args); i += 2 {
+				s.hash[args[i]] = []byte(args[i+1])
+			}
+			fmt.Fprintf(w, ":%d\r\n", (len(args)-2)/2)
+		case args[0] == "HSCAN":
+			keys := slices.Sorted(maps.Keys(s.hash))
+			var cursor int
+			fmt.Sscan(args[2], &cursor)
+			next := "0"
+			if cursor+1 < len(keys) {
+				next = fmt.Sprint(cursor + 1)
+			}
+			fmt.Fprintf(w, "*2\r\n$%d\r\n%s\r\n", len(next), next)
+			if cursor < len(keys) {
+				key, value := keys[cursor], s.hash[keys[cursor]]
+				fmt.Fprintf(w, "*2\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n", len(key), key, len(value), value)
+			} else {
+				w.WriteString("*0\r\n")
+			}
+		default:
+			fmt.Fprintf(w, "-ERR unknown command '%s'\r\n", args[0])
+		}
+		s.mu.Unlock()
+		if w.Flush() != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package store
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// RemoteOptions tune a backend kept on a shared server, such as Redis or Postgres. An interval or timeout that
+// isn't positive is taken from DefaultRemoteOptions
+type RemoteOptions struct {
+	Name         string        // hash key in Redis or table in Postgres the entries are kept in
+	SyncInterval time.Duration // how often buffered writes are sent to the server
+	Timeout      time.Duration // for connecting and for each round trip
+}
+
+// DefaultRemoteOptions flush every second, with a 10 second timeout
+func DefaultRemoteOptions() RemoteOptions {
+	return RemoteOptions{SyncInterval: time.Second, Timeout: 10 * time.Second}
+}
+
+// withDefaults fills in the interval and timeout from DefaultRemoteOptions where they aren't positive
+func (o RemoteOptions) withDefaults() RemoteOptions {
+	defaults := DefaultRemoteOptions()
+	if o.SyncInterval <= 0 {
+		o.SyncInterval = defaults.SyncInterval
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = defaults.Timeout
+	}
+	return o
+}
+
+// writeBehind buffers the latest value of every key saved and hands them to flush every interval, so that Save,
+// which the store calls with its lock held, never waits on the network. A failed flush keeps the values for the
+// next one, unless the key has been saved again since
+type writeBehind struct {
+	name  string // for logs
+	flush func(values map[string][]byte) error
+
+	mu      sync.Mutex
+	pending map[string][]byte
+	syncErr error
+	flushMu sync.Mutex // held while a flush is in progress, so flushes don't overlap
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+func newWriteBehind(name string, interval time.Duration, flush func(map[string][]byte) error) *writeBehind {
+	wb := &writeBehind{
+		name:    name,
+		flush:   flush,
+		pending: make(map[string][]byte),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go wb.syncLoop(interval)
+	return wb
+}
+
+func (wb *writeBehind) save(key string, value []byte) {
+	wb.mu.Lock()
+	wb.pending[key] = value
+	wb.mu.Unlock()
+}
+
+// sync sends every pending value
+func (wb *writeBehind) sync() error {
+	wb.flushMu.Lock()
+	defer wb.flushMu.Unlock()
+
+	wb.mu.Lock()
+	values := wb.pending
+	wb.pending = make(map[string][]byte)
+	wb.mu.Unlock()
+	if len(values) == 0 {
+		return nil
+	}
+
+	err := wb.flush(values)
+	if err != nil {
+		wb.mu.Lock()
+		for key, value := range values {
+			if _, ok := wb.pending[key]; !ok {
+				wb.pending[key] = value
+			}
+		}
+		wb.mu.Unlock()
+	}
+	return err
+}
+
+func (wb *writeBehind) syncLoop(interval time.Duration) {
+	defer close(wb.stopped)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			err := wb.sync()
+			if err != nil {
+				slog.Error("failed to sync backend", "backend", wb.name, "err", err)
+			}
+			wb.mu.Lock()
+			wb.syncErr = err
+			wb.mu.Unlock()
+		case <-wb.done:
+			return
+		}
+	}
+}
+
+// healthy returns the error from the last background flush, if it failed
+func (wb *writeBehind) healthy() error {
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+	if wb.syncErr != nil {
+		return fmt.Errorf("failed to sync %s: %w", wb.name, wb.syncErr)
+	}
+	return nil
+}
+
+// close stops the background flushes and sends what is left
+func (wb *writeBehind) close() error {
+	close(wb.done)
+	<-wb.stopped
+	return wb.sync()
+}
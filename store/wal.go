@@ -0,0 +1,380 @@
+// Package store contains durable storage backends for game server state
+package store
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Each WAL record is a fixed header followed by the payload:
+//
+//	crc32 (4 bytes) | payload length (4 bytes) | key length (uvarint) | key | value
+//
+// The checksum covers the payload, so a record torn by a crash mid-write is detected on replay and dropped. A
+// record with an empty value removes the key, see Remove
+const walHeaderSize = 8
+
+// compactMinRecords avoids rewriting small logs over and over
+const compactMinRecords = 1024
+
+// WAL is a file backed write-ahead log. Every Save and Remove appends a record; on replay only the latest record
+// for each key counts. Writes are buffered and flushed to disk every SyncInterval (and on Close), so a crash loses at most
+// that window of updates. Once the log holds more than twice as many records as live keys it is compacted in the
+// background by rewriting only the latest record for each key
+type WAL struct {
+	path string
+
+	mu      sync.Mutex
+	f       *os.File
+	w       *bufio.Writer
+	size    int64
+	index   map[string]int64 // key -> offset of its latest record
+	records int
+	syncErr error         // result of the last background flush
+	compact chan struct{} // wakes syncLoop to compact the log
+	done    chan struct{}
+	stopped chan struct{} // closed once syncLoop has returned
+}
+
+// OpenWAL opens or creates the log at path, flushing it to disk every syncInterval, which must be positive
+func OpenWAL(path string, syncInterval time.Duration) (*WAL, error) {
+	if syncInterval <= 0 {
+		return nil, fmt.Errorf("wal sync interval must be positive, got %s", syncInterval)
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &WAL{path: path, f: f, index: make(map[string]int64), compact: make(chan struct{}, 1),
+		done: make(chan struct{}), stopped: make(chan struct{})}
+	if err := w.scan(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	w.w = bufio.NewWriter(f)
+
+	go w.syncLoop(syncInterval)
+	return w, nil
+}
+
+// scan rebuilds the index and truncates any torn record at the tail of the file
+func (w *WAL) scan() error {
+	info, err := w.f.Stat()
+	if err != nil {
+		return err
+	}
+	if _, err := w.f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	r := bufio.NewReader(w.f)
+	var offset int64
+	for {
+		key, value, n, err := readRecord(r, info.Size()-offset)
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				// Anything after the first bad record is unreachable; cut it off so new records follow a good one
+				if err := w.f.Truncate(offset); err != nil {
+					return err
+				}
+			}
+			break
+		}
+		if len(value) == 0 {
+			delete(w.index, key)
+		} else {
+			w.index[key] = offset
+		}
+		w.records++
+		offset += n
+	}
+
+	w.size = offset
+	_, err = w.f.Seek(offset, io.SeekStart)
+	return err
+}
+
+// readRecord reads the next record from r, which has remaining bytes left. A length that runs past them is taken
+// for a torn or corrupt header rather than trusted with an allocation
+func readRecord(r io.Reader, remaining int64) (key string, value []byte, n int64, err error) {
+	var header [walHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			err = errors.New("torn record header")
+		}
+		return "", nil, 0, err
+	}
+	sum := binary.BigEndian.Uint32(header[:4])
+	length := binary.BigEndian.Uint32(header[4:])
+	if int64(length) > remaining-walHeaderSize {
+		return "", nil, 0, fmt.Errorf("torn record: length %d runs past the end of the file", length)
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return "", nil, 0, fmt.Errorf("torn record: %w", err)
+	}
+	if crc32.ChecksumIEEE(payload) != sum {
+		return "", nil, 0, errors.New("record checksum mismatch")
+	}
+
+	keyLen, k := binary.Uvarint(payload)
+	if k <= 0 || uint64(len(payload)-k) < keyLen {
+		return "", nil, 0, errors.New("malformed record")
+	}
+	key = string(payload[k : k+int(keyLen)])
+	value = payload[k+int(keyLen):]
+	return key, value, walHeaderSize + int64(length), nil
+}
+
+func encodeRecord(key string, value []byte) []byte {
+	payload := binary.AppendUvarint(nil, uint64(len(key)))
+	payload = append(payload, key...)
+	payload = append(payload, value...)
+
+	record := make([]byte, walHeaderSize, walHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(record[:4], crc32.ChecksumIEEE(payload))
+	binary.BigEndian.PutUint32(record[4:], uint32(len(payload)))
+	return append(record, payload...)
+}
+
+// Save appends the new value of key to the log
+func (w *WAL) Save(key string, value []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	record := encodeRecord(key, value)
+	if _, err := w.w.Write(record); err != nil {
+		return err
+	}
+	w.index[key] = w.size
+	w.size += int64(len(record))
+	w.records++
+	w.maybeCompactLocked()
+	return nil
+}
+
+// Remove appends a record that forgets key, so it isn't loaded again. A key that isn't in the log is left alone
+func (w *WAL) Remove(key string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if _, ok := w.index[key]; !ok {
+		return nil
+	}
+	record := encodeRecord(key, nil)
+	if _, err := w.w.Write(record); err != nil {
+		return err
+	}
+	delete(w.index, key)
+	w.size += int64(len(record))
+	w.records++
+	w.maybeCompactLocked()
+	return nil
+}
+
+// maybeCompactLocked wakes syncLoop to compact the log if it has grown enough
+func (w *WAL) maybeCompactLocked() {
+	if w.needsCompactionLocked() {
+		select {
+		case w.compact <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (w *WAL) needsCompactionLocked() bool {
+	return w.records > compactMinRecords && w.records > 2*len(w.index)
+}
+
+// Load calls fn with the latest value of every key in the log
+func (w *WAL) Load(fn func(key string, value []byte)) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := w.w.Flush(); err != nil {
+		return err
+	}
+	return w.eachLive(w.f, w.size, w.index, func(key string, value []byte, _ []byte) error {
+		fn(key, value)
+		return nil
+	})
+}
+
+// eachLive walks the first size bytes of f, calling fn for records that are the latest for their key by index. The
+// raw encoded record is passed along so compaction can copy it verbatim
+func (w *WAL) eachLive(f *os.File, size int64, index map[string]int64,
+	fn func(key string, value, record []byte) error) error {
+	r := bufio.NewReader(io.NewSectionReader(f, 0, size))
+	var offset int64
+	for offset < size {
+		key, value, n, err := readRecord(r, size-offset)
+		if err != nil {
+			return fmt.Errorf("failed to read %s at offset %d: %w", w.path, offset, err)
+		}
+		if latest, ok := index[key]; ok && latest == offset {
+			if err := fn(key, value, encodeRecord(key, value)); err != nil {
+				return err
+			}
+		}
+		offset += n
+	}
+	return nil
+}
+
+// compactLog rewrites the log with only the latest record for each key and atomically swaps it in. The records
+// written before it started are copied without holding the lock, so saves carry on meanwhile; the few saved since
+// are copied as they are once it takes the lock again to swap the files
+func (w *WAL) compactLog() error {
+	w.mu.Lock()
+	if !w.needsCompactionLocked() {
+		w.mu.Unlock()
+		return nil
+	}
+	if err := w.w.Flush(); err != nil {
+		w.mu.Unlock()
+		return err
+	}
+	old, oldSize, oldRecords, oldIndex := w.f, w.size, w.records, maps.Clone(w.index)
+	w.mu.Unlock()
+
+	tmp, err := os.CreateTemp(filepath.Dir(w.path), ".wal-compact-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	out := bufio.NewWriter(tmp)
+	index := make(map[string]int64, len(oldIndex))
+	var size int64
+	err = w.eachLive(old, oldSize, oldIndex, func(key string, _, record []byte) error {
+		index[key] = size
+		size += int64(len(record))
+		_, err := out.Write(record)
+		return err
+	})
+	if err == nil {
+		err = out.Flush()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	live := len(index)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	// Saved while the live records were copied
+	tail := w.size - oldSize
+	err = w.w.Flush()
+	if err == nil {
+		_, err = io.Copy(out, io.NewSectionReader(w.f, oldSize, tail))
+	}
+	if err == nil {
+		err = out.Flush()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), w.path)
+	}
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	for key, offset := range w.index {
+		if offset >= oldSize {
+			index[key] = size + offset - oldSize
+		}
+	}
+	for key := range index {
+		// Removed while the live records were copied; the removal is in the tail
+		if _, ok := w.index[key]; !ok {
+			delete(index, key)
+		}
+	}
+	w.f.Close()
+	w.f = tmp
+	w.size = size + tail
+	if _, err := w.f.Seek(w.size, io.SeekStart); err != nil {
+		return err
+	}
+	w.w = bufio.NewWriter(w.f)
+	w.index = index
+	w.records = live + w.records - oldRecords
+	return nil
+}
+
+func (w *WAL) syncLoop(interval time.Duration) {
+	defer close(w.stopped)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			w.mu.Lock()
+			w.syncErr = w.syncLocked()
+			if w.syncErr != nil {
+				slog.Error("failed to sync write-ahead log", "path", w.path, "err", w.syncErr)
+			}
+			w.mu.Unlock()
+		case <-w.compact:
+			if err := w.compactLog(); err != nil {
+				slog.Error("failed to compact write-ahead log", "path", w.path, "err", err)
+			}
+		case <-w.done:
+			return
+		}
+	}
+}
+
+func (w *WAL) syncLocked() error {
+	if err := w.w.Flush(); err != nil {
+		return err
+	}
+	return w.f.Sync()
+}
+
+// Healthy returns the error from the last background flush, if it failed
+func (w *WAL) Healthy() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.syncErr != nil {
+		return fmt.Errorf("failed to sync %s: %w", w.path, w.syncErr)
+	}
+	return nil
+}
+
+// Close flushes outstanding writes to disk and closes the file
+func (w *WAL) Close() error {
+	close(w.done)
+	<-w.stopped
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.syncLocked(); err != nil {
+		w.f.Close()
+		return err
+	}
+	return w.f.Close()
+}
@@ -0,0 +1,160 @@
+package store
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestWALCompactsInTheBackground saves the same keys over and over from several goroutines, so compactions run
+// while saves carry on, and checks a reopened log holds the latest value of every key and no more records than it
+// needs
+func TestWALCompactsInTheBackground(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.wal")
+	w, err := OpenWAL(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const keys, rounds = 100, 100
+	var wg sync.WaitGroup
+	for g := range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for round := range rounds {
+				for k := g; k < keys; k += 4 {
+					if err := w.Save(fmt.Sprintf("key-%d", k), fmt.Appendf(nil, "%d", round)); err != nil {
+						t.Error(err)
+						return
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err = OpenWAL(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	got := make(map[string]string)
+	if err := w.Load(func(key string, value []byte) { got[key] = string(value) }); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != keys {
+		t.Fatalf("loaded %d keys, want %d", len(got), keys)
+	}
+	for key, value := range got {
+		if want := fmt.Sprint(rounds - 1); value != want {
+			t.Errorf("%s = %s, want %s", key, value, want)
+		}
+	}
+	if w.records >= keys*rounds {
+		t.Errorf("%d records after %d saves, the log was never compacted", w.records, keys*rounds)
+	}
+}
+
+// TestWALTruncatesAnOversizedRecord corrupts the length of the last record to claim far more bytes than the file
+// holds, and checks the log reopens without it instead of trying to read that much
+func TestWALTruncatesAnOversizedRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.wal")
+	w, err := OpenWAL(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Save("a", []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Save("b", []byte("2")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := len(encodeRecord("a", []byte("1")))
+	binary.BigEndian.PutUint32(data[last+4:], 0xffffffff)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err = OpenWAL(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	got := make(map[string]string)
+	if err := w.Load(func(key string, value []byte) { got[key] = string(value) }); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got["a"] != "1" {
+		t.Errorf("loaded %v, want only a = 1", got)
+	}
+	if w.size != int64(last) {
+		t.Errorf("log is %d bytes, want the corrupt record cut off at %d", w.size, last)
+	}
+}
+
+// TestWALForgetsRemovedKeys checks a removed key stays gone once the log is reopened, whether the removal is still
+// in the log or the log has been compacted since
+func TestWALForgetsRemovedKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.wal")
+	w, err := OpenWAL(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"kept", "removed"} {
+		if err := w.Save(key, []byte(key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Remove("removed"); err != nil {
+		t.Fatal(err)
+	}
+
+	load := func() map[string]string {
+		t.Helper()
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if w, err = OpenWAL(path, time.Hour); err != nil {
+			t.Fatal(err)
+		}
+		got := make(map[string]string)
+		if err := w.Load(func(key string, value []byte) { got[key] = string(value) }); err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+	if got := load(); len(got) != 1 || got["kept"] != "kept" {
+		t.Fatalf("loaded %v after the removal, want only kept", got)
+	}
+
+	for range compactMinRecords {
+		if err := w.Save("kept", []byte("kept")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.compactLog(); err != nil {
+		t.Fatal(err)
+	}
+	if got := load(); len(got) != 1 || got["kept"] != "kept" {
+		t.Errorf("loaded %v after compaction, want only kept", got)
+	}
+	if w.records != 1 {
+		t.Errorf("%d records after compaction, want 1", w.records)
+	}
+	w.Close()
+}
@@ -0,0 +1,218 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// OTLPExporter sends spans to an OpenTelemetry collector, or any backend that accepts OTLP/HTTP with JSON
+// encoding, such as Jaeger or Grafana Tempo
+type OTLPExporter struct {
+	Endpoint string      // full URL of the traces endpoint, e.g. http://localhost:4318/v1/traces
+	Headers  http.Header // added to every request, e.g. for the backend's credentials
+	Client   *http.Client
+}
+
+func (e *OTLPExporter) ExportSpans(ctx context.Context, service, instance string, spans []SpanData) error {
+	body, err := json.Marshal(otlpRequest(service, instance, spans))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for name, values := range e.Headers {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := e.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("exporting %d spans to %s returned %s", len(spans), e.Endpoint, resp.Status)
+	}
+	return nil
+}
+
+// The OTLP/JSON encoding of a trace export request, with IDs in hex and 64 bit integers as strings
+type (
+	otlpTraces struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpSpan struct {
+		TraceID      string          `json:"traceId"`
+		SpanID       string          `json:"spanId"`
+		ParentSpanID string          `json:"parentSpanId,omitempty"`
+		Name         string          `json:"name"`
+		Kind         SpanKind        `json:"kind"`
+		Start        string          `json:"startTimeUnixNano"`
+		End          string          `json:"endTimeUnixNano"`
+		Attributes   []otlpAttribute `json:"attributes,omitempty"`
+		Status       otlpStatus      `json:"status"`
+	}
+	otlpStatus struct {
+		Code    int    `json:"code"` // 0 unset, 2 error
+		Message string `json:"message,omitempty"`
+	}
+	otlpAttribute struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+	otlpValue struct {
+		String *string     `json:"stringValue,omitempty"`
+		Bool   *bool       `json:"boolValue,omitempty"`
+		Int    *string     `json:"intValue,omitempty"`
+		Double *float64    `json:"doubleValue,omitempty"`
+		Array  *otlpValues `json:"arrayValue,omitempty"`
+	}
+	otlpValues struct {
+		Values []otlpValue `json:"values"`
+	}
+)
+
+func otlpRequest(service, instance string, spans []SpanData) otlpTraces {
+	encoded := make([]otlpSpan, len(spans))
+	for i, s := range spans {
+		encoded[i] = otlpSpan{
+			TraceID:    s.Context.TraceID.String(),
+			SpanID:     s.Context.SpanID.String(),
+			Name:       s.Name,
+			Kind:       s.Kind,
+			Start:      strconv.FormatInt(s.Start.UnixNano(), 10),
+			End:        strconv.FormatInt(s.End.UnixNano(), 10),
+			Attributes: otlpAttributes(s.Attributes),
+		}
+		if s.Parent != (SpanID{}) {
+			encoded[i].ParentSpanID = s.Parent.String()
+		}
+		if s.Error != "" {
+			encoded[i].Status = otlpStatus{Code: 2, Message: s.Error}
+		}
+	}
+	resource := otlpAttributes([]Attribute{Attr("service.name", service), Attr("service.instance.id", instance)})
+	return otlpTraces{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: resource},
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: "gmathur.dev/gossiper"}, Spans: encoded}},
+	}}}
+}
+
+func otlpAttributes(attrs []Attribute) []otlpAttribute {
+	encoded := make([]otlpAttribute, 0, len(attrs))
+	for _, a := range attrs {
+		encoded = append(encoded, otlpAttribute{Key: a.Key, Value: otlpValueOf(a.Value)})
+	}
+	return encoded
+}
+
+func otlpValueOf(v any) otlpValue {
+	switch v := v.(type) {
+	case string:
+		return otlpValue{String: &v}
+	case bool:
+		return otlpValue{Bool: &v}
+	case int:
+		s := strconv.Itoa(v)
+		return otlpValue{Int: &s}
+	case int64:
+		s := strconv.FormatInt(v, 10)
+		return otlpValue{Int: &s}
+	case uint64:
+		s := strconv.FormatUint(v, 10)
+		return otlpValue{Int: &s}
+	case float64:
+		return otlpValue{Double: &v}
+	case []string:
+		values := make([]otlpValue, len(v))
+		for i, s := range v {
+			values[i] = otlpValueOf(s)
+		}
+		return otlpValue{Array: &otlpValues{Values: values}}
+	default:
+		s := fmt.Sprint(v)
+		return otlpValue{String: &s}
+	}
+}
+
+// LogExporter writes every span to a logger at debug level, for trying tracing out without a backend
+type LogExporter struct {
+	Logger *slog.Logger
+}
+
+func (e LogExporter) ExportSpans(ctx context.Context, service, instance string, spans []SpanData) error {
+	for _, s := range spans {
+		args := []any{"name", s.Name, "trace", s.Context.TraceID.String(), "span", s.Context.SpanID.String(),
+			"duration", s.End.Sub(s.Start)}
+		if s.Parent != (SpanID{}) {
+			args = append(args, "parent", s.Parent.String())
+		}
+		for _, a := range s.Attributes {
+			args = append(args, a.Key, a.Value)
+		}
+		if s.Error != "" {
+			args = append(args, "error", s.Error)
+		}
+		e.Logger.DebugContext(ctx, "span", args...)
+	}
+	return nil
+}
+
+// ParseHeaders parses a comma separated list of name=value headers
+func ParseHeaders(s string) (http.Header, error) {
+	header := make(http.Header)
+	if s == "" {
+		return header, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("header %q is not name=value", pair)
+		}
+		header.Add(name, value)
+	}
+	return header, nil
+}
+
+// NewExporter builds the exporter named by kind: "otlp" sends to endpoint, "log" logs spans at debug level
+func NewExporter(kind, endpoint string, logger *slog.Logger) (Exporter, error) {
+	switch kind {
+	case "otlp":
+		if endpoint == "" {
+			return nil, fmt.Errorf("the otlp trace exporter needs an endpoint")
+		}
+		return &OTLPExporter{Endpoint: endpoint}, nil
+	case "log":
+		return LogExporter{Logger: logger}, nil
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q", kind)
+	}
+}
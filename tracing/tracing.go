@@ -0,0 +1,361 @@
+// Package tracing is a small, dependency free implementation of OpenTelemetry style distributed tracing: spans
+// with W3C trace context propagation over HTTP headers, exported in batches over OTLP/HTTP or to a logger
+package tracing
+
+import (
+	"context"
+	"encoding/hex"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TraceparentHeader carries a span's context between nodes, as specified by W3C Trace Context
+const TraceparentHeader = "traceparent"
+
+type (
+	TraceID [16]byte
+	SpanID  [8]byte
+)
+
+func (id TraceID) String() string { return hex.EncodeToString(id[:]) }
+func (id SpanID) String() string  { return hex.EncodeToString(id[:]) }
+
+// SpanContext identifies a span across process boundaries
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool // the trace is recorded; spans of an unsampled trace are propagated but not exported
+}
+
+// Valid reports whether the context identifies a span
+func (sc SpanContext) Valid() bool {
+	return sc.TraceID != TraceID{} && sc.SpanID != SpanID{}
+}
+
+// Traceparent formats the context as a traceparent header value
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + flags
+}
+
+// ParseTraceparent parses a traceparent header value
+func ParseTraceparent(s string) (SpanContext, bool) {
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 ||
+		len(parts[3]) != 2 {
+		return SpanContext{}, false
+	}
+	var sc SpanContext
+	var flags [1]byte
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return SpanContext{}, false
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return SpanContext{}, false
+	}
+	if _, err := hex.Decode(flags[:], []byte(parts[3])); err != nil {
+		return SpanContext{}, false
+	}
+	sc.Sampled = flags[0]&1 == 1
+	return sc, sc.Valid()
+}
+
+// SpanKind says what a span's operation is to the rest of the system
+type SpanKind int
+
+const (
+	KindInternal SpanKind = iota + 1
+	KindServer            // handling a request from another process
+	KindClient            // a request to another process
+)
+
+// Attribute is a key-value pair describing a span. Values are strings, bools, integers, floats or string slices
+type Attribute struct {
+	Key   string
+	Value any
+}
+
+// Attr builds an Attribute
+func Attr(key string, value any) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// SpanData is a finished span, as handed to an Exporter
+type SpanData struct {
+	Name       string
+	Kind       SpanKind
+	Context    SpanContext
+	Parent     SpanID // zero for a root span
+	Start      time.Time
+	End        time.Time
+	Attributes []Attribute
+	Error      string // set when the operation failed
+}
+
+// Exporter sends finished spans to a tracing backend
+type Exporter interface {
+	ExportSpans(ctx context.Context, service, instance string, spans []SpanData) error
+}
+
+// Tracer starts spans and exports the sampled ones in batches, every FlushInterval or as soon as BatchSize have
+// ended. A nil Tracer starts no spans, so instrumented code needs no checks of its own
+type Tracer struct {
+	Service       string  // service.name of every span
+	Instance      string  // service.instance.id of every span, e.g. the node ID
+	SampleRatio   float64 // share of new traces recorded, 0 to 1; traces started elsewhere follow the caller's choice
+	BatchSize     int
+	MaxQueue      int           // ended spans kept waiting for export, the newest are dropped beyond it
+	FlushInterval time.Duration // 0 or less is DefaultFlushInterval
+	Exporter      Exporter
+	Logger        *slog.Logger
+
+	mu      sync.Mutex
+	queue   []SpanData
+	dropped int
+	flush   chan struct{}
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+// DefaultFlushInterval is how often a tracer exports the spans that ended, unless a full batch goes first
+const DefaultFlushInterval = 5 * time.Second
+
+// NewTracer creates a tracer that samples every trace and starts its export loop. Close it to flush the spans
+// still queued
+func NewTracer(service, instance string, exporter Exporter, logger *slog.Logger) *Tracer {
+	t := &Tracer{
+		Service:       service,
+		Instance:      instance,
+		SampleRatio:   1,
+		BatchSize:     512,
+		MaxQueue:      4096,
+		FlushInterval: DefaultFlushInterval,
+		Exporter:      exporter,
+		Logger:        logger,
+		flush:         make(chan struct{}, 1),
+		done:          make(chan struct{}),
+		stopped:       make(chan struct{}),
+	}
+	go t.exportLoop()
+	return t
+}
+
+// Start begins a span as a child of the span in ctx, or of the remote span put there by Extract, or as the root
+// of a new trace. End the returned span when the operation is done
+func (t *Tracer) Start(ctx context.Context, name string, kind SpanKind, attrs ...Attribute) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+	parent := SpanContextFromContext(ctx)
+	sc := SpanContext{TraceID: parent.TraceID, Sampled: parent.Sampled}
+	if !parent.Valid() {
+		sc.TraceID = newTraceID()
+		sc.Sampled = rand.Float64() < t.SampleRatio
+	}
+	sc.SpanID = newSpanID()
+
+	s := &Span{tracer: t, data: SpanData{
+		Name:       name,
+		Kind:       kind,
+		Context:    sc,
+		Parent:     parent.SpanID,
+		Start:      time.Now(),
+		Attributes: attrs,
+	}}
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// Close stops the export loop once the queued spans have been exported, or ctx is done
+func (t *Tracer) Close(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	close(t.done)
+	select {
+	case <-t.stopped:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return t.export(ctx)
+}
+
+func (t *Tracer) exportLoop() {
+	defer close(t.stopped)
+	interval := t.FlushInterval
+	if interval <= 0 {
+		interval = DefaultFlushInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-t.done:
+			return
+		case <-ticker.C:
+		case <-t.flush:
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		if err := t.export(ctx); err != nil {
+			t.Logger.Warn("failed to export spans", "err", err)
+		}
+		cancel()
+	}
+}
+
+// export hands every queued span to the exporter, a batch at a time
+func (t *Tracer) export(ctx context.Context) error {
+	for {
+		t.mu.Lock()
+		n := min(len(t.queue), max(t.BatchSize, 1))
+		batch := t.queue[:n:n]
+		t.queue = t.queue[n:]
+		dropped := t.dropped
+		t.dropped = 0
+		t.mu.Unlock()
+
+		if dropped > 0 {
+			t.Logger.Warn("dropped spans, the export queue was full", "count", dropped)
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := t.Exporter.ExportSpans(ctx, t.Service, t.Instance, batch); err != nil {
+			return err
+		}
+	}
+}
+
+func (t *Tracer) enqueue(data SpanData) {
+	t.mu.Lock()
+	if len(t.queue) >= t.MaxQueue {
+		t.dropped++
+	} else {
+		t.queue = append(t.queue, data)
+	}
+	full := len(t.queue) >= t.BatchSize
+	t.mu.Unlock()
+
+	if full {
+		select {
+		case t.flush <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Span is an operation being traced. All of its methods do nothing on a nil Span
+type Span struct {
+	tracer *Tracer
+	mu     sync.Mutex
+	data   SpanData
+	ended  bool
+}
+
+// Context returns the span's context, the zero SpanContext for a nil Span
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.data.Context
+}
+
+// SetAttributes adds attributes to the span
+func (s *Span) SetAttributes(attrs ...Attribute) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.data.Attributes = append(s.data.Attributes, attrs...)
+	s.mu.Unlock()
+}
+
+// SetError marks the span as failed with err, if err isn't nil
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	s.data.Error = err.Error()
+	s.mu.Unlock()
+}
+
+// End finishes the span and queues it for export if its trace is sampled. Only the first call has any effect
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	data := s.data
+	s.mu.Unlock()
+
+	if data.Context.Sampled {
+		s.tracer.enqueue(data)
+	}
+}
+
+type (
+	spanKey   struct{}
+	remoteKey struct{}
+)
+
+// SpanFromContext returns the span started in ctx, or nil
+func SpanFromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+// SpanContextFromContext returns the context of the span started in ctx, or the remote one put there by
+// Extract, or the zero SpanContext
+func SpanContextFromContext(ctx context.Context) SpanContext {
+	if s := SpanFromContext(ctx); s != nil {
+		return s.Context()
+	}
+	sc, _ := ctx.Value(remoteKey{}).(SpanContext)
+	return sc
+}
+
+// Inject sets the traceparent header for the span in ctx, so the receiver's spans join its trace
+func Inject(ctx context.Context, header http.Header) {
+	if sc := SpanContextFromContext(ctx); sc.Valid() {
+		header.Set(TraceparentHeader, sc.Traceparent())
+	}
+}
+
+// Extract returns ctx with the remote span named by the traceparent header, if any, for Start to continue
+func Extract(ctx context.Context, header http.Header) context.Context {
+	sc, ok := ParseTraceparent(header.Get(TraceparentHeader))
+	if !ok {
+		return ctx
+	}
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+func newTraceID() TraceID {
+	var id TraceID
+	for id == (TraceID{}) {
+		rand.Read(id[:])
+	}
+	return id
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	for id == (SpanID{}) {
+		rand.Read(id[:])
+	}
+	return id
+}
@@ -0,0 +1,391 @@
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gmathur.dev/gossiper/gossip"
+	"gmathur.dev/gossiper/server"
+)
+
+// maxSnapshotBodySize caps snapshots uploaded to /admin/snapshot
+const maxSnapshotBodySize = 1 << 30
+
+// HandleAdminStatus reports the node's identity, uptime, state size, gossip activity and view of its peers
+func (s *Server) HandleAdminStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		methodNotAllowed(w, http.MethodGet, http.MethodHead)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, s.gs.Status())
+}
+
+// HandleAdminCluster reports the status of every member that answers, as each reports it, see
+// server.GameServer.ClusterStatus
+func (s *Server) HandleAdminCluster(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		methodNotAllowed(w, http.MethodGet, http.MethodHead)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, s.gs.ClusterStatus(r.Context()))
+}
+
+// HandleAdminGossip runs a gossip round straight away and returns once it is done
+func (s *Server) HandleAdminGossip(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	s.gs.GossipNow(r.Context())
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// SyncResult is the outcome of a full sync with each peer, "ok" or the reason it failed
+type SyncResult struct {
+	Peers map[string]string `json:"peers"`
+}
+
+// HandleAdminSync runs a full sync with the peer given by the peer parameter, or with every alive peer. It answers
+// 502 if any of them failed
+func (s *Server) HandleAdminSync(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	peers := s.gs.Membership.Peers()
+	if peer := r.URL.Query().Get("peer"); peer != "" {
+		if !slices.Contains(peers, peer) {
+			writeAPIError(w, &APIError{Code: CodeNotFound, Message: "peer not found or not alive", Details: map[string]any{"peer": peer}})
+			return
+		}
+		peers = []string{peer}
+	}
+
+	status := http.StatusOK
+	result := SyncResult{Peers: make(map[string]string, len(peers))}
+	for _, peer := range peers {
+		result.Peers[peer] = "ok"
+		if err := s.gs.SyncWithPeer(r.Context(), peer); err != nil {
+			result.Peers[peer] = err.Error()
+			status = http.StatusBadGateway
+		}
+	}
+
+	writeJSON(w, status, result)
+}
+
+// RestoreResult reports how many entries an uploaded snapshot held
+type RestoreResult struct {
+	Restored int `json:"restored"`
+}
+
+// HandleAdminSnapshot dumps the node's state on GET and merges an uploaded snapshot into it on POST. The format
+// parameter selects json (the default) or gob
+func (s *Server) HandleAdminSnapshot(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "gob" {
+		writeFieldError(w, "format", "format must be json or gob")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		if format == "json" {
+			w.Header().Set("Content-Type", "application/json")
+		} else {
+			w.Header().Set("Content-Type", "application/octet-stream")
+		}
+		w.Header().Set("Content-Disposition", `attachment; filename="`+s.gs.ID+`.snapshot.`+format+`"`)
+		if err := s.gs.State.WriteSnapshot(w, format); err != nil {
+			s.gs.Logger.Error("failed to write snapshot", "err", err)
+		}
+	case http.MethodPost:
+		n, err := s.gs.RestoreSnapshot(http.MaxBytesReader(w, r.Body, maxSnapshotBodySize), format)
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeBodyError(w, err)
+			return
+		}
+		if err != nil {
+			writeError(w, CodeInvalidArgument, "invalid snapshot: "+err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, RestoreResult{Restored: n})
+	default:
+		methodNotAllowed(w, http.MethodGet, http.MethodPost)
+	}
+}
+
+// exportFormat reads the format parameter of /admin/export and /admin/import, json by default
+func exportFormat(w http.ResponseWriter, r *http.Request) (string, bool) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "gob" {
+		writeFieldError(w, "format", "format must be json or gob")
+		return "", false
+	}
+	return format, true
+}
+
+// HandleAdminExport streams the node's state in key order as an export (see gossip.Store.Export): newline
+// delimited JSON, or gob with format=gob. The prefix parameter exports only players whose ID starts with it, and
+// tombstones=false leaves out deleted players
+func (s *Server) HandleAdminExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+	format, ok := exportFormat(w, r)
+	if !ok {
+		return
+	}
+	filter := gossip.ExportFilter{Prefix: r.URL.Query().Get("prefix"), Tombstones: true}
+	if v := r.URL.Query().Get("tombstones"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			writeFieldError(w, "tombstones", "tombstones must be true or false")
+			return
+		}
+		filter.Tombstones = b
+	}
+
+	if format == "json" {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	} else {
+		w.Header().Set("Content-Type", "application/octet-stream")
+	}
+	w.Header().Set("Content-Disposition", `attachment; filename="`+s.gs.ID+`.export.`+format+`"`)
+	n, err := s.gs.State.Export(w, format, filter)
+	if err != nil {
+		// The response has started, so all the client sees is a truncated stream
+		s.gs.Logger.Error("failed to export state", "exported", n, "err", err)
+		return
+	}
+	s.gs.Logger.Info("exported state", "players", n, "prefix", filter.Prefix)
+}
+
+// ImportResult reports how many entries an import merged
+type ImportResult struct {
+	Imported int `json:"imported"`
+}
+
+// HandleAdminImport merges an export uploaded in the body into the cluster through the node, as it is read. There
+// is no size limit: a broken stream leaves what was read before it merged, and is answered with the count so far
+func (s *Server) HandleAdminImport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+	format, ok := exportFormat(w, r)
+	if !ok {
+		return
+	}
+
+	n, err := s.gs.ImportState(r.Body, format)
+	if err != nil {
+		writeAPIError(w, &APIError{Code: CodeInvalidArgument, Message: "invalid export: " + err.Error(),
+			Details: map[string]any{"imported": n}})
+		return
+	}
+	writeJSON(w, http.StatusOK, ImportResult{Imported: n})
+}
+
+// ChaosSettings are the faults chaos injection is applying, see server.ChaosConfig
+type ChaosSettings struct {
+	Drop    float64  `json:"drop"`
+	Delay   string   `json:"delay"`
+	Jitter  string   `json:"jitter"`
+	Corrupt float64  `json:"corrupt"`
+	Isolate []string `json:"isolate"`
+}
+
+func chaosSettings(cfg server.ChaosConfig) ChaosSettings {
+	return ChaosSettings{Drop: cfg.Drop, Delay: cfg.Delay.String(), Jitter: cfg.Jitter.String(), Corrupt: cfg.Corrupt,
+		Isolate: append([]string{}, cfg.Isolate...)}
+}
+
+// RoomSettings is the answer to /admin/rooms
+type RoomSettings struct {
+	Rooms []string `json:"rooms"` // empty when the node holds every room
+}
+
+// HandleAdminRooms shows the rooms the node holds on GET, replaces them with the comma separated ?rooms= on POST,
+// and makes the node hold every room again on DELETE, see server.GameServer.SetRooms
+func (s *Server) HandleAdminRooms(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost, http.MethodDelete:
+		var rooms []string
+		if r.Method == http.MethodPost {
+			for _, room := range strings.Split(r.URL.Query().Get("rooms"), ",") {
+				if room = strings.TrimSpace(room); room != "" {
+					rooms = append(rooms, room)
+				}
+			}
+		}
+		if err := s.gs.SetRooms(rooms); err != nil {
+			writeFieldError(w, "rooms", err.Error())
+			return
+		}
+	default:
+		methodNotAllowed(w, http.MethodGet, http.MethodPost, http.MethodDelete)
+		return
+	}
+	writeJSON(w, http.StatusOK, RoomSettings{Rooms: append([]string{}, s.gs.HeldRooms()...)})
+}
+
+// HandleAdminLeases lists the leases that are held on GET, and breaks the one named by ?name= on DELETE, whoever
+// holds it, for a holder that went away without releasing a long lease
+func (s *Server) HandleAdminLeases(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, s.gs.HeldLeases())
+	case http.MethodDelete:
+		name := r.URL.Query().Get("name")
+		if err := validateId("name", name); err != nil {
+			writeFieldError(w, "name", err.Error())
+			return
+		}
+		l, ok := s.gs.GetLease(name)
+		if !ok || !s.gs.ReleaseLease(name, l.Holder) {
+			writeAPIError(w, &APIError{Code: CodeNotFound, Message: "lease isn't held", Details: map[string]any{"name": name}})
+			return
+		}
+		writeJSON(w, http.StatusOK, l)
+	default:
+		methodNotAllowed(w, http.MethodGet, http.MethodDelete)
+	}
+}
+
+// HandleAdminClocks reports the skew between the node's clock and its peers', see server.GameServer.Clocks
+func (s *Server) HandleAdminClocks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.gs.Clocks())
+}
+
+// HandleAdminDecommission takes the node out of the cluster for good, handing its state to ?replicas= peers (2 if
+// not given) first, see server.GameServer.Decommission. The process shuts down once the response is written
+func (s *Server) HandleAdminDecommission(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+	query := r.URL.Query()
+	replicas := 2
+	if v := query.Get("replicas"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			writeFieldError(w, "replicas", "replicas must be a positive integer")
+			return
+		}
+		replicas = n
+	}
+	force := false
+	if v := query.Get("force"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			writeFieldError(w, "force", "force must be true or false")
+			return
+		}
+		force = b
+	}
+
+	report, err := s.gs.Decommission(r.Context(), replicas, force)
+	switch {
+	case errors.Is(err, server.ErrDecommissioning):
+		writeError(w, CodeConflict, err.Error())
+	case err != nil:
+		writeAPIError(w, &APIError{Code: CodePeerUnreachable, Message: err.Error(), Details: map[string]any{"peers": report.Peers}})
+	default:
+		writeJSON(w, http.StatusOK, report)
+	}
+}
+
+// HandleAdminChaos shows the faults being injected into the node's peer traffic on GET, replaces them on POST
+// with the drop, delay, jitter, corrupt and isolate parameters (any left out are turned off), and turns them all
+// off on DELETE. It answers 404 unless the node was started with chaos injection enabled
+func (s *Server) HandleAdminChaos(w http.ResponseWriter, r *http.Request) {
+	chaos := s.gs.Chaos
+	if chaos == nil {
+		writeError(w, CodeNotFound, "chaos injection is not enabled on this node")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		cfg, err := parseChaosConfig(r.URL.Query())
+		if err == nil {
+			err = chaos.Set(cfg)
+		}
+		if err != nil {
+			writeError(w, CodeInvalidArgument, err.Error())
+			return
+		}
+		s.gs.Logger.Warn("chaos injection changed", "drop", cfg.Drop, "delay", cfg.Delay, "jitter", cfg.Jitter,
+			"corrupt", cfg.Corrupt, "isolate", cfg.Isolate)
+	case http.MethodDelete:
+		chaos.Set(server.ChaosConfig{})
+		s.gs.Logger.Info("chaos injection turned off")
+	default:
+		methodNotAllowed(w, http.MethodGet, http.MethodPost, http.MethodDelete)
+		return
+	}
+	writeJSON(w, http.StatusOK, chaosSettings(chaos.Config()))
+}
+
+func parseChaosConfig(query url.Values) (server.ChaosConfig, error) {
+	for key := range query {
+		if !slices.Contains([]string{"drop", "delay", "jitter", "corrupt", "isolate"}, key) {
+			return server.ChaosConfig{}, fmt.Errorf("unknown chaos setting %q", key)
+		}
+	}
+	var cfg server.ChaosConfig
+	var err error
+	parseFloat := func(name string, v *float64) {
+		if s := query.Get(name); s != "" && err == nil {
+			if *v, err = strconv.ParseFloat(s, 64); err != nil {
+				err = fmt.Errorf("%s must be a number", name)
+			}
+		}
+	}
+	parseDuration := func(name string, v *time.Duration) {
+		if s := query.Get(name); s != "" && err == nil {
+			if *v, err = time.ParseDuration(s); err != nil {
+				err = fmt.Errorf("%s must be a duration such as 200ms", name)
+			}
+		}
+	}
+	parseFloat("drop", &cfg.Drop)
+	parseFloat("corrupt", &cfg.Corrupt)
+	parseDuration("delay", &cfg.Delay)
+	parseDuration("jitter", &cfg.Jitter)
+	for _, peers := range query["isolate"] {
+		for _, peer := range strings.Split(peers, ",") {
+			if peer = strings.TrimSpace(peer); peer != "" {
+				cfg.Isolate = append(cfg.Isolate, peer)
+			}
+		}
+	}
+	return cfg, err
+}
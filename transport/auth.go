@@ -0,0 +1,148 @@
+package transport
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Authenticator checks the credentials of a client API request. It is separate from the cluster keyring, which
+// authenticates peers: clients hold API keys or tokens, never the cluster key
+type Authenticator interface {
+	// Authenticate returns nil if the request may go ahead, ErrNoCredentials if it carries none this
+	// authenticator understands, and any other error if its credentials are invalid
+	Authenticate(r *http.Request) error
+}
+
+// ErrNoCredentials is returned by an Authenticator for a request without credentials it recognises
+var ErrNoCredentials = errors.New("missing credentials")
+
+// SetAuthenticator requires every request to the client API (/update, /increment, /delete, /state, /leaderboard,
+// /watch, /subscribe, /events, /presence, /broadcast, /leases, /settings, /rooms and their room forms, /whois and
+// /leader) to pass auth. Call it before serving requests; nil leaves the API open
+func (s *Server) SetAuthenticator(auth Authenticator) {
+	s.auth = auth
+}
+
+// clientAuth rejects client requests that don't pass the authenticator, if one is set, and every client request
+// while the node bootstraps its state, see server.GameServer.Bootstrapped
+func (s *Server) clientAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-s.gs.Bootstrapped():
+		default:
+			w.Header().Set("Retry-After", "1")
+			writeError(w, CodeUnavailable, "node is pulling its state from a peer")
+			return
+		}
+		if s.auth == nil {
+			next(w, r)
+			return
+		}
+		if err := s.auth.Authenticate(r); err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="gossiper"`)
+			writeError(w, CodeUnauthenticated, err.Error())
+			return
+		}
+		next(w, r)
+	}
+}
+
+// AnyOf accepts a request if any of the authenticators does, so API keys and tokens can be used side by side.
+// The error for a rejected request is from the first authenticator that recognised its credentials
+func AnyOf(auths ...Authenticator) Authenticator {
+	return anyOf(auths)
+}
+
+type anyOf []Authenticator
+
+func (a anyOf) Authenticate(r *http.Request) error {
+	err := ErrNoCredentials
+	for _, auth := range a {
+		authErr := auth.Authenticate(r)
+		if authErr == nil {
+			return nil
+		}
+		if errors.Is(err, ErrNoCredentials) {
+			err = authErr
+		}
+	}
+	return err
+}
+
+// bearerToken returns the token of an "Authorization: Bearer" header, or of an access_token query parameter on
+// GET requests, since browsers can't set headers on EventSource and WebSocket connections
+func bearerToken(r *http.Request) (string, bool) {
+	if scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " "); ok && strings.EqualFold(scheme, "Bearer") {
+		return strings.TrimSpace(token), true
+	}
+	if token := r.URL.Query().Get("access_token"); token != "" && r.Method == http.MethodGet {
+		return token, true
+	}
+	return "", false
+}
+
+// APIKeys accepts requests carrying one of a set of static keys, either in an X-API-Key header or as a bearer
+// token. Keys are compared by hash in constant time, so neither their length nor their content leaks
+type APIKeys struct {
+	hashes [][sha256.Size]byte
+}
+
+// NewAPIKeys returns an authenticator accepting the given keys
+func NewAPIKeys(keys ...string) *APIKeys {
+	a := &APIKeys{}
+	for _, key := range keys {
+		a.hashes = append(a.hashes, sha256.Sum256([]byte(key)))
+	}
+	return a
+}
+
+// LoadAPIKeys reads API keys from a file, one per line. Blank lines and lines starting with # are skipped
+func LoadAPIKeys(path string) (*APIKeys, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var keys []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		keys = append(keys, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no API keys found in %s", path)
+	}
+	return NewAPIKeys(keys...), nil
+}
+
+func (a *APIKeys) Authenticate(r *http.Request) error {
+	key := r.Header.Get("X-API-Key")
+	if key == "" {
+		var ok bool
+		if key, ok = bearerToken(r); !ok {
+			return ErrNoCredentials
+		}
+	}
+	hash := sha256.Sum256([]byte(key))
+	match := 0
+	for _, h := range a.hashes {
+		match |= subtle.ConstantTimeCompare(hash[:], h[:])
+	}
+	if match == 0 {
+		return errors.New("invalid API key")
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package transport
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gmathur.dev/gossiper/server"
+)
+
+func TestAPIKeys(t *testing.T) {
+	keys := NewAPIKeys("key-one", "key-two")
+	tests := []struct {
+		method, target, header, value string
+		want                          error // nil, ErrNoCredentials, or errInvalid for any other error
+	}{
+		{"GET", "/state", "", "", ErrNoCredentials},
+		{"GET", "/state", "X-API-Key", "key-two", nil},
+		{"GET", "/state", "X-API-Key", "key-three", errInvalid},
+		{"POST", "/update", "Authorization", "Bearer key-one", nil},
+		{"POST", "/update", "Authorization", "Basic a2V5LW9uZQ==", ErrNoCredentials},
+		{"GET", "/watch?access_token=key-one", "", "", nil},
+		{"POST", "/update?access_token=key-one", "", "", ErrNoCredentials},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.target, nil)
+		if tt.header != "" {
+			r.Header.Set(tt.header, tt.value)
+		}
+		if err := keys.Authenticate(r); !sameAuthError(err, tt.want) {
+			t.Errorf("%s %s %s %q: got %v, want %v", tt.method, tt.target, tt.header, tt.value, err, tt.want)
+		}
+	}
+}
+
+func TestLoadAPIKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys")
+	os.WriteFile(path, []byte("# clients\nkey-one\n\n  key-two  \n"), 0o600)
+	keys, err := LoadAPIKeys(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest("GET", "/state", nil)
+	r.Header.Set("X-API-Key", "key-two")
+	if err := keys.Authenticate(r); err != nil {
+		t.Errorf("key-two: %v", err)
+	}
+	r.Header.Set("X-API-Key", "# clients")
+	if err := keys.Authenticate(r); err == nil {
+		t.Error("accepted a comment as a key")
+	}
+
+	os.WriteFile(path, []byte("# nobody yet\n"), 0o600)
+	if _, err := LoadAPIKeys(path); err == nil {
+		t.Error("loaded a file without keys")
+	}
+}
+
+func TestAnyOf(t *testing.T) {
+	secret := []byte("hmac secret")
+	auth := AnyOf(NewAPIKeys("key-one"), &JWT{Secret: secret})
+	tests := []struct {
+		token string
+		want  error
+	}{
+		{"", ErrNoCredentials},
+		{"key-one", nil},
+		{signJWT(t, "HS256", secret, validClaims()), nil},
+		{"key-two", errInvalid},
+		{signJWT(t, "HS256", []byte("other secret"), validClaims()), errInvalid},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/state", nil)
+		if tt.token != "" {
+			r.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		if err := auth.Authenticate(r); !sameAuthError(err, tt.want) {
+			t.Errorf("%q: got %v, want %v", tt.token, err, tt.want)
+		}
+	}
+}
+
+// errInvalid stands for any error but ErrNoCredentials in the tables above
+var errInvalid = errors.New("invalid credentials")
+
+func sameAuthError(got, want error) bool {
+	if want == errInvalid {
+		return got != nil && !errors.Is(got, ErrNoCredentials)
+	}
+	return errors.Is(got, want)
+}
+
+func TestClientAuthCoversLookups(t *testing.T) {
+	gs := server.NewGameServer("node1", "localhost:0", nil)
+	gs.Bootstrap.Timeout = 0
+	gs.Start(t.Context())
+	<-gs.Bootstrapped()
+	s := NewServer(gs)
+	s.SetAuthenticator(NewAPIKeys("key-one"))
+
+	for _, surface := range []Surface{SurfaceAPI, SurfaceAdmin} {
+		h := s.Handler(surface)
+		for _, target := range []string{"/whois/alice", "/leader"} {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest("GET", target, nil))
+			if rec.Code != http.StatusUnauthorized {
+				t.Errorf("surface %v %s without a key: got %d, want %d", surface, target, rec.Code, http.StatusUnauthorized)
+			}
+
+			r := httptest.NewRequest("GET", target, nil)
+			r.Header.Set("X-API-Key", "key-one")
+			rec = httptest.NewRecorder()
+			h.ServeHTTP(rec, r)
+			if rec.Code != http.StatusOK {
+				t.Errorf("surface %v %s with a key: got %d, want %d", surface, target, rec.Code, http.StatusOK)
+			}
+		}
+	}
+}
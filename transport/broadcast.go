@@ -0,0 +1,125 @@
+package transport
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sync"
+	"time"
+
+	"gmathur.dev/gossiper/server"
+)
+
+// BroadcastRequest is the JSON body of a POST /broadcast
+type BroadcastRequest struct {
+	Id    string          `json:"id,omitempty"` // dedup ID, random if empty; a retry with the same ID isn't broadcast again
+	Topic string          `json:"topic,omitempty"`
+	Data  json.RawMessage `json:"data,omitempty"`
+}
+
+// broadcastStreamBuffer is how many messages a /broadcast stream can fall behind before it is ended
+const broadcastStreamBuffer = 256
+
+// HandleBroadcastSync takes broadcast messages being spread by a peer
+func (s *Server) HandleBroadcastSync(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+	var msgs []server.Message
+	if err := json.NewDecoder(r.Body).Decode(&msgs); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	s.gs.MergeRumors(r.Header.Get(SenderHeader), msgs)
+	w.WriteHeader(http.StatusOK)
+}
+
+// HandleBroadcast broadcasts a message to every node on POST, and streams the messages this node hears of as
+// Server-Sent Events on GET, only those of the topic given as ?topic= if there is one
+func (s *Server) HandleBroadcast(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.streamBroadcasts(w, r)
+		return
+	case http.MethodPost:
+	default:
+		methodNotAllowed(w, http.MethodGet, http.MethodPost)
+		return
+	}
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+		writeError(w, CodeUnsupportedMediaType, "content type must be application/json")
+		return
+	}
+	var req BroadcastRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, server.MaxBroadcastSize+maxUpdateBodySize)).Decode(&req); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	if req.Id != "" {
+		if err := validateId("id", req.Id); err != nil {
+			writeFieldError(w, "id", err.Error())
+			return
+		}
+	}
+	msg, err := s.gs.Broadcast(server.Message{Id: req.Id, Topic: req.Topic, Data: req.Data})
+	if err != nil {
+		writeError(w, CodeInvalidArgument, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusAccepted, msg)
+}
+
+// streamBroadcasts streams broadcast messages until the client goes away. A client that doesn't keep up is cut
+// off rather than buffered for, and misses the messages broadcast while it reconnects
+func (s *Server) streamBroadcasts(w http.ResponseWriter, r *http.Request) {
+	rc := http.NewResponseController(w)
+	topic := r.URL.Query().Get("topic")
+
+	msgs := make(chan server.Message, broadcastStreamBuffer)
+	behind := make(chan struct{})
+	var once sync.Once
+	unsubscribe := s.gs.OnBroadcast(func(msg server.Message) {
+		if topic != "" && msg.Topic != topic {
+			return
+		}
+		select {
+		case msgs <- msg:
+		default:
+			once.Do(func() { close(behind) })
+		}
+	})
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	keepAlive := time.NewTicker(sseKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-behind:
+			return
+		case <-keepAlive.C:
+			io.WriteString(w, ": keep-alive\n\n")
+		case msg := <-msgs:
+			data, err := json.Marshal(msg)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "event: broadcast\ndata: %s\n\n", data)
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package transport
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+
+	"gmathur.dev/gossiper/server"
+)
+
+// NewDebugHandler serves the Go profiler under /debug/pprof/ and runtime and gossip statistics on /debug/vars.
+// Profiles expose the node's memory and can load it heavily, so serve it on its own address, reachable by
+// operators only
+func NewDebugHandler(gs *server.GameServer) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("/debug/vars", func(w http.ResponseWriter, r *http.Request) {
+		handleDebugVars(w, r, gs)
+	})
+	return mux
+}
+
+// handleDebugVars writes every published expvar, memstats and cmdline among them, and the node's DebugVars
+// under "gossiper". The node's statistics aren't published with expvar itself, since its variables are global
+// to the process and several nodes may share one
+func handleDebugVars(w http.ResponseWriter, r *http.Request, gs *server.GameServer) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		methodNotAllowed(w, http.MethodGet, http.MethodHead)
+		return
+	}
+	vars, err := json.Marshal(gs.DebugVars())
+	if err != nil {
+		writeError(w, CodeInternal, "failed to encode debug vars")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	fmt.Fprintf(w, "{\n%q: %s", "gossiper", vars)
+	expvar.Do(func(kv expvar.KeyValue) {
+		fmt.Fprintf(w, ",\n%q: %s", kv.Key, kv.Value)
+	})
+	fmt.Fprintf(w, "\n}\n")
+}
@@ -0,0 +1,139 @@
+package transport
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Error codes of an APIError. Each code always comes with the same status, so clients can branch on either
+const (
+	CodeInvalidArgument      = "invalid_argument"       // 400: a parameter or body field failed validation
+	CodeIncompatibleProtocol = "incompatible_protocol"  // 400: a peer shares no gossip protocol version with this node
+	CodeUnauthenticated      = "unauthenticated"        // 401: missing or invalid client credentials or peer signature
+	CodeNotFound             = "not_found"              // 404
+	CodeMethodNotAllowed     = "method_not_allowed"     // 405: the Allow header lists the methods served
+	CodeConflict             = "conflict"               // 409: a compare-and-set write found the player at another version, a lease has another holder, or the node is being decommissioned
+	CodeRequestInProgress    = "request_in_progress"    // 409: a request with the same Idempotency-Key is still being handled; retry after the Retry-After header
+	CodeIdempotencyKeyReused = "idempotency_key_reused" // 422: the Idempotency-Key was used for a different request
+	CodePayloadTooLarge      = "payload_too_large"      // 413
+	CodeUnsupportedMediaType = "unsupported_media_type" // 415: an unknown Content-Type or Content-Encoding
+	CodeRateLimited          = "rate_limited"           // 429: retry after the Retry-After header
+	CodeInternal             = "internal"               // 500
+	CodePeerUnreachable      = "peer_unreachable"       // 502: a peer the request needed didn't answer
+	CodeUnavailable          = "unavailable"            // 503: the node refused the request, e.g. by chaos injection
+)
+
+var codeStatus = map[string]int{
+	CodeInvalidArgument:      http.StatusBadRequest,
+	CodeIncompatibleProtocol: http.StatusBadRequest,
+	CodeUnauthenticated:      http.StatusUnauthorized,
+	CodeNotFound:             http.StatusNotFound,
+	CodeMethodNotAllowed:     http.StatusMethodNotAllowed,
+	CodeConflict:             http.StatusConflict,
+	CodeRequestInProgress:    http.StatusConflict,
+	CodeIdempotencyKeyReused: http.StatusUnprocessableEntity,
+	CodePayloadTooLarge:      http.StatusRequestEntityTooLarge,
+	CodeUnsupportedMediaType: http.StatusUnsupportedMediaType,
+	CodeRateLimited:          http.StatusTooManyRequests,
+	CodeInternal:             http.StatusInternalServerError,
+	CodePeerUnreachable:      http.StatusBadGateway,
+	CodeUnavailable:          http.StatusServiceUnavailable,
+}
+
+// APIError is the JSON body of every error response, from the client API and peer endpoints alike
+type APIError struct {
+	Code    string         `json:"code"`
+	Message string         `json:"message"`
+	Details map[string]any `json:"details,omitempty"` // e.g. the request field that failed validation
+}
+
+func (e *APIError) Error() string {
+	return e.Message
+}
+
+// Status returns the HTTP status that goes with the error's code
+func (e *APIError) Status() int {
+	if status, ok := codeStatus[e.Code]; ok {
+		return status
+	}
+	return http.StatusInternalServerError
+}
+
+func writeError(w http.ResponseWriter, code, msg string) {
+	writeAPIError(w, &APIError{Code: code, Message: msg})
+}
+
+// writeFieldError reports a request field or query parameter that failed validation
+func writeFieldError(w http.ResponseWriter, field, msg string) {
+	writeAPIError(w, &APIError{Code: CodeInvalidArgument, Message: msg, Details: map[string]any{"field": field}})
+}
+
+// writeBodyError reports a request body that couldn't be read or decoded, telling one over the size limit apart
+func writeBodyError(w http.ResponseWriter, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeAPIError(w, &APIError{
+			Code:    CodePayloadTooLarge,
+			Message: fmt.Sprintf("request body is larger than %d bytes", tooLarge.Limit),
+			Details: map[string]any{"limit": tooLarge.Limit},
+		})
+		return
+	}
+	writeError(w, CodeInvalidArgument, "invalid request body: "+err.Error())
+}
+
+// methodNotAllowed rejects a request whose method the handler doesn't serve
+func methodNotAllowed(w http.ResponseWriter, allowed ...string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	writeAPIError(w, &APIError{Code: CodeMethodNotAllowed, Message: "method not allowed", Details: map[string]any{"allow": allowed}})
+}
+
+func writeAPIError(w http.ResponseWriter, apiErr *APIError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(apiErr.Status())
+	json.NewEncoder(w).Encode(apiErr)
+}
+
+// writeJSON encodes v before writing any of it, so that a value that can't be encoded is reported as an error
+// rather than cut off after a 200
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		writeError(w, CodeInternal, "failed to encode response")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(append(data, '\n'))
+}
+
+// validatePlayerId checks a player ID against the ID rules: 1 to maxPlayerIdLength bytes of letters, digits and
+// the punctuation in playerIdPunctuation, so IDs are safe in URLs, logs and metrics labels
+func validatePlayerId(playerId string) error {
+	return validateId("playerId", playerId)
+}
+
+// validateId checks an ID that is part of a state key, a player's or a room's, named field in errors
+func validateId(field, id string) error {
+	switch {
+	case id == "":
+		return fmt.Errorf("missing %s", field)
+	case len(id) > maxPlayerIdLength:
+		return fmt.Errorf("%s is longer than %d bytes", field, maxPlayerIdLength)
+	case !utf8.ValidString(id):
+		return fmt.Errorf("%s must be valid UTF-8", field)
+	case strings.ContainsFunc(id, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && !strings.ContainsRune(playerIdPunctuation, r)
+	}):
+		return fmt.Errorf("%s may only contain letters, digits and %q", field, playerIdPunctuation)
+	}
+	return nil
+}
+
+// playerIdPunctuation are the characters besides letters and digits allowed in player IDs
+const playerIdPunctuation = "-_.:@"
@@ -0,0 +1,26 @@
+package transport
+
+import (
+	"errors"
+
+	"gmathur.dev/gossiper/server"
+)
+
+// probesOver returns the fallback transport of a transport that doesn't carry the failure detector's probes itself
+func probesOver(fallback server.GossipTransport) (server.ProbeTransport, error) {
+	probes, ok := fallback.(server.ProbeTransport)
+	if !ok {
+		return nil, errors.New("fallback transport does not support probes")
+	}
+	return probes, nil
+}
+
+// peersOver returns the fallback transport of a transport that doesn't carry the rest of the peer traffic itself,
+// see server.PeerTransport
+func peersOver(fallback server.GossipTransport) (server.PeerTransport, error) {
+	peers, ok := fallback.(server.PeerTransport)
+	if !ok {
+		return nil, errors.New("fallback transport does not support peer requests")
+	}
+	return peers, nil
+}
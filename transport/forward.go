@@ -0,0 +1,124 @@
+package transport
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+
+	"gmathur.dev/gossiper/server"
+)
+
+// ForwardedHeader is set on a client request one node forwards to another, naming the forwarding node. A
+// forwarded request is always served where it lands, so nodes whose placements of the shards disagree for a
+// moment can't bounce it between them
+const ForwardedHeader = "X-Gossiper-Forwarded-By"
+
+// APIAddressMeta is the metadata key under which a node that serves its client API on a listener of its own, see
+// Surface, advertises the API's address. Requests are forwarded there rather than to its gossip address
+const APIAddressMeta = "api-addr"
+
+// forwardedHeaders are the request headers carried over to the node a request is forwarded to
+var forwardedHeaders = []string{"Content-Type", "Accept", "Authorization", "X-API-Key", IdempotencyKeyHeader}
+
+// forward passes a request for the player of the given key on to the nodes holding the player's shard and room,
+// see server.GameServer.PlayerHolders, when this node doesn't hold them, and relays the first answer that isn't a
+// server error. It reports whether it answered the request; if no holder could, the request is served locally,
+// which for a write means it reaches the holders by gossip instead
+func (s *Server) forward(w http.ResponseWriter, r *http.Request, handler, key string, body []byte) bool {
+	if r.Header.Get(ForwardedHeader) != "" {
+		return false
+	}
+	owners := s.gs.PlayerHolders(key)
+	if owners == nil || slices.Contains(owners, s.gs.Address) {
+		return false
+	}
+	return s.forwardTo(w, r, handler, key, owners, body)
+}
+
+// forwardRoom passes a request for a whole room, such as its leaderboard, on to the nodes holding the room when
+// this node doesn't, like forward
+func (s *Server) forwardRoom(w http.ResponseWriter, r *http.Request, handler string) bool {
+	room := roomOf(r)
+	if room == "" || r.Header.Get(ForwardedHeader) != "" {
+		return false
+	}
+	holders := s.gs.RoomHolders(room)
+	if holders == nil || slices.Contains(holders, s.gs.Address) {
+		return false
+	}
+	return s.forwardTo(w, r, handler, server.RoomKey(room, ""), holders, nil)
+}
+
+// forwardTo relays a request to the first of owners that answers it without a server error
+func (s *Server) forwardTo(w http.ResponseWriter, r *http.Request, handler, key string, owners []string, body []byte) bool {
+	header := make(http.Header)
+	for _, name := range forwardedHeaders {
+		if values := r.Header.Values(name); len(values) > 0 {
+			header[name] = values
+		}
+	}
+	header.Set(ForwardedHeader, s.gs.Address)
+
+	alive := s.gs.Membership.Peers()
+	apiAddrs := make(map[string]string)
+	for _, m := range s.gs.Membership.Members() {
+		if addr := m.Meta[APIAddressMeta]; addr != "" {
+			apiAddrs[m.Address] = addr
+		}
+	}
+	for _, owner := range owners {
+		if !slices.Contains(alive, owner) {
+			continue
+		}
+		target := owner
+		if addr, ok := apiAddrs[owner]; ok {
+			target = addr
+		}
+		resp, err := s.forwardClient().Forward(r.Context(), target, r.Method, r.URL.RequestURI(), header, body)
+		if err == nil && resp.StatusCode >= 500 {
+			resp.Body.Close()
+			err = fmt.Errorf("%s returned %s", owner, resp.Status)
+		}
+		if err != nil {
+			s.gs.Metrics.Forwards.With(handler, "failed").Inc()
+			s.gs.Logger.Debug("failed to forward request", "handler", handler, "player", key, "owner", owner,
+				"err", err)
+			continue
+		}
+
+		s.gs.Metrics.Forwards.With(handler, "ok").Inc()
+		for name, values := range resp.Header {
+			w.Header()[name] = values
+		}
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+		resp.Body.Close()
+		return true
+	}
+	s.gs.Metrics.Forwards.With(handler, "local").Inc()
+	return false
+}
+
+// defaultForwardClient forwards the requests of nodes that don't send over HTTP
+var defaultForwardClient = NewPeerClient()
+
+// forwardClient returns the peer client of the node's HTTP transport, or of the one a UDP or QUIC transport falls
+// back to, so forwarded requests go out with its scheme and TLS settings
+func (s *Server) forwardClient() *PeerClient {
+	t := s.gs.Transport
+	for {
+		switch tt := t.(type) {
+		case *HTTPGossipTransport:
+			return tt.Client
+		case *HTTPTransport:
+			return tt.Client
+		case *UDPTransport:
+			t = tt.Fallback
+		case *QUICTransport:
+			t = tt.Fallback
+		default:
+			return defaultForwardClient
+		}
+	}
+}
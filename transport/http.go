@@ -0,0 +1,1058 @@
+// Package transport connects game servers to each other and to clients: the HTTP API and peer endpoints served by
+// Server, the UDP gossip transport and TLS configuration
+package transport
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gmathur.dev/gossiper/gossip"
+	"gmathur.dev/gossiper/internal/bufpool"
+	"gmathur.dev/gossiper/server"
+	"gmathur.dev/gossiper/tracing"
+)
+
+// Server is the HTTP API of a game server, both for clients and for its peers. It is an http.Handler with its own
+// routes, so several nodes can be served from one process and callers can wrap it in their own middleware. To
+// serve peers, clients and operators on listeners of their own, use Handler for each
+type Server struct {
+	gs      *server.GameServer
+	mux     *http.ServeMux
+	routes  []route
+	limiter *rateLimiter  // see SetRateLimit
+	auth    Authenticator // see SetAuthenticator
+}
+
+// Surface is a group of routes for one kind of caller, so that each can be served on its own listener with its
+// own firewall rules. Health checks are part of every surface
+type Surface int
+
+const (
+	SurfaceGossip Surface = 1 << iota // peer endpoints: gossip, syncs, probes, joins and the member list
+	SurfaceAPI                        // the client API
+	SurfaceAdmin                      // metrics, the admin API and the member list
+
+	AllSurfaces = SurfaceGossip | SurfaceAPI | SurfaceAdmin
+)
+
+type route struct {
+	surfaces Surface
+	pattern  string
+	handler  http.Handler
+}
+
+func NewServer(gs *server.GameServer) *Server {
+	s := &Server{gs: gs, mux: http.NewServeMux()}
+	s.registerHandlers()
+	return s
+}
+
+// ServeHTTP serves every surface
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Handler returns a handler serving only the routes of the given surfaces, and 404 for the rest
+func (s *Server) Handler(surfaces Surface) http.Handler {
+	mux := http.NewServeMux()
+	for _, rt := range s.routes {
+		if rt.surfaces&surfaces != 0 {
+			mux.Handle(rt.pattern, rt.handler)
+		}
+	}
+	return mux
+}
+
+func (s *Server) registerHandlers() {
+	// Peer handlers
+	s.handle(SurfaceGossip, "/gossip", s.peer(s.HandleGossip))
+	s.handle(SurfaceGossip, "/digest", s.peer(s.HandleDigest))
+	s.handle(SurfaceGossip, "/sync", s.peer(s.HandleSync))
+	s.handle(SurfaceGossip, "/presence-sync", s.peer(s.HandlePresenceSync))
+	s.handle(SurfaceGossip, "/broadcast-sync", s.peer(s.HandleBroadcastSync))
+	s.handle(SurfaceGossip, "/lease-sync", s.peer(s.HandleLeaseSync))
+	s.handle(SurfaceGossip, "/settings-sync", s.peer(s.HandleSettingsSync))
+	s.handle(SurfaceGossip, "/idempotency-sync", s.peer(s.HandleIdempotencySync))
+
+	// API handlers
+	s.handle(SurfaceAPI, "/update", s.limited("/update", s.clientAuth(s.idempotent(s.HandleUpdate))))
+	s.handle(SurfaceAPI, "/increment", s.limited("/increment", s.clientAuth(s.idempotent(s.HandleIncrement))))
+	s.handle(SurfaceAPI, "/state", s.limited("/state", s.clientAuth(s.HandleGetState)))
+	s.handle(SurfaceAPI, "/state/{playerId...}", s.limited("/state/{playerId...}", s.clientAuth(s.HandleGetPlayer)))
+	s.handle(SurfaceAPI, "/delete", s.clientAuth(s.HandleDelete))
+	s.handle(SurfaceAPI, "/leaderboard", s.clientAuth(s.HandleLeaderboard))
+	s.handle(SurfaceAPI, "/watch", s.clientAuth(s.HandleWatch))
+	s.handle(SurfaceAPI, "/subscribe", s.clientAuth(s.HandleSubscribe))
+	s.handle(SurfaceAPI, "/events", s.clientAuth(s.HandleEvents))
+	s.handle(SurfaceAPI, "/presence", s.clientAuth(s.HandlePresence))
+	s.handle(SurfaceAPI, "/presence/{playerId...}", s.clientAuth(s.HandlePlayerPresence))
+	s.handle(SurfaceAPI, "/broadcast", s.limited("/broadcast", s.clientAuth(s.HandleBroadcast)))
+	s.handle(SurfaceAPI, "/leases", s.clientAuth(s.HandleLeases))
+	s.handle(SurfaceAPI, "/leases/{name}", s.clientAuth(s.HandleLease))
+	s.handle(SurfaceAPI, "/settings", s.clientAuth(s.HandleSettings))
+
+	// The same API for the players of one room, see server.RoomKey
+	s.handle(SurfaceAPI, "/rooms", s.clientAuth(s.HandleRooms))
+	s.handle(SurfaceAPI, "/rooms/{roomId}/update", s.limited("/rooms/{roomId}/update", s.clientAuth(s.idempotent(s.inRoom(s.HandleUpdate)))))
+	s.handle(SurfaceAPI, "/rooms/{roomId}/increment", s.limited("/rooms/{roomId}/increment", s.clientAuth(s.idempotent(s.inRoom(s.HandleIncrement)))))
+	s.handle(SurfaceAPI, "/rooms/{roomId}/state", s.limited("/rooms/{roomId}/state", s.clientAuth(s.inRoom(s.HandleGetState))))
+	s.handle(SurfaceAPI, "/rooms/{roomId}/state/{playerId...}", s.limited("/rooms/{roomId}/state/{playerId...}", s.clientAuth(s.inRoom(s.HandleGetPlayer))))
+	s.handle(SurfaceAPI, "/rooms/{roomId}/delete", s.clientAuth(s.inRoom(s.HandleDelete)))
+	s.handle(SurfaceAPI, "/rooms/{roomId}/leaderboard", s.clientAuth(s.inRoom(s.HandleLeaderboard)))
+
+	// Cluster membership handlers
+	s.handle(SurfaceGossip, "/join", s.peer(s.HandleJoin))
+	s.handle(SurfaceGossip, "/leave", s.peer(s.HandleLeave))
+	s.handle(SurfaceGossip|SurfaceAdmin, "/members", s.HandleMembers)
+	s.handle(SurfaceGossip, "/status", s.peer(s.HandleAdminStatus))
+	s.handle(SurfaceAPI|SurfaceAdmin, "/whois/{playerId...}", s.clientAuth(s.HandleWhoIs))
+	s.handle(SurfaceAPI|SurfaceAdmin, "/leader", s.clientAuth(s.HandleLeader))
+
+	// Failure detector handlers
+	s.handle(SurfaceGossip, "/ping", s.peer(s.HandlePing))
+	s.handle(SurfaceGossip, "/ping-req", s.peer(s.HandlePingReq))
+
+	// Observability
+	s.handle(AllSurfaces, "/healthz", s.HandleHealthz)
+	s.handle(AllSurfaces, "/readyz", s.HandleReadyz)
+	s.route(SurfaceAdmin, "/metrics", s.gs.Metrics.Registry)
+
+	// Admin handlers
+	s.handle(SurfaceAdmin, "/admin/status", s.HandleAdminStatus)
+	s.handle(SurfaceAdmin, "/admin/cluster", s.HandleAdminCluster)
+	s.handle(SurfaceAdmin, "/admin/gossip", s.HandleAdminGossip)
+	s.handle(SurfaceAdmin, "/admin/sync", s.HandleAdminSync)
+	s.handle(SurfaceAdmin, "/admin/snapshot", s.HandleAdminSnapshot)
+	s.handle(SurfaceAdmin, "/admin/export", s.HandleAdminExport)
+	s.handle(SurfaceAdmin, "/admin/import", s.HandleAdminImport)
+	s.handle(SurfaceAdmin, "/admin/chaos", s.HandleAdminChaos)
+	s.handle(SurfaceAdmin, "/admin/rooms", s.HandleAdminRooms)
+	s.handle(SurfaceAdmin, "/admin/leases", s.HandleAdminLeases)
+	s.handle(SurfaceAdmin, "/admin/decommission", s.HandleAdminDecommission)
+	s.handle(SurfaceAdmin, "/admin/clocks", s.HandleAdminClocks)
+	s.handle(SurfaceAdmin, "/admin/settings", s.HandleAdminSettings)
+
+	// Dashboard
+	s.route(SurfaceAdmin, "GET /{$}", http.RedirectHandler("/ui/", http.StatusFound))
+	s.route(SurfaceAdmin, "/ui/", http.StripPrefix("/ui/", http.FileServerFS(uiFiles)))
+}
+
+// route registers a handler as part of the given surfaces
+func (s *Server) route(surfaces Surface, pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+	s.routes = append(s.routes, route{surfaces, pattern, handler})
+}
+
+// untraced are the routes left out of tracing: probes and health checks come every second or so and would drown
+// out everything else
+var untraced = map[string]bool{"/ping": true, "/ping-req": true, "/healthz": true, "/readyz": true}
+
+// handle registers a handler instrumented with a latency histogram and, when the node has a tracer, a server span
+// that continues the caller's trace if the request carries one
+func (s *Server) handle(surfaces Surface, pattern string, handler http.HandlerFunc) {
+	traced := !untraced[pattern]
+	s.route(surfaces, pattern, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		var span *tracing.Span
+		if traced {
+			var ctx context.Context
+			ctx, span = s.gs.Tracer.Start(tracing.Extract(r.Context(), r.Header), r.Method+" "+pattern,
+				tracing.KindServer, tracing.Attr("http.request.method", r.Method), tracing.Attr("http.route", pattern),
+				tracing.Attr("client.address", r.RemoteAddr))
+			r = r.WithContext(ctx)
+		}
+		handler(rec, r)
+		if rec.status >= http.StatusInternalServerError {
+			span.SetError(errors.New(http.StatusText(rec.status)))
+		}
+		span.SetAttributes(tracing.Attr("http.response.status_code", rec.status))
+		span.End()
+		s.gs.Metrics.HTTPDuration.With(pattern, strconv.Itoa(rec.status)).Observe(time.Since(start).Seconds())
+	}))
+}
+
+// statusRecorder captures the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, for streaming handlers
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// peer wraps a handler for requests from other nodes: the peer must speak a protocol version we do, the request
+// must be signed, its body may be compressed, and the response is compressed when the peer accepts it
+func (s *Server) peer(next http.HandlerFunc) http.HandlerFunc {
+	return s.versioned(s.chaotic(s.compressed(s.bounded(s.authenticated(s.decompressed(next))))))
+}
+
+// chaotic applies the node's chaos injection to requests from peers: requests from isolated peers, and dropped
+// ones, are refused with 503, and the rest are delayed before they are handled
+func (s *Server) chaotic(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		chaos := s.gs.Chaos
+		if chaos.Refuses(r.Header.Get(SenderHeader)) {
+			writeError(w, CodeUnavailable, "request dropped by chaos injection")
+			return
+		}
+		if err := chaos.Wait(r.Context()); err != nil {
+			return
+		}
+		next(w, r)
+	}
+}
+
+// bounded rejects peer request bodies over the gossip size limit with 413, before buffering more of them than
+// that. The limit applies again to the body once decompressed, so a small compressed body can't expand past it
+func (s *Server) bounded(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limit := int64(s.gs.MaxGossipBytes())
+		if r.ContentLength > limit {
+			writeBodyError(w, &http.MaxBytesError{Limit: limit})
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+		next(w, r)
+	}
+}
+
+// versioned is the receiving half of the protocol handshake: every response tells the peer which versions we
+// speak, and requests from peers that share none with us are refused before anything else is done with them
+func (s *Server) versioned(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(server.ProtocolHeader, server.LocalProtocols.String())
+		peer, err := server.ParseProtocolRange(r.Header.Get(server.ProtocolHeader))
+		if err == nil {
+			_, err = server.NegotiateProtocol(peer)
+		}
+		if err != nil {
+			writeError(w, CodeIncompatibleProtocol, err.Error())
+			return
+		}
+		next(w, r)
+	}
+}
+
+// decompressed decodes a request body sent with a Content-Encoding
+func (s *Server) decompressed(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := server.Decompress(r.Header.Get("Content-Encoding"), r.Body)
+		if err != nil {
+			writeError(w, CodeUnsupportedMediaType, "unsupported content encoding")
+			return
+		}
+		defer body.Close()
+		r.Body = http.MaxBytesReader(w, io.NopCloser(body), int64(s.gs.MaxGossipBytes()))
+		next(w, r)
+	}
+}
+
+// compressed advertises the encodings we accept in every response, and compresses responses over the threshold
+// with the best encoding the peer accepts. Responses are buffered to decide
+func (s *Server) compressed(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		compression := s.gs.Compression
+		if len(compression.Encodings) > 0 {
+			w.Header().Set("Accept-Encoding", compression.AcceptEncoding())
+		}
+		encoding := compression.Negotiate(r.Header.Get("Accept-Encoding"))
+		if encoding == "" {
+			next(w, r)
+			return
+		}
+
+		buf := &bufferedResponse{header: w.Header(), status: http.StatusOK, body: bufpool.Get()}
+		defer bufpool.Put(buf.body)
+		next(buf, r)
+
+		body := buf.body.Bytes()
+		if len(body) >= compression.Threshold {
+			if compressed, err := server.Compress(encoding, body); err == nil {
+				s.gs.Metrics.CompressionRatio.With(encoding).Observe(float64(len(compressed)) / float64(len(body)))
+				w.Header().Set("Content-Encoding", encoding)
+				w.Header().Del("Content-Length")
+				body = compressed
+			}
+		}
+		w.WriteHeader(buf.status)
+		w.Write(body)
+	}
+}
+
+// bufferedResponse holds a response in memory, in a pooled buffer, until the handler is done
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   *bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header         { return b.header }
+func (b *bufferedResponse) Write(p []byte) (int, error) { return b.body.Write(p) }
+func (b *bufferedResponse) WriteHeader(status int)      { b.status = status }
+
+// authenticated rejects peer requests that aren't signed with a key on the cluster keyring, or were signed more
+// than server.MaxSignatureSkew ago. Without a keyring every request is let through
+func (s *Server) authenticated(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		keyring := s.gs.Keyring
+		if keyring == nil {
+			next(w, r)
+			return
+		}
+
+		body := bufpool.Get()
+		defer bufpool.Put(body)
+		if _, err := body.ReadFrom(r.Body); err != nil {
+			writeBodyError(w, err)
+			return
+		}
+		err := keyring.VerifyRequest(r.Method, r.URL.RequestURI(), body.Bytes(), r.Header.Get(server.TimestampHeader),
+			r.Header.Get(server.SignatureHeader), time.Now())
+		if err != nil {
+			writeError(w, CodeUnauthenticated, err.Error())
+			return
+		}
+
+		r.Body = io.NopCloser(bytes.NewReader(body.Bytes()))
+		next(w, r)
+	}
+}
+
+func (s *Server) HandleGossip(w http.ResponseWriter, r *http.Request) {
+	msg, codec, ok := s.readGossip(w, r)
+	if !ok {
+		return
+	}
+	span := tracing.SpanFromContext(r.Context())
+	span.SetAttributes(tracing.Attr("peer.address", msg.From))
+	span.SetAttributes(server.TracedPlayers(msg.State)...)
+
+	// In push mode we only need to merge incoming state with local state
+	if server.GossipMode(r.URL.Query().Get("mode")) != server.GossipPushPull {
+		s.gs.MergeState(msg.State)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// A push-pull peer expects our changes in return so that it converges in the same round
+	writeGossip(w, codec, s.gs.ReceiveGossip(msg))
+}
+
+// HandleSync serves anti-entropy syncs, which are always answered with our own state whatever the gossip mode
+func (s *Server) HandleSync(w http.ResponseWriter, r *http.Request) {
+	msg, codec, ok := s.readGossip(w, r)
+	if !ok {
+		return
+	}
+	span := tracing.SpanFromContext(r.Context())
+	span.SetAttributes(tracing.Attr("peer.address", msg.From))
+	span.SetAttributes(server.TracedPlayers(msg.State)...)
+	writeGossip(w, codec, s.gs.ReceiveSync(msg))
+}
+
+// readGossip decodes a gossip message in whichever codec the peer used, writing the error if that fails
+func (s *Server) readGossip(w http.ResponseWriter, r *http.Request) (server.GossipMessage, server.Codec, bool) {
+	// Peers learn from this which codecs they can send us
+	w.Header().Set("Accept-Post", server.AcceptedContentTypes())
+	codec, ok := server.CodecFor(r.Header.Get("Content-Type"))
+	if !ok {
+		writeError(w, CodeUnsupportedMediaType, "unsupported content type")
+		return server.GossipMessage{}, nil, false
+	}
+
+	// The message is decoded into values of its own, so the buffer it was read into goes back to the pool
+	var msg server.GossipMessage
+	body := bufpool.Get()
+	defer bufpool.Put(body)
+	_, err := body.ReadFrom(r.Body)
+	if err == nil {
+		err = codec.Unmarshal(body.Bytes(), &msg)
+	}
+	if err != nil {
+		writeBodyError(w, err)
+		return server.GossipMessage{}, nil, false
+	}
+	s.gs.Metrics.PayloadBytes.With("received").Observe(float64(body.Len()))
+	if err := server.CheckProtocol(msg); err != nil {
+		writeError(w, CodeIncompatibleProtocol, err.Error())
+		return server.GossipMessage{}, nil, false
+	}
+	return msg, codec, true
+}
+
+// writeGossip sends a reply back in the codec the peer used, encoded in a pooled buffer if the codec can
+func writeGossip(w http.ResponseWriter, codec server.Codec, reply server.GossipMessage) {
+	data := bufpool.Get()
+	defer bufpool.Put(data)
+	if err := server.MarshalTo(data, codec, reply); err != nil {
+		writeError(w, CodeInternal, "failed to encode state")
+		return
+	}
+	w.Header().Set("Content-Type", codec.ContentType())
+	w.Write(data.Bytes())
+}
+
+func (s *Server) HandleDigest(w http.ResponseWriter, r *http.Request) {
+	var msg server.DigestMessage
+	body := &countingReader{r: r.Body}
+	if err := json.NewDecoder(body).Decode(&msg); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	s.gs.Metrics.PayloadBytes.With("received").Observe(float64(body.n))
+
+	reply, err := s.gs.ReceiveDigest(msg)
+	if err != nil {
+		writeError(w, CodeInvalidArgument, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, reply)
+}
+
+// countingReader counts the bytes read through it, for payload size metrics
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += n
+	return n, err
+}
+
+// UpdateRequest is the JSON body of an /update request
+type UpdateRequest struct {
+	PlayerId   string                     `json:"playerId"`
+	Score      *int64                     `json:"score"`                // required unless attributes or a priority are given; a pointer to tell a score of 0 from a missing one
+	Attributes map[string]json.RawMessage `json:"attributes,omitempty"` // set alongside the score, null removes one; see server.Attribute
+	TTL        string                     `json:"ttl,omitempty"`        // Go duration, e.g. "10m"; defaults to the node's EntryTTL
+	Priority   string                     `json:"priority,omitempty"`   // high, normal or low, kept by later writes; see gossip.Priority
+
+	// Only write if the player is still at this clock, 0 for a player that doesn't exist, see server.GameServer.UpdatePlayerIf
+	ExpectedClock *uint64 `json:"expectedClock,omitempty"`
+}
+
+const (
+	maxPlayerIdLength = 256
+	maxUpdateBodySize = 64 << 10
+	// Scores are kept within the range a float64 represents exactly, so JSON clients in any language read back
+	// the score they wrote
+	maxScore = 1 << 53
+)
+
+func (s *Server) HandleUpdate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
+		methodNotAllowed(w, http.MethodPost, http.MethodPut)
+		return
+	}
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+		writeError(w, CodeUnsupportedMediaType, "content type must be application/json")
+		return
+	}
+
+	// Kept whole, in case the request is forwarded
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxUpdateBodySize))
+	if err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	var req UpdateRequest
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			writeFieldError(w, typeErr.Field, fmt.Sprintf("%s must be a JSON %s", typeErr.Field, jsonTypeName(typeErr.Type)))
+			return
+		}
+		writeBodyError(w, err)
+		return
+	}
+	if dec.More() {
+		writeError(w, CodeInvalidArgument, "invalid request body: unexpected data after the JSON object")
+		return
+	}
+
+	ttl, field, err := validateUpdate(req)
+	if err != nil {
+		writeFieldError(w, field, err.Error())
+		return
+	}
+	tracing.SpanFromContext(r.Context()).SetAttributes(tracing.Attr("player.id", req.PlayerId))
+	key := playerKey(r, req.PlayerId)
+	if s.forward(w, r, "/update", key, body) {
+		return
+	}
+
+	// Update player score and attributes, expiring the player after the requested TTL if one is given
+	if req.TTL == "" {
+		ttl = s.gs.EntryTTL
+	}
+	var opts []server.WriteOption
+	if req.Priority != "" {
+		priority, _ := gossip.ParsePriority(req.Priority) // checked by validateUpdate
+		opts = append(opts, server.WithPriority(priority))
+	}
+	if req.ExpectedClock == nil {
+		if err := s.gs.UpdatePlayer(key, req.Score, req.Attributes, ttl, opts...); err != nil {
+			writeError(w, CodeInternal, "failed to update player: "+err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// A compare-and-set answers with the player as written, for the clock the next one expects
+	player, err := s.gs.UpdatePlayerIf(key, *req.ExpectedClock, req.Score, req.Attributes, ttl, opts...)
+	var conflict *server.ConflictError
+	switch {
+	case errors.As(err, &conflict):
+		writeAPIError(w, &APIError{Code: CodeConflict, Message: conflict.Error(),
+			Details: map[string]any{"playerId": req.PlayerId, "clock": conflict.Clock}})
+	case err != nil:
+		writeError(w, CodeInternal, "failed to update player: "+err.Error())
+	default:
+		writeJSON(w, http.StatusOK, player)
+	}
+}
+
+// IncrementResult is the answer to /increment
+type IncrementResult struct {
+	PlayerId string `json:"playerId"`
+	Score    int64  `json:"score"` // the player's score on the node once the increment was applied
+}
+
+// HandleIncrement adds ?delta= to the score of ?playerId= as a commutative operation, so increments made on
+// different nodes at the same time are all counted rather than one write winning, see
+// server.GameServer.IncrementPlayerScore
+func (s *Server) HandleIncrement(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+	query := r.URL.Query()
+	playerId := query.Get("playerId")
+	if err := validatePlayerId(playerId); err != nil {
+		writeFieldError(w, "playerId", err.Error())
+		return
+	}
+	delta, err := strconv.ParseInt(query.Get("delta"), 10, 64)
+	if err != nil || delta > maxScore || delta < -maxScore {
+		writeFieldError(w, "delta", fmt.Sprintf("delta must be an integer between %d and %d", -maxScore, maxScore))
+		return
+	}
+	tracing.SpanFromContext(r.Context()).SetAttributes(tracing.Attr("player.id", playerId))
+	key := playerKey(r, playerId)
+	if s.forward(w, r, "/increment", key, nil) {
+		return
+	}
+
+	score, err := s.gs.IncrementPlayerScore(key, delta)
+	if err != nil {
+		writeError(w, CodeInternal, "failed to increment score: "+err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, IncrementResult{PlayerId: playerId, Score: score})
+}
+
+// jsonTypeName describes the JSON value expected for a Go type, for error messages
+func jsonTypeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return "integer"
+	case reflect.String:
+		return "string"
+	default:
+		return t.String()
+	}
+}
+
+// validateUpdate checks an update request and parses its TTL. On failure it returns the offending field
+func validateUpdate(req UpdateRequest) (time.Duration, string, error) {
+	if err := validatePlayerId(req.PlayerId); err != nil {
+		return 0, "playerId", err
+	}
+	switch {
+	case req.Score == nil && len(req.Attributes) == 0 && req.Priority == "":
+		return 0, "score", errors.New("missing score, attributes or priority")
+	case req.Score != nil && (*req.Score > maxScore || *req.Score < -maxScore):
+		return 0, "score", fmt.Errorf("score must be between %d and %d", -maxScore, maxScore)
+	}
+	if err := server.ValidateAttributes(req.Attributes); err != nil {
+		return 0, "attributes", err
+	}
+	if req.Priority != "" {
+		if _, err := gossip.ParsePriority(req.Priority); err != nil {
+			return 0, "priority", err
+		}
+	}
+
+	if req.TTL == "" {
+		return 0, "", nil
+	}
+	ttl, err := time.ParseDuration(req.TTL)
+	if err != nil || ttl < 0 {
+		return 0, "ttl", errors.New("ttl must be a non-negative duration such as 10m")
+	}
+	return ttl, "", nil
+}
+
+func (s *Server) HandleDelete(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		methodNotAllowed(w, http.MethodPost, http.MethodDelete)
+		return
+	}
+	playerId := r.URL.Query().Get("playerId")
+	if err := validatePlayerId(playerId); err != nil {
+		writeFieldError(w, "playerId", err.Error())
+		return
+	}
+
+	s.gs.DeletePlayer(playerKey(r, playerId))
+
+	w.WriteHeader(http.StatusOK)
+}
+
+const maxStatePageSize = 10000
+
+// HandleGetState returns the state of every player, or the page of players selected by the query parameters.
+// When there are more players after a page its Link header points at the next one
+func (s *Server) HandleGetState(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		methodNotAllowed(w, http.MethodGet, http.MethodHead)
+		return
+	}
+	q, field, err := parsePlayerQuery(r.URL.Query())
+	if err != nil {
+		writeFieldError(w, field, err.Error())
+		return
+	}
+
+	if s.forwardRoom(w, r, "/state") {
+		return
+	}
+	q.Room = roomOf(r)
+	page, next := s.gs.ScanPlayers(q)
+	if next != "" {
+		params := r.URL.Query()
+		params.Set("cursor", base64.RawURLEncoding.EncodeToString([]byte(next)))
+		w.Header().Set("Link", fmt.Sprintf("<%s?%s>; rel=\"next\"", r.URL.Path, params.Encode()))
+	}
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	writePlayers(w, r, page)
+}
+
+// gzipOnly negotiates the compression of client API responses, which browsers and curl decode
+var gzipOnly = server.Compression{Encodings: []string{server.EncodingGzip}}
+
+// writePlayers streams a page of players as a JSON object keyed by player ID, encoding them one at a time as they
+// are read rather than building the response in memory, so that dumping a large state doesn't spike the heap. The
+// response goes out chunked, gzipped if the client accepts it
+func writePlayers(w http.ResponseWriter, r *http.Request, page iter.Seq2[string, server.PlayerState]) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Add("Vary", "Accept-Encoding")
+	var out io.Writer = w
+	if gzipOnly.Negotiate(r.Header.Get("Accept-Encoding")) != "" {
+		w.Header().Set("Content-Encoding", server.EncodingGzip)
+		zw, _ := gzip.NewWriterLevel(w, gzip.BestSpeed)
+		defer zw.Close()
+		out = zw
+	}
+	w.WriteHeader(http.StatusOK)
+	bw := bufio.NewWriterSize(out, 32<<10)
+	defer bw.Flush()
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	sep := byte('{')
+	for playerId, p := range page {
+		buf.Reset()
+		buf.WriteByte(sep)
+		sep = ','
+		// Encode ends every value with a newline, which is dropped to keep the object on one line
+		enc.Encode(playerId)
+		buf.Truncate(buf.Len() - 1)
+		buf.WriteByte(':')
+		if err := enc.Encode(p); err != nil {
+			return
+		}
+		buf.Truncate(buf.Len() - 1)
+		if _, err := bw.Write(buf.Bytes()); err != nil {
+			// The client went away
+			return
+		}
+	}
+	if sep == '{' {
+		bw.WriteByte('{')
+	}
+	bw.WriteString("}\n")
+}
+
+// parsePlayerQuery reads the /state query parameters. On failure it returns the offending parameter
+func parsePlayerQuery(params url.Values) (server.PlayerQuery, string, error) {
+	q := server.PlayerQuery{Prefix: params.Get("prefix")}
+
+	if minScore := params.Get("minScore"); minScore != "" {
+		n, err := strconv.ParseInt(minScore, 10, 64)
+		if err != nil {
+			return q, "minScore", errors.New("minScore must be an integer")
+		}
+		q.MinScore = &n
+	}
+	if limit := params.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > maxStatePageSize {
+			return q, "limit", fmt.Errorf("limit must be an integer between 1 and %d", maxStatePageSize)
+		}
+		q.Limit = n
+	}
+	if cursor := params.Get("cursor"); cursor != "" {
+		after, err := base64.RawURLEncoding.DecodeString(cursor)
+		if err != nil {
+			return q, "cursor", errors.New("invalid cursor")
+		}
+		q.After = string(after)
+	}
+	return q, "", nil
+}
+
+func (s *Server) HandleGetPlayer(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		methodNotAllowed(w, http.MethodGet, http.MethodHead)
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	playerId := r.PathValue("playerId")
+	if err := validatePlayerId(playerId); err != nil {
+		writeFieldError(w, "playerId", err.Error())
+		return
+	}
+	consistency, err := server.ParseConsistency(r.URL.Query().Get("consistency"))
+	if err != nil {
+		writeFieldError(w, "consistency", err.Error())
+		return
+	}
+	tracing.SpanFromContext(r.Context()).SetAttributes(tracing.Attr("player.id", playerId))
+	key := playerKey(r, playerId)
+	if s.forward(w, r, "/state/{playerId...}", key, nil) {
+		return
+	}
+	player, ok, err := s.gs.GetPlayerWithConsistency(r.Context(), key, consistency)
+	if err != nil {
+		writeAPIError(w, &APIError{Code: CodePeerUnreachable, Message: err.Error(),
+			Details: map[string]any{"consistency": consistency}})
+		return
+	}
+	if !ok {
+		writeAPIError(w, &APIError{Code: CodeNotFound, Message: "player not found", Details: map[string]any{"playerId": playerId}})
+		return
+	}
+	if names := r.URL.Query().Get("attributes"); names != "" {
+		player.Attributes = selectAttributes(player.Attributes, strings.Split(names, ","))
+	}
+	writeJSON(w, http.StatusOK, player)
+}
+
+// selectAttributes keeps only the named attributes, for reading some fields of a player without the rest
+func selectAttributes(attrs map[string]json.RawMessage, names []string) map[string]json.RawMessage {
+	var selected map[string]json.RawMessage
+	for _, name := range names {
+		if value, ok := attrs[name]; ok {
+			if selected == nil {
+				selected = make(map[string]json.RawMessage, len(names))
+			}
+			selected[name] = value
+		}
+	}
+	return selected
+}
+
+const (
+	defaultLeaderboardSize = 10
+	maxLeaderboardSize     = 1000
+)
+
+func (s *Server) HandleLeaderboard(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		methodNotAllowed(w, http.MethodGet, http.MethodHead)
+		return
+	}
+
+	top := defaultLeaderboardSize
+	if topStr := r.URL.Query().Get("top"); topStr != "" {
+		n, err := strconv.Atoi(topStr)
+		if err != nil || n < 1 || n > maxLeaderboardSize {
+			writeFieldError(w, "top", fmt.Sprintf("top must be an integer between 1 and %d", maxLeaderboardSize))
+			return
+		}
+		top = n
+	}
+
+	if s.forwardRoom(w, r, "/leaderboard") {
+		return
+	}
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	writeJSON(w, http.StatusOK, s.gs.RoomLeaderboard(roomOf(r), top))
+}
+
+const (
+	defaultEventsLimit = 100
+	maxEventsLimit     = 1000
+)
+
+// HandleEvents returns the node's change log after ?since=, up to ?limit= changes. A consumer passes the
+// response's next as since to read on; a first above since+1 means changes were lost to retention in between
+func (s *Server) HandleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		methodNotAllowed(w, http.MethodGet, http.MethodHead)
+		return
+	}
+
+	var since uint64
+	if sinceStr := r.URL.Query().Get("since"); sinceStr != "" {
+		n, err := strconv.ParseUint(sinceStr, 10, 64)
+		if err != nil {
+			writeFieldError(w, "since", "since must be a sequence number")
+			return
+		}
+		since = n
+	}
+	limit := defaultEventsLimit
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		n, err := strconv.Atoi(limitStr)
+		if err != nil || n < 1 || n > maxEventsLimit {
+			writeFieldError(w, "limit", fmt.Sprintf("limit must be an integer between 1 and %d", maxEventsLimit))
+			return
+		}
+		limit = n
+	}
+
+	feed, err := s.gs.ReadChanges(since, limit)
+	if err != nil {
+		s.gs.Logger.Error("failed to read change log", "err", err)
+		writeError(w, CodeInternal, "failed to read change log")
+		return
+	}
+	if feed == nil {
+		writeError(w, CodeNotFound, "change log is disabled on this node")
+		return
+	}
+	writeJSON(w, http.StatusOK, feed)
+}
+
+// sseKeepAlive is how often an idle /watch stream gets a comment, so proxies don't time it out
+const sseKeepAlive = 15 * time.Second
+
+// HandleWatch streams player changes as Server-Sent Events until the client goes away. Each event is named after
+// its type and carries the PlayerEvent as JSON. With ?prefix= only players whose ID starts with it are streamed
+func (s *Server) HandleWatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+	rc := http.NewResponseController(w)
+	prefix := r.URL.Query().Get("prefix")
+
+	watcher := s.gs.Watch()
+	defer watcher.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	keepAlive := time.NewTicker(sseKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			io.WriteString(w, ": keep-alive\n\n")
+		case event, ok := <-watcher.C:
+			if !ok {
+				// Fell behind or shutting down; the client reconnects and reads /state again
+				return
+			}
+			if !strings.HasPrefix(event.PlayerId, prefix) {
+				continue
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// HandleHealthz is the liveness check: 200 while the node's gossip loop is running, 503 once it is stuck
+func (s *Server) HandleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, s.gs.Liveness())
+}
+
+// HandleReadyz is the readiness check: 200 once the node can serve requests, 503 while it can't, see
+// GameServer.Readiness
+func (s *Server) HandleReadyz(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, s.gs.Readiness())
+}
+
+func writeHealth(w http.ResponseWriter, h server.Health) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if !h.OK() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(h)
+}
+
+// HandleMembers returns the membership list, this node included, for nodes joining through a seed
+func (s *Server) HandleMembers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		methodNotAllowed(w, http.MethodGet, http.MethodHead)
+		return
+	}
+	members := s.gs.Membership.Members()
+
+	// meta.<key>=<value> parameters keep only the members whose metadata matches all of them
+	filter := make(map[string]string)
+	for param, values := range r.URL.Query() {
+		if key, ok := strings.CutPrefix(param, "meta."); ok && key != "" {
+			filter[key] = values[0]
+		}
+	}
+	if len(filter) > 0 {
+		members = slices.DeleteFunc(members, func(m server.Member) bool { return !m.MatchesMeta(filter) })
+	}
+	writeJSON(w, http.StatusOK, members)
+}
+
+// HandleWhoIs reports which nodes hold a player's state, see server.GameServer.WhoIs
+func (s *Server) HandleWhoIs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		methodNotAllowed(w, http.MethodGet, http.MethodHead)
+		return
+	}
+	playerId := r.PathValue("playerId")
+	if err := validatePlayerId(playerId); err != nil {
+		writeFieldError(w, "playerId", err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, s.gs.WhoIs(playerId))
+}
+
+// LeaderResponse is the body of a GET /leader
+type LeaderResponse struct {
+	Leader   string    `json:"leader"` // "" while there is none
+	Since    time.Time `json:"since,omitzero"`
+	Self     string    `json:"self"`
+	IsLeader bool      `json:"isLeader"`
+}
+
+// HandleLeader reports the cluster's leader as this node sees it, see server.GameServer.Leader
+func (s *Server) HandleLeader(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		methodNotAllowed(w, http.MethodGet, http.MethodHead)
+		return
+	}
+	leader, since := s.gs.Leader()
+	writeJSON(w, http.StatusOK, LeaderResponse{Leader: leader, Since: since, Self: s.gs.Address, IsLeader: leader == s.gs.Address})
+}
+
+func (s *Server) HandleJoin(w http.ResponseWriter, r *http.Request) {
+	addr, ok := peerAddrFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	s.gs.AddPeer(addr)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) HandleLeave(w http.ResponseWriter, r *http.Request) {
+	addr, ok := peerAddrFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	s.gs.RemovePeer(addr)
+	w.WriteHeader(http.StatusOK)
+}
+
+// peerAddrFromRequest validates a join/leave request and extracts the host:port of the peer it refers to. On
+// failure an error response has already been written
+func peerAddrFromRequest(w http.ResponseWriter, r *http.Request) (string, bool) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return "", false
+	}
+
+	addr := r.URL.Query().Get("addr")
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		writeFieldError(w, "addr", "missing or invalid addr, expected host:port")
+		return "", false
+	}
+	return addr, true
+}
+
+func (s *Server) HandlePing(w http.ResponseWriter, r *http.Request) {
+	var msg server.PingMessage
+	if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.gs.Membership.HandlePing(msg))
+}
+
+func (s *Server) HandlePingReq(w http.ResponseWriter, r *http.Request) {
+	var msg server.PingMessage
+	if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	if msg.Target == "" {
+		writeFieldError(w, "target", "missing target")
+		return
+	}
+
+	ack, err := s.gs.Membership.HandlePingReq(r.Context(), msg)
+	if err != nil {
+		writeAPIError(w, &APIError{Code: CodePeerUnreachable, Message: "target did not respond", Details: map[string]any{"target": msg.Target}})
+		return
+	}
+	writeJSON(w, http.StatusOK, ack)
+}
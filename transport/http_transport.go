@@ -0,0 +1,296 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+
+	"gmathur.dev/gossiper/gossip"
+	"gmathur.dev/gossiper/internal/bufpool"
+	"gmathur.dev/gossiper/server"
+)
+
+// HTTPGossipTransport sends a node's peer traffic as HTTP requests to the peer's endpoints, /gossip, /sync, /ping
+// and the rest, served by Server. It only sends: the node's endpoints are served on the application's own HTTP
+// servers, as cmd/server does, or HTTPTransport adds a listener of its own to run a node on
+type HTTPGossipTransport struct {
+	Client *PeerClient
+
+	node *server.GameServer
+}
+
+// NewHTTPGossipTransport sends a node's peer traffic with client, or a new PeerClient if it is nil. Create the node
+// WithTransport of it, which binds the two
+func NewHTTPGossipTransport(client *PeerClient) *HTTPGossipTransport {
+	if client == nil {
+		client = NewPeerClient()
+	}
+	return &HTTPGossipTransport{Client: client}
+}
+
+// BindNode makes the transport and its client send for node, with its address, keyring, compression and limits
+func (t *HTTPGossipTransport) BindNode(node *server.GameServer) {
+	t.node = node
+	t.Client.node = node
+}
+
+// PeerProtocol returns the protocol version negotiated with the peer at peerAddr, see server.ProtocolTransport
+func (t *HTTPGossipTransport) PeerProtocol(peerAddr string) uint32 {
+	return t.Client.PeerProtocol(peerAddr)
+}
+
+func (t *HTTPGossipTransport) maxBytes() int {
+	return t.node.MaxGossipBytes()
+}
+
+// readLimited reads a reply body into buf, failing with server.ErrGossipTooLarge rather than buffering one over the limit
+func (t *HTTPGossipTransport) readLimited(buf *bytes.Buffer, body io.Reader) error {
+	limit := t.maxBytes()
+	_, err := buf.ReadFrom(io.LimitReader(body, int64(limit)+1))
+	if err == nil && buf.Len() > limit {
+		err = fmt.Errorf("%w: reply over %d bytes", server.ErrGossipTooLarge, limit)
+	}
+	return err
+}
+
+// SendGossip encodes the message with the client's preferred codec if the peer accepts it, JSON otherwise
+func (t *HTTPGossipTransport) SendGossip(ctx context.Context, peerAddr string, msg server.GossipMessage, mode server.GossipMode) (server.GossipMessage, error) {
+	return t.post(ctx, peerAddr, "gossip", "/gossip?mode="+string(mode), msg, mode == server.GossipPushPull)
+}
+
+// SendSync posts a sync message to the peer's /sync endpoint
+func (t *HTTPGossipTransport) SendSync(ctx context.Context, peerAddr string, msg server.GossipMessage) (server.GossipMessage, error) {
+	return t.post(ctx, peerAddr, "sync", "/sync", msg, true)
+}
+
+// post sends a gossip message to one of the peer's endpoints, decoding its reply if withReply is set. The message
+// and the reply are encoded and read in pooled buffers
+func (t *HTTPGossipTransport) post(ctx context.Context, peerAddr, name, path string, msg server.GossipMessage, withReply bool) (server.GossipMessage, error) {
+	codec := t.Client.peerCodec(peerAddr)
+	payload := bufpool.Get()
+	if err := server.MarshalTo(payload, codec, msg); err != nil {
+		bufpool.Put(payload)
+		return server.GossipMessage{}, err
+	}
+	if size, limit := payload.Len(), t.maxBytes(); size > limit {
+		bufpool.Put(payload)
+		return server.GossipMessage{}, fmt.Errorf("%w: %d bytes to %s, limit %d", server.ErrGossipTooLarge, size, peerAddr, limit)
+	}
+	t.node.Metrics.PayloadBytes.With("sent").Observe(float64(payload.Len()))
+
+	resp, err := t.Client.postBuffer(ctx, peerAddr, path, codec.ContentType(), payload)
+	if err != nil {
+		return server.GossipMessage{}, err
+	}
+
+	defer drainAndClose(resp)
+
+	t.Client.rememberCodecs(peerAddr, resp.Header.Get("Accept-Post"))
+	if resp.StatusCode != http.StatusOK {
+		return server.GossipMessage{}, fmt.Errorf("%s to %s returned %s", name, peerAddr, resp.Status)
+	}
+
+	var reply server.GossipMessage
+	if withReply {
+		replyCodec, ok := server.CodecFor(resp.Header.Get("Content-Type"))
+		if !ok {
+			return server.GossipMessage{}, fmt.Errorf("peer %s replied with unsupported content type %q", peerAddr,
+				resp.Header.Get("Content-Type"))
+		}
+		body := bufpool.Get()
+		defer bufpool.Put(body)
+		err := t.readLimited(body, resp.Body)
+		if err == nil {
+			err = replyCodec.Unmarshal(body.Bytes(), &reply)
+		}
+		if err != nil {
+			return server.GossipMessage{}, fmt.Errorf("failed to decode state from peer %s: %w", peerAddr, err)
+		}
+	}
+	return reply, nil
+}
+
+// SendDigest posts a digest to the peer's /digest endpoint
+func (t *HTTPGossipTransport) SendDigest(ctx context.Context, peerAddr string, msg server.DigestMessage) (server.DigestReply, error) {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return server.DigestReply{}, err
+	}
+	t.node.Metrics.PayloadBytes.With("sent").Observe(float64(len(payload)))
+
+	resp, err := t.Client.Post(ctx, peerAddr, "/digest", payload)
+	if err != nil {
+		return server.DigestReply{}, err
+	}
+
+	defer drainAndClose(resp)
+
+	if resp.StatusCode != http.StatusOK {
+		return server.DigestReply{}, fmt.Errorf("digest to %s returned %s", peerAddr, resp.Status)
+	}
+
+	var reply server.DigestReply
+	body := bufpool.Get()
+	defer bufpool.Put(body)
+	err = t.readLimited(body, resp.Body)
+	if err == nil {
+		err = json.Unmarshal(body.Bytes(), &reply)
+	}
+	if err != nil {
+		return server.DigestReply{}, fmt.Errorf("failed to decode digest from peer %s: %w", peerAddr, err)
+	}
+	return reply, nil
+}
+
+// SendProbe posts a probe to the peer's /ping endpoint, or /ping-req for an indirect one
+func (t *HTTPGossipTransport) SendProbe(ctx context.Context, peerAddr string, msg server.PingMessage) (server.PingMessage, error) {
+	path := "/ping"
+	if msg.Target != "" {
+		path = "/ping-req"
+	}
+	var reply server.PingMessage
+	err := t.postJSON(ctx, peerAddr, path, msg, &reply)
+	return reply, err
+}
+
+// FetchMembers gets the peer's membership list from its /members endpoint
+func (t *HTTPGossipTransport) FetchMembers(ctx context.Context, peerAddr string) ([]server.Member, error) {
+	var members []server.Member
+	err := t.getJSON(ctx, peerAddr, "/members", &members, http.StatusOK)
+	return members, err
+}
+
+// FetchReadiness gets the peer's readiness check from its /readyz endpoint, whether it is ready or not
+func (t *HTTPGossipTransport) FetchReadiness(ctx context.Context, peerAddr string) (server.Health, error) {
+	var h server.Health
+	err := t.getJSON(ctx, peerAddr, "/readyz", &h, http.StatusOK, http.StatusServiceUnavailable)
+	return h, err
+}
+
+// FetchStatus gets the peer's server.Status from its /status endpoint
+func (t *HTTPGossipTransport) FetchStatus(ctx context.Context, peerAddr string) (server.Status, error) {
+	var st server.Status
+	err := t.getJSON(ctx, peerAddr, "/status", &st, http.StatusOK)
+	return st, err
+}
+
+// SendBroadcasts posts broadcast messages to the peer's /broadcast-sync endpoint
+func (t *HTTPGossipTransport) SendBroadcasts(ctx context.Context, peerAddr string, msgs []server.Message) error {
+	return t.postJSON(ctx, peerAddr, "/broadcast-sync", msgs, nil)
+}
+
+// storePaths are the endpoints peers merge each side store at
+var storePaths = map[string]string{
+	server.StoreSessions:    "/presence-sync",
+	server.StoreLeases:      "/lease-sync",
+	server.StoreSettings:    "/settings-sync",
+	server.StoreIdempotency: "/idempotency-sync",
+}
+
+// SendStore posts the store's entries to the peer's endpoint for it, such as /presence-sync for the sessions
+func (t *HTTPGossipTransport) SendStore(ctx context.Context, peerAddr, store string, entries map[string]gossip.Entry) error {
+	path, ok := storePaths[store]
+	if !ok {
+		return fmt.Errorf("unknown store %q", store)
+	}
+	return t.postJSON(ctx, peerAddr, path, entries, nil)
+}
+
+// postJSON posts msg encoded as JSON to one of the peer's endpoints, decoding its reply into reply unless it is nil
+func (t *HTTPGossipTransport) postJSON(ctx context.Context, peerAddr, path string, msg, reply any) error {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	resp, err := t.Client.Post(ctx, peerAddr, path, payload)
+	if err != nil {
+		return err
+	}
+	defer drainAndClose(resp)
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s%s returned %s", peerAddr, path, resp.Status)
+	}
+	if reply == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(reply)
+}
+
+// getJSON gets one of the peer's endpoints, decoding its JSON reply into reply if it has one of the given statuses
+func (t *HTTPGossipTransport) getJSON(ctx context.Context, peerAddr, path string, reply any, statuses ...int) error {
+	resp, err := t.Client.Get(ctx, peerAddr, path)
+	if err != nil {
+		return err
+	}
+	defer drainAndClose(resp)
+
+	if !slices.Contains(statuses, resp.StatusCode) {
+		return fmt.Errorf("%s%s returned %s", peerAddr, path, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(reply)
+}
+
+// HTTPTransport runs a node on HTTP: it sends gossip and the rest of the peer traffic the way HTTPGossipTransport
+// does, and serves the node's gossip endpoints, see SurfaceGossip, on a listener of its own. cmd/server sends with
+// an HTTPGossipTransport instead and serves the endpoints on its HTTP servers, alongside the client API
+type HTTPTransport struct {
+	*HTTPGossipTransport
+	TLSConfig *tls.Config // serves HTTPS when set, for peers whose client uses the https scheme; set before Start
+
+	server   *http.Server
+	listener net.Listener
+}
+
+// NewHTTPTransport binds a TCP listener on addr for the gossip endpoints of the node created WithTransport of it,
+// which the node's Start serves. Requests to peers are sent with client, or a new PeerClient if it is nil
+func NewHTTPTransport(addr string, client *PeerClient) (*HTTPTransport, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &HTTPTransport{
+		HTTPGossipTransport: NewHTTPGossipTransport(client),
+		server:              &http.Server{},
+		listener:            ln,
+	}, nil
+}
+
+// Serve serves node's gossip endpoints until ctx is done or the transport is closed
+func (t *HTTPTransport) Serve(ctx context.Context, node *server.GameServer) error {
+	t.server.Handler = NewServer(node).Handler(SurfaceGossip)
+	stop := context.AfterFunc(ctx, func() { t.server.Close() })
+	defer stop()
+	var err error
+	if t.TLSConfig != nil {
+		t.server.TLSConfig = t.TLSConfig
+		err = t.server.ServeTLS(t.listener, "", "")
+	} else {
+		err = t.server.Serve(t.listener)
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// Addr returns the address of the TCP listener
+func (t *HTTPTransport) Addr() string {
+	return t.listener.Addr().String()
+}
+
+// Close closes the listener and the connections being served
+func (t *HTTPTransport) Close() error {
+	err := t.server.Close()
+	if closeErr := t.listener.Close(); err == nil && !errors.Is(closeErr, net.ErrClosed) {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,116 @@
+package transport
+
+import (
+	"fmt"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gmathur.dev/gossiper/server"
+)
+
+func TestHTTPTransport(t *testing.T) {
+	addrs := []string{freeAddr(t), freeAddr(t)}
+	var nodes []*server.GameServer
+	for i, addr := range addrs {
+		ht, err := NewHTTPTransport(addr, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { ht.Close() })
+		gs := server.NewGameServer(addr, addr, nil, server.WithGossipInterval(20*time.Millisecond),
+			server.WithTransport(ht))
+		gs.Seeds = addrs[:i]
+		gs.Bootstrap.Timeout = 0
+		gs.Start(t.Context())
+		nodes = append(nodes, gs)
+	}
+
+	nodes[1].UpdatePlayerScore("alice", 3)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if p, ok := nodes[0].GetPlayer("alice"); ok && p.Score == 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("alice did not reach the seed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if cs := nodes[0].ClusterStatus(t.Context()); len(cs.Nodes) != 2 || len(cs.Errors) != 0 {
+		t.Errorf("cluster status holds %d nodes, errors %v", len(cs.Nodes), cs.Errors)
+	}
+}
+
+// BenchmarkPushPullRound runs the exchange of a push-pull round between two nodes holding the same 1000 players:
+// a full sync sent over HTTP to the peer's gossip endpoint, merged there, and the peer's full state sent back and
+// decoded. It covers every codec, uncompressed and with each encoding, and counts the allocations of both sides
+func BenchmarkPushPullRound(b *testing.B) {
+	for _, codecName := range []string{"json", "msgpack", "protobuf"} {
+		codec, err := server.ParseCodec(codecName)
+		if err != nil {
+			b.Fatal(err)
+		}
+		for _, encoding := range []string{"", server.EncodingSnappy, server.EncodingGzip} {
+			name := "codec=" + codecName + "/compression=none"
+			if encoding != "" {
+				name = "codec=" + codecName + "/compression=" + encoding
+			}
+			b.Run(name, func(b *testing.B) {
+				benchmarkPushPullRound(b, codec, encoding, 1000)
+			})
+		}
+	}
+}
+
+func benchmarkPushPullRound(b *testing.B, codec server.Codec, encoding string, players int) {
+	compression := server.Compression{Threshold: 1024}
+	if encoding != "" {
+		compression.Encodings = []string{encoding}
+	}
+	peer := server.NewGameServer("peer", "127.0.0.1:0", nil)
+	peer.Compression = compression
+	srv := httptest.NewServer(NewServer(peer).Handler(SurfaceGossip))
+	defer srv.Close()
+	peerAddr := strings.TrimPrefix(srv.URL, "http://")
+
+	client := NewPeerClient()
+	client.Codec = codec
+	ht := NewHTTPGossipTransport(client)
+	node := server.NewGameServer("node", "127.0.0.1:0", []string{peerAddr}, server.WithTransport(ht))
+	node.Compression = compression
+	for i := range players {
+		node.UpdatePlayerScore(fmt.Sprintf("player-%d", i), int64(i*7))
+	}
+	state, version := node.State.Delta(0)
+	peer.MergeState(state)
+	msg := server.GossipMessage{From: node.Address, Version: version, Full: true, State: state,
+		Protocol: server.ProtocolVersion}
+
+	// The first round teaches the node the codecs and encodings the peer accepts
+	if _, err := ht.SendGossip(b.Context(), peerAddr, msg, server.GossipPushPull); err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	for b.Loop() {
+		reply, err := ht.SendGossip(b.Context(), peerAddr, msg, server.GossipPushPull)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(reply.State) != players {
+			b.Fatalf("reply holds %d players, want %d", len(reply.State), players)
+		}
+	}
+}
+
+// freeAddr returns a loopback address nothing is listening on, for a node that has to know its address up front
+func freeAddr(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().String()
+}
@@ -0,0 +1,120 @@
+package transport
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"gmathur.dev/gossiper/gossip"
+	"gmathur.dev/gossiper/server"
+)
+
+const (
+	// IdempotencyKeyHeader is set by clients on a write, such as an increment, that they may retry, to a value
+	// unique to the write such as a random UUID; the retries carry the same value. See idempotent
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// IdempotentReplayedHeader is set on the answer to a retry, sent back without handling the request again
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+)
+
+// idempotent handles a request carrying an Idempotency-Key header at most once, answering retries with the response
+// kept from the first request with the key, see server.GameServer.Idempotent. Requests are told apart from their
+// retries by method, URL and body, so a key reused for another request is refused with 422
+func (s *Server) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(IdempotencyKeyHeader)
+		if key == "" || s.gs.Idempotency.Window <= 0 {
+			next(w, r)
+			return
+		}
+		if err := validateIdempotencyKey(key); err != nil {
+			writeFieldError(w, IdempotencyKeyHeader, err.Error())
+			return
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxUpdateBodySize))
+		if err != nil {
+			writeBodyError(w, err)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		h := sha256.New()
+		fmt.Fprintf(h, "%s %s\n", r.Method, r.URL.RequestURI())
+		h.Write(body)
+
+		resp, replayed, err := s.gs.Idempotent(key, hex.EncodeToString(h.Sum(nil)), func() (server.IdempotentResponse, bool) {
+			rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+			next(rec, r)
+			resp := server.IdempotentResponse{Status: rec.status, ContentType: w.Header().Get("Content-Type"),
+				Body: rec.body.Bytes()}
+			return resp, rec.status < http.StatusMultipleChoices
+		})
+		switch {
+		case errors.Is(err, server.ErrRequestInProgress):
+			w.Header().Set("Retry-After", "1")
+			writeAPIError(w, &APIError{Code: CodeRequestInProgress, Message: err.Error(),
+				Details: map[string]any{"idempotencyKey": key}})
+		case errors.Is(err, server.ErrIdempotencyKeyReused):
+			writeAPIError(w, &APIError{Code: CodeIdempotencyKeyReused, Message: err.Error(),
+				Details: map[string]any{"idempotencyKey": key}})
+		case err != nil:
+			// The request was handled and answered, but its retries will be handled again
+			s.gs.Logger.Warn("failed to keep response to idempotent request", "key", key, "err", err)
+		case replayed:
+			if resp.ContentType != "" {
+				w.Header().Set("Content-Type", resp.ContentType)
+			}
+			w.Header().Set(IdempotentReplayedHeader, "true")
+			w.WriteHeader(resp.Status)
+			w.Write(resp.Body)
+		}
+	}
+}
+
+// validateIdempotencyKey checks the value of an Idempotency-Key header
+func validateIdempotencyKey(key string) error {
+	switch {
+	case len(key) > server.MaxIdempotencyKeyLen:
+		return fmt.Errorf("%s is longer than %d bytes", IdempotencyKeyHeader, server.MaxIdempotencyKeyLen)
+	case strings.ContainsFunc(key, func(r rune) bool { return r <= ' ' || r > '~' }):
+		return fmt.Errorf("%s may only contain printable ASCII characters", IdempotencyKeyHeader)
+	}
+	return nil
+}
+
+// responseRecorder captures the status code and body written by a handler, while passing them on
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(p []byte) (int, error) {
+	r.body.Write(p)
+	return r.ResponseWriter.Write(p)
+}
+
+// HandleIdempotencySync merges responses to idempotent requests pushed by a peer
+func (s *Server) HandleIdempotencySync(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+	var responses map[string]gossip.Entry
+	if err := json.NewDecoder(r.Body).Decode(&responses); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	s.gs.MergeIdempotency(responses)
+	w.WriteHeader(http.StatusOK)
+}
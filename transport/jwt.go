@@ -0,0 +1,186 @@
+package transport
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// JWT accepts requests carrying a bearer JSON Web Token signed with HS256 under Secret, or with RS256 or ES256
+// under PublicKey. The token must not have expired, and must name Issuer and Audience when they are set
+type JWT struct {
+	Secret    []byte           // HMAC key for HS256 tokens
+	PublicKey crypto.PublicKey // *rsa.PublicKey for RS256 or *ecdsa.PublicKey (P-256) for ES256 tokens
+	Issuer    string           // required iss claim, if set
+	Audience  string           // required aud claim, if set
+	Leeway    time.Duration    // allowed clock skew when checking exp and nbf
+}
+
+// LoadJWTPublicKey reads a PEM encoded RSA or ECDSA public key, or a certificate holding one
+func LoadJWTPublicKey(path string) (crypto.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data in %s", path)
+	}
+	var key any
+	switch block.Type {
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid certificate in %s: %w", path, err)
+		}
+		key = cert.PublicKey
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key in %s: %w", path, err)
+	}
+	switch key.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey:
+		return key, nil
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T in %s", key, path)
+	}
+}
+
+// jwtClaims are the registered claims JWT checks
+type jwtClaims struct {
+	Issuer    string      `json:"iss"`
+	Audience  jwtAudience `json:"aud"`
+	ExpiresAt *int64      `json:"exp"`
+	NotBefore *int64      `json:"nbf"`
+}
+
+// jwtAudience is the aud claim, which is either a string or an array of strings
+type jwtAudience []string
+
+func (a *jwtAudience) UnmarshalJSON(data []byte) error {
+	var one string
+	if json.Unmarshal(data, &one) == nil {
+		*a = jwtAudience{one}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]string)(a))
+}
+
+// Authenticate reports tokens that aren't JWTs as missing credentials, so that AnyOf can fall through to an
+// authenticator that understands them
+func (j *JWT) Authenticate(r *http.Request) error {
+	token, ok := bearerToken(r)
+	if !ok || strings.Count(token, ".") != 2 {
+		return ErrNoCredentials
+	}
+	if err := j.verify(token); err != nil {
+		return fmt.Errorf("invalid token: %w", err)
+	}
+	return nil
+}
+
+func (j *JWT) verify(token string) error {
+	parts := strings.Split(token, ".")
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return fmt.Errorf("malformed header: %w", err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return errors.New("malformed signature")
+	}
+	if err := j.verifySignature(header.Alg, parts[0]+"."+parts[1], signature); err != nil {
+		return err
+	}
+
+	var claims jwtClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return fmt.Errorf("malformed claims: %w", err)
+	}
+	now := time.Now()
+	switch {
+	case claims.ExpiresAt == nil:
+		return errors.New("no expiry")
+	case now.After(time.Unix(*claims.ExpiresAt, 0).Add(j.Leeway)):
+		return errors.New("expired")
+	case claims.NotBefore != nil && now.Add(j.Leeway).Before(time.Unix(*claims.NotBefore, 0)):
+		return errors.New("not valid yet")
+	case j.Issuer != "" && claims.Issuer != j.Issuer:
+		return fmt.Errorf("unexpected issuer %q", claims.Issuer)
+	case j.Audience != "" && !slices.Contains(claims.Audience, j.Audience):
+		return errors.New("not issued for this audience")
+	}
+	return nil
+}
+
+// verifySignature checks the signature of a token's header and claims. The algorithm has to match the kind of
+// key configured, so a token can't pick a weaker check than the one intended, such as HS256 keyed with the
+// public key, or none at all
+func (j *JWT) verifySignature(alg, signed string, signature []byte) error {
+	digest := sha256.Sum256([]byte(signed))
+	switch alg {
+	case "HS256":
+		if len(j.Secret) == 0 {
+			break
+		}
+		mac := hmac.New(sha256.New, j.Secret)
+		mac.Write([]byte(signed))
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return errors.New("bad signature")
+		}
+		return nil
+	case "RS256":
+		key, ok := j.PublicKey.(*rsa.PublicKey)
+		if !ok {
+			break
+		}
+		if rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) != nil {
+			return errors.New("bad signature")
+		}
+		return nil
+	case "ES256":
+		key, ok := j.PublicKey.(*ecdsa.PublicKey)
+		if !ok || key.Curve != elliptic.P256() {
+			break
+		}
+		// JWS encodes the signature as r and s concatenated rather than in ASN.1
+		if len(signature) != 64 {
+			return errors.New("bad signature")
+		}
+		r, s := new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(key, digest[:], r, s) {
+			return errors.New("bad signature")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported signing algorithm %q", alg)
+}
+
+func decodeSegment(segment string, out any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
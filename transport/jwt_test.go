@@ -0,0 +1,205 @@
+package transport
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	testRSAKey, _   = rsa.GenerateKey(rand.Reader, 2048)
+	testECDSAKey, _ = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+)
+
+// signJWT encodes claims as a token signed with alg, using secret for HS256 and the test keys otherwise
+func signJWT(t *testing.T, alg string, secret []byte, claims map[string]any) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": alg, "typ": "JWT"})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+	switch alg {
+	case "HS256":
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	case "RS256":
+		signature, err = rsa.SignPKCS1v15(rand.Reader, testRSAKey, crypto.SHA256, digest[:])
+	case "ES256":
+		r, s, signErr := ecdsa.Sign(rand.Reader, testECDSAKey, digest[:])
+		signature, err = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...), signErr
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func validClaims() map[string]any {
+	return map[string]any{"iss": "auth.example", "aud": "gossiper", "exp": time.Now().Add(time.Hour).Unix()}
+}
+
+func TestJWTAlgorithms(t *testing.T) {
+	secret := []byte("hmac secret")
+	tests := []struct {
+		alg string
+		jwt *JWT
+	}{
+		{"HS256", &JWT{Secret: secret}},
+		{"RS256", &JWT{PublicKey: &testRSAKey.PublicKey}},
+		{"ES256", &JWT{PublicKey: &testECDSAKey.PublicKey}},
+	}
+	for _, tt := range tests {
+		token := signJWT(t, tt.alg, secret, validClaims())
+		if err := tt.jwt.verify(token); err != nil {
+			t.Errorf("%s: %v", tt.alg, err)
+		}
+
+		// Flip a bit of the signature
+		parts := strings.Split(token, ".")
+		signature, _ := base64.RawURLEncoding.DecodeString(parts[2])
+		signature[0] ^= 1
+		tampered := parts[0] + "." + parts[1] + "." + base64.RawURLEncoding.EncodeToString(signature)
+		if err := tt.jwt.verify(tampered); err == nil {
+			t.Errorf("%s: accepted a tampered signature", tt.alg)
+		}
+	}
+}
+
+// TestJWTAlgorithmMustMatchKey checks a token can't choose a check other than the configured key's, such as
+// HS256 keyed with the public key or no signature at all
+func TestJWTAlgorithmMustMatchKey(t *testing.T) {
+	rsaJWT := &JWT{PublicKey: &testRSAKey.PublicKey}
+	publicDER := x509.MarshalPKCS1PublicKey(&testRSAKey.PublicKey)
+	if err := rsaJWT.verify(signJWT(t, "HS256", publicDER, validClaims())); err == nil {
+		t.Error("accepted an HS256 token keyed with the RSA public key")
+	}
+	if err := rsaJWT.verify(signJWT(t, "ES256", nil, validClaims())); err == nil {
+		t.Error("accepted an ES256 token with an RSA key configured")
+	}
+	if err := (&JWT{Secret: []byte("s")}).verify(signJWT(t, "RS256", nil, validClaims())); err == nil {
+		t.Error("accepted an RS256 token with only a secret configured")
+	}
+
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`))
+	claims, _ := json.Marshal(validClaims())
+	unsigned := header + "." + base64.RawURLEncoding.EncodeToString(claims) + "."
+	if err := rsaJWT.verify(unsigned); err == nil {
+		t.Error("accepted an unsigned token")
+	}
+}
+
+func TestJWTClaims(t *testing.T) {
+	secret := []byte("hmac secret")
+	j := &JWT{Secret: secret, Issuer: "auth.example", Audience: "gossiper", Leeway: time.Minute}
+	now := time.Now()
+	tests := []struct {
+		name   string
+		change func(claims map[string]any)
+		ok     bool
+	}{
+		{"valid", func(map[string]any) {}, true},
+		{"audience in a list", func(c map[string]any) { c["aud"] = []string{"other", "gossiper"} }, true},
+		{"expired within the leeway", func(c map[string]any) { c["exp"] = now.Add(-30 * time.Second).Unix() }, true},
+		{"expired", func(c map[string]any) { c["exp"] = now.Add(-2 * time.Minute).Unix() }, false},
+		{"no expiry", func(c map[string]any) { delete(c, "exp") }, false},
+		{"not valid yet", func(c map[string]any) { c["nbf"] = now.Add(2 * time.Minute).Unix() }, false},
+		{"valid soon, within the leeway", func(c map[string]any) { c["nbf"] = now.Add(30 * time.Second).Unix() }, true},
+		{"wrong issuer", func(c map[string]any) { c["iss"] = "elsewhere" }, false},
+		{"wrong audience", func(c map[string]any) { c["aud"] = []string{"other"} }, false},
+		{"no audience", func(c map[string]any) { delete(c, "aud") }, false},
+	}
+	for _, tt := range tests {
+		claims := validClaims()
+		tt.change(claims)
+		err := j.verify(signJWT(t, "HS256", secret, claims))
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: got %v, want ok %v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestJWTAuthenticate(t *testing.T) {
+	secret := []byte("hmac secret")
+	j := &JWT{Secret: secret}
+
+	r := httptest.NewRequest("GET", "/state", nil)
+	if err := j.Authenticate(r); !errors.Is(err, ErrNoCredentials) {
+		t.Errorf("no token: got %v, want ErrNoCredentials", err)
+	}
+	r.Header.Set("Authorization", "Bearer an-api-key")
+	if err := j.Authenticate(r); !errors.Is(err, ErrNoCredentials) {
+		t.Errorf("a token that isn't a JWT: got %v, want ErrNoCredentials", err)
+	}
+	r.Header.Set("Authorization", "Bearer "+signJWT(t, "HS256", []byte("other secret"), validClaims()))
+	if err := j.Authenticate(r); err == nil || errors.Is(err, ErrNoCredentials) {
+		t.Errorf("wrong secret: got %v, want an invalid token", err)
+	}
+	r.Header.Set("Authorization", "bearer "+signJWT(t, "HS256", secret, validClaims()))
+	if err := j.Authenticate(r); err != nil {
+		t.Errorf("valid token: %v", err)
+	}
+
+	query := httptest.NewRequest("GET", "/watch?access_token="+signJWT(t, "HS256", secret, validClaims()), nil)
+	if err := j.Authenticate(query); err != nil {
+		t.Errorf("valid token in the query: %v", err)
+	}
+}
+
+func TestLoadJWTPublicKey(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, typ string, der []byte) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	pkix, _ := x509.MarshalPKIXPublicKey(&testECDSAKey.PublicKey)
+
+	for _, path := range []string{
+		write("pkix.pem", "PUBLIC KEY", pkix),
+		write("pkcs1.pem", "RSA PUBLIC KEY", x509.MarshalPKCS1PublicKey(&testRSAKey.PublicKey)),
+		write("cert.pem", "CERTIFICATE", selfSignedDER(t)),
+	} {
+		if _, err := LoadJWTPublicKey(path); err != nil {
+			t.Errorf("%s: %v", filepath.Base(path), err)
+		}
+	}
+
+	garbage := filepath.Join(dir, "garbage.pem")
+	os.WriteFile(garbage, []byte("not a key"), 0o600)
+	for _, path := range []string{garbage, write("bad.pem", "PUBLIC KEY", []byte("junk")), filepath.Join(dir, "missing")} {
+		if _, err := LoadJWTPublicKey(path); err == nil {
+			t.Errorf("%s: loaded", filepath.Base(path))
+		}
+	}
+}
+
+func selfSignedDER(t *testing.T) []byte {
+	cert, err := selfSignedCert("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert.Certificate[0]
+}
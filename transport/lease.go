@@ -0,0 +1,108 @@
+package transport
+
+import (
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+	"time"
+
+	"gmathur.dev/gossiper/gossip"
+	"gmathur.dev/gossiper/server"
+)
+
+// AcquireRequest is the JSON body of a POST /leases/{name}, which takes the lease or renews it
+type AcquireRequest struct {
+	Holder string `json:"holder"`
+	TTL    string `json:"ttl"` // Go duration, e.g. "30s"
+}
+
+// HandleLeaseSync merges leases pushed by a peer's lease gossip
+func (s *Server) HandleLeaseSync(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+	var leases map[string]gossip.Entry
+	if err := json.NewDecoder(r.Body).Decode(&leases); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	s.gs.MergeLeases(leases)
+	w.WriteHeader(http.StatusOK)
+}
+
+// HandleLeases lists the leases that are held, as far as this node knows
+func (s *Server) HandleLeases(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		methodNotAllowed(w, http.MethodGet, http.MethodHead)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.gs.HeldLeases())
+}
+
+// HandleLease takes or renews a lease on POST, returns it on GET, and releases it on DELETE, only for the holder
+// given as ?holder=
+func (s *Server) HandleLease(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if err := validateId("name", name); err != nil {
+		writeFieldError(w, "name", err.Error())
+		return
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		l, ok := s.gs.GetLease(name)
+		if !ok {
+			writeAPIError(w, &APIError{Code: CodeNotFound, Message: "lease isn't held", Details: map[string]any{"name": name}})
+			return
+		}
+		writeJSON(w, http.StatusOK, l)
+	case http.MethodPost, http.MethodPut:
+		s.acquireLease(w, r, name)
+	case http.MethodDelete:
+		holder := r.URL.Query().Get("holder")
+		if err := validateId("holder", holder); err != nil {
+			writeFieldError(w, "holder", err.Error())
+			return
+		}
+		if !s.gs.ReleaseLease(name, holder) {
+			writeAPIError(w, &APIError{Code: CodeNotFound, Message: "lease isn't held by holder", Details: map[string]any{"name": name, "holder": holder}})
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	default:
+		methodNotAllowed(w, http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodDelete)
+	}
+}
+
+func (s *Server) acquireLease(w http.ResponseWriter, r *http.Request, name string) {
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+		writeError(w, CodeUnsupportedMediaType, "content type must be application/json")
+		return
+	}
+	var req AcquireRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxUpdateBodySize)).Decode(&req); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	if err := validateId("holder", req.Holder); err != nil {
+		writeFieldError(w, "holder", err.Error())
+		return
+	}
+	ttl, err := time.ParseDuration(req.TTL)
+	if err != nil {
+		writeFieldError(w, "ttl", "ttl must be a duration such as 30s")
+		return
+	}
+	l, err := s.gs.AcquireLease(name, req.Holder, ttl)
+	var held *server.LeaseHeldError
+	switch {
+	case errors.As(err, &held):
+		writeAPIError(w, &APIError{Code: CodeConflict, Message: held.Error(),
+			Details: map[string]any{"name": name, "holder": held.Lease.Holder, "expires": held.Lease.Expires, "token": held.Lease.Token}})
+	case err != nil:
+		writeFieldError(w, "ttl", err.Error())
+	default:
+		writeJSON(w, http.StatusOK, l)
+	}
+}
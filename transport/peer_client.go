@@ -0,0 +1,316 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gmathur.dev/gossiper/internal/bufpool"
+	"gmathur.dev/gossiper/server"
+	"gmathur.dev/gossiper/tracing"
+)
+
+// PeerClient is used for every HTTP request a game server makes to its peers, so that scheme and TLS settings
+// are configured in one place. Requests are signed with the node's Keyring and compressed with its Compression,
+// once the client is bound to the node with the transport it belongs to, see HTTPGossipTransport
+type PeerClient struct {
+	Scheme string // "http", or "https" when peers serve TLS
+	Client *http.Client
+	Codec  server.Codec // preferred encoding for gossip messages, used with peers that accept it
+
+	node          *server.GameServer // see HTTPGossipTransport.BindNode
+	peerEncodings sync.Map           // peer address to the encoding it accepts for request bodies
+	peerCodecs    sync.Map           // peer address to the gossip codec it accepts
+	peerProtocols sync.Map           // peer address to the protocol version negotiated with it
+}
+
+// PeerClientOptions configure the pooled HTTP client shared by gossip and probes. Every round reuses the same
+// keep-alive connections, so a node talking to a handful of peers holds a handful of sockets instead of opening
+// a new one per request
+type PeerClientOptions struct {
+	Timeout             time.Duration // bounds a whole request; contexts usually cut it shorter
+	DialTimeout         time.Duration
+	IdleConnTimeout     time.Duration // how long an unused keep-alive connection is kept
+	MaxIdleConnsPerHost int
+	TLSConfig           *tls.Config // client TLS settings, for peers that serve HTTPS
+}
+
+func DefaultPeerClientOptions() PeerClientOptions {
+	return PeerClientOptions{
+		Timeout:             10 * time.Second,
+		DialTimeout:         2 * time.Second,
+		IdleConnTimeout:     90 * time.Second,
+		MaxIdleConnsPerHost: 16,
+	}
+}
+
+// SenderHeader carries the address of the node a peer request comes from
+const SenderHeader = "X-Gossiper-Sender"
+
+func NewPeerClient() *PeerClient {
+	c := &PeerClient{Scheme: "http", Codec: server.JSONCodec{}}
+	c.Configure(DefaultPeerClientOptions())
+	return c
+}
+
+// Configure replaces the underlying HTTP client with one built from opts. Call it before Start
+func (c *PeerClient) Configure(opts PeerClientOptions) {
+	dialer := &net.Dialer{Timeout: opts.DialTimeout, KeepAlive: 30 * time.Second}
+	c.Client = &http.Client{
+		Timeout: opts.Timeout,
+		Transport: &http.Transport{
+			Proxy:               http.ProxyFromEnvironment,
+			DialContext:         dialer.DialContext,
+			TLSClientConfig:     opts.TLSConfig,
+			TLSHandshakeTimeout: opts.DialTimeout,
+			MaxIdleConnsPerHost: opts.MaxIdleConnsPerHost,
+			IdleConnTimeout:     opts.IdleConnTimeout,
+			ForceAttemptHTTP2:   true,
+		},
+	}
+}
+
+// Post sends a JSON payload to path on the peer at addr. The request, including reading the response body, is
+// abandoned when ctx is done, and carries the trace context of the span in ctx. Payloads over the compression
+// threshold are compressed once the peer has said which encodings it accepts, and a compressed response body is
+// decompressed transparently
+func (c *PeerClient) Post(ctx context.Context, addr, path string, payload []byte) (*http.Response, error) {
+	return c.PostAs(ctx, addr, path, server.ContentTypeJSON, payload)
+}
+
+// PostAs is Post for a payload of the given content type
+func (c *PeerClient) PostAs(ctx context.Context, addr, path, contentType string, payload []byte) (*http.Response, error) {
+	return c.post(ctx, addr, path, contentType, payload, nil)
+}
+
+// postBuffer is PostAs for a payload in a pooled buffer, which it gives back to the pool once the request
+// is done with it, whether or not it succeeds: the request may still be reading it after the response has come
+func (c *PeerClient) postBuffer(ctx context.Context, addr, path, contentType string,
+	payload *bytes.Buffer) (*http.Response, error) {
+	return c.post(ctx, addr, path, contentType, payload.Bytes(), payload)
+}
+
+// post sends payload, giving pooled back to the pool, if set, once nothing reads the payload any more
+func (c *PeerClient) post(ctx context.Context, addr, path, contentType string, payload []byte,
+	pooled *bytes.Buffer) (*http.Response, error) {
+	shared := newSharedBody(pooled)
+	defer shared.done()
+
+	body, encoding := payload, ""
+	if enc, ok := c.peerEncodings.Load(addr); ok && len(payload) >= c.node.Compression.Threshold {
+		compressed := bufpool.Get()
+		shared.buffers = append(shared.buffers, compressed)
+		if err := server.CompressTo(compressed, enc.(string), payload); err != nil {
+			return nil, err
+		}
+		body, encoding = compressed.Bytes(), enc.(string)
+		c.node.Metrics.CompressionRatio.With(encoding).Observe(float64(len(body)) / float64(len(payload)))
+	}
+
+	// Corruption happens on the wire, after the body has been signed
+	timestamp, signature := "", ""
+	if c.node.Keyring != nil {
+		timestamp, signature = c.node.Keyring.SignRequest(http.MethodPost, path, body, time.Now())
+	}
+	body, err := c.node.Chaos.Outgoing(ctx, addr, body)
+	if err != nil {
+		return nil, err
+	}
+
+	url := fmt.Sprintf("%s://%s%s", c.Scheme, addr, path)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, http.NoBody)
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > 0 {
+		shared.data = body
+		req.Body, req.ContentLength = shared.reader(), int64(len(body))
+		req.GetBody = func() (io.ReadCloser, error) { return shared.reader(), nil }
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set(server.ProtocolHeader, server.LocalProtocols.String())
+	tracing.Inject(ctx, req.Header)
+	if encoding != "" {
+		req.Header.Set("Content-Encoding", encoding)
+	}
+	if len(c.node.Compression.Encodings) > 0 {
+		req.Header.Set("Accept-Encoding", c.node.Compression.AcceptEncoding())
+	}
+	req.Header.Set(SenderHeader, c.node.Address)
+	// The signature covers the body as it goes over the wire
+	if signature != "" {
+		req.Header.Set(server.TimestampHeader, timestamp)
+		req.Header.Set(server.SignatureHeader, signature)
+	}
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.rememberProtocol(addr, resp.Header.Get(server.ProtocolHeader)); err != nil {
+		drainAndClose(resp)
+		return nil, fmt.Errorf("peer %s: %w", addr, err)
+	}
+
+	if enc := c.node.Compression.Negotiate(resp.Header.Get("Accept-Encoding")); enc != "" {
+		c.peerEncodings.Store(addr, enc)
+	} else {
+		c.peerEncodings.Delete(addr)
+	}
+	if enc := resp.Header.Get("Content-Encoding"); enc != "" {
+		decoded, err := server.Decompress(enc, resp.Body)
+		if err != nil {
+			resp.Body.Close()
+			return nil, fmt.Errorf("failed to decode response from %s: %w", addr, err)
+		}
+		resp.Body = decodedBody{decoded, resp.Body}
+		resp.Header.Del("Content-Encoding")
+	}
+	return resp, nil
+}
+
+// Get fetches path from the peer at addr
+func (c *PeerClient) Get(ctx context.Context, addr, path string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s://%s%s", c.Scheme, addr, path), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(server.ProtocolHeader, server.LocalProtocols.String())
+	req.Header.Set(SenderHeader, c.node.Address)
+	if c.node.Keyring != nil {
+		timestamp, signature := c.node.Keyring.SignRequest(http.MethodGet, path, nil, time.Now())
+		req.Header.Set(server.TimestampHeader, timestamp)
+		req.Header.Set(server.SignatureHeader, signature)
+	}
+	tracing.Inject(ctx, req.Header)
+	return c.Client.Do(req)
+}
+
+// Forward sends a client's request on to the peer at addr, with a copy of the given headers, and returns the
+// peer's response as it is
+func (c *PeerClient) Forward(ctx context.Context, addr, method, uri string, header http.Header, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, fmt.Sprintf("%s://%s%s", c.Scheme, addr, uri), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if header != nil {
+		req.Header = header.Clone()
+	}
+	tracing.Inject(ctx, req.Header)
+	return c.Client.Do(req)
+}
+
+// peerCodec returns the codec to send gossip to addr with: the preferred codec once the peer has advertised it,
+// JSON until then
+func (c *PeerClient) peerCodec(addr string) server.Codec {
+	if codec, ok := c.peerCodecs.Load(addr); ok {
+		return codec.(server.Codec)
+	}
+	return server.JSONCodec{}
+}
+
+// rememberCodecs records whether a peer accepts the preferred codec, from the Accept-Post header of its reply
+func (c *PeerClient) rememberCodecs(addr, acceptPost string) {
+	for _, contentType := range strings.Split(acceptPost, ",") {
+		if c.Codec != nil && strings.TrimSpace(contentType) == c.Codec.ContentType() {
+			c.peerCodecs.Store(addr, c.Codec)
+			return
+		}
+	}
+	c.peerCodecs.Delete(addr)
+}
+
+// PeerProtocol returns the protocol version negotiated with a peer, or 0 if it hasn't answered a request yet
+func (c *PeerClient) PeerProtocol(addr string) uint32 {
+	if version, ok := c.peerProtocols.Load(addr); ok {
+		return version.(uint32)
+	}
+	return 0
+}
+
+// rememberProtocol negotiates the protocol version to talk to a peer in from the ProtocolHeader of its reply
+func (c *PeerClient) rememberProtocol(addr, header string) error {
+	peer, err := server.ParseProtocolRange(header)
+	if err == nil {
+		var version uint32
+		if version, err = server.NegotiateProtocol(peer); err == nil {
+			c.peerProtocols.Store(addr, version)
+			return nil
+		}
+	}
+	c.peerProtocols.Delete(addr)
+	return err
+}
+
+// decodedBody reads through a decoder, closing it along with the underlying body
+type decodedBody struct {
+	io.ReadCloser
+	body io.Closer
+}
+
+func (b decodedBody) Close() error {
+	b.ReadCloser.Close()
+	return b.body.Close()
+}
+
+// sharedBody is a request body whose bytes live in pooled buffers. The transport may read a request body after
+// the response has come, and may read it again through GetBody after a failed attempt, so the buffers are only
+// given back once the sender is done, see done, and every reader over the bytes has been closed, as the
+// transport closes each one when it is through with it
+type sharedBody struct {
+	data    []byte
+	buffers []*bytes.Buffer
+	refs    atomic.Int32 // the sender's, and one for each open reader
+}
+
+// newSharedBody returns a body holding the sender's reference, to give pooled back with its buffers if it is set
+func newSharedBody(pooled *bytes.Buffer) *sharedBody {
+	b := &sharedBody{}
+	if pooled != nil {
+		b.buffers = append(b.buffers, pooled)
+	}
+	b.refs.Store(1)
+	return b
+}
+
+func (b *sharedBody) reader() io.ReadCloser {
+	b.refs.Add(1)
+	return &sharedReader{Reader: bytes.NewReader(b.data), body: b}
+}
+
+// done drops a reference, giving the buffers back to the pool with the last one
+func (b *sharedBody) done() {
+	if b.refs.Add(-1) == 0 {
+		for _, buf := range b.buffers {
+			bufpool.Put(buf)
+		}
+	}
+}
+
+type sharedReader struct {
+	*bytes.Reader
+	body   *sharedBody
+	closed atomic.Bool
+}
+
+func (r *sharedReader) Close() error {
+	if !r.closed.Swap(true) {
+		r.body.done()
+	}
+	return nil
+}
+
+// drainAndClose reads what is left of a response body before closing it, so the connection goes back to the
+// pool instead of being torn down
+func drainAndClose(resp *http.Response) {
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+}
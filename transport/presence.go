@@ -0,0 +1,98 @@
+package transport
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+
+	"gmathur.dev/gossiper/gossip"
+	"gmathur.dev/gossiper/server"
+	"gmathur.dev/gossiper/tracing"
+)
+
+// ConnectRequest is the JSON body of a POST /presence, sent when a player connects to this node and again as a
+// heartbeat to keep the session
+type ConnectRequest struct {
+	PlayerId string `json:"playerId"`
+	Session  string `json:"session,omitempty"` // the game server's ID for the connection
+}
+
+// HandlePresenceSync merges sessions pushed by a peer's presence gossip
+func (s *Server) HandlePresenceSync(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+	var sessions map[string]gossip.Entry
+	if err := json.NewDecoder(r.Body).Decode(&sessions); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	s.gs.MergePresence(sessions)
+	w.WriteHeader(http.StatusOK)
+}
+
+// HandlePresence connects a player's session to this node on POST, and lists the sessions the node knows of on
+// GET, only those hosted by the node with the ID given as ?node= if there is one
+func (s *Server) HandlePresence(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		writeJSON(w, http.StatusOK, s.gs.Sessions(r.URL.Query().Get("node")))
+		return
+	case http.MethodPost, http.MethodPut:
+	default:
+		methodNotAllowed(w, http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut)
+		return
+	}
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+		writeError(w, CodeUnsupportedMediaType, "content type must be application/json")
+		return
+	}
+	var req ConnectRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxUpdateBodySize)).Decode(&req); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	if err := validatePlayerId(req.PlayerId); err != nil {
+		writeFieldError(w, "playerId", err.Error())
+		return
+	}
+	if err := server.ValidateSessionId(req.Session); err != nil {
+		writeFieldError(w, "session", err.Error())
+		return
+	}
+	tracing.SpanFromContext(r.Context()).SetAttributes(tracing.Attr("player.id", req.PlayerId))
+	session, err := s.gs.Connect(req.PlayerId, req.Session)
+	if err != nil {
+		writeError(w, CodeInternal, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, session)
+}
+
+// HandlePlayerPresence finds the node hosting a player's session on GET, and ends the session on DELETE, which
+// only the hosting node does. A DELETE with ?session= only ends that session
+func (s *Server) HandlePlayerPresence(w http.ResponseWriter, r *http.Request) {
+	playerId := r.PathValue("playerId")
+	if err := validatePlayerId(playerId); err != nil {
+		writeFieldError(w, "playerId", err.Error())
+		return
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		session, ok := s.gs.Lookup(playerId)
+		if !ok {
+			writeAPIError(w, &APIError{Code: CodeNotFound, Message: "player isn't connected", Details: map[string]any{"playerId": playerId}})
+			return
+		}
+		writeJSON(w, http.StatusOK, session)
+	case http.MethodDelete:
+		if !s.gs.Disconnect(playerId, r.URL.Query().Get("session")) {
+			writeAPIError(w, &APIError{Code: CodeNotFound, Message: "session isn't hosted by this node", Details: map[string]any{"playerId": playerId}})
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	default:
+		methodNotAllowed(w, http.MethodGet, http.MethodHead, http.MethodDelete)
+	}
+}
@@ -0,0 +1,315 @@
+package transport
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+
+	"gmathur.dev/gossiper/gossip"
+	"gmathur.dev/gossiper/server"
+)
+
+// quicRetryInterval is how long a peer that couldn't be reached over QUIC is sent gossip over the fallback transport
+// before QUIC is tried again
+const quicRetryInterval = 30 * time.Second
+
+// QUICOptions configure the QUIC transport. ServerTLS and ClientTLS are the node's HTTPS configurations, see
+// NewTLSConfigs; QUIC always encrypts, so without them the node presents a self-signed certificate and doesn't
+// verify those of its peers, which is no weaker than the plain HTTP it replaces. Messages are still signed when the
+// cluster has a keyring
+type QUICOptions struct {
+	ServerTLS        *tls.Config
+	ClientTLS        *tls.Config
+	Timeout          time.Duration // bounds a whole exchange; contexts usually cut it shorter
+	HandshakeTimeout time.Duration
+	IdleTimeout      time.Duration // how long a connection to a peer is kept without any traffic
+	KeepAlive        time.Duration // how often an idle connection is pinged to keep it, and NAT mappings, open
+	Codec            server.Codec  // preferred encoding for gossip messages, used with peers that accept it; nil is JSON
+}
+
+func DefaultQUICOptions() QUICOptions {
+	return QUICOptions{
+		Timeout:          10 * time.Second,
+		HandshakeTimeout: 2 * time.Second,
+		IdleTimeout:      90 * time.Second,
+		KeepAlive:        15 * time.Second,
+	}
+}
+
+// QUICTransport is an experimental transport that carries gossip, anti-entropy syncs and digests as HTTP/3 requests
+// over QUIC. Each peer gets one connection, kept open between rounds, on which every exchange is its own stream, so
+// a slow or lost message holds up only its own exchange rather than the ones sharing the connection, and a lost
+// packet costs a retransmission rather than a stalled TCP stream. A connection that does drop is re-established in
+// one round trip, resuming the TLS session. Requests are the same ones HTTPGossipTransport sends, with the same
+// signing, compression and codecs, and are served by the node's own gossip handlers. Peers listen for QUIC on the
+// same host:port (UDP) as their HTTP server; a peer that can't be reached over QUIC, such as one running another
+// transport, is sent gossip over the Fallback transport for a while before QUIC is tried again. Probes and the rest of
+// the peer traffic always go over Fallback
+type QUICTransport struct {
+	Fallback server.GossipTransport
+
+	gs     *server.GameServer
+	quic   *HTTPGossipTransport
+	h3     *http3.Transport
+	server *http3.Server
+	conn   net.PacketConn
+
+	unreachable sync.Map // peer address to the time until which it is sent gossip over Fallback
+}
+
+// NewQUICTransport binds a UDP socket on addr for incoming QUIC connections, which the game server's Start serves.
+// Fallback defaults to the game server's current transport, so create the QUIC transport before replacing
+// gs.Transport. Requests are signed and compressed with the game server's keyring and compression
+func NewQUICTransport(gs *server.GameServer, addr string, opts QUICOptions) (*QUICTransport, error) {
+	serverTLS, clientTLS := opts.ServerTLS, opts.ClientTLS
+	if serverTLS == nil {
+		cert, err := selfSignedCert(addr)
+		if err != nil {
+			return nil, err
+		}
+		serverTLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+		if clientTLS == nil {
+			clientTLS = &tls.Config{InsecureSkipVerify: true}
+		}
+	}
+	if clientTLS == nil {
+		clientTLS = &tls.Config{}
+	}
+	clientTLS = clientTLS.Clone()
+	clientTLS.MinVersion = tls.VersionTLS13
+	if clientTLS.ClientSessionCache == nil {
+		clientTLS.ClientSessionCache = tls.NewLRUClientSessionCache(0)
+	}
+
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	quicConfig := &quic.Config{
+		HandshakeIdleTimeout: opts.HandshakeTimeout,
+		MaxIdleTimeout:       opts.IdleTimeout,
+		KeepAlivePeriod:      opts.KeepAlive,
+	}
+	h3 := &http3.Transport{TLSClientConfig: clientTLS, QUICConfig: quicConfig}
+	client := &PeerClient{Scheme: "https", Client: &http.Client{Timeout: opts.Timeout, Transport: h3}, Codec: opts.Codec}
+	if client.Codec == nil {
+		client.Codec = server.JSONCodec{}
+	}
+	quicHTTP := NewHTTPGossipTransport(client)
+	quicHTTP.BindNode(gs)
+
+	return &QUICTransport{
+		Fallback: gs.Transport,
+		gs:       gs,
+		quic:     quicHTTP,
+		h3:       h3,
+		server:   &http3.Server{TLSConfig: http3.ConfigureTLSConfig(serverTLS.Clone()), QUICConfig: quicConfig},
+		conn:     conn,
+	}, nil
+}
+
+func (t *QUICTransport) SendGossip(ctx context.Context, peerAddr string, msg server.GossipMessage, mode server.GossipMode) (server.GossipMessage, error) {
+	if t.reachable(peerAddr) {
+		reply, err := t.quic.SendGossip(ctx, peerAddr, msg, mode)
+		if !t.fallBack(ctx, peerAddr, err) {
+			return reply, err
+		}
+	}
+	return t.Fallback.SendGossip(ctx, peerAddr, msg, mode)
+}
+
+// SendSync sends anti-entropy syncs over QUIC too, or the fallback transport if the peer can't be reached over it
+func (t *QUICTransport) SendSync(ctx context.Context, peerAddr string, msg server.GossipMessage) (server.GossipMessage, error) {
+	if t.reachable(peerAddr) {
+		reply, err := t.quic.SendSync(ctx, peerAddr, msg)
+		if !t.fallBack(ctx, peerAddr, err) {
+			return reply, err
+		}
+	}
+	syncs, ok := t.Fallback.(server.SyncTransport)
+	if !ok {
+		return server.GossipMessage{}, errors.New("fallback transport does not support anti-entropy syncs")
+	}
+	return syncs.SendSync(ctx, peerAddr, msg)
+}
+
+// SendDigest sends digests over QUIC too, or the fallback transport if the peer can't be reached over it
+func (t *QUICTransport) SendDigest(ctx context.Context, peerAddr string, msg server.DigestMessage) (server.DigestReply, error) {
+	if t.reachable(peerAddr) {
+		reply, err := t.quic.SendDigest(ctx, peerAddr, msg)
+		if !t.fallBack(ctx, peerAddr, err) {
+			return reply, err
+		}
+	}
+	digests, ok := t.Fallback.(server.DigestTransport)
+	if !ok {
+		return server.DigestReply{}, errors.New("fallback transport does not support digests")
+	}
+	return digests.SendDigest(ctx, peerAddr, msg)
+}
+
+// SendProbe sends the failure detector's probes over the fallback transport, so that a peer QUIC can't reach isn't
+// suspected for it
+func (t *QUICTransport) SendProbe(ctx context.Context, peerAddr string, msg server.PingMessage) (server.PingMessage, error) {
+	probes, err := probesOver(t.Fallback)
+	if err != nil {
+		return server.PingMessage{}, err
+	}
+	return probes.SendProbe(ctx, peerAddr, msg)
+}
+
+// FetchMembers fetches the peer's member list over the fallback transport
+func (t *QUICTransport) FetchMembers(ctx context.Context, peerAddr string) ([]server.Member, error) {
+	peers, err := peersOver(t.Fallback)
+	if err != nil {
+		return nil, err
+	}
+	return peers.FetchMembers(ctx, peerAddr)
+}
+
+// FetchReadiness fetches the peer's readiness check over the fallback transport
+func (t *QUICTransport) FetchReadiness(ctx context.Context, peerAddr string) (server.Health, error) {
+	peers, err := peersOver(t.Fallback)
+	if err != nil {
+		return server.Health{}, err
+	}
+	return peers.FetchReadiness(ctx, peerAddr)
+}
+
+// FetchStatus fetches the peer's status over the fallback transport
+func (t *QUICTransport) FetchStatus(ctx context.Context, peerAddr string) (server.Status, error) {
+	peers, err := peersOver(t.Fallback)
+	if err != nil {
+		return server.Status{}, err
+	}
+	return peers.FetchStatus(ctx, peerAddr)
+}
+
+// SendBroadcasts sends broadcast messages over the fallback transport
+func (t *QUICTransport) SendBroadcasts(ctx context.Context, peerAddr string, msgs []server.Message) error {
+	peers, err := peersOver(t.Fallback)
+	if err != nil {
+		return err
+	}
+	return peers.SendBroadcasts(ctx, peerAddr, msgs)
+}
+
+// SendStore sends the entries of a side store over the fallback transport
+func (t *QUICTransport) SendStore(ctx context.Context, peerAddr, store string, entries map[string]gossip.Entry) error {
+	peers, err := peersOver(t.Fallback)
+	if err != nil {
+		return err
+	}
+	return peers.SendStore(ctx, peerAddr, store, entries)
+}
+
+// reachable reports whether peerAddr is tried over QUIC, that is, it hasn't failed to connect within the last
+// quicRetryInterval
+func (t *QUICTransport) reachable(peerAddr string) bool {
+	until, ok := t.unreachable.Load(peerAddr)
+	if !ok {
+		return true
+	}
+	if time.Now().After(until.(time.Time)) {
+		t.unreachable.Delete(peerAddr)
+		return true
+	}
+	return false
+}
+
+// fallBack reports whether an exchange that failed with err should be retried over the fallback transport: the
+// QUIC connection to the peer couldn't be set up, rather than the peer refusing the message or the exchange running
+// out of time. The peer is then sent gossip over the fallback transport for quicRetryInterval
+func (t *QUICTransport) fallBack(ctx context.Context, peerAddr string, err error) bool {
+	if err == nil || ctx.Err() != nil || t.Fallback == nil {
+		return false
+	}
+	var idle *quic.IdleTimeoutError
+	var handshake *quic.HandshakeTimeoutError
+	var transportErr *quic.TransportError
+	var opErr *net.OpError
+	if !errors.As(err, &idle) && !errors.As(err, &handshake) && !errors.As(err, &transportErr) &&
+		!errors.As(err, &opErr) {
+		return false
+	}
+	if _, ok := t.unreachable.Swap(peerAddr, time.Now().Add(quicRetryInterval)); !ok {
+		t.gs.Logger.Warn("peer unreachable over QUIC, using fallback transport", "peer", peerAddr,
+			"retry", quicRetryInterval, "err", err)
+	}
+	return true
+}
+
+// Serve serves node's gossip endpoints, see SurfaceGossip, to incoming QUIC connections until ctx is done or the
+// transport is closed
+func (t *QUICTransport) Serve(ctx context.Context, node *server.GameServer) error {
+	t.server.Handler = NewServer(node).Handler(SurfaceGossip)
+	stop := context.AfterFunc(ctx, func() { t.server.Close() })
+	defer stop()
+	err := t.server.Serve(t.conn)
+	if errors.Is(err, http.ErrServerClosed) || errors.Is(err, quic.ErrServerClosed) || errors.Is(err, net.ErrClosed) {
+		return nil
+	}
+	return err
+}
+
+// Addr returns the address of the UDP socket QUIC connections are accepted on
+func (t *QUICTransport) Addr() string {
+	return t.conn.LocalAddr().String()
+}
+
+// Close closes the listener, incoming connections and the connections to peers
+func (t *QUICTransport) Close() error {
+	err := t.server.Close()
+	t.h3.Close()
+	t.conn.Close()
+	return err
+}
+
+// selfSignedCert generates a certificate for the QUIC listener of a node without TLS configured
+func selfSignedCert(addr string) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	host, _, _ := net.SplitHostPort(addr)
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "gossiper " + addr},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(365 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		template.IPAddresses = []net.IP{ip}
+	} else if host != "" {
+		template.DNSNames = []string{host}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
@@ -0,0 +1,145 @@
+package transport
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RateLimit caps how fast clients can call the public data endpoints, /update and /state, so that one
+// misbehaving client can't saturate a node. Both limits are token buckets refilled at the given rate; requests
+// over either are answered with 429 Too Many Requests
+type RateLimit struct {
+	PerIP  float64 // requests per second from one client IP, 0 is unlimited
+	Global float64 // requests per second from all clients together, 0 is unlimited
+	Burst  int     // requests a bucket can take at once, the rate rounded up if 0
+}
+
+// SetRateLimit applies limits to the public data endpoints. Call it before serving requests
+func (s *Server) SetRateLimit(limit RateLimit) {
+	if limit.PerIP <= 0 && limit.Global <= 0 {
+		s.limiter = nil
+		return
+	}
+	s.limiter = &rateLimiter{limit: limit, clients: make(map[string]*tokenBucket)}
+	if limit.Global > 0 {
+		s.limiter.global = newTokenBucket(limit.Global, limit.Burst, time.Now())
+	}
+}
+
+// limited rejects requests over the rate limit, if one is set, telling the client when to retry
+func (s *Server) limited(pattern string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.limiter == nil {
+			next(w, r)
+			return
+		}
+		ip, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			ip = r.RemoteAddr
+		}
+		if scope, retry := s.limiter.allow(ip, time.Now()); scope != "" {
+			s.gs.Metrics.RateLimited.With(pattern, scope).Inc()
+			seconds := int(math.Ceil(retry.Seconds()))
+			w.Header().Set("Retry-After", strconv.Itoa(seconds))
+			writeAPIError(w, &APIError{
+				Code:    CodeRateLimited,
+				Message: "rate limit exceeded",
+				Details: map[string]any{"limit": scope, "retryAfter": seconds},
+			})
+			return
+		}
+		next(w, r)
+	}
+}
+
+// rateLimiter holds a token bucket for every client IP seen recently, and one shared by all of them
+type rateLimiter struct {
+	limit RateLimit
+
+	mu        sync.Mutex
+	global    *tokenBucket
+	clients   map[string]*tokenBucket
+	lastPrune time.Time
+}
+
+// allow takes a token for a request from ip. A rejected request returns the limit it hit, "ip" or "global", and
+// how long until a token is available
+func (l *rateLimiter) allow(ip string, now time.Time) (string, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.pruneLocked(now)
+
+	var client *tokenBucket
+	if l.limit.PerIP > 0 {
+		client = l.clients[ip]
+		if client == nil {
+			client = newTokenBucket(l.limit.PerIP, l.limit.Burst, now)
+			l.clients[ip] = client
+		}
+		if wait := client.wait(now); wait > 0 {
+			return "ip", wait
+		}
+	}
+	// A request turned away by the global limit doesn't use up the client's own allowance
+	if l.global != nil {
+		if wait := l.global.wait(now); wait > 0 {
+			return "global", wait
+		}
+		l.global.take()
+	}
+	if client != nil {
+		client.take()
+	}
+	return "", 0
+}
+
+// pruneLocked forgets clients whose buckets have refilled, which are no different from new ones, at most once a
+// minute so that the map doesn't grow with every address that ever called
+func (l *rateLimiter) pruneLocked(now time.Time) {
+	if now.Sub(l.lastPrune) < time.Minute {
+		return
+	}
+	l.lastPrune = now
+	for ip, b := range l.clients {
+		if b.refill(now); b.tokens >= b.burst {
+			delete(l.clients, ip)
+		}
+	}
+}
+
+type tokenBucket struct {
+	rate   float64 // tokens added per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int, now time.Time) *tokenBucket {
+	b := float64(burst)
+	if burst <= 0 {
+		b = math.Ceil(rate)
+	}
+	return &tokenBucket{rate: rate, burst: b, tokens: b, last: now}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+}
+
+// wait refills the bucket and returns how long until it holds a token, 0 if it already does
+func (b *tokenBucket) wait(now time.Time) time.Duration {
+	b.refill(now)
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+func (b *tokenBucket) take() {
+	b.tokens--
+}
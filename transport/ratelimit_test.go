@@ -0,0 +1,75 @@
+package transport
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	tests := []struct {
+		name  string
+		rate  float64
+		burst int
+		at    []time.Duration // when requests are made, from the bucket's creation
+		want  []time.Duration // wait returned for each; 0 takes a token
+	}{
+		{"burst", 2, 3, []time.Duration{0, 0, 0, 0}, []time.Duration{0, 0, 0, 500 * time.Millisecond}},
+		{"refill", 2, 1, []time.Duration{0, 0, 250 * time.Millisecond, 500 * time.Millisecond},
+			[]time.Duration{0, 500 * time.Millisecond, 250 * time.Millisecond, 0}},
+		{"capped at burst", 10, 2, []time.Duration{0, time.Hour, time.Hour, time.Hour},
+			[]time.Duration{0, 0, 0, 100 * time.Millisecond}},
+		{"burst from rate", 2.5, 0, []time.Duration{0, 0, 0, 0}, []time.Duration{0, 0, 0, 400 * time.Millisecond}},
+		{"slow", 0.5, 1, []time.Duration{0, time.Second, 2 * time.Second}, []time.Duration{0, time.Second, 0}},
+	}
+	start := time.Unix(1_000_000, 0)
+	for _, tt := range tests {
+		b := newTokenBucket(tt.rate, tt.burst, start)
+		for i, at := range tt.at {
+			got := b.wait(start.Add(at))
+			if got == 0 {
+				b.take()
+			}
+			if got != tt.want[i] {
+				t.Errorf("%s: request %d at %v waits %v, want %v", tt.name, i, at, got, tt.want[i])
+			}
+		}
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	tests := []struct {
+		name  string
+		limit RateLimit
+		ips   []string
+		want  []string // limit each request hits, "" if it is let through
+	}{
+		{"per ip", RateLimit{PerIP: 1, Burst: 1}, []string{"a", "b", "a", "b"}, []string{"", "", "ip", "ip"}},
+		{"global", RateLimit{Global: 1, Burst: 2}, []string{"a", "b", "c"}, []string{"", "", "global"}},
+		// Requests turned away by the global limit leave the client's tokens alone
+		{"both", RateLimit{PerIP: 1, Global: 1, Burst: 2}, []string{"a", "b", "b", "a"}, []string{"", "", "global", "global"}},
+	}
+	for _, tt := range tests {
+		s := &Server{}
+		s.SetRateLimit(tt.limit)
+		now := time.Now() // SetRateLimit filled the global bucket just before
+		for i, ip := range tt.ips {
+			if got, _ := s.limiter.allow(ip, now); got != tt.want[i] {
+				t.Errorf("%s: request %d from %s hit %q, want %q", tt.name, i, ip, got, tt.want[i])
+			}
+		}
+	}
+}
+
+func TestRateLimiterForgetsRefilledClients(t *testing.T) {
+	start := time.Unix(1_000_000, 0)
+	s := &Server{}
+	s.SetRateLimit(RateLimit{PerIP: 1, Burst: 5})
+	s.limiter.allow("a", start)
+	s.limiter.allow("b", start.Add(time.Minute))
+	if _, ok := s.limiter.clients["a"]; ok {
+		t.Error("kept the bucket of a client that refilled a minute ago")
+	}
+	if _, ok := s.limiter.clients["b"]; !ok {
+		t.Error("forgot the bucket of a client that just made a request")
+	}
+}
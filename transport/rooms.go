@@ -0,0 +1,43 @@
+package transport
+
+import (
+	"net/http"
+
+	"gmathur.dev/gossiper/server"
+)
+
+// inRoom serves a player handler under /rooms/{roomId}/, for the players of that room. The handlers find the room
+// through playerKey and roomOf, and address the players outside any room when they are served without it
+func (s *Server) inRoom(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := validateRoomId(r.PathValue("roomId")); err != nil {
+			writeFieldError(w, "roomId", err.Error())
+			return
+		}
+		next(w, r)
+	}
+}
+
+// roomOf returns the room a request is for, "" outside /rooms/
+func roomOf(r *http.Request) string {
+	return r.PathValue("roomId")
+}
+
+// playerKey returns the key of a player of the request's room, see server.RoomKey
+func playerKey(r *http.Request, playerId string) string {
+	return server.RoomKey(roomOf(r), playerId)
+}
+
+// validateRoomId checks a room ID, which follows the rules of player IDs
+func validateRoomId(roomId string) error {
+	return validateId("roomId", roomId)
+}
+
+// HandleRooms lists the rooms this node has players of, with how many
+func (s *Server) HandleRooms(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		methodNotAllowed(w, http.MethodGet, http.MethodHead)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.gs.RoomSummaries())
+}
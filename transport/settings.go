@@ -0,0 +1,63 @@
+package transport
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"gmathur.dev/gossiper/gossip"
+)
+
+// HandleSettingsSync merges cluster-wide settings pushed by a peer
+func (s *Server) HandleSettingsSync(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+	var settings map[string]gossip.Entry
+	if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	s.gs.MergeSettings(settings)
+	w.WriteHeader(http.StatusOK)
+}
+
+// HandleSettings lists the cluster-wide settings, for game servers reading feature flags
+func (s *Server) HandleSettings(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		methodNotAllowed(w, http.MethodGet, http.MethodHead)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.gs.Settings())
+}
+
+// HandleAdminSettings lists the cluster-wide settings on GET, sets ?key= to ?value= on POST, and removes ?key= on
+// DELETE, see server.GameServer.SetSetting
+func (s *Server) HandleAdminSettings(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		writeJSON(w, http.StatusOK, s.gs.Settings())
+		return
+	}
+	query := r.URL.Query()
+	key := query.Get("key")
+	if err := validateId("key", key); err != nil {
+		writeFieldError(w, "key", err.Error())
+		return
+	}
+	switch r.Method {
+	case http.MethodPost:
+		if err := s.gs.SetSetting(key, query.Get("value")); err != nil {
+			writeFieldError(w, "value", err.Error())
+			return
+		}
+	case http.MethodDelete:
+		if !s.gs.RemoveSetting(key) {
+			writeAPIError(w, &APIError{Code: CodeNotFound, Message: "setting isn't set", Details: map[string]any{"key": key}})
+			return
+		}
+	default:
+		methodNotAllowed(w, http.MethodGet, http.MethodPost, http.MethodDelete)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.gs.Settings())
+}
@@ -0,0 +1,63 @@
+package transport
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// TLSOptions describes the certificates a node uses for HTTPS. CAFile, when set, is the CA that peer
+// certificates are verified against, in both directions; otherwise the system roots are used. With
+// RequireClientCert every client, peer or not, must present a certificate signed by that CA (mutual TLS)
+type TLSOptions struct {
+	CertFile          string
+	KeyFile           string
+	CAFile            string
+	RequireClientCert bool
+}
+
+// NewTLSConfigs builds the server side configuration for our own listener and the client side configuration used
+// when calling peers. The node's certificate is presented in both roles so peers can authenticate us under mTLS
+func NewTLSConfigs(opts TLSOptions) (serverCfg, clientCfg *tls.Config, err error) {
+	if opts.CertFile == "" || opts.KeyFile == "" {
+		return nil, nil, errors.New("both a TLS certificate and key are required")
+	}
+	cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load TLS key pair: %w", err)
+	}
+
+	var pool *x509.CertPool
+	if opts.CAFile != "" {
+		pem, err := os.ReadFile(opts.CAFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read TLS CA: %w", err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, nil, fmt.Errorf("no certificates found in %s", opts.CAFile)
+		}
+	}
+	if opts.RequireClientCert && pool == nil {
+		return nil, nil, errors.New("requiring client certificates needs a CA to verify them against")
+	}
+
+	serverCfg = &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+	}
+	if opts.RequireClientCert {
+		serverCfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	clientCfg = &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      pool,
+	}
+	return serverCfg, clientCfg, nil
+}
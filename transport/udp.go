@@ -0,0 +1,255 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+
+	"gmathur.dev/gossiper/gossip"
+	"gmathur.dev/gossiper/server"
+)
+
+// Every datagram starts with a fixed header: a two byte magic, the framing version, the message type and the
+// length of the JSON body that follows. When the cluster has a keyring the type carries udpFlagSigned and an
+// HMAC of header and body is appended after the body
+const (
+	udpMagic         = "GS"
+	udpFrameVersion  = 1
+	udpHeaderSize    = 8
+	udpMsgGossipPush = 1
+	udpFlagSigned    = 0x80
+	udpMACSize       = sha256.Size
+
+	// DefaultMaxPacketSize keeps datagrams under the typical 1500 byte Ethernet MTU once IP and UDP headers are
+	// added, so they are never fragmented
+	DefaultMaxPacketSize = 1400
+)
+
+// UDPTransport sends push gossip as single datagrams, which avoids a TCP handshake and HTTP request per round.
+// Payloads that don't fit in MaxPacketSize, and push-pull exchanges, probes and the rest of the peer traffic that need
+// a reply, are sent over the TCP based Fallback transport instead, the same split memberlist uses. Peers listen for
+// UDP on the same host:port as their HTTP server. Like any datagram, a message can be lost; the periodic full sync
+// repairs whatever a lost delta missed
+type UDPTransport struct {
+	MaxPacketSize int
+	Fallback      server.GossipTransport
+	Keyring       *server.Keyring
+
+	gs   *server.GameServer
+	conn *net.UDPConn
+}
+
+// NewUDPTransport binds a UDP socket on addr for incoming gossip, which the game server's Start serves. Fallback
+// defaults to the game server's current transport, so create the UDP transport before replacing gs.Transport
+func NewUDPTransport(gs *server.GameServer, addr string) (*UDPTransport, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &UDPTransport{
+		MaxPacketSize: DefaultMaxPacketSize,
+		Fallback:      gs.Transport,
+		Keyring:       gs.Keyring,
+		gs:            gs,
+		conn:          conn,
+	}, nil
+}
+
+func (t *UDPTransport) SendGossip(ctx context.Context, peerAddr string, msg server.GossipMessage, mode server.GossipMode) (server.GossipMessage, error) {
+	if mode == server.GossipPushPull {
+		return t.Fallback.SendGossip(ctx, peerAddr, msg, mode)
+	}
+
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return server.GossipMessage{}, err
+	}
+	size := udpHeaderSize + len(body)
+	if t.Keyring != nil {
+		size += udpMACSize
+	}
+	if size > t.MaxPacketSize {
+		return t.Fallback.SendGossip(ctx, peerAddr, msg, mode)
+	}
+
+	udpAddr, err := net.ResolveUDPAddr("udp", peerAddr)
+	if err != nil {
+		return server.GossipMessage{}, err
+	}
+	frame := make([]byte, udpHeaderSize, size)
+	copy(frame, udpMagic)
+	frame[2] = udpFrameVersion
+	frame[3] = udpMsgGossipPush
+	if t.Keyring != nil {
+		frame[3] |= udpFlagSigned
+	}
+	binary.BigEndian.PutUint32(frame[4:], uint32(len(body)))
+	frame = append(frame, body...)
+	if t.Keyring != nil {
+		frame = append(frame, t.Keyring.Sign(frame)...)
+	}
+
+	frame, err = t.gs.Chaos.Outgoing(ctx, peerAddr, frame)
+	if err != nil {
+		return server.GossipMessage{}, err
+	}
+	t.gs.Metrics.PayloadBytes.With("sent").Observe(float64(len(frame)))
+	_, err = t.conn.WriteToUDP(frame, udpAddr)
+	return server.GossipMessage{}, err
+}
+
+// SendDigest sends digest exchanges over the fallback transport, as they need a reply
+func (t *UDPTransport) SendDigest(ctx context.Context, peerAddr string, msg server.DigestMessage) (server.DigestReply, error) {
+	digests, ok := t.Fallback.(server.DigestTransport)
+	if !ok {
+		return server.DigestReply{}, errors.New("fallback transport does not support digests")
+	}
+	return digests.SendDigest(ctx, peerAddr, msg)
+}
+
+// SendSync sends anti-entropy syncs over the fallback transport, as they need a reply
+func (t *UDPTransport) SendSync(ctx context.Context, peerAddr string, msg server.GossipMessage) (server.GossipMessage, error) {
+	syncs, ok := t.Fallback.(server.SyncTransport)
+	if !ok {
+		return server.GossipMessage{}, errors.New("fallback transport does not support anti-entropy syncs")
+	}
+	return syncs.SendSync(ctx, peerAddr, msg)
+}
+
+// SendProbe sends the failure detector's probes over the fallback transport, as they need a reply
+func (t *UDPTransport) SendProbe(ctx context.Context, peerAddr string, msg server.PingMessage) (server.PingMessage, error) {
+	probes, err := probesOver(t.Fallback)
+	if err != nil {
+		return server.PingMessage{}, err
+	}
+	return probes.SendProbe(ctx, peerAddr, msg)
+}
+
+// FetchMembers fetches the peer's member list over the fallback transport
+func (t *UDPTransport) FetchMembers(ctx context.Context, peerAddr string) ([]server.Member, error) {
+	peers, err := peersOver(t.Fallback)
+	if err != nil {
+		return nil, err
+	}
+	return peers.FetchMembers(ctx, peerAddr)
+}
+
+// FetchReadiness fetches the peer's readiness check over the fallback transport
+func (t *UDPTransport) FetchReadiness(ctx context.Context, peerAddr string) (server.Health, error) {
+	peers, err := peersOver(t.Fallback)
+	if err != nil {
+		return server.Health{}, err
+	}
+	return peers.FetchReadiness(ctx, peerAddr)
+}
+
+// FetchStatus fetches the peer's status over the fallback transport
+func (t *UDPTransport) FetchStatus(ctx context.Context, peerAddr string) (server.Status, error) {
+	peers, err := peersOver(t.Fallback)
+	if err != nil {
+		return server.Status{}, err
+	}
+	return peers.FetchStatus(ctx, peerAddr)
+}
+
+// SendBroadcasts sends broadcast messages over the fallback transport
+func (t *UDPTransport) SendBroadcasts(ctx context.Context, peerAddr string, msgs []server.Message) error {
+	peers, err := peersOver(t.Fallback)
+	if err != nil {
+		return err
+	}
+	return peers.SendBroadcasts(ctx, peerAddr, msgs)
+}
+
+// SendStore sends the entries of a side store over the fallback transport
+func (t *UDPTransport) SendStore(ctx context.Context, peerAddr, store string, entries map[string]gossip.Entry) error {
+	peers, err := peersOver(t.Fallback)
+	if err != nil {
+		return err
+	}
+	return peers.SendStore(ctx, peerAddr, store, entries)
+}
+
+// Serve reads incoming datagrams for node until ctx is done or the socket is closed
+func (t *UDPTransport) Serve(ctx context.Context, node *server.GameServer) error {
+	stop := context.AfterFunc(ctx, func() { t.conn.Close() })
+	defer stop()
+
+	// One spare byte lets us tell an exactly-full datagram from one the kernel truncated
+	buf := make([]byte, t.MaxPacketSize+1)
+	for {
+		n, from, err := t.conn.ReadFromUDP(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+
+		t.gs.Metrics.PayloadBytes.With("received").Observe(float64(n))
+		msg, err := decodeUDPFrame(buf[:n], t.MaxPacketSize, t.Keyring)
+		if err == nil {
+			err = server.CheckProtocol(msg)
+		}
+		if err != nil {
+			t.gs.DroppedMessage(from.String(), err)
+			continue
+		}
+		// Datagrams received aren't delayed, which would hold up the ones behind them
+		if t.gs.Chaos.Refuses(msg.From) {
+			continue
+		}
+		node.MergeState(msg.State)
+	}
+}
+
+// Addr returns the address of the UDP socket
+func (t *UDPTransport) Addr() string {
+	return t.conn.LocalAddr().String()
+}
+
+func (t *UDPTransport) Close() error {
+	return t.conn.Close()
+}
+
+func decodeUDPFrame(frame []byte, maxSize int, keyring *server.Keyring) (server.GossipMessage, error) {
+	var msg server.GossipMessage
+	if len(frame) > maxSize {
+		return msg, fmt.Errorf("datagram exceeds %d bytes", maxSize)
+	}
+	if len(frame) < udpHeaderSize || !bytes.Equal(frame[:2], []byte(udpMagic)) {
+		return msg, errors.New("not a gossip frame")
+	}
+	signed := frame[3]&udpFlagSigned != 0
+	if frame[2] != udpFrameVersion || frame[3]&^udpFlagSigned != udpMsgGossipPush {
+		return msg, fmt.Errorf("unsupported frame version %d type %d", frame[2], frame[3])
+	}
+	if keyring != nil && !signed {
+		return msg, errors.New("unsigned frame")
+	}
+
+	end := len(frame)
+	if signed {
+		end -= udpMACSize
+	}
+	length := binary.BigEndian.Uint32(frame[4:])
+	if end < udpHeaderSize || int(length) != end-udpHeaderSize {
+		return msg, fmt.Errorf("frame length %d does not match datagram size %d", length, len(frame))
+	}
+	if keyring != nil && !keyring.Verify(frame[:end], frame[end:]) {
+		return msg, errors.New("invalid frame signature")
+	}
+
+	err := json.Unmarshal(frame[udpHeaderSize:end], &msg)
+	return msg, err
+}
@@ -0,0 +1,13 @@
+package transport
+
+import (
+	"embed"
+	"io/fs"
+)
+
+//go:embed ui
+var uiDir embed.FS
+
+// uiFiles is the dashboard served at /ui/: a single page that polls /admin/cluster for the ring, gossip heatmap and
+// state sizes, and follows /watch for the leaderboard
+var uiFiles, _ = fs.Sub(uiDir, "ui")
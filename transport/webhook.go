@@ -0,0 +1,52 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+
+	"gmathur.dev/gossiper/server"
+)
+
+// WebhookClient sends a node's webhook requests over HTTP, see server.WebhookSender
+type WebhookClient struct {
+	Client *http.Client
+}
+
+// NewWebhookClient sends webhook requests with http.DefaultClient; each request is bounded by its webhook's Timeout
+func NewWebhookClient() *WebhookClient {
+	return &WebhookClient{Client: http.DefaultClient}
+}
+
+// SendWebhook POSTs body to the webhook. With a Secret the body is signed with HMAC-SHA256 in the
+// X-Gossiper-Signature header, as "sha256=<hex>"
+func (c *WebhookClient) SendWebhook(ctx context.Context, cfg server.WebhookConfig, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for name, value := range cfg.Headers {
+		req.Header.Set(name, value)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if cfg.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(cfg.Secret))
+		mac.Write(body)
+		req.Header.Set(server.SignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return server.WebhookStatusError{Status: resp.StatusCode}
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
+
+	"gmathur.dev/gossiper/server"
+)
+
+// SubscribeMessage is sent by WebSocket clients to choose which changes they are notified of. Subscriptions
+// accumulate: each subscribe adds player IDs, and replaces the score threshold if it sets one
+type SubscribeMessage struct {
+	Type      string   `json:"type"`                // "subscribe" or "unsubscribe"
+	PlayerIds []string `json:"playerIds,omitempty"` // players to (un)subscribe to by ID
+	MinScore  *int64   `json:"minScore,omitempty"`  // subscribe to every player at or above this score
+}
+
+// wsPingInterval is how often idle WebSocket clients are pinged, to detect dead connections
+const wsPingInterval = 30 * time.Second
+
+// subscription is the filter of one WebSocket client
+type subscription struct {
+	playerIds map[string]bool
+	minScore  *int64
+	above     map[string]bool // players last reported at or above minScore, so dropping below it is reported too
+}
+
+// match reports whether a client with this subscription is interested in event
+func (sub *subscription) match(event server.PlayerEvent) bool {
+	if sub.playerIds[event.PlayerId] {
+		return true
+	}
+	if sub.minScore == nil {
+		return false
+	}
+	above := event.State != nil && event.State.Score >= *sub.minScore
+	wasAbove := sub.above[event.PlayerId]
+	if above {
+		sub.above[event.PlayerId] = true
+	} else {
+		delete(sub.above, event.PlayerId)
+	}
+	return above || wasAbove
+}
+
+// apply updates the subscription from a client message, returning the IDs newly subscribed to
+func (sub *subscription) apply(msg SubscribeMessage) ([]string, *APIError) {
+	switch msg.Type {
+	case "subscribe":
+		for _, id := range msg.PlayerIds {
+			if err := validatePlayerId(id); err != nil {
+				return nil, &APIError{Code: CodeInvalidArgument, Message: err.Error(), Details: map[string]any{"field": "playerIds"}}
+			}
+		}
+		var added []string
+		for _, id := range msg.PlayerIds {
+			if !sub.playerIds[id] {
+				sub.playerIds[id] = true
+				added = append(added, id)
+			}
+		}
+		if msg.MinScore != nil {
+			sub.minScore = msg.MinScore
+			sub.above = make(map[string]bool)
+		}
+		return added, nil
+	case "unsubscribe":
+		for _, id := range msg.PlayerIds {
+			delete(sub.playerIds, id)
+		}
+		if msg.MinScore != nil {
+			sub.minScore, sub.above = nil, nil
+		}
+		return nil, nil
+	default:
+		return nil, &APIError{Code: CodeInvalidArgument, Message: `type must be "subscribe" or "unsubscribe"`, Details: map[string]any{"field": "type"}}
+	}
+}
+
+// HandleSubscribe upgrades the request to a WebSocket on which the client subscribes to players by ID or by score
+// threshold with SubscribeMessages, and is sent a PlayerEvent for every matching change, local or gossiped.
+// Subscribing to a player ID also sends its current state straight away. Threshold subscribers are sent an event
+// after a player drops below the threshold as well, so they can keep an accurate list
+func (s *Server) HandleSubscribe(w http.ResponseWriter, r *http.Request) {
+	// The API is served to browsers from any origin, as /state is
+	conn, err := websocket.Accept(w, r, &websocket.AcceptOptions{InsecureSkipVerify: true})
+	if err != nil {
+		return
+	}
+	defer conn.CloseNow()
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	watcher := s.gs.Watch()
+	defer watcher.Close()
+
+	// Reads run on their own so that pings and client messages are handled while we wait for events
+	messages := make(chan SubscribeMessage)
+	go func() {
+		defer cancel()
+		for {
+			_, data, err := conn.Read(ctx)
+			if err != nil {
+				return
+			}
+			var msg SubscribeMessage
+			if err := json.Unmarshal(data, &msg); err != nil {
+				// Answered with an error like any other message we don't understand
+				msg = SubscribeMessage{}
+			}
+			select {
+			case messages <- msg:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	sub := &subscription{playerIds: make(map[string]bool)}
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			conn.Close(websocket.StatusGoingAway, "")
+			return
+		case <-ping.C:
+			if err := conn.Ping(ctx); err != nil {
+				return
+			}
+		case msg := <-messages:
+			added, apiErr := sub.apply(msg)
+			if apiErr != nil {
+				if wsjson.Write(ctx, conn, apiErr) != nil {
+					return
+				}
+				continue
+			}
+			for _, playerId := range added {
+				if state, ok := s.gs.GetPlayer(playerId); ok {
+					event := server.PlayerEvent{Type: server.PlayerUpdated, PlayerId: playerId, State: &state}
+					if wsjson.Write(ctx, conn, event) != nil {
+						return
+					}
+				}
+			}
+		case event, ok := <-watcher.C:
+			if !ok {
+				// Fell behind or shutting down; the client reconnects and subscribes again
+				conn.Close(websocket.StatusTryAgainLater, "fell behind or shutting down")
+				return
+			}
+			if sub.match(event) {
+				if wsjson.Write(ctx, conn, event) != nil {
+					return
+				}
+			}
+		}
+	}
+}